@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -14,15 +15,20 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
 
 	"github.com/langchou/tesgazer/internal/api/handlers"
 	"github.com/langchou/tesgazer/internal/api/tesla"
 	"github.com/langchou/tesgazer/internal/config"
 	"github.com/langchou/tesgazer/internal/repository"
 	"github.com/langchou/tesgazer/internal/service"
+	"github.com/langchou/tesgazer/internal/version"
 	"github.com/langchou/tesgazer/pkg/ws"
 )
 
+// dbReservedConns 连接池为 API 请求和后台任务预留的连接数
+const dbReservedConns = 4
+
 func main() {
 	// 加载配置
 	cfg, err := config.Load()
@@ -32,17 +38,50 @@ func main() {
 	}
 
 	// 初始化日志
-	logger := initLogger(cfg.Debug)
+	logger, closeLog := initLogger(cfg)
+	defer closeLog()
 	defer logger.Sync()
 
-	logger.Info("Starting tesgazer", zap.String("port", cfg.ServerPort))
+	build := version.Get()
+	logger.Info("Starting tesgazer",
+		zap.String("port", cfg.ServerPort),
+		zap.String("version", build.Version),
+		zap.String("commit", build.Commit),
+		zap.String("build_time", build.BuildTime))
+
+	if len(cfg.StreamingFields) > 0 {
+		if err := tesla.ValidateStreamingFields(cfg.StreamingFields); err != nil {
+			logger.Fatal("Invalid STREAMING_FIELDS", zap.Error(err))
+		}
+	}
+
+	// pgx 默认按本地时区返回 timestamptz，设置 TIMEZONE 后数据库读取的时间转换到该时区
+	var dbLocation *time.Location
+	if cfg.Location != time.Local {
+		dbLocation = cfg.Location
+		logger.Info("Using configured timezone", zap.String("timezone", cfg.Location.String()))
+	}
 
 	// 创建 context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// 连接数据库
-	db, err := repository.New(ctx, cfg.DatabaseURL)
+	// 每个并发轮询同时最多占用一个连接，另外为 API 请求和后台任务预留连接
+	maxConns := cfg.DBMaxConns
+	if minConns := cfg.PollConcurrency + dbReservedConns; maxConns < minConns {
+		logger.Warn("DB_MAX_CONNS too small for POLL_CONCURRENCY, raising pool size",
+			zap.Int("db_max_conns", maxConns),
+			zap.Int("poll_concurrency", cfg.PollConcurrency),
+			zap.Int("pool_size", minConns))
+		maxConns = minConns
+	}
+	db, err := repository.New(ctx, cfg.DatabaseURL, repository.PoolConfig{
+		MaxConns:        maxConns,
+		MinConns:        cfg.DBMinConns,
+		MaxConnLifetime: cfg.DBMaxConnLifetime,
+		Location:        dbLocation,
+	})
 	if err != nil {
 		logger.Fatal("Failed to connect database", zap.Error(err))
 	}
@@ -54,43 +93,66 @@ func main() {
 	}
 	logger.Info("Database migrated successfully")
 
+	// 启用 PostGIS（可选）
+	if cfg.UsePostGIS {
+		if err := db.EnablePostGIS(ctx); err != nil {
+			logger.Warn("PostGIS unavailable, falling back to lat/lng queries", zap.Error(err))
+		} else {
+			logger.Info("PostGIS enabled")
+		}
+	}
+
 	// 创建 Repository
 	carRepo := repository.NewCarRepository(db)
 	posRepo := repository.NewPositionRepository(db)
 	driveRepo := repository.NewDriveRepository(db)
 	chargeRepo := repository.NewChargeRepository(db)
 	parkingRepo := repository.NewParkingRepository(db)
-
-	// 创建 Tesla API 客户端
-	teslaClient := tesla.NewClient(
-		cfg.TeslaAuthHost,
-		cfg.TeslaAPIHost,
-		cfg.TeslaClientID,
-		cfg.TeslaRedirectURI,
-	)
-
-	// 加载 Token（如果存在）
-	if err := loadToken(cfg.TokenFile, teslaClient); err != nil {
-		logger.Warn("No existing token found, please authenticate", zap.Error(err))
-	}
+	geofenceRepo := repository.NewGeofenceRepository(db)
+	settingsRepo := repository.NewSettingsRepository(db)
+	accountRepo := repository.NewAccountRepository(db)
+	tripRepo := repository.NewTripRepository(db)
+	stateRepo := repository.NewStateRepository(db)
+	maintenanceRepo := repository.NewMaintenanceRepository(db)
 
 	// 创建 WebSocket Hub
 	wsHub := ws.NewHub(logger)
+	wsHub.SetFlushInterval(cfg.WSFlushInterval)
+	wsHub.SetSendBufferSize(cfg.WSSendBuffer)
 	go wsHub.Run()
 
 	// 创建车辆服务
 	vehicleService := service.NewVehicleService(
 		cfg,
 		logger,
-		teslaClient,
 		carRepo,
 		posRepo,
 		driveRepo,
 		chargeRepo,
 		parkingRepo,
+		geofenceRepo,
+		settingsRepo,
+		accountRepo,
+		tripRepo,
+		stateRepo,
+		maintenanceRepo,
 		wsHub,
 	)
 
+	// Token 刷新后立即保存，避免进程异常退出时丢失已轮换的 Refresh Token
+	vehicleService.SetTokenSaver(func(tokens map[string]*tesla.Token) error {
+		return saveTokens(cfg.TokenFile, tokens)
+	})
+
+	// 加载各账号的 Token（如果存在）
+	tokens, err := loadTokens(cfg.TokenFile)
+	if err != nil {
+		logger.Warn("No existing token found, please authenticate", zap.Error(err))
+	}
+	for label, token := range tokens {
+		vehicleService.SetAccountToken(label, token)
+	}
+
 	// 设置 WebSocket Hub 的初始数据提供者
 	wsHub.SetInitDataProvider(func() *ws.InitData {
 		cars, err := vehicleService.GetCars(ctx)
@@ -99,27 +161,42 @@ func main() {
 			return nil
 		}
 		states := vehicleService.GetAllStates()
+		vehicleService.RedactCoordinates(ctx, states)
 		return &ws.InitData{
 			Cars:   cars,
 			States: states,
 		}
 	})
 
-	// 启动车辆服务（如果已认证）
-	if teslaClient.GetToken() != nil {
+	// 设置单车辆当前状态提供者 (SSE 订阅连接时发送)
+	wsHub.SetCarStateProvider(func(carID int64) interface{} {
+		vs, ok := vehicleService.GetState(carID)
+		if !ok {
+			return nil
+		}
+		vehicleService.RedactCoordinates(ctx, vs)
+		return vs
+	})
+
+	// 启动车辆服务（如果已认证），失败时在后台按退避间隔重试
+	if vehicleService.HasAccounts() {
 		if err := vehicleService.Start(ctx); err != nil {
 			logger.Error("Failed to start vehicle service", zap.Error(err))
+			go retryStart(ctx, vehicleService, cfg, logger)
 		}
 	}
 
 	// 创建 HTTP 处理器
 	handler := handlers.NewHandler(
 		logger,
+		db,
 		carRepo,
 		driveRepo,
 		chargeRepo,
 		posRepo,
 		parkingRepo,
+		tripRepo,
+		geofenceRepo,
 		vehicleService,
 		wsHub,
 	)
@@ -131,15 +208,18 @@ func main() {
 
 	// 创建路由
 	router := gin.New()
+	router.Use(requestLogger(logger, cfg.RequestLogSkip))
 	router.Use(gin.Recovery())
-	router.Use(corsMiddleware())
+	router.Use(corsMiddleware(cfg.CORSAllowedOrigins, cfg.Debug))
 
 	// 注册路由
 	handler.RegisterRoutes(router)
 
 	// 添加认证路由
+	// account 为账号标识，用于同时记录多个 Tesla 账号下的车辆，默认 default
 	router.POST("/api/auth/token", func(c *gin.Context) {
 		var req struct {
+			Account      string `json:"account"`
 			AccessToken  string `json:"access_token"`
 			RefreshToken string `json:"refresh_token"`
 		}
@@ -155,43 +235,46 @@ func main() {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "access_token is required"})
 			return
 		}
+		if req.Account == "" {
+			req.Account = service.DefaultAccount
+		}
+		if len(req.Account) > 64 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "account must be at most 64 characters"})
+			return
+		}
+
+		// 有效期取自访问令牌 (JWT)，无法解析时按 8 小时
+		token := tesla.NewToken(strings.TrimSpace(req.AccessToken), strings.TrimSpace(req.RefreshToken))
 
 		logger.Info("Received auth token request",
-			zap.Int("access_token_len", len(req.AccessToken)),
-			zap.Int("refresh_token_len", len(req.RefreshToken)),
+			zap.String("account", req.Account),
+			zap.Int("access_token_len", len(token.AccessToken)),
+			zap.Int("refresh_token_len", len(token.RefreshToken)),
+			zap.Time("access_token_expires_at", token.ExpiresAt()),
 		)
 
-		token := &tesla.Token{
-			AccessToken:  req.AccessToken,
-			RefreshToken: req.RefreshToken,
-			CreatedAt:    time.Now(),
-			ExpiresIn:    3600 * 8, // 8 小时
-		}
-		teslaClient.SetToken(token)
-
-		// 如果提供了 Refresh Token，先尝试刷新获取新的 Access Token
-		if req.RefreshToken != "" {
-			logger.Info("Attempting to refresh access token using refresh token...")
-			if err := teslaClient.RefreshToken(ctx); err != nil {
-				logger.Warn("Failed to refresh token, will try with provided access token",
-					zap.Error(err),
-				)
-			} else {
-				logger.Info("Successfully refreshed access token")
-				token = teslaClient.GetToken()
-			}
+		// 先校验 Token，无效时直接返回明确的错误，不替换已有账号的 Token
+		verified, err := vehicleService.VerifyToken(c.Request.Context(), token)
+		if err != nil {
+			logger.Warn("Rejected auth token", zap.String("account", req.Account), zap.Error(err))
+			status, msg := tokenErrorResponse(err)
+			c.JSON(status, gin.H{"error": msg})
+			return
 		}
+		token = verified
+
+		// 设置 Token 并同步该账号的车辆（服务未运行时启动服务）
+		logger.Info("Starting vehicle service...", zap.String("account", req.Account))
+		startErr := vehicleService.AddAccount(ctx, req.Account, token)
 
 		// 保存 token
-		if err := saveToken(cfg.TokenFile, token); err != nil {
+		if err := saveTokens(cfg.TokenFile, vehicleService.AccountTokens()); err != nil {
 			logger.Error("Failed to save token", zap.Error(err))
 		} else {
 			logger.Info("Token saved to file", zap.String("file", cfg.TokenFile))
 		}
 
-		// 启动服务
-		logger.Info("Starting vehicle service...")
-		if err := vehicleService.Start(ctx); err != nil {
+		if err := startErr; err != nil {
 			logger.Error("Failed to start vehicle service",
 				zap.Error(err),
 				zap.String("error_detail", fmt.Sprintf("%+v", err)),
@@ -211,14 +294,20 @@ func main() {
 
 		logger.Info("Vehicle service started successfully")
 
-		c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Authentication successful, syncing vehicles..."})
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "account": req.Account, "message": "Authentication successful, syncing vehicles..."})
 	})
 
 	// 启动 HTTP 服务器
+	// /ws 升级后和 SSE 连接会清除读写截止时间，WriteTimeout 只约束普通 HTTP 请求
 	server := &http.Server{
-		Addr:    ":" + cfg.ServerPort,
-		Handler: router,
+		Addr:         ":" + cfg.ServerPort,
+		Handler:      router,
+		ReadTimeout:  cfg.HTTPReadTimeout,
+		WriteTimeout: cfg.HTTPWriteTimeout,
+		IdleTimeout:  cfg.HTTPIdleTimeout,
 	}
+	// 关闭时断开 WebSocket 和 SSE 长连接，避免 Shutdown 等待到超时
+	server.RegisterOnShutdown(wsHub.DisconnectAll)
 
 	go func() {
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -239,8 +328,8 @@ func main() {
 	vehicleService.Stop()
 
 	// 保存 token
-	if token := teslaClient.GetToken(); token != nil {
-		saveToken(cfg.TokenFile, token)
+	if tokens := vehicleService.AccountTokens(); len(tokens) > 0 {
+		saveTokens(cfg.TokenFile, tokens)
 	}
 
 	// 优雅关闭
@@ -254,24 +343,137 @@ func main() {
 	logger.Info("Server exited")
 }
 
+// tokenErrorResponse 将 Token 校验失败的原因转换为 HTTP 状态码和提示
+func tokenErrorResponse(err error) (int, string) {
+	switch {
+	case errors.Is(err, service.ErrTokenExpired):
+		return http.StatusBadRequest, "Access Token 已过期，请重新获取或同时提交 Refresh Token"
+	case errors.Is(err, tesla.ErrUnauthorized), errors.Is(err, tesla.ErrRefreshRejected):
+		return http.StatusBadRequest, "Token 已过期或无效，请重新获取"
+	case errors.Is(err, tesla.ErrForbidden):
+		return http.StatusBadRequest, "Token 权限不足"
+	case errors.Is(err, tesla.ErrRateLimited):
+		return http.StatusTooManyRequests, "Tesla API 请求过于频繁，请稍后重试"
+	default:
+		return http.StatusBadGateway, "无法连接 Tesla API，请检查网络"
+	}
+}
+
+// maxStartupRetryBackoff 启动重试的最长等待时间
+const maxStartupRetryBackoff = 5 * time.Minute
+
+// retryStart 按 STARTUP_RETRY_ATTEMPTS / STARTUP_RETRY_BACKOFF 重试启动车辆服务
+// 期间通过 /api/auth/token 重新认证启动成功时 Start 直接返回，重试随之结束
+func retryStart(ctx context.Context, vehicleService *service.VehicleService, cfg *config.Config, logger *zap.Logger) {
+	backoff := cfg.StartupRetryBackoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+
+	for attempt := 1; attempt <= cfg.StartupRetryAttempts; attempt++ {
+		logger.Info("Retrying vehicle service start",
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", cfg.StartupRetryAttempts),
+			zap.Duration("backoff", backoff))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		err := vehicleService.Start(ctx)
+		if err == nil {
+			logger.Info("Vehicle service started after retry", zap.Int("attempt", attempt))
+			return
+		}
+		logger.Error("Failed to start vehicle service", zap.Int("attempt", attempt), zap.Error(err))
+
+		backoff *= 2
+		if backoff > maxStartupRetryBackoff {
+			backoff = maxStartupRetryBackoff
+		}
+	}
+
+	if cfg.StartupRetryAttempts > 0 {
+		logger.Error("Giving up starting vehicle service, please re-submit the token via /api/auth/token",
+			zap.Int("attempts", cfg.StartupRetryAttempts))
+	}
+}
+
 // initLogger 初始化日志
-func initLogger(debug bool) *zap.Logger {
-	var config zap.Config
-	if debug {
-		config = zap.NewDevelopmentConfig()
-		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+// 配置了 LOG_FILE 时以 JSON 格式写入文件并按大小轮转；
+// 标准输出在未配置文件、debug 模式或 LOG_STDOUT=true 时启用
+func initLogger(cfg *config.Config) (*zap.Logger, func()) {
+	level := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	if cfg.Debug {
+		level.SetLevel(zapcore.DebugLevel)
+	}
+
+	var cores []zapcore.Core
+	cleanup := func() {}
+
+	if cfg.LogFile == "" || cfg.Debug || cfg.LogStdout {
+		var encoder zapcore.Encoder
+		if cfg.Debug {
+			encCfg := zap.NewDevelopmentEncoderConfig()
+			encCfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+			encoder = zapcore.NewConsoleEncoder(encCfg)
+		} else {
+			encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+		}
+		cores = append(cores, zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), level))
+	}
+
+	if cfg.LogFile != "" {
+		writer := &lumberjack.Logger{
+			Filename:   cfg.LogFile,
+			MaxSize:    cfg.LogMaxSizeMB,
+			MaxBackups: cfg.LogMaxBackups,
+		}
+		encCfg := zap.NewProductionEncoderConfig()
+		encCfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		cores = append(cores, zapcore.NewCore(zapcore.NewJSONEncoder(encCfg), zapcore.AddSync(writer), level))
+		cleanup = func() { writer.Close() }
+	}
+
+	opts := []zap.Option{zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr))}
+	if cfg.Debug {
+		opts = append(opts, zap.Development(), zap.AddStacktrace(zapcore.WarnLevel))
 	} else {
-		config = zap.NewProductionConfig()
+		opts = append(opts, zap.AddStacktrace(zapcore.ErrorLevel))
 	}
 
-	logger, _ := config.Build()
-	return logger
+	return zap.New(zapcore.NewTee(cores...), opts...), cleanup
 }
 
 // corsMiddleware CORS 中间件
-func corsMiddleware() gin.HandlerFunc {
+// 请求的 Origin 在 allowed 中时回显该来源并允许携带凭据；allowed 包含 * 时其他来源得到通配符 (不允许凭据)
+// allowed 为空时 debug 模式允许任意来源，否则不发送 CORS 响应头
+func corsMiddleware(allowed []string, debug bool) gin.HandlerFunc {
+	origins := make(map[string]bool, len(allowed))
+	allowAny := len(allowed) == 0 && debug
+	for _, origin := range allowed {
+		if origin == "*" {
+			allowAny = true
+			continue
+		}
+		origins[strings.ToLower(strings.TrimSuffix(origin, "/"))] = true
+	}
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		origin := c.GetHeader("Origin")
+		switch {
+		case origin != "" && origins[strings.ToLower(origin)]:
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		case allowAny:
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
+		if len(origins) > 0 {
+			// 响应随 Origin 变化，避免缓存把一个来源的响应头返回给另一个来源
+			c.Header("Vary", "Origin")
+		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
 
@@ -284,25 +486,73 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// loadToken 加载 token
-func loadToken(filename string, client *tesla.Client) error {
+// requestLogger 请求日志中间件，记录方法、路径、状态码、耗时和客户端 IP
+// 2xx/3xx 为 debug 级别，4xx 为 warn，5xx 为 error；skip 中的路径 (请求路径或路由模式) 不记录
+// 需在 Recovery 之前注册，panic 恢复后返回的 500 也会被记录
+func requestLogger(logger *zap.Logger, skip []string) gin.HandlerFunc {
+	skipped := make(map[string]bool, len(skip))
+	for _, path := range skip {
+		skipped[path] = true
+	}
+
+	return func(c *gin.Context) {
+		if skipped[c.Request.URL.Path] || skipped[c.FullPath()] {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.String("method", c.Request.Method),
+			zap.String("path", c.Request.URL.Path),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("client_ip", c.ClientIP()),
+		}
+		if route := c.FullPath(); route != "" && route != c.Request.URL.Path {
+			fields = append(fields, zap.String("route", route))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+
+		switch {
+		case status >= http.StatusInternalServerError:
+			logger.Error("HTTP request", fields...)
+		case status >= http.StatusBadRequest:
+			logger.Warn("HTTP request", fields...)
+		default:
+			logger.Debug("HTTP request", fields...)
+		}
+	}
+}
+
+// loadTokens 加载各账号的 token (account -> token)
+// 兼容旧版单账号格式，旧 token 归属默认账号
+func loadTokens(filename string) (map[string]*tesla.Token, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	var token tesla.Token
-	if err := json.Unmarshal(data, &token); err != nil {
-		return err
+	var legacy tesla.Token
+	if err := json.Unmarshal(data, &legacy); err == nil && legacy.AccessToken != "" {
+		return map[string]*tesla.Token{service.DefaultAccount: &legacy}, nil
 	}
 
-	client.SetToken(&token)
-	return nil
+	var tokens map[string]*tesla.Token
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, err
+	}
+	return tokens, nil
 }
 
-// saveToken 保存 token
-func saveToken(filename string, token *tesla.Token) error {
-	data, err := json.MarshalIndent(token, "", "  ")
+// saveTokens 保存各账号的 token
+func saveTokens(filename string, tokens map[string]*tesla.Token) error {
+	data, err := json.MarshalIndent(tokens, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/langchou/tesgazer/internal/config"
+	"github.com/langchou/tesgazer/internal/importer"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// tesla-export-import 从 Tesla 账户数据导出 (CSV/JSON) 导入历史行程和充电
+// 目标数据库使用与服务相同的 DATABASE_URL 配置，不带时区的时间按 TIMEZONE 解析
+func main() {
+	vin := flag.String("vin", "", "导入到的车辆 VIN (行中没有 VIN 列时使用，只有一辆车时可省略)")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: tesla-export-import [-vin <VIN>] <file or directory>...")
+		os.Exit(2)
+	}
+
+	// 加载配置
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("Failed to load config: %v\n", err)
+		os.Exit(1)
+	}
+
+	// 初始化日志
+	logger := initLogger(cfg.Debug)
+	defer logger.Sync()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 连接目标数据库
+	db, err := repository.New(ctx, cfg.DatabaseURL, repository.PoolConfig{
+		MaxConns:        cfg.DBMaxConns,
+		MinConns:        cfg.DBMinConns,
+		MaxConnLifetime: cfg.DBMaxConnLifetime,
+	})
+	if err != nil {
+		logger.Fatal("Failed to connect database", zap.Error(err))
+	}
+	defer db.Close()
+
+	// 确保目标表结构是最新的
+	if err := db.Migrate(ctx); err != nil {
+		logger.Fatal("Failed to migrate database", zap.Error(err))
+	}
+
+	carID, err := defaultCarID(ctx, repository.NewCarRepository(db), *vin)
+	if err != nil {
+		logger.Fatal("Failed to resolve vehicle", zap.Error(err))
+	}
+
+	logger.Info("Starting Tesla export import", zap.Strings("paths", flag.Args()), zap.Int64("car_id", carID))
+	start := time.Now()
+
+	im := importer.NewTeslaExportImporter(logger, db, cfg.Location, carID)
+	stats, err := im.Run(ctx, flag.Args())
+	if err != nil {
+		logger.Fatal("Import failed", zap.Error(err), zap.Any("stats", stats))
+	}
+
+	logger.Info("Import completed",
+		zap.Duration("elapsed", time.Since(start)),
+		zap.Int("files", stats.Files),
+		zap.Int("drives", stats.Drives),
+		zap.Int("charging_processes", stats.ChargingProcesses),
+		zap.Int("duplicates", stats.Duplicates),
+		zap.Int("skipped", stats.Skipped),
+	)
+}
+
+// defaultCarID 确定没有 VIN 列的行导入到的车辆
+// 指定 VIN 时车辆必须存在；未指定时数据库中只有一辆车则使用该车，否则返回 0 (跳过没有 VIN 的行)
+func defaultCarID(ctx context.Context, cars *repository.CarRepository, vin string) (int64, error) {
+	if vin != "" {
+		car, err := cars.GetByVIN(ctx, strings.ToUpper(vin))
+		if err != nil {
+			return 0, err
+		}
+		if car == nil {
+			return 0, fmt.Errorf("vehicle %s not found", vin)
+		}
+		return car.ID, nil
+	}
+
+	list, err := cars.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if len(list) == 1 {
+		return list[0].ID, nil
+	}
+	return 0, nil
+}
+
+// initLogger 初始化日志
+func initLogger(debug bool) *zap.Logger {
+	var config zap.Config
+	if debug {
+		config = zap.NewDevelopmentConfig()
+		config.EncoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+	} else {
+		config = zap.NewProductionConfig()
+	}
+
+	logger, _ := config.Build()
+	return logger
+}
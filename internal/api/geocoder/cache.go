@@ -0,0 +1,79 @@
+package geocoder
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// addressCache 带容量上限的 LRU 地址缓存
+// 超出容量时淘汰最久未使用的条目，常用地点 (家、公司) 会一直保留
+type addressCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List // 队首为最近使用
+	items    map[string]*list.Element
+}
+
+// cacheEntry 缓存条目
+type cacheEntry struct {
+	key     string
+	address *models.Address
+}
+
+// newAddressCache 创建 LRU 地址缓存
+func newAddressCache(capacity int) *addressCache {
+	return &addressCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get 获取缓存的地址，命中时标记为最近使用
+func (c *addressCache) get(key string) (*models.Address, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry).address, true
+}
+
+// put 写入缓存，超出容量时淘汰最久未使用的条目
+func (c *addressCache) put(key string, address *models.Address) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).address = address
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, address: address})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// clear 清空缓存
+func (c *addressCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+// len 当前缓存条目数
+func (c *addressCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
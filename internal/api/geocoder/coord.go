@@ -0,0 +1,76 @@
+package geocoder
+
+import "math"
+
+// 国测局坐标 (GCJ-02，"火星坐标") 偏移参数，基于克拉索夫斯基椭球
+const (
+	gcjSemiMajorAxis = 6378245.0              // 长半轴 (米)
+	gcjEccentricity  = 0.00669342162296594323 // 第一偏心率的平方
+)
+
+// outOfChina 坐标是否在中国大陆范围 (粗略矩形) 之外，范围外不做偏移
+func outOfChina(lat, lng float64) bool {
+	return lng < 72.004 || lng > 137.8347 || lat < 0.8293 || lat > 55.8271
+}
+
+// WGS84ToGCJ02 将 WGS-84 坐标 (GPS 原始坐标) 转换为 GCJ-02 坐标 (高德等国内地图使用)
+// 中国大陆范围外原样返回
+func WGS84ToGCJ02(lat, lng float64) (float64, float64) {
+	if outOfChina(lat, lng) {
+		return lat, lng
+	}
+	dLat, dLng := gcjOffset(lat, lng)
+	return lat + dLat, lng + dLng
+}
+
+// GCJ02ToWGS84 将 GCJ-02 坐标转换回 WGS-84 坐标 (迭代逼近，误差小于 1 厘米)
+// 中国大陆范围外原样返回
+func GCJ02ToWGS84(lat, lng float64) (float64, float64) {
+	if outOfChina(lat, lng) {
+		return lat, lng
+	}
+	wgsLat, wgsLng := lat, lng
+	for i := 0; i < 10; i++ {
+		gcjLat, gcjLng := WGS84ToGCJ02(wgsLat, wgsLng)
+		dLat, dLng := gcjLat-lat, gcjLng-lng
+		wgsLat -= dLat
+		wgsLng -= dLng
+		if math.Abs(dLat) < 1e-9 && math.Abs(dLng) < 1e-9 {
+			break
+		}
+	}
+	return wgsLat, wgsLng
+}
+
+// gcjOffset 计算 WGS-84 坐标在 GCJ-02 中的偏移量 (度)
+func gcjOffset(lat, lng float64) (float64, float64) {
+	x, y := lng-105.0, lat-35.0
+	dLat := transformLat(x, y)
+	dLng := transformLng(x, y)
+
+	radLat := lat / 180.0 * math.Pi
+	magic := math.Sin(radLat)
+	magic = 1 - gcjEccentricity*magic*magic
+	sqrtMagic := math.Sqrt(magic)
+	dLat = (dLat * 180.0) / ((gcjSemiMajorAxis * (1 - gcjEccentricity)) / (magic * sqrtMagic) * math.Pi)
+	dLng = (dLng * 180.0) / (gcjSemiMajorAxis / sqrtMagic * math.Cos(radLat) * math.Pi)
+	return dLat, dLng
+}
+
+// transformLat 纬度偏移的多项式和正弦项 (以 105°E, 35°N 为原点)
+func transformLat(x, y float64) float64 {
+	ret := -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(y*math.Pi) + 40.0*math.Sin(y/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (160.0*math.Sin(y/12.0*math.Pi) + 320*math.Sin(y*math.Pi/30.0)) * 2.0 / 3.0
+	return ret
+}
+
+// transformLng 经度偏移的多项式和正弦项 (以 105°E, 35°N 为原点)
+func transformLng(x, y float64) float64 {
+	ret := 300.0 + x + 2.0*y + 0.1*x*x + 0.1*x*y + 0.1*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(x*math.Pi) + 40.0*math.Sin(x/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (150.0*math.Sin(x/12.0*math.Pi) + 300.0*math.Sin(x/30.0*math.Pi)) * 2.0 / 3.0
+	return ret
+}
@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/langchou/tesgazer/internal/models"
 	"go.uber.org/zap"
@@ -22,38 +25,88 @@ type Client struct {
 	logger     *zap.Logger
 
 	// 缓存：避免重复请求相同坐标
-	cache   map[string]*models.Address
-	cacheMu sync.RWMutex
+	cache          *addressCache
+	cachePrecision int // 缓存 key 的经纬度小数位数
+
+	// 地址语言和格式
+	language     string // 地址语言 (如 zh-CN、en)
+	shortAddress bool   // formatted_address 只保留 "街道, 城市"
+
+	// 请求高德前将 WGS-84 坐标转换为 GCJ-02 (高德使用国测局坐标，直接传 GPS 坐标会偏移 50-500 米)
+	amapGCJ02 bool
 
 	// Nominatim 请求限流（每秒最多 1 次）
 	lastNominatimRequest time.Time
 	nominatimMu          sync.Mutex
 }
 
+// 缓存默认参数
+const (
+	DefaultCachePrecision = 4     // 小数点后 4 位，约 11 米
+	DefaultCacheSize      = 10000 // 最多缓存的地址数
+	maxCachePrecision     = 6     // 小数点后 6 位，约 0.1 米，与请求坐标精度一致
+)
+
+// DefaultLanguage 默认地址语言
+const DefaultLanguage = "zh-CN"
+
+// 地址格式
+const (
+	AddressFormatFull  = "full"  // 服务商返回的完整地址
+	AddressFormatShort = "short" // 只保留 "街道, 城市"
+)
+
 // NewClient 创建逆地理编码客户端
-func NewClient(amapAPIKey string, logger *zap.Logger) *Client {
+// cachePrecision 为缓存 key 的经纬度小数位数，cacheSize 为 LRU 缓存容量
+func NewClient(amapAPIKey string, cachePrecision, cacheSize int, logger *zap.Logger) *Client {
+	if cachePrecision < 0 || cachePrecision > maxCachePrecision {
+		cachePrecision = DefaultCachePrecision
+	}
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
 	return &Client{
 		amapAPIKey: amapAPIKey,
 		httpClient: &http.Client{
 			Timeout: 10 * time.Second,
 		},
-		logger: logger,
-		cache:  make(map[string]*models.Address),
+		logger:         logger,
+		cache:          newAddressCache(cacheSize),
+		cachePrecision: cachePrecision,
+		language:       DefaultLanguage,
+		amapGCJ02:      true,
+	}
+}
+
+// SetLanguage 设置地址语言 (Nominatim 的 accept-language，高德只支持中文和英文)，为空时使用默认的 zh-CN
+// 需在开始解析地址前调用
+func (c *Client) SetLanguage(language string) {
+	if language == "" {
+		language = DefaultLanguage
 	}
+	c.language = language
+}
+
+// SetAddressFormat 设置 formatted_address 的格式 (full 或 short)，需在开始解析地址前调用
+func (c *Client) SetAddressFormat(format string) {
+	c.shortAddress = format == AddressFormatShort
+}
+
+// SetAmapGCJ02 设置请求高德前是否将坐标转换为 GCJ-02 (默认开启，车辆上报的已是 GCJ-02 坐标时关闭)
+// 中国大陆范围外的坐标不做转换。需在开始解析地址前调用
+func (c *Client) SetAmapGCJ02(enabled bool) {
+	c.amapGCJ02 = enabled
 }
 
 // ReverseGeocode 逆地理编码：根据经纬度获取结构化地址
 func (c *Client) ReverseGeocode(ctx context.Context, lat, lng float64) (*models.Address, error) {
-	// 生成缓存 key（精确到小数点后4位，约11米精度）
-	cacheKey := fmt.Sprintf("%.4f,%.4f", lat, lng)
+	// 生成缓存 key（按配置的小数位数取整，默认 4 位约 11 米）
+	cacheKey := fmt.Sprintf("%.*f,%.*f", c.cachePrecision, lat, c.cachePrecision, lng)
 
 	// 检查缓存
-	c.cacheMu.RLock()
-	if addr, ok := c.cache[cacheKey]; ok {
-		c.cacheMu.RUnlock()
+	if addr, ok := c.cache.get(cacheKey); ok {
 		return addr, nil
 	}
-	c.cacheMu.RUnlock()
 
 	var address *models.Address
 	var err error
@@ -69,15 +122,14 @@ func (c *Client) ReverseGeocode(ctx context.Context, lat, lng float64) (*models.
 		return nil, err
 	}
 
-	// 存入缓存
-	c.cacheMu.Lock()
-	c.cache[cacheKey] = address
-	// 限制缓存大小
-	if len(c.cache) > 10000 {
-		c.cache = make(map[string]*models.Address)
-		c.cache[cacheKey] = address
+	if c.shortAddress {
+		if short := shortFormattedAddress(address); short != "" {
+			address.FormattedAddress = short
+		}
 	}
-	c.cacheMu.Unlock()
+
+	// 存入缓存（超出容量时淘汰最久未使用的地址）
+	c.cache.put(cacheKey, address)
 
 	return address, nil
 }
@@ -121,14 +173,22 @@ type AmapAddressComponent struct {
 }
 
 func (c *Client) reverseGeocodeAmap(ctx context.Context, lat, lng float64) (*models.Address, error) {
-	// 高德 API 要求经度在前，纬度在后
-	location := fmt.Sprintf("%.6f,%.6f", lng, lat)
+	// 高德 API 要求经度在前，纬度在后，坐标系为 GCJ-02
+	amapLat, amapLng := lat, lng
+	if c.amapGCJ02 {
+		amapLat, amapLng = WGS84ToGCJ02(lat, lng)
+	}
+	location := fmt.Sprintf("%.6f,%.6f", amapLng, amapLat)
 
 	apiURL := fmt.Sprintf(
 		"https://restapi.amap.com/v3/geocode/regeo?key=%s&location=%s&extensions=base&output=JSON",
 		url.QueryEscape(c.amapAPIKey),
 		url.QueryEscape(location),
 	)
+	// 高德只支持中文 (默认) 和英文，非中文语言均请求英文地址
+	if !strings.HasPrefix(strings.ToLower(c.language), "zh") {
+		apiURL += "&language=en"
+	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
 	if err != nil {
@@ -210,8 +270,8 @@ func (c *Client) reverseGeocodeNominatim(ctx context.Context, lat, lng float64)
 	c.nominatimMu.Unlock()
 
 	apiURL := fmt.Sprintf(
-		"https://nominatim.openstreetmap.org/reverse?lat=%.6f&lon=%.6f&format=json&accept-language=zh-CN",
-		lat, lng,
+		"https://nominatim.openstreetmap.org/reverse?lat=%.6f&lon=%.6f&format=json&accept-language=%s",
+		lat, lng, url.QueryEscape(c.language),
 	)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
@@ -267,6 +327,39 @@ func (c *Client) reverseGeocodeNominatim(ctx context.Context, lat, lng float64)
 
 // ============ 工具函数 ============
 
+// shortFormattedAddress 生成 "街道, 城市" 格式的简短地址
+// 没有城市时依次使用区县、省份；街道和城市都没有时返回空字符串 (保留完整地址)
+func shortFormattedAddress(a *models.Address) string {
+	street := joinStreet(a.Street, a.StreetNumber)
+	city := a.City
+	if city == "" {
+		city = a.District
+	}
+	if city == "" {
+		city = a.Province
+	}
+
+	var parts []string
+	for _, p := range []string{street, city} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// joinStreet 拼接道路和门牌号：中文地址直接相连 (如 "建国路88号")，其他语言以空格分隔
+func joinStreet(street, number string) string {
+	if street == "" || number == "" {
+		return street + number
+	}
+	last, _ := utf8.DecodeLastRuneInString(street)
+	if unicode.Is(unicode.Han, last) {
+		return street + number
+	}
+	return street + " " + number
+}
+
 func interfaceToString(v interface{}) string {
 	if v == nil {
 		return ""
@@ -281,14 +374,10 @@ func interfaceToString(v interface{}) string {
 
 // ClearCache 清空缓存
 func (c *Client) ClearCache() {
-	c.cacheMu.Lock()
-	c.cache = make(map[string]*models.Address)
-	c.cacheMu.Unlock()
+	c.cache.clear()
 }
 
 // CacheSize 获取缓存大小
 func (c *Client) CacheSize() int {
-	c.cacheMu.RLock()
-	defer c.cacheMu.RUnlock()
-	return len(c.cache)
+	return c.cache.len()
 }
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/service"
+)
+
+// requireAdminToken 管理接口认证中间件
+// 需携带 Authorization: Bearer <token>；未配置 ADMIN_TOKEN 时管理接口一律拒绝访问
+func (h *Handler) requireAdminToken(c *gin.Context) {
+	token := h.vehicleService.AdminToken()
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin API is disabled, set ADMIN_TOKEN to enable"})
+		return
+	}
+	auth := c.GetHeader("Authorization")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid admin token"})
+		return
+	}
+	c.Next()
+}
+
+// GetAdminInfo 获取诊断信息
+// GET /api/admin/info
+// 返回生效的配置 (令牌和 API Key 已隐藏)、构建信息以及各车辆的状态、轮询间隔和推送连接状态
+func (h *Handler) GetAdminInfo(c *gin.Context) {
+	info, err := h.vehicleService.GetAdminInfo(c.Request.Context())
+	if err != nil {
+		h.logger.Error("Failed to get admin info", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get admin info"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": info})
+}
+
+// SyncVehicles 重新同步 Tesla 账号下的车辆列表
+// POST /api/admin/sync-vehicles
+// 账号下新增车辆后无需重启即可开始记录，返回同步到的全部车辆
+func (h *Handler) SyncVehicles(c *gin.Context) {
+	cars, err := h.vehicleService.SyncVehicles(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrNotAuthenticated) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not authenticated"})
+			return
+		}
+		h.logger.Error("Failed to sync vehicles", zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to sync vehicles from Tesla"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": cars})
+}
+
+// PrunePositions 手动触发位置数据清理
+// POST /api/admin/prune-positions?days=90
+// 未指定 days 时使用 POSITION_RETENTION_DAYS，清理在后台执行，结果见日志
+func (h *Handler) PrunePositions(c *gin.Context) {
+	days := h.vehicleService.PositionRetentionDays()
+	if d := c.Query("days"); d != "" {
+		v, err := strconv.Atoi(d)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid days"})
+			return
+		}
+		days = v
+	}
+	if days <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Retention is disabled, specify days > 0"})
+		return
+	}
+
+	if err := h.vehicleService.TriggerPrunePositions(days); err != nil {
+		if errors.Is(err, service.ErrPruneInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to prune positions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to prune positions"})
+		return
+	}
+
+	h.logger.Info("Position pruning triggered via API", zap.Int("retention_days", days))
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":        "Position pruning started",
+		"retention_days": days,
+	})
+}
+
+// TriggerGeocodeBackfill 为缺少地址的记录补全逆地理编码
+// POST /api/admin/geocode-backfill?type=drives,charges,parkings
+// 未指定 type 时处理全部类型，任务在后台执行，进度通过 GET 查询
+func (h *Handler) TriggerGeocodeBackfill(c *gin.Context) {
+	types := []string{service.BackfillDrives, service.BackfillCharges, service.BackfillParkings}
+	if t := c.Query("type"); t != "" {
+		types = strings.Split(t, ",")
+	}
+
+	status, err := h.vehicleService.TriggerGeocodeBackfill(types)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidBackfillType):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrBackfillInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			h.logger.Error("Failed to start geocode backfill", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start geocode backfill"})
+		}
+		return
+	}
+
+	h.logger.Info("Geocode backfill triggered via API", zap.Strings("types", types))
+	c.JSON(http.StatusAccepted, gin.H{"data": status})
+}
+
+// GetGeocodeBackfillStatus 获取地址补全任务进度
+// GET /api/admin/geocode-backfill
+func (h *Handler) GetGeocodeBackfillStatus(c *gin.Context) {
+	status := h.vehicleService.GetGeocodeBackfillStatus()
+	if status == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geocode backfill has not been run"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": status})
+}
+
+// CancelGeocodeBackfill 取消正在执行的地址补全任务
+// DELETE /api/admin/geocode-backfill
+func (h *Handler) CancelGeocodeBackfill(c *gin.Context) {
+	if err := h.vehicleService.CancelGeocodeBackfill(); err != nil {
+		if errors.Is(err, service.ErrNoBackfillRunning) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel geocode backfill"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Geocode backfill cancelled"})
+}
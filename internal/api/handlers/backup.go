@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/importer"
+)
+
+// ExportCarFull 导出车辆的全部数据 (NDJSON 备份文件)
+// GET /api/cars/:id/export/full?from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z
+// from/to 可选 (RFC3339)，数据边查询边写出，可用于导出大量位置记录
+func (h *Handler) ExportCarFull(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	var from, to *time.Time
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC3339"})
+			return
+		}
+		from = &t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC3339"})
+			return
+		}
+		to = &t
+	}
+
+	car, err := h.carRepo.GetByID(c.Request.Context(), carID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	// 导出可能持续较长时间，不受 HTTP_WRITE_TIMEOUT 限制
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("tesgazer-%s-%s.ndjson", car.VIN, time.Now().In(h.vehicleService.Location()).Format("20060102"))
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	exporter := importer.NewBackupExporter(h.db)
+	stats, err := exporter.Export(c.Request.Context(), c.Writer, car, from, to, c.Writer.Flush)
+	if err != nil {
+		// 响应头已发出，只能中断输出；文件缺少 footer，导入时会被识别为不完整
+		h.logger.Error("Failed to export car", zap.Error(err), zap.Int64("car_id", carID))
+		return
+	}
+
+	h.logger.Info("Exported car backup", zap.Int64("car_id", carID), zap.Any("counts", stats))
+}
+
+// ImportCarFull 从备份文件导入车辆数据
+// POST /api/admin/import/full (请求体为导出的 NDJSON 文件)
+// 车辆按 VIN 匹配，不存在时创建；已存在的记录会跳过，可重复导入
+func (h *Handler) ImportCarFull(c *gin.Context) {
+	// 备份文件可能很大，不受 HTTP_READ_TIMEOUT/HTTP_WRITE_TIMEOUT 限制
+	rc := http.NewResponseController(c.Writer)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	im := importer.NewBackupImporter(h.logger, h.db, 0)
+	result, err := im.Run(c.Request.Context(), c.Request.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, importer.ErrBackupMissingHeader), errors.Is(err, importer.ErrBackupUnsupportedVersion):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, importer.ErrBackupIncomplete):
+			// 已读取的记录已导入，返回结果供确认
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "data": result})
+		default:
+			h.logger.Error("Failed to import backup", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import backup", "data": result})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
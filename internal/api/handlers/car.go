@@ -1,11 +1,20 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+	"github.com/langchou/tesgazer/internal/service"
 )
 
 // ListCars 获取车辆列表
@@ -37,6 +46,78 @@ func (h *Handler) GetCar(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"data": car})
 }
 
+// displayColorPattern 显示颜色格式: #RGB、#RRGGBB 或 #RRGGBBAA
+var displayColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+
+// maxIconLength 图标标识的最大长度
+const maxIconLength = 64
+
+// UpdateCarRequest 更新车辆显示设置请求，未提供的字段保持不变，空字符串表示清除
+type UpdateCarRequest struct {
+	DisplayColor *string `json:"display_color"` // 显示颜色，如 #E31937
+	Icon         *string `json:"icon"`          // 图标标识，由前端解释
+}
+
+// UpdateCar 更新车辆的显示颜色和图标
+// PUT /api/cars/:id
+// 只保存用户设置，不影响从 Tesla 同步的 exterior_color 等字段
+func (h *Handler) UpdateCar(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	var req UpdateCarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	car, err := h.carRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	if req.DisplayColor != nil {
+		color := strings.TrimSpace(*req.DisplayColor)
+		if color != "" && !displayColorPattern.MatchString(color) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "display_color must be a hex color like #RRGGBB"})
+			return
+		}
+		car.DisplayColor = color
+	}
+	if req.Icon != nil {
+		icon := strings.TrimSpace(*req.Icon)
+		if len(icon) > maxIconLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "icon is too long"})
+			return
+		}
+		car.Icon = icon
+	}
+
+	found, err := h.carRepo.UpdateDisplay(c.Request.Context(), id, car.DisplayColor, car.Icon)
+	if err != nil {
+		h.logger.Error("Failed to update car", zap.Error(err), zap.Int64("car_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update car"})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	car, err = h.carRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to get updated car", zap.Error(err), zap.Int64("car_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get car"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": car})
+}
+
 // GetCarState 获取车辆实时状态
 func (h *Handler) GetCarState(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
@@ -50,10 +131,65 @@ func (h *Handler) GetCarState(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Car state not found"})
 		return
 	}
+	h.vehicleService.RedactCoordinates(c.Request.Context(), state)
 
 	c.JSON(http.StatusOK, gin.H{"data": state})
 }
 
+// GetPollStatus 获取车辆轮询状态
+// GET /api/cars/:id/poll-status
+// 返回轮询间隔、退避级别、限流冷却和 Streaming 连接状态，用于排查车辆为何没有更新
+func (h *Handler) GetPollStatus(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	status, err := h.vehicleService.GetPollStatus(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": status})
+}
+
+// 状态历史返回条数
+const (
+	defaultStateHistoryLimit = 50
+	maxStateHistoryLimit     = 500
+)
+
+// GetStateHistory 获取车辆最近的状态变化 (在线、休眠、驾驶、充电等)
+// GET /api/cars/:id/state-history?limit=50
+// 按开始时间倒序，未结束的状态时长计算到当前时间
+func (h *Handler) GetStateHistory(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	limit := defaultStateHistoryLimit
+	if s := c.Query("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxStateHistoryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit, expected 1-500"})
+			return
+		}
+	}
+
+	states, err := h.vehicleService.GetStateHistory(c.Request.Context(), id, limit)
+	if err != nil {
+		h.logger.Error("Failed to get state history", zap.Error(err), zap.Int64("car_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get state history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": states})
+}
+
 // SuspendLogging 暂停日志记录
 // POST /api/cars/:id/suspend
 // 手动暂停车辆的日志记录，允许车辆进入休眠以减少吸血鬼功耗
@@ -114,7 +250,7 @@ func (h *Handler) GetCarStats(c *gin.Context) {
 		return
 	}
 
-	driveCount, _ := h.driveRepo.CountByCarID(c.Request.Context(), carID)
+	driveCount, _ := h.driveRepo.CountByCarID(c.Request.Context(), carID, repository.DriveFilter{})
 	chargeCount, _ := h.chargeRepo.CountProcessesByCarID(c.Request.Context(), carID)
 
 	c.JSON(http.StatusOK, gin.H{
@@ -125,3 +261,229 @@ func (h *Handler) GetCarStats(c *gin.Context) {
 		},
 	})
 }
+
+// GetBatteryHealth 按充电记录估算电池健康度
+// GET /api/cars/:id/battery-health
+func (h *Handler) GetBatteryHealth(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	health, err := h.vehicleService.GetBatteryHealth(c.Request.Context(), carID)
+	if err != nil {
+		h.logger.Error("Failed to get battery health", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get battery health"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": health})
+}
+
+// odometerGranularities 里程表汇总支持的周期及其最短时长 (用于限制周期数)
+var odometerGranularities = map[string]time.Duration{
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 28 * 24 * time.Hour,
+}
+
+// maxOdometerPoints 里程表汇总最多返回的周期数
+const maxOdometerPoints = 1000
+
+// GetOdometerHistory 获取里程表随时间变化 (每个周期的读数和行驶里程)
+// GET /api/cars/:id/odometer?from=&to=&granularity=day
+// 默认最近 90 天，granularity: day (默认), week, month
+func (h *Handler) GetOdometerHistory(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", "day")
+	period, ok := odometerGranularities[granularity]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid granularity, expected day, week or month"})
+		return
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -90)
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC3339"})
+			return
+		}
+		from = t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC3339"})
+			return
+		}
+		to = t
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+	if to.Sub(from)/period >= maxOdometerPoints {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time range too large for granularity"})
+		return
+	}
+
+	history, err := h.vehicleService.GetOdometerHistory(c.Request.Context(), carID, from, to, granularity)
+	if err != nil {
+		h.logger.Error("Failed to get odometer history", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get odometer history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": history})
+}
+
+// GetRawVehicleData 获取 Tesla 返回的 vehicle_data (调试用)
+// GET /api/cars/:id/raw?wake=true&raw=true
+// 默认返回最近一次轮询的缓存；wake=true 时实时请求 Tesla (会唤醒车辆)；raw=true 时附带原始 JSON
+func (h *Handler) GetRawVehicleData(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	car, err := h.carRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	wake := c.Query("wake") == "true"
+	h.logger.Info("Manual raw vehicle data fetch", zap.Int64("car_id", id), zap.Bool("wake", wake))
+
+	var result *service.RawVehicleData
+	if wake {
+		result, err = h.vehicleService.FetchVehicleData(c.Request.Context(), car)
+		if err != nil {
+			switch {
+			case errors.Is(err, tesla.ErrVehicleUnavailable):
+				c.JSON(http.StatusGatewayTimeout, gin.H{"error": "Vehicle did not wake up in time"})
+			case errors.Is(err, service.ErrNoAccount):
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No Tesla account for this car"})
+			default:
+				h.logger.Error("Failed to fetch raw vehicle data", zap.Error(err), zap.Int64("car_id", id))
+				c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to fetch vehicle data from Tesla"})
+			}
+			return
+		}
+	} else {
+		result = h.vehicleService.CachedVehicleData(id)
+		if result == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No cached vehicle data, pass wake=true to fetch from Tesla"})
+			return
+		}
+	}
+
+	resp := *result
+	if c.Query("raw") != "true" {
+		resp.Raw = nil
+	}
+	c.JSON(http.StatusOK, gin.H{"data": resp})
+}
+
+// GetFleetStats 获取所有车辆的汇总统计
+// GET /api/stats/fleet?period=month
+// period: day, week, month (默认), year, all，按 TIMEZONE 时区的自然日/周/月/年计算
+func (h *Handler) GetFleetStats(c *gin.Context) {
+	period := c.DefaultQuery("period", "month")
+	since, ok := periodStart(period, time.Now().In(h.vehicleService.Location()))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period, expected day, week, month, year or all"})
+		return
+	}
+
+	cars, err := h.carRepo.GetFleetStats(c.Request.Context(), since)
+	if err != nil {
+		h.logger.Error("Failed to get fleet stats", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get fleet stats"})
+		return
+	}
+
+	stats := &models.FleetStats{
+		Period: period,
+		Since:  since,
+		Cars:   cars,
+	}
+	if stats.Cars == nil {
+		stats.Cars = []*models.FleetCarStats{}
+	}
+	for _, car := range cars {
+		stats.Total.Add(car.FleetTotals)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+// GetMonthlyReport 获取车辆的月度汇总报告 (行程、充电、停车、最长行程、最常去的地点)
+// GET /api/cars/:id/reports/monthly?month=2024-01
+// month 按 TIMEZONE 时区解析，默认当月
+func (h *Handler) GetMonthlyReport(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	loc := h.vehicleService.Location()
+	month := time.Now().In(loc)
+	if m := c.Query("month"); m != "" {
+		t, err := time.ParseInLocation("2006-01", m, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+			return
+		}
+		month = t
+	}
+
+	if _, err := h.carRepo.GetByID(c.Request.Context(), carID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	report, err := h.vehicleService.MonthlyReport(c.Request.Context(), carID, month)
+	if err != nil {
+		h.logger.Error("Failed to get monthly report", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get monthly report"})
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), report)
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
+
+// periodStart 计算统计周期的开始时间 (按 now 所在时区)，all 返回 nil (不限制)
+func periodStart(period string, now time.Time) (*time.Time, bool) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	var start time.Time
+	switch period {
+	case "day":
+		start = today
+	case "week":
+		// 周一为一周的开始
+		offset := (int(today.Weekday()) + 6) % 7
+		start = today.AddDate(0, 0, -offset)
+	case "month":
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	case "year":
+		start = time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+	case "all":
+		return nil, true
+	default:
+		return nil, false
+	}
+	return &start, true
+}
@@ -1,11 +1,17 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/service"
 )
 
 // ListCharges 获取充电列表
@@ -16,18 +22,12 @@ func (h *Handler) ListCharges(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
 	}
 
-	offset := (page - 1) * perPage
-
-	charges, err := h.chargeRepo.ListProcessesByCarID(c.Request.Context(), carID, perPage, offset)
+	charges, err := h.chargeRepo.ListProcessesByCarID(c.Request.Context(), carID, page.after, page.perPage, page.offset())
 	if err != nil {
 		h.logger.Error("Failed to list charges", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list charges"})
@@ -35,15 +35,13 @@ func (h *Handler) ListCharges(c *gin.Context) {
 	}
 
 	total, _ := h.chargeRepo.CountProcessesByCarID(c.Request.Context(), carID)
+	h.vehicleService.RedactCoordinates(c.Request.Context(), charges)
 
-	c.JSON(http.StatusOK, gin.H{
-		"data": charges,
-		"pagination": gin.H{
-			"page":     page,
-			"per_page": perPage,
-			"total":    total,
-		},
-	})
+	var lastStart time.Time
+	if len(charges) > 0 {
+		lastStart = charges[len(charges)-1].StartTime
+	}
+	c.JSON(http.StatusOK, page.response(charges, len(charges), lastStart, total))
 }
 
 // GetCharge 获取充电详情
@@ -60,6 +58,7 @@ func (h *Handler) GetCharge(c *gin.Context) {
 		return
 	}
 
+	h.vehicleService.RedactCoordinates(c.Request.Context(), charge)
 	c.JSON(http.StatusOK, gin.H{"data": charge})
 }
 
@@ -80,3 +79,333 @@ func (h *Handler) GetChargeDetails(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"data": charges})
 }
+
+// DeleteCharge 删除充电记录及其充电详情 (用于清理误判的短暂充电)
+// DELETE /api/charges/:id
+func (h *Handler) DeleteCharge(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid charge ID"})
+		return
+	}
+
+	charge, err := h.chargeRepo.GetProcessByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charge not found"})
+		return
+	}
+
+	if err := h.vehicleService.DeleteCharge(c.Request.Context(), charge); err != nil {
+		switch {
+		case errors.Is(err, service.ErrChargeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Charge not found"})
+			return
+		case errors.Is(err, service.ErrChargeInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "Charge is still in progress"})
+			return
+		}
+		h.logger.Error("Failed to delete charge", zap.Error(err), zap.Int64("charging_process_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete charge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"deleted": id}})
+}
+
+// MergeChargeRequest 合并充电记录请求
+type MergeChargeRequest struct {
+	WithID int64 `json:"with_id" binding:"required"` // 相邻的另一条充电记录
+}
+
+// MergeCharge 合并相邻的两条充电记录 (充电中断后重新开始被记录为两次充电时)
+// POST /api/charges/:id/merge
+func (h *Handler) MergeCharge(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid charge ID"})
+		return
+	}
+
+	var req MergeChargeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "with_id is required"})
+		return
+	}
+
+	charge, err := h.chargeRepo.GetProcessByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charge not found"})
+		return
+	}
+	other, err := h.chargeRepo.GetProcessByID(c.Request.Context(), req.WithID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charge to merge with not found"})
+		return
+	}
+
+	merged, err := h.vehicleService.MergeCharges(c.Request.Context(), charge, other)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrChargeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Charge not found"})
+		case errors.Is(err, service.ErrChargeInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "Charge is still in progress"})
+		case errors.Is(err, service.ErrChargesNotAdjacent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Charges must belong to the same car with no other charge between them"})
+		default:
+			h.logger.Error("Failed to merge charges", zap.Error(err), zap.Int64("charging_process_id", id), zap.Int64("with_id", req.WithID))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge charges"})
+		}
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), merged)
+	c.JSON(http.StatusOK, gin.H{"data": merged})
+}
+
+// GetChargeCurve 获取充电曲线 (功率 vs 电量)
+// GET /api/cars/:id/charges/:chargeId/curve?bucket=1
+// 按电量分桶聚合充电采样，便于对比不同充电过程的功率曲线
+func (h *Handler) GetChargeCurve(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	chargeID, err := strconv.ParseInt(c.Param("chargeId"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid charge ID"})
+		return
+	}
+
+	bucket, err := strconv.Atoi(c.DefaultQuery("bucket", "1"))
+	if err != nil || bucket < 1 || bucket > 50 || 100%bucket != 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bucket size, must divide 100 (e.g. 1, 5, 10)"})
+		return
+	}
+
+	cp, err := h.chargeRepo.GetProcessByID(c.Request.Context(), chargeID)
+	if err != nil || cp.CarID != carID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Charge not found"})
+		return
+	}
+
+	curve, err := h.chargeRepo.GetChargeCurve(c.Request.Context(), chargeID, bucket)
+	if err != nil {
+		h.logger.Error("Failed to get charge curve", zap.Error(err), zap.Int64("charging_process_id", chargeID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get charge curve"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": curve})
+}
+
+// ExportCharges 导出充电记录 (报销用的表格)
+// GET /api/cars/:id/charges/export?format=csv&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z
+// 按开始时间升序逐行输出已结束的充电，最后一行为合计
+func (h *Handler) ExportCharges(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+	if format := c.DefaultQuery("format", "csv"); format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, only csv is supported"})
+		return
+	}
+
+	var from, to *time.Time
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC3339"})
+			return
+		}
+		from = &t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC3339"})
+			return
+		}
+		to = &t
+	}
+
+	car, err := h.carRepo.GetByID(c.Request.Context(), carID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	// 导出可能持续较长时间，不受 HTTP_WRITE_TIMEOUT 限制
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	filename := fmt.Sprintf("tesgazer-charges-%s-%s.csv", car.VIN, time.Now().In(h.vehicleService.Location()).Format("20060102"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Status(http.StatusOK)
+
+	count, err := h.vehicleService.ExportChargesCSV(c.Request.Context(), c.Writer, carID, from, to, c.Writer.Flush)
+	if err != nil {
+		// 响应头已发出，只能中断输出；文件缺少合计行
+		h.logger.Error("Failed to export charges", zap.Error(err), zap.Int64("car_id", carID))
+		return
+	}
+
+	h.logger.Info("Exported charges", zap.Int64("car_id", carID), zap.Int("count", count))
+}
+
+// GetChargeStats 获取按交流/直流分类的充电统计
+// GET /api/cars/:id/charges/stats?start=2024-01-01T00:00:00Z&end=2024-02-01T00:00:00Z
+// 默认最近 30 天，峰值功率达到 DC_POWER_THRESHOLD_KW 的充电视为直流快充
+func (h *Handler) GetChargeStats(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	end := time.Now()
+	start := end.AddDate(0, 0, -30)
+
+	if s := c.Query("start"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start, expected RFC3339"})
+			return
+		}
+		start = t
+	}
+	if e := c.Query("end"); e != "" {
+		t, err := time.Parse(time.RFC3339, e)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end, expected RFC3339"})
+			return
+		}
+		end = t
+	}
+
+	stats, err := h.chargeRepo.GetStatsByType(c.Request.Context(), carID, start, end, h.vehicleService.DCPowerThresholdKw())
+	if err != nil {
+		h.logger.Error("Failed to get charge stats", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get charge stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+// GetGeofenceCharges 获取开始位置在地理围栏内的充电记录及汇总
+// GET /api/geofences/:id/charges?period=month
+// period: day, week, month (默认), year, all
+func (h *Handler) GetGeofenceCharges(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid geofence ID"})
+		return
+	}
+
+	period := c.DefaultQuery("period", "month")
+	since, ok := periodStart(period, time.Now().In(h.vehicleService.Location()))
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid period, expected day, week, month, year or all"})
+		return
+	}
+
+	geofence, err := h.geofenceRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to get geofence", zap.Error(err), zap.Int64("geofence_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get geofence"})
+		return
+	}
+	if geofence == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		return
+	}
+
+	charges, err := h.chargeRepo.ListProcessesByGeofence(c.Request.Context(), id, since)
+	if err != nil {
+		h.logger.Error("Failed to list geofence charges", zap.Error(err), zap.Int64("geofence_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list charges"})
+		return
+	}
+
+	stats := &models.GeofenceChargeStats{
+		Geofence:    geofence,
+		Period:      period,
+		Since:       since,
+		ChargeCount: int64(len(charges)),
+		Charges:     charges,
+	}
+	if stats.Charges == nil {
+		stats.Charges = []*models.ChargingProcess{}
+	}
+	for _, cp := range charges {
+		stats.EnergyChargedKwh += cp.ChargeEnergyAdded
+		if cp.Cost != nil {
+			stats.ChargeCost += *cp.Cost
+		}
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), stats)
+	c.JSON(http.StatusOK, gin.H{"data": stats})
+}
+
+// ListPlugSessions 获取插枪会话列表 (插枪到拔枪，包含等待预约充电等未充电的时段)
+// GET /api/cars/:id/plug-sessions
+func (h *Handler) ListPlugSessions(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := h.chargeRepo.ListPlugSessionsByCarID(c.Request.Context(), carID, page.after, page.perPage, page.offset())
+	if err != nil {
+		h.logger.Error("Failed to list plug sessions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list plug sessions"})
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), sessions)
+
+	total, _ := h.chargeRepo.CountPlugSessionsByCarID(c.Request.Context(), carID)
+
+	var lastStart time.Time
+	if len(sessions) > 0 {
+		lastStart = sessions[len(sessions)-1].StartTime
+	}
+	c.JSON(http.StatusOK, page.response(sessions, len(sessions), lastStart, total))
+}
+
+// GetPlugSession 获取插枪会话详情 (含插枪、充电开始/停止、拔枪事件和期间的充电记录)
+// GET /api/plug-sessions/:id
+func (h *Handler) GetPlugSession(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid plug session ID"})
+		return
+	}
+
+	session, err := h.chargeRepo.GetPlugSessionByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Plug session not found"})
+		return
+	}
+
+	if err := h.vehicleService.LoadPlugSessionDetails(c.Request.Context(), session); err != nil {
+		h.logger.Error("Failed to load plug session details", zap.Error(err), zap.Int64("plug_session_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get plug session"})
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), session)
+	c.JSON(http.StatusOK, gin.H{"data": session})
+}
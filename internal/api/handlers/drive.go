@@ -1,15 +1,25 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+	"github.com/langchou/tesgazer/internal/service"
 )
 
 // ListDrives 获取行程列表
+// GET /api/cars/:id/drives?include=polyline&from_geofence=&to_geofence=
+// from_geofence / to_geofence 按起点/终点所在地理围栏筛选 (如家→公司的通勤)，可单独使用
+// include=polyline 时每个行程附带缩略轨迹 (encoded polyline)
 func (h *Handler) ListDrives(c *gin.Context) {
 	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -17,34 +27,53 @@ func (h *Handler) ListDrives(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
 	}
 
-	offset := (page - 1) * perPage
+	var filter repository.DriveFilter
+	if s := c.Query("from_geofence"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from_geofence, expected a geofence ID"})
+			return
+		}
+		filter.StartGeofenceID = &id
+	}
+	if s := c.Query("to_geofence"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to_geofence, expected a geofence ID"})
+			return
+		}
+		filter.EndGeofenceID = &id
+	}
 
-	drives, err := h.driveRepo.ListByCarID(c.Request.Context(), carID, perPage, offset)
+	drives, err := h.driveRepo.ListByCarID(c.Request.Context(), carID, filter, page.after, page.perPage, page.offset())
 	if err != nil {
 		h.logger.Error("Failed to list drives", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list drives"})
 		return
 	}
 
-	total, _ := h.driveRepo.CountByCarID(c.Request.Context(), carID)
+	if includes(c, "polyline") {
+		if err := h.vehicleService.DrivePolylines(c.Request.Context(), drives); err != nil {
+			h.logger.Error("Failed to get drive polylines", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list drives"})
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"data": drives,
-		"pagination": gin.H{
-			"page":     page,
-			"per_page": perPage,
-			"total":    total,
-		},
-	})
+	h.vehicleService.RedactCoordinates(c.Request.Context(), drives)
+
+	total, _ := h.driveRepo.CountByCarID(c.Request.Context(), carID, filter)
+
+	var lastStart time.Time
+	if len(drives) > 0 {
+		lastStart = drives[len(drives)-1].StartTime
+	}
+	c.JSON(http.StatusOK, page.response(drives, len(drives), lastStart, total))
 }
 
 // GetDrive 获取行程详情
@@ -61,9 +90,118 @@ func (h *Handler) GetDrive(c *gin.Context) {
 		return
 	}
 
+	h.vehicleService.RedactCoordinates(c.Request.Context(), drive)
 	c.JSON(http.StatusOK, gin.H{"data": drive})
 }
 
+// SplitDriveRequest 拆分行程请求，position_id 和 timestamp 二选一
+type SplitDriveRequest struct {
+	PositionID int64      `json:"position_id"` // 拆分点 (前半段的最后一个位置点)
+	Timestamp  *time.Time `json:"timestamp"`   // 按时间拆分，取该时间点及之前的最后一个位置点
+}
+
+// SplitDrive 在指定位置点拆分行程
+// POST /api/drives/:id/split
+func (h *Handler) SplitDrive(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drive ID"})
+		return
+	}
+
+	var req SplitDriveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.PositionID <= 0 && req.Timestamp == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "position_id or timestamp is required"})
+		return
+	}
+	var at time.Time
+	if req.Timestamp != nil {
+		at = *req.Timestamp
+	}
+
+	drive, err := h.driveRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drive not found"})
+		return
+	}
+
+	ids, err := h.vehicleService.SplitDrive(c.Request.Context(), drive, req.PositionID, at)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrDriveInProgress):
+			c.JSON(http.StatusConflict, gin.H{"error": "Drive is still in progress"})
+		case errors.Is(err, service.ErrInvalidSplitPoint):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Split point must leave positions on both sides"})
+		default:
+			h.logger.Error("Failed to split drive", zap.Error(err), zap.Int64("drive_id", id))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to split drive"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"drive_ids": ids}})
+}
+
+// ReprocessDrive 重新计算单个行程的统计数据并重新解析起止地址
+// POST /api/drives/:id/reprocess
+func (h *Handler) ReprocessDrive(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drive ID"})
+		return
+	}
+
+	drive, err := h.driveRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drive not found"})
+		return
+	}
+
+	updated, err := h.vehicleService.ReprocessDrive(c.Request.Context(), drive)
+	if err != nil {
+		if errors.Is(err, service.ErrDriveInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Drive is still in progress"})
+			return
+		}
+		h.logger.Error("Failed to reprocess drive", zap.Error(err), zap.Int64("drive_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reprocess drive"})
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), updated)
+	c.JSON(http.StatusOK, gin.H{"data": updated})
+}
+
+// GetActiveDrive 获取车辆进行中的行程
+// GET /api/cars/:id/drives/active
+// 返回行程 (距离和时长计算到当前)、已记录的位置点和实时统计；没有进行中的行程时返回 204
+func (h *Handler) GetActiveDrive(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	active, err := h.vehicleService.GetActiveDrive(c.Request.Context(), carID)
+	if err != nil {
+		h.logger.Error("Failed to get active drive", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active drive"})
+		return
+	}
+	if active == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), active.Drive)
+	h.vehicleService.RedactCoordinates(c.Request.Context(), active.Positions)
+	c.JSON(http.StatusOK, gin.H{"data": active})
+}
+
 // GetDrivePositions 获取行程轨迹
 func (h *Handler) GetDrivePositions(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
@@ -79,10 +217,45 @@ func (h *Handler) GetDrivePositions(c *gin.Context) {
 		return
 	}
 
+	h.vehicleService.RedactCoordinates(c.Request.Context(), positions)
 	c.JSON(http.StatusOK, gin.H{"data": positions})
 }
 
+// GetDriveMatchedPath 获取行程纠偏后的轨迹
+// GET /api/drives/:id/matched
+// 未配置 MAPMATCH_URL、行程未结束或纠偏失败时返回原始轨迹 (matched 为 false)
+func (h *Handler) GetDriveMatchedPath(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drive ID"})
+		return
+	}
+
+	drive, err := h.driveRepo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drive not found"})
+		return
+	}
+
+	path, err := h.vehicleService.GetMatchedPath(c.Request.Context(), drive)
+	if err != nil {
+		h.logger.Error("Failed to get matched path", zap.Error(err), zap.Int64("drive_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get matched path"})
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), path)
+	c.JSON(http.StatusOK, gin.H{"data": path})
+}
+
+// 足迹查询默认和最多返回的行程数
+const (
+	defaultFootprintLimit = 500
+	maxFootprintLimit     = 2000
+)
+
 // GetFootprint 获取足迹数据 (批量行程轨迹)
+// GET /api/cars/:id/footprint?start=&end=&bbox=&min_distance_km=&order=&limit=&offset=&downsample=
 func (h *Handler) GetFootprint(c *gin.Context) {
 	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -105,12 +278,166 @@ func (h *Handler) GetFootprint(c *gin.Context) {
 		}
 	}
 
-	paths, err := h.driveRepo.GetDrivePathsInRange(c.Request.Context(), carID, start, end)
+	opts := repository.FootprintOptions{Limit: defaultFootprintLimit}
+
+	// 视野范围: bbox=minLng,minLat,maxLng,maxLat
+	if b := c.Query("bbox"); b != "" {
+		opts.BBox, err = parseBoundingBox(b)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bbox, expected minLng,minLat,maxLng,maxLat"})
+			return
+		}
+	}
+	if s := c.Query("min_distance_km"); s != "" {
+		opts.MinDistanceKm, err = strconv.ParseFloat(s, 64)
+		if err != nil || opts.MinDistanceKm < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_distance_km, expected a non-negative number"})
+			return
+		}
+	}
+	switch c.DefaultQuery("order", "desc") {
+	case "asc":
+		opts.Ascending = true
+	case "desc":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order, expected asc or desc"})
+		return
+	}
+	if s := c.Query("limit"); s != "" {
+		opts.Limit, err = strconv.Atoi(s)
+		if err != nil || opts.Limit < 1 || opts.Limit > maxFootprintLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid limit, expected 1-%d", maxFootprintLimit)})
+			return
+		}
+	}
+	if s := c.Query("offset"); s != "" {
+		opts.Offset, err = strconv.Atoi(s)
+		if err != nil || opts.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset, expected a non-negative integer"})
+			return
+		}
+	}
+	if s := c.Query("downsample"); s != "" {
+		opts.Downsample, err = strconv.Atoi(s)
+		if err != nil || opts.Downsample < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid downsample, expected a positive integer"})
+			return
+		}
+	}
+
+	paths, total, err := h.driveRepo.GetDrivePathsInRange(c.Request.Context(), carID, start, end, opts)
 	if err != nil {
 		h.logger.Error("Failed to get drive paths", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get footprint data"})
 		return
 	}
+	h.vehicleService.RedactCoordinates(c.Request.Context(), paths)
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": paths,
+		"pagination": gin.H{
+			"limit":  opts.Limit,
+			"offset": opts.Offset,
+			"total":  total,
+		},
+	})
+}
+
+// maxPositionQueryPoints 位置查询最多返回的点数，超出时自动抽样
+const maxPositionQueryPoints = 20000
+
+// QueryCarPositions 按时间范围和经纬度范围查询车辆的位置点 (热力图)
+// GET /api/cars/:id/positions?bbox=&from=&to=&downsample=
+// 默认最近 90 天；downsample 为抽样间隔 (每 N 个点保留一个)，不指定时按点数上限自动抽样
+func (h *Handler) QueryCarPositions(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -90)
+	if s := c.Query("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected RFC3339"})
+			return
+		}
+		from = t
+	}
+	if s := c.Query("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected RFC3339"})
+			return
+		}
+		to = t
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	var bbox *models.BoundingBox
+	if b := c.Query("bbox"); b != "" {
+		bbox, err = parseBoundingBox(b)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bbox, expected minLng,minLat,maxLng,maxLat"})
+			return
+		}
+	}
+
+	step := 0
+	if s := c.Query("downsample"); s != "" {
+		step, err = strconv.Atoi(s)
+		if err != nil || step < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid downsample, expected a positive integer"})
+			return
+		}
+	}
+
+	cloud, err := h.posRepo.Query(c.Request.Context(), carID, from, to, bbox, step, maxPositionQueryPoints)
+	if err != nil {
+		h.logger.Error("Failed to query positions", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query positions"})
+		return
+	}
 
-	c.JSON(http.StatusOK, gin.H{"data": paths})
+	h.vehicleService.RedactCoordinates(c.Request.Context(), cloud)
+	c.JSON(http.StatusOK, gin.H{"data": cloud})
+}
+
+// includes 检查逗号分隔的 include 查询参数是否包含指定项
+func includes(c *gin.Context, name string) bool {
+	for _, v := range strings.Split(c.Query("include"), ",") {
+		if strings.TrimSpace(v) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBoundingBox 解析 minLng,minLat,maxLng,maxLat 格式的范围参数
+func parseBoundingBox(s string) (*models.BoundingBox, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("expected 4 values, got %d", len(parts))
+	}
+
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = f
+	}
+
+	bbox := &models.BoundingBox{MinLng: v[0], MinLat: v[1], MaxLng: v[2], MaxLat: v[3]}
+	if bbox.MinLng > bbox.MaxLng || bbox.MinLat > bbox.MaxLat ||
+		bbox.MinLat < -90 || bbox.MaxLat > 90 || bbox.MinLng < -180 || bbox.MaxLng > 180 {
+		return nil, fmt.Errorf("invalid bounds")
+	}
+	return bbox, nil
 }
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/service"
+)
+
+// 地理围栏半径范围 (米)
+const (
+	minGeofenceRadius = 10
+	maxGeofenceRadius = 5000
+)
+
+// ListFrequentLocations 获取车辆的常去地点 (停车位置聚类)
+// GET /api/cars/:id/frequent-locations
+func (h *Handler) ListFrequentLocations(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	locations, err := h.vehicleService.FindFrequentLocations(c.Request.Context(), carID)
+	if err != nil {
+		h.logger.Error("Failed to find frequent locations", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find frequent locations"})
+		return
+	}
+
+	h.vehicleService.RedactCoordinates(c.Request.Context(), locations)
+	c.JSON(http.StatusOK, gin.H{"data": locations})
+}
+
+// CreateGeofenceRequest 创建地理围栏请求，可直接使用常去地点的 latitude/longitude/radius_m
+type CreateGeofenceRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Latitude  *float64 `json:"latitude" binding:"required"`
+	Longitude *float64 `json:"longitude" binding:"required"`
+	Radius    int      `json:"radius"`  // 米，默认 50
+	Privacy   bool     `json:"privacy"` // 隐私区域：区域内的坐标在输出中替换为围栏中心
+}
+
+// CreateGeofence 创建地理围栏并关联围栏内已有的停车、充电和行程
+// POST /api/geofences
+func (h *Handler) CreateGeofence(c *gin.Context) {
+	var req CreateGeofenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	g := &models.Geofence{
+		Name:      strings.TrimSpace(req.Name),
+		Latitude:  *req.Latitude,
+		Longitude: *req.Longitude,
+		Radius:    req.Radius,
+		Privacy:   req.Privacy,
+	}
+	if g.Radius == 0 {
+		g.Radius = 50
+	}
+	if g.Name == "" || len(g.Name) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be 1-255 characters"})
+		return
+	}
+	if g.Latitude < -90 || g.Latitude > 90 || g.Longitude < -180 || g.Longitude > 180 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid latitude or longitude"})
+		return
+	}
+	if g.Radius < minGeofenceRadius || g.Radius > maxGeofenceRadius {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "radius must be between 10 and 5000 meters"})
+		return
+	}
+
+	assigned, err := h.vehicleService.CreateGeofence(c.Request.Context(), g)
+	if errors.Is(err, service.ErrGeofenceAssign) {
+		// 围栏已创建，只是历史记录未关联，仍返回围栏并告知前端
+		h.logger.Error("Geofence created but failed to assign existing records",
+			zap.Int64("geofence_id", g.ID), zap.Error(err))
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"geofence": g, "assigned": nil, "assign_error": "Failed to assign existing records"}})
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to create geofence", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create geofence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"geofence": g, "assigned": assigned}})
+}
+
+// SetGeofencePrivacyRequest 设置隐私区域请求
+type SetGeofencePrivacyRequest struct {
+	Privacy *bool `json:"privacy" binding:"required"`
+}
+
+// SetGeofencePrivacy 设置地理围栏是否为隐私区域
+// PUT /api/geofences/:id/privacy
+func (h *Handler) SetGeofencePrivacy(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid geofence ID"})
+		return
+	}
+
+	var req SetGeofencePrivacyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	g, err := h.vehicleService.SetGeofencePrivacy(c.Request.Context(), id, *req.Privacy)
+	if err != nil {
+		h.logger.Error("Failed to set geofence privacy", zap.Error(err), zap.Int64("geofence_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update geofence"})
+		return
+	}
+	if g == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Geofence not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": g})
+}
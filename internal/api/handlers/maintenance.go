@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// MaintenanceReminderRequest 创建或修改保养提醒请求
+// 修改时只更新传入的字段；interval_km 和 interval_days 至少需要一个大于 0
+type MaintenanceReminderRequest struct {
+	Type         *string    `json:"type"`          // 保养项目，如 tire_rotation、cabin_filter、brake_fluid
+	Note         *string    `json:"note"`          // 备注
+	IntervalKm   *float64   `json:"interval_km"`   // 里程间隔 (km)，0 表示不按里程
+	IntervalDays *int       `json:"interval_days"` // 天数间隔，0 表示不按天数
+	LastDoneKm   *float64   `json:"last_done_km"`  // 上次保养时的里程，创建时默认为当前里程
+	LastDoneAt   *time.Time `json:"last_done_at"`  // 上次保养时间，创建时默认为当前时间
+}
+
+// apply 将请求中传入的字段写入保养提醒并校验，返回错误信息 (为空表示有效)
+func (req *MaintenanceReminderRequest) apply(m *models.MaintenanceReminder) string {
+	if req.Type != nil {
+		m.Type = strings.TrimSpace(*req.Type)
+	}
+	if req.Note != nil {
+		m.Note = strings.TrimSpace(*req.Note)
+	}
+	if req.IntervalKm != nil {
+		m.IntervalKm = positiveOrNil(*req.IntervalKm)
+	}
+	if req.IntervalDays != nil {
+		m.IntervalDays = nil
+		if *req.IntervalDays > 0 {
+			m.IntervalDays = req.IntervalDays
+		}
+	}
+	if req.LastDoneKm != nil {
+		m.LastDoneKm = req.LastDoneKm
+	}
+	if req.LastDoneAt != nil {
+		m.LastDoneAt = req.LastDoneAt
+	}
+
+	switch {
+	case m.Type == "" || len(m.Type) > 64:
+		return "type must be 1-64 characters"
+	case req.IntervalKm != nil && *req.IntervalKm < 0, req.IntervalDays != nil && *req.IntervalDays < 0:
+		return "intervals must not be negative"
+	case m.IntervalKm == nil && m.IntervalDays == nil:
+		return "interval_km or interval_days must be positive"
+	case m.LastDoneKm != nil && *m.LastDoneKm < 0:
+		return "last_done_km must not be negative"
+	}
+	return ""
+}
+
+// positiveOrNil 大于 0 时返回指针，否则返回 nil
+func positiveOrNil(v float64) *float64 {
+	if v > 0 {
+		return &v
+	}
+	return nil
+}
+
+// ListMaintenanceReminders 获取车辆的保养提醒 (含按当前里程和时间计算的状态)
+// GET /api/cars/:id/maintenance-reminders
+func (h *Handler) ListMaintenanceReminders(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	reminders, err := h.vehicleService.ListMaintenanceReminders(c.Request.Context(), carID)
+	if err != nil {
+		h.logger.Error("Failed to list maintenance reminders", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list maintenance reminders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": reminders})
+}
+
+// CreateMaintenanceReminder 创建保养提醒
+// POST /api/cars/:id/maintenance-reminders
+func (h *Handler) CreateMaintenanceReminder(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	var req MaintenanceReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	m := &models.MaintenanceReminder{CarID: carID}
+	if msg := req.apply(m); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if _, err := h.carRepo.GetByID(c.Request.Context(), carID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	if err := h.vehicleService.CreateMaintenanceReminder(c.Request.Context(), m); err != nil {
+		h.logger.Error("Failed to create maintenance reminder", zap.Error(err), zap.Int64("car_id", carID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create maintenance reminder"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": m})
+}
+
+// UpdateMaintenanceReminder 修改保养提醒 (只更新传入的字段)
+// PUT /api/maintenance-reminders/:id
+func (h *Handler) UpdateMaintenanceReminder(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return
+	}
+
+	var req MaintenanceReminderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	m, err := h.vehicleService.GetMaintenanceReminder(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to get maintenance reminder", zap.Error(err), zap.Int64("reminder_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update maintenance reminder"})
+		return
+	}
+	if m == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance reminder not found"})
+		return
+	}
+	if msg := req.apply(m); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	updated, err := h.vehicleService.UpdateMaintenanceReminder(c.Request.Context(), m)
+	if err != nil {
+		h.logger.Error("Failed to update maintenance reminder", zap.Error(err), zap.Int64("reminder_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update maintenance reminder"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance reminder not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": m})
+}
+
+// CompleteMaintenanceRequest 标记保养完成请求 (请求体可省略)
+type CompleteMaintenanceRequest struct {
+	DoneKm *float64   `json:"done_km"` // 保养时的里程，默认为当前里程
+	DoneAt *time.Time `json:"done_at"` // 保养时间，默认为当前时间
+}
+
+// CompleteMaintenanceReminder 标记保养已完成，从本次保养重新计算间隔
+// POST /api/maintenance-reminders/:id/done
+func (h *Handler) CompleteMaintenanceReminder(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return
+	}
+
+	var req CompleteMaintenanceRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if req.DoneKm != nil && *req.DoneKm < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "done_km must not be negative"})
+		return
+	}
+
+	m, err := h.vehicleService.CompleteMaintenanceReminder(c.Request.Context(), id, req.DoneKm, req.DoneAt)
+	if err != nil {
+		h.logger.Error("Failed to complete maintenance reminder", zap.Error(err), zap.Int64("reminder_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to complete maintenance reminder"})
+		return
+	}
+	if m == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance reminder not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": m})
+}
+
+// DeleteMaintenanceReminder 删除保养提醒
+// DELETE /api/maintenance-reminders/:id
+func (h *Handler) DeleteMaintenanceReminder(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reminder ID"})
+		return
+	}
+
+	deleted, err := h.vehicleService.DeleteMaintenanceReminder(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to delete maintenance reminder", zap.Error(err), zap.Int64("reminder_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete maintenance reminder"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance reminder not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": gin.H{"deleted": id}})
+}
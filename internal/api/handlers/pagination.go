@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPerPage = 20
+	maxPerPage     = 100
+)
+
+// pageParams 列表分页参数
+// 默认按 page/per_page 偏移分页；传入 after (上一页返回的 next_cursor，RFC3339) 时改为游标分页，
+// 只返回开始时间早于 after 的记录，避免翻到很深时的大 OFFSET
+type pageParams struct {
+	page    int
+	perPage int
+	after   *time.Time
+}
+
+// parsePageParams 解析分页参数，after 格式错误时返回 400 并返回 false
+func parsePageParams(c *gin.Context) (pageParams, bool) {
+	p := pageParams{page: 1, perPage: defaultPerPage}
+	if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 0 {
+		p.page = page
+	}
+	if perPage, err := strconv.Atoi(c.Query("per_page")); err == nil && perPage > 0 && perPage <= maxPerPage {
+		p.perPage = perPage
+	}
+	if s := c.Query("after"); s != "" {
+		after, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid after, expected RFC3339 time"})
+			return p, false
+		}
+		p.after = &after
+	}
+	return p, true
+}
+
+// offset 偏移量，游标分页时为 0
+func (p pageParams) offset() int {
+	if p.after != nil {
+		return 0
+	}
+	return (p.page - 1) * p.perPage
+}
+
+// response 构造列表响应
+// lastStart 为本页最后一条记录的开始时间，本页已满时作为 next_cursor 返回 (不足一页说明没有更多数据)
+func (p pageParams) response(data interface{}, count int, lastStart time.Time, total int64) gin.H {
+	pagination := gin.H{
+		"per_page": p.perPage,
+		"total":    total,
+	}
+	if p.after != nil {
+		pagination["after"] = p.after
+	} else {
+		pagination["page"] = p.page
+	}
+	if count == p.perPage {
+		pagination["next_cursor"] = lastStart
+	} else {
+		pagination["next_cursor"] = nil
+	}
+	return gin.H{"data": data, "pagination": pagination}
+}
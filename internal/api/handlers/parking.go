@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
@@ -16,34 +17,27 @@ func (h *Handler) ListParkings(c *gin.Context) {
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
 	}
 
-	offset := (page - 1) * perPage
-
-	parkings, err := h.parkingRepo.ListByCarID(c.Request.Context(), carID, perPage, offset)
+	parkings, err := h.parkingRepo.ListByCarID(c.Request.Context(), carID, page.after, page.perPage, page.offset())
 	if err != nil {
 		h.logger.Error("Failed to list parkings", zap.Error(err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list parkings"})
 		return
 	}
 
+	h.vehicleService.RedactCoordinates(c.Request.Context(), parkings)
+
 	total, _ := h.parkingRepo.CountByCarID(c.Request.Context(), carID)
 
-	c.JSON(http.StatusOK, gin.H{
-		"data": parkings,
-		"pagination": gin.H{
-			"page":     page,
-			"per_page": perPage,
-			"total":    total,
-		},
-	})
+	var lastStart time.Time
+	if len(parkings) > 0 {
+		lastStart = parkings[len(parkings)-1].StartTime
+	}
+	c.JSON(http.StatusOK, page.response(parkings, len(parkings), lastStart, total))
 }
 
 // GetParking 获取停车详情
@@ -60,6 +54,7 @@ func (h *Handler) GetParking(c *gin.Context) {
 		return
 	}
 
+	h.vehicleService.RedactCoordinates(c.Request.Context(), parking)
 	c.JSON(http.StatusOK, gin.H{"data": parking})
 }
 
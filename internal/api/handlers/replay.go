@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+const (
+	replayDefaultInterval = 5 * time.Second
+	replayMinInterval     = 1 * time.Second
+	replayMaxInterval     = 5 * time.Minute
+	replayDefaultMaxGap   = 60 * time.Second
+	replayMaxPoints       = 20000 // 单次返回的最大点数，避免超长行程配合极小间隔产生过大的响应
+)
+
+// GetDriveReplay 获取行程回放数据 (按固定时间间隔重采样的轨迹)
+// GET /api/drives/:id/replay?interval=5s&max_gap=60s
+// 相邻位置点间隔超过 max_gap (如隧道内无 GPS) 时不插值，缺失区间内不输出点
+func (h *Handler) GetDriveReplay(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid drive ID"})
+		return
+	}
+
+	interval := replayDefaultInterval
+	if s := c.Query("interval"); s != "" {
+		interval, err = time.ParseDuration(s)
+		if err != nil || interval < replayMinInterval || interval > replayMaxInterval {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid interval, expected a duration between 1s and 5m"})
+			return
+		}
+	}
+
+	maxGap := replayDefaultMaxGap
+	if s := c.Query("max_gap"); s != "" {
+		maxGap, err = time.ParseDuration(s)
+		if err != nil || maxGap <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid max_gap, expected a positive duration"})
+			return
+		}
+	}
+
+	if _, err := h.driveRepo.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Drive not found"})
+		return
+	}
+
+	positions, err := h.posRepo.ListByDriveID(c.Request.Context(), id)
+	if err != nil {
+		h.logger.Error("Failed to list positions", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list positions"})
+		return
+	}
+
+	if n := len(positions); n > 1 {
+		span := positions[n-1].RecordedAt.Sub(positions[0].RecordedAt)
+		if span/interval > replayMaxPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Interval too small for this drive, use a larger interval"})
+			return
+		}
+	}
+
+	points := resamplePositions(positions, interval, maxGap)
+	h.vehicleService.RedactCoordinates(c.Request.Context(), points)
+	c.JSON(http.StatusOK, gin.H{"data": models.DriveReplay{
+		DriveID:     id,
+		IntervalSec: interval.Seconds(),
+		MaxGapSec:   maxGap.Seconds(),
+		Points:      points,
+	}})
+}
+
+// resamplePositions 将位置点按固定时间间隔重采样，经纬度和速度线性插值
+// positions 需按时间排序；间隔超过 maxGap 的相邻点之间不插值
+func resamplePositions(positions []*models.Position, interval, maxGap time.Duration) []models.ReplayPoint {
+	points := []models.ReplayPoint{}
+	if len(positions) == 0 {
+		return points
+	}
+
+	start := positions[0].RecordedAt
+	end := positions[len(positions)-1].RecordedAt
+	gap := false
+	i := 0
+
+	for t := start; !t.After(end); t = t.Add(interval) {
+		// 找到包含 t 的区间 [positions[i], positions[i+1]]
+		for i+1 < len(positions) && !positions[i+1].RecordedAt.After(t) {
+			i++
+		}
+
+		a := positions[i]
+		if i+1 == len(positions) || a.RecordedAt.Equal(t) {
+			points = append(points, replayPointAt(a, start, t, gap))
+			gap = false
+			continue
+		}
+
+		b := positions[i+1]
+		span := b.RecordedAt.Sub(a.RecordedAt)
+		if span > maxGap {
+			gap = true
+			continue
+		}
+
+		frac := float64(t.Sub(a.RecordedAt)) / float64(span)
+		p := replayPointAt(a, start, t, gap)
+		p.Latitude = lerp(a.Latitude, b.Latitude, frac)
+		p.Longitude = lerp(a.Longitude, b.Longitude, frac)
+		if a.Speed != nil && b.Speed != nil {
+			speed := math.Round(lerp(float64(*a.Speed), float64(*b.Speed), frac)*10) / 10
+			p.Speed = &speed
+		} else {
+			p.Speed = nil
+		}
+		points = append(points, p)
+		gap = false
+	}
+
+	return points
+}
+
+// replayPointAt 以位置点 pos 的数据生成时间 t 的回放点
+func replayPointAt(pos *models.Position, start, t time.Time, gap bool) models.ReplayPoint {
+	p := models.ReplayPoint{
+		Time:      t,
+		OffsetSec: t.Sub(start).Seconds(),
+		Latitude:  pos.Latitude,
+		Longitude: pos.Longitude,
+		Heading:   pos.Heading,
+		GapBefore: gap,
+	}
+	if pos.Speed != nil {
+		speed := float64(*pos.Speed)
+		p.Speed = &speed
+	}
+	return p
+}
+
+// lerp 线性插值
+func lerp(a, b, frac float64) float64 {
+	return a + (b-a)*frac
+}
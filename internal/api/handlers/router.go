@@ -1,7 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -9,17 +16,21 @@ import (
 
 	"github.com/langchou/tesgazer/internal/repository"
 	"github.com/langchou/tesgazer/internal/service"
+	"github.com/langchou/tesgazer/internal/version"
 	"github.com/langchou/tesgazer/pkg/ws"
 )
 
 // Handler HTTP 处理器
 type Handler struct {
 	logger         *zap.Logger
+	db             *repository.DB
 	carRepo        *repository.CarRepository
 	driveRepo      *repository.DriveRepository
 	chargeRepo     *repository.ChargeRepository
 	posRepo        *repository.PositionRepository
 	parkingRepo    *repository.ParkingRepository
+	tripRepo       *repository.TripRepository
+	geofenceRepo   *repository.GeofenceRepository
 	vehicleService *service.VehicleService
 	wsHub          *ws.Hub
 	upgrader       websocket.Upgrader
@@ -28,29 +39,60 @@ type Handler struct {
 // NewHandler 创建处理器
 func NewHandler(
 	logger *zap.Logger,
+	db *repository.DB,
 	carRepo *repository.CarRepository,
 	driveRepo *repository.DriveRepository,
 	chargeRepo *repository.ChargeRepository,
 	posRepo *repository.PositionRepository,
 	parkingRepo *repository.ParkingRepository,
+	tripRepo *repository.TripRepository,
+	geofenceRepo *repository.GeofenceRepository,
 	vehicleService *service.VehicleService,
 	wsHub *ws.Hub,
 ) *Handler {
-	return &Handler{
+	h := &Handler{
 		logger:         logger,
+		db:             db,
 		carRepo:        carRepo,
 		driveRepo:      driveRepo,
 		chargeRepo:     chargeRepo,
 		posRepo:        posRepo,
 		parkingRepo:    parkingRepo,
+		tripRepo:       tripRepo,
+		geofenceRepo:   geofenceRepo,
 		vehicleService: vehicleService,
 		wsHub:          wsHub,
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // 开发环境允许所有来源
-			},
+			// 客户端支持时启用 permessage-deflate，状态消息为重复度高的 JSON，压缩后体积约减半
+			EnableCompression: true,
 		},
 	}
+	h.upgrader.CheckOrigin = h.checkWebSocketOrigin
+	return h
+}
+
+// checkWebSocketOrigin 校验 WebSocket 握手的 Origin，与 CORS 使用相同的 CORS_ALLOWED_ORIGINS
+// 没有 Origin (非浏览器客户端) 或与请求 Host 同源时允许；未配置来源时只有 debug 模式允许任意来源
+func (h *Handler) checkWebSocketOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	allowed, debug := h.vehicleService.AllowedOrigins()
+	if len(allowed) == 0 {
+		return debug
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	h.logger.Warn("Rejected WebSocket connection from disallowed origin", zap.String("origin", origin))
+	return false
 }
 
 // RegisterRoutes 注册路由
@@ -61,26 +103,83 @@ func (h *Handler) RegisterRoutes(r *gin.Engine) {
 		// 车辆
 		api.GET("/cars", h.ListCars)
 		api.GET("/cars/:id", h.GetCar)
+		api.PUT("/cars/:id", h.UpdateCar)
 		api.GET("/cars/:id/state", h.GetCarState)
+		api.GET("/cars/:id/stream", h.StreamCarState) // SSE: WebSocket 被拦截时的实时状态推送
+		api.GET("/cars/:id/poll-status", h.GetPollStatus)
+		api.GET("/cars/:id/state-history", h.GetStateHistory)
 		api.POST("/cars/:id/suspend", h.SuspendLogging) // 暂停日志记录
 		api.POST("/cars/:id/resume", h.ResumeLogging)   // 恢复日志记录
 		api.GET("/cars/:id/stats", h.GetCarStats)
+		api.GET("/cars/:id/battery-health", h.GetBatteryHealth)
+		api.GET("/cars/:id/odometer", h.GetOdometerHistory)
+		api.GET("/cars/:id/reports/monthly", h.GetMonthlyReport)
+		api.GET("/cars/:id/raw", h.requireAdminToken, h.GetRawVehicleData) // 调试: Tesla 原始数据
+		api.GET("/cars/:id/settings", h.GetCarSettings)
+		api.PUT("/cars/:id/settings", h.UpdateCarSettings)
+		api.GET("/cars/:id/export/full", h.ExportCarFull)
+		api.GET("/stats/fleet", h.GetFleetStats)
 
 		// 行程
 		api.GET("/cars/:id/drives", h.ListDrives)
+		api.GET("/cars/:id/drives/active", h.GetActiveDrive)
 		api.GET("/drives/:id", h.GetDrive)
 		api.GET("/drives/:id/positions", h.GetDrivePositions)
+		api.GET("/drives/:id/replay", h.GetDriveReplay)
+		api.GET("/drives/:id/matched", h.GetDriveMatchedPath)
+		api.POST("/drives/:id/split", h.SplitDrive)
+		api.POST("/drives/:id/reprocess", h.ReprocessDrive)
 		api.GET("/cars/:id/footprint", h.GetFootprint)
+		api.GET("/cars/:id/positions", h.QueryCarPositions) // 热力图: 按时间和范围查询位置点
+
+		// 旅程
+		api.GET("/cars/:id/trips", h.ListTrips)
 
 		// 充电
 		api.GET("/cars/:id/charges", h.ListCharges)
+		api.GET("/cars/:id/charges/stats", h.GetChargeStats)
+		api.GET("/cars/:id/charges/export", h.ExportCharges)
+		api.GET("/cars/:id/charges/:chargeId/curve", h.GetChargeCurve)
 		api.GET("/charges/:id", h.GetCharge)
 		api.GET("/charges/:id/details", h.GetChargeDetails)
+		api.DELETE("/charges/:id", h.DeleteCharge)
+		api.POST("/charges/:id/merge", h.MergeCharge)
+		api.GET("/cars/:id/plug-sessions", h.ListPlugSessions)
+		api.GET("/plug-sessions/:id", h.GetPlugSession)
 
 		// 停车
 		api.GET("/cars/:id/parkings", h.ListParkings)
 		api.GET("/parkings/:id", h.GetParking)
 		api.GET("/parkings/:id/events", h.GetParkingEvents)
+
+		// 地点
+		api.GET("/cars/:id/frequent-locations", h.ListFrequentLocations)
+		api.POST("/geofences", h.CreateGeofence)
+		api.PUT("/geofences/:id/privacy", h.SetGeofencePrivacy)
+		api.GET("/geofences/:id/charges", h.GetGeofenceCharges)
+
+		// 保养提醒
+		api.GET("/cars/:id/maintenance-reminders", h.ListMaintenanceReminders)
+		api.POST("/cars/:id/maintenance-reminders", h.CreateMaintenanceReminder)
+		api.PUT("/maintenance-reminders/:id", h.UpdateMaintenanceReminder)
+		api.DELETE("/maintenance-reminders/:id", h.DeleteMaintenanceReminder)
+		api.POST("/maintenance-reminders/:id/done", h.CompleteMaintenanceReminder)
+
+		// 管理 (需要 ADMIN_TOKEN 认证，未配置时禁用)
+		admin := api.Group("/admin", h.requireAdminToken)
+		admin.GET("/info", h.GetAdminInfo)
+		admin.POST("/sync-vehicles", h.SyncVehicles)
+		admin.POST("/prune-positions", h.PrunePositions)
+		admin.POST("/geocode-backfill", h.TriggerGeocodeBackfill)
+		admin.GET("/geocode-backfill", h.GetGeocodeBackfillStatus)
+		admin.DELETE("/geocode-backfill", h.CancelGeocodeBackfill)
+		admin.POST("/import/full", h.ImportCarFull)
+
+		// Fleet Telemetry 数据接收
+		api.POST("/telemetry", h.IngestTelemetry)
+
+		// 构建版本
+		api.GET("/version", h.GetVersion)
 	}
 
 	// WebSocket
@@ -98,7 +197,16 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	// http.Server 的 ReadTimeout/WriteTimeout 在连接被接管后仍然生效，
+	// WebSocket 是长连接，需要清除截止时间，否则会在超时后被强制断开
+	conn.NetConn().SetReadDeadline(time.Time{})
+	conn.NetConn().SetWriteDeadline(time.Time{})
+
 	client := ws.NewClient(h.wsHub, conn)
+	// 重连时客户端携带收到的最后序号 (/ws?last_seq=123)，补发断线期间的事件
+	if lastSeq, err := strconv.ParseUint(c.Query("last_seq"), 10, 64); err == nil {
+		client.ResumeAfter(lastSeq)
+	}
 	client.Register()
 
 	// 启动读写协程
@@ -106,10 +214,111 @@ func (h *Handler) HandleWebSocket(c *gin.Context) {
 	go client.WritePump()
 }
 
+// sseKeepAliveInterval SSE 心跳注释的发送间隔，避免代理因连接空闲而断开
+const sseKeepAliveInterval = 25 * time.Second
+
+// StreamCarState 以 Server-Sent Events 推送车辆状态更新 (代理或网络拦截 WebSocket 时的替代方案)
+// GET /api/cars/:id/stream
+// 每个事件的 data 与 WebSocket 消息格式相同 (连接时先发送当前状态的 state_update，之后是状态更新和告警)，
+// id 为消息序号；浏览器重连时自动携带 Last-Event-ID，补发断线期间的事件 (也可用 ?last_seq=)
+func (h *Handler) StreamCarState(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+	if _, err := h.carRepo.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	// 长连接，不受 HTTP_WRITE_TIMEOUT 限制
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{})
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // 关闭 nginx 的响应缓冲
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	client := ws.NewSubscriber(h.wsHub, id)
+	lastSeq := c.GetHeader("Last-Event-ID")
+	if lastSeq == "" {
+		lastSeq = c.Query("last_seq")
+	}
+	if seq, err := strconv.ParseUint(lastSeq, 10, 64); err == nil {
+		client.ResumeAfter(seq)
+	}
+	client.Register()
+	defer client.Unregister()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	ctx := c.Request.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message, ok := <-client.Messages():
+			if !ok {
+				// 消费过慢被 Hub 断开，客户端会自动重连并补发事件
+				return
+			}
+			if err := writeSSEMessage(c.Writer, message); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeSSEMessage 将 WebSocket 消息写为一个 SSE 事件，消息序号作为事件 id
+func writeSSEMessage(w io.Writer, message []byte) error {
+	var head struct {
+		Seq uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(message, &head); err == nil && head.Seq != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", head.Seq); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "data: %s\n\n", message)
+	return err
+}
+
 // HealthCheck 健康检查
+// streaming.degraded 为 true 表示有车辆的 Streaming 连续重连失败，已降级为仅轮询
 func (h *Handler) HealthCheck(c *gin.Context) {
+	degraded := h.vehicleService.StreamingDegradedVehicles()
+	accounts := h.vehicleService.AccountAuthStatuses()
+	reauthRequired := false
+	for _, acct := range accounts {
+		reauthRequired = reauthRequired || acct.ReauthRequired
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"status":     "ok",
 		"ws_clients": h.wsHub.ClientCount(),
+		"db_pool":    h.db.Stats(),
+		"streaming": gin.H{
+			"degraded":          len(degraded) > 0,
+			"degraded_vehicles": degraded,
+		},
+		"auth": gin.H{
+			"reauth_required": reauthRequired,
+			"accounts":        accounts,
+		},
+		"position_retry": h.vehicleService.PositionRetryStatus(),
 	})
 }
+
+// GetVersion 获取运行中的构建版本 (版本号、提交哈希、构建时间)
+// GET /api/version
+func (h *Handler) GetVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": version.Get()})
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/service"
+)
+
+// GetCarSettings 获取车辆设置覆盖
+// GET /api/cars/:id/settings
+func (h *Handler) GetCarSettings(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	if _, err := h.carRepo.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": h.vehicleService.GetCarSettings(id)})
+}
+
+// UpdateCarSettings 更新车辆设置覆盖
+// PUT /api/cars/:id/settings
+// 请求体为 key/value 对象，值为 null 表示删除该覆盖（恢复全局配置）
+func (h *Handler) UpdateCarSettings(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	if _, err := h.carRepo.GetByID(c.Request.Context(), id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Car not found"})
+		return
+	}
+
+	var req map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// 数字和字符串都接受，统一按字符串存储
+	updates := make(map[string]*string, len(req))
+	for key, raw := range req {
+		if string(raw) == "null" {
+			updates[key] = nil
+			continue
+		}
+		var str string
+		if err := json.Unmarshal(raw, &str); err != nil {
+			str = strings.TrimSpace(string(raw))
+		}
+		updates[key] = &str
+	}
+
+	if err := h.vehicleService.UpdateCarSettings(c.Request.Context(), id, updates); err != nil {
+		if errors.Is(err, service.ErrInvalidSetting) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Error("Failed to update car settings", zap.Error(err), zap.Int64("car_id", id))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": h.vehicleService.GetCarSettings(id)})
+}
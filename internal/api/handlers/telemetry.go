@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/service"
+)
+
+// maxTelemetryBodyBytes 单条 Fleet Telemetry 消息的大小上限
+const maxTelemetryBodyBytes = 1 << 20
+
+// IngestTelemetry 接收 Fleet Telemetry 推送
+// POST /api/telemetry (请求体为 protobuf 编码的 Payload 消息，由 Fleet Telemetry 服务端转发)
+// 配置 TELEMETRY_TOKEN 时需携带 Authorization: Bearer <token>
+func (h *Handler) IngestTelemetry(c *gin.Context) {
+	if !h.vehicleService.TelemetryEnabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fleet telemetry is disabled, set TELEMETRY_MODE=fleet_telemetry"})
+		return
+	}
+
+	if token := h.vehicleService.TelemetryToken(); token != "" {
+		auth := c.GetHeader("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid telemetry token"})
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxTelemetryBodyBytes+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	if len(body) > maxTelemetryBodyBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Telemetry payload too large"})
+		return
+	}
+
+	payload, err := tesla.DecodeTelemetryPayload(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.vehicleService.HandleTelemetry(c.Request.Context(), payload); err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownVehicle):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Unknown vehicle"})
+		default:
+			h.logger.Error("Failed to handle telemetry", zap.Error(err), zap.String("vin", payload.VIN))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to handle telemetry"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
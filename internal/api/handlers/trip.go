@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// ListTrips 获取旅程列表 (由中途充电串联的多段行程)
+// GET /api/cars/:id/trips?page=1&per_page=20
+func (h *Handler) ListTrips(c *gin.Context) {
+	carID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid car ID"})
+		return
+	}
+
+	page, ok := parsePageParams(c)
+	if !ok {
+		return
+	}
+
+	trips, err := h.tripRepo.ListByCarID(c.Request.Context(), carID, page.after, page.perPage, page.offset())
+	if err != nil {
+		h.logger.Error("Failed to list trips", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list trips"})
+		return
+	}
+
+	total, _ := h.tripRepo.CountByCarID(c.Request.Context(), carID)
+	h.vehicleService.RedactCoordinates(c.Request.Context(), trips)
+
+	var lastStart time.Time
+	if len(trips) > 0 {
+		lastStart = trips[len(trips)-1].StartTime
+	}
+	c.JSON(http.StatusOK, page.response(trips, len(trips), lastStart, total))
+}
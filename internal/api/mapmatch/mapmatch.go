@@ -0,0 +1,281 @@
+package mapmatch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Client 轨迹纠偏 (map matching) 客户端，将 GPS 轨迹吸附到路网
+// 支持 OSRM match 服务 (如 http://osrm:5000/match/v1/driving) 和
+// Valhalla trace_route 服务 (如 http://valhalla:8002/trace_route)，按 URL 路径区分
+type Client struct {
+	url        string
+	valhalla   bool
+	httpClient *http.Client
+	logger     *zap.Logger
+}
+
+// Point 轨迹点
+type Point struct {
+	Latitude  float64
+	Longitude float64
+	Time      time.Time
+}
+
+const (
+	// maxChunkPoints 单次请求的最大点数 (OSRM 默认 max-matching-size 为 100)
+	maxChunkPoints = 100
+	// gpsAccuracyM GPS 误差半径 (米)，隧道和高楼间的漂移通常在该范围内
+	gpsAccuracyM = 25
+)
+
+// ErrTooFewPoints 轨迹点不足两个，无法纠偏
+var ErrTooFewPoints = errors.New("too few points to match")
+
+// NewClient 创建轨迹纠偏客户端，matchURL 为空时不启用
+func NewClient(matchURL string, logger *zap.Logger) *Client {
+	matchURL = strings.TrimRight(matchURL, "/")
+	return &Client{
+		url:      matchURL,
+		valhalla: strings.HasSuffix(matchURL, "/trace_route"),
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		logger: logger,
+	}
+}
+
+// IsConfigured 是否配置了纠偏服务
+func (c *Client) IsConfigured() bool {
+	return c.url != ""
+}
+
+// GetProvider 返回当前使用的服务类型
+func (c *Client) GetProvider() string {
+	switch {
+	case c.url == "":
+		return ""
+	case c.valhalla:
+		return "valhalla"
+	default:
+		return "osrm"
+	}
+}
+
+// Match 纠偏轨迹，返回吸附到路网后的路径 [lat, lng]
+// 点数超过单次请求上限时分段请求 (相邻两段共用一个点) 后拼接
+func (c *Client) Match(ctx context.Context, points []Point) ([][2]float64, error) {
+	if len(points) < 2 {
+		return nil, ErrTooFewPoints
+	}
+
+	var path [][2]float64
+	for start := 0; start < len(points)-1; start += maxChunkPoints - 1 {
+		end := start + maxChunkPoints
+		if end > len(points) {
+			end = len(points)
+		}
+
+		var chunk [][2]float64
+		var err error
+		if c.valhalla {
+			chunk, err = c.matchValhalla(ctx, points[start:end])
+		} else {
+			chunk, err = c.matchOSRM(ctx, points[start:end])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// 去掉与上一段末尾重合的点
+		if len(path) > 0 && len(chunk) > 0 && chunk[0] == path[len(path)-1] {
+			chunk = chunk[1:]
+		}
+		path = append(path, chunk...)
+	}
+
+	c.logger.Debug("Matched track",
+		zap.String("provider", c.GetProvider()),
+		zap.Int("points", len(points)),
+		zap.Int("matched_points", len(path)))
+
+	return path, nil
+}
+
+// ============ OSRM 实现 ============
+
+// osrmMatchResponse OSRM match 响应
+type osrmMatchResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Matchings []struct {
+		Geometry struct {
+			Coordinates [][2]float64 `json:"coordinates"` // [lng, lat]
+		} `json:"geometry"`
+	} `json:"matchings"`
+}
+
+func (c *Client) matchOSRM(ctx context.Context, points []Point) ([][2]float64, error) {
+	coords := make([]string, len(points))
+	timestamps := make([]string, len(points))
+	radiuses := make([]string, len(points))
+	radius := strconv.Itoa(gpsAccuracyM)
+	for i, p := range points {
+		coords[i] = fmt.Sprintf("%.6f,%.6f", p.Longitude, p.Latitude)
+		timestamps[i] = strconv.FormatInt(p.Time.Unix(), 10)
+		radiuses[i] = radius
+	}
+
+	query := url.Values{}
+	query.Set("overview", "full")
+	query.Set("geometries", "geojson")
+	query.Set("gaps", "ignore")
+	query.Set("timestamps", strings.Join(timestamps, ";"))
+	query.Set("radiuses", strings.Join(radiuses, ";"))
+	apiURL := c.url + "/" + strings.Join(coords, ";") + "?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result osrmMatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if result.Code != "Ok" {
+		return nil, fmt.Errorf("osrm match error: %s %s", result.Code, result.Message)
+	}
+
+	var path [][2]float64
+	for _, m := range result.Matchings {
+		for _, coord := range m.Geometry.Coordinates {
+			path = append(path, [2]float64{coord[1], coord[0]})
+		}
+	}
+	return path, nil
+}
+
+// ============ Valhalla 实现 ============
+
+// valhallaShapePoint Valhalla 轨迹点
+type valhallaShapePoint struct {
+	Lat  float64 `json:"lat"`
+	Lon  float64 `json:"lon"`
+	Time int64   `json:"time"`
+}
+
+// valhallaTraceResponse Valhalla trace_route 响应
+type valhallaTraceResponse struct {
+	Trip struct {
+		Legs []struct {
+			Shape string `json:"shape"` // 精度 6 的 encoded polyline
+		} `json:"legs"`
+	} `json:"trip"`
+	ErrorCode int    `json:"error_code"`
+	Error     string `json:"error"`
+}
+
+func (c *Client) matchValhalla(ctx context.Context, points []Point) ([][2]float64, error) {
+	shape := make([]valhallaShapePoint, len(points))
+	for i, p := range points {
+		shape[i] = valhallaShapePoint{Lat: p.Latitude, Lon: p.Longitude, Time: p.Time.Unix()}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"shape":          shape,
+		"costing":        "auto",
+		"shape_match":    "map_snap",
+		"use_timestamps": true,
+		"trace_options": map[string]interface{}{
+			"gps_accuracy":  gpsAccuracyM,
+			"search_radius": gpsAccuracyM * 2,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result valhallaTraceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.ErrorCode != 0 {
+		return nil, fmt.Errorf("valhalla trace_route error: %d %s", result.ErrorCode, result.Error)
+	}
+
+	var path [][2]float64
+	for _, leg := range result.Trip.Legs {
+		decoded, err := decodePolyline(leg.Shape, 1e6)
+		if err != nil {
+			return nil, err
+		}
+		if len(path) > 0 && len(decoded) > 0 && decoded[0] == path[len(path)-1] {
+			decoded = decoded[1:]
+		}
+		path = append(path, decoded...)
+	}
+	return path, nil
+}
+
+// decodePolyline 解码 encoded polyline，返回 [lat, lng]
+func decodePolyline(s string, factor float64) ([][2]float64, error) {
+	var path [][2]float64
+	var lat, lng int64
+	for i := 0; i < len(s); {
+		var deltas [2]int64
+		for j := range deltas {
+			var result int64
+			var shift uint
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("invalid polyline")
+				}
+				b := int64(s[i]) - 63
+				i++
+				result |= (b & 0x1f) << shift
+				shift += 5
+				if b < 0x20 {
+					break
+				}
+			}
+			if result&1 != 0 {
+				deltas[j] = ^(result >> 1)
+			} else {
+				deltas[j] = result >> 1
+			}
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		path = append(path, [2]float64{float64(lat) / factor, float64(lng) / factor})
+	}
+	return path, nil
+}
@@ -0,0 +1,128 @@
+package tesla
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen 熔断器打开，请求未发送 (Tesla API 持续故障，等待冷却后再试)
+var ErrCircuitOpen = errors.New("tesla api circuit open")
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"    // 正常请求
+	BreakerOpen     = "open"      // 连续失败达到阈值，冷却期间直接拒绝请求
+	BreakerHalfOpen = "half_open" // 冷却结束，放行一个试探请求
+)
+
+// BreakerStatus 熔断器状态
+type BreakerStatus struct {
+	Enabled             bool       `json:"enabled"`
+	State               string     `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"` // 最近一次打开的时间
+	RetryAt             *time.Time `json:"retry_at,omitempty"`  // 打开状态下允许试探请求的时间
+}
+
+// circuitBreaker 按账号 (Client) 统计连续失败的熔断器
+// 只有网络错误、超时和 5xx 计为失败；车辆休眠 (408)、限流 (429) 等说明 API 仍可用
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // 连续失败次数阈值，0 表示不启用
+	cooldown  time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool // 半开状态下是否已有试探请求
+}
+
+// allow 判断是否可以发送请求，半开状态下只放行一个试探请求
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return nil
+	}
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	}
+	return nil
+}
+
+// record 记录请求结果；ignore 为 true 时 (如请求被取消) 不影响统计，只释放试探名额
+func (b *circuitBreaker) record(failed, ignore bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+	if ignore {
+		return
+	}
+	if !failed {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	// 半开状态下试探失败立即重新打开
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// status 获取熔断器状态
+func (b *circuitBreaker) status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		Enabled:             b.threshold > 0,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+	}
+	if !b.openedAt.IsZero() {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	if b.state == BreakerOpen {
+		retryAt := b.openedAt.Add(b.cooldown)
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+// isBreakerFailure 判断请求结果是否计为 API 故障
+// 返回 ignore 表示结果不代表 API 可用性 (请求被调用方取消)；请求超时计为故障
+func isBreakerFailure(ctx context.Context, resp *http.Response, err error) (failed, ignore bool) {
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return false, true
+		}
+		return true, false
+	}
+	return resp.StatusCode >= http.StatusInternalServerError, false
+}
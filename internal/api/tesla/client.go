@@ -2,12 +2,16 @@ package tesla
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,6 +29,61 @@ func (t *Token) IsExpired() bool {
 	return time.Now().After(t.CreatedAt.Add(time.Duration(t.ExpiresIn-300) * time.Second))
 }
 
+// ExpiresAt 访问令牌的过期时间
+func (t *Token) ExpiresAt() time.Time {
+	return t.CreatedAt.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// defaultTokenLifetime 无法从访问令牌解析有效期时假定的有效期
+const defaultTokenLifetime = 8 * time.Hour
+
+// NewToken 由用户提交的访问令牌和 Refresh Token 创建 Token
+// 访问令牌是 JWT 时按其中的 iat/exp 设置有效期，无法解析时假定从现在起 8 小时有效
+func NewToken(accessToken, refreshToken string) *Token {
+	token := &Token{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		CreatedAt:    time.Now(),
+		ExpiresIn:    int(defaultTokenLifetime.Seconds()),
+	}
+	if issuedAt, expiresAt, ok := parseJWTExpiry(accessToken); ok {
+		if !issuedAt.IsZero() {
+			token.CreatedAt = issuedAt
+		}
+		token.ExpiresIn = int(expiresAt.Sub(token.CreatedAt).Seconds())
+	}
+	return token
+}
+
+// parseJWTExpiry 解析 JWT 载荷中的签发时间 (iat，可能为零值) 和过期时间 (exp)，不校验签名
+func parseJWTExpiry(accessToken string) (issuedAt, expiresAt time.Time, ok bool) {
+	parts := strings.Split(accessToken, ".")
+	if len(parts) != 3 {
+		return time.Time{}, time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	var claims struct {
+		Iat int64 `json:"iat"`
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	if claims.Iat > 0 && claims.Iat < claims.Exp {
+		issuedAt = time.Unix(claims.Iat, 0)
+	}
+	return issuedAt, time.Unix(claims.Exp, 0), true
+}
+
+// ErrRefreshRejected 认证服务拒绝了 Refresh Token (已过期或被撤销)，需要重新认证
+var ErrRefreshRejected = errors.New("refresh token rejected")
+
+// DefaultUserAgent 请求 Tesla API 和 Streaming 时默认使用的 User-Agent
+const DefaultUserAgent = "Tesgazer/1.0"
+
 // Client Tesla API 客户端
 type Client struct {
 	httpClient  *http.Client
@@ -32,7 +91,17 @@ type Client struct {
 	apiHost     string
 	clientID    string
 	redirectURI string
-	token       *Token
+
+	// 请求标识
+	userAgent      string
+	teslaUserAgent string // X-Tesla-User-Agent，为空时不发送
+
+	mu               sync.RWMutex
+	token            *Token
+	refreshMu        sync.Mutex   // 串行化刷新，避免并发轮询时重复刷新
+	onTokenRefreshed func(*Token) // 刷新成功后的回调
+
+	breaker *circuitBreaker // API 持续故障时暂停请求
 }
 
 // NewClient 创建新的 Tesla API 客户端
@@ -45,29 +114,83 @@ func NewClient(authHost, apiHost, clientID, redirectURI string) *Client {
 		apiHost:     apiHost,
 		clientID:    clientID,
 		redirectURI: redirectURI,
+		userAgent:   DefaultUserAgent,
+		breaker:     &circuitBreaker{state: BreakerClosed},
+	}
+}
+
+// SetCircuitBreaker 设置熔断器：连续 threshold 次请求失败 (网络错误或 5xx) 后暂停请求 cooldown，
+// 之后放行一个试探请求，成功则恢复；threshold 为 0 时不启用
+func (c *Client) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	c.breaker.mu.Lock()
+	c.breaker.threshold = threshold
+	c.breaker.cooldown = cooldown
+	c.breaker.mu.Unlock()
+}
+
+// BreakerStatus 获取熔断器状态
+func (c *Client) BreakerStatus() BreakerStatus {
+	return c.breaker.status()
+}
+
+// SetUserAgent 设置请求的 User-Agent 和 X-Tesla-User-Agent
+// userAgent 为空时使用 DefaultUserAgent，teslaUserAgent 为空时不发送 X-Tesla-User-Agent
+func (c *Client) SetUserAgent(userAgent, teslaUserAgent string) {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	c.userAgent = userAgent
+	c.teslaUserAgent = teslaUserAgent
+}
+
+// setIdentityHeaders 设置请求的客户端标识头
+func setIdentityHeaders(h http.Header, userAgent, teslaUserAgent string) {
+	h.Set("User-Agent", userAgent)
+	if teslaUserAgent != "" {
+		h.Set("X-Tesla-User-Agent", teslaUserAgent)
 	}
 }
 
 // SetToken 设置认证令牌
 func (c *Client) SetToken(token *Token) {
+	c.mu.Lock()
 	c.token = token
+	c.mu.Unlock()
 }
 
 // GetToken 获取当前令牌
 func (c *Client) GetToken() *Token {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.token
 }
 
+// OnTokenRefreshed 设置 token 刷新成功后的回调 (如同步给 Streaming 客户端)
+// 回调在持有刷新锁时调用，不能在回调中再次刷新
+func (c *Client) OnTokenRefreshed(fn func(*Token)) {
+	c.mu.Lock()
+	c.onTokenRefreshed = fn
+	c.mu.Unlock()
+}
+
 // RefreshToken 刷新访问令牌
 func (c *Client) RefreshToken(ctx context.Context) error {
-	if c.token == nil || c.token.RefreshToken == "" {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+	return c.refreshToken(ctx)
+}
+
+// refreshToken 刷新访问令牌（调用方需持有 refreshMu）
+func (c *Client) refreshToken(ctx context.Context) error {
+	token := c.GetToken()
+	if token == nil || token.RefreshToken == "" {
 		return fmt.Errorf("no refresh token available")
 	}
 
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("client_id", c.clientID)
-	data.Set("refresh_token", c.token.RefreshToken)
+	data.Set("refresh_token", token.RefreshToken)
 	data.Set("scope", "openid email offline_access")
 
 	req, err := http.NewRequestWithContext(ctx, "POST", c.authHost+"/oauth2/v3/token", strings.NewReader(data.Encode()))
@@ -76,6 +199,7 @@ func (c *Client) RefreshToken(ctx context.Context) error {
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setIdentityHeaders(req.Header, c.userAgent, c.teslaUserAgent)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -85,6 +209,10 @@ func (c *Client) RefreshToken(ctx context.Context) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
+		// 400 (invalid_grant) / 401 / 403 表示 Refresh Token 本身不可用，重试无效
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("%w: status=%d body=%s", ErrRefreshRejected, resp.StatusCode, string(body))
+		}
 		return fmt.Errorf("refresh token failed: status=%d body=%s", resp.StatusCode, string(body))
 	}
 
@@ -94,22 +222,52 @@ func (c *Client) RefreshToken(ctx context.Context) error {
 	}
 
 	tokenResp.CreatedAt = time.Now()
-	c.token = &tokenResp
+	c.SetToken(&tokenResp)
+
+	c.mu.RLock()
+	onRefreshed := c.onTokenRefreshed
+	c.mu.RUnlock()
+	if onRefreshed != nil {
+		onRefreshed(&tokenResp)
+	}
 
 	return nil
 }
 
+// ensureFreshToken 确保 token 未过期，过期则刷新
+// 多个并发请求同时发现过期时只会刷新一次
+func (c *Client) ensureFreshToken(ctx context.Context) (*Token, error) {
+	token := c.GetToken()
+	if token == nil {
+		return nil, fmt.Errorf("not authenticated")
+	}
+	if !token.IsExpired() {
+		return token, nil
+	}
+
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	// 获取锁后再次检查，其他请求可能已经完成刷新
+	if token = c.GetToken(); !token.IsExpired() {
+		return token, nil
+	}
+	if err := c.refreshToken(ctx); err != nil {
+		return nil, err
+	}
+	return c.GetToken(), nil
+}
+
 // doRequest 执行带认证的请求
 func (c *Client) doRequest(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
-	if c.token == nil {
+	if c.GetToken() == nil {
 		return nil, fmt.Errorf("not authenticated")
 	}
 
 	// 检查 token 是否过期
-	if c.token.IsExpired() {
-		if err := c.RefreshToken(ctx); err != nil {
-			return nil, fmt.Errorf("refresh token: %w", err)
-		}
+	token, err := c.ensureFreshToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("refresh token: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, c.apiHost+path, body)
@@ -117,11 +275,61 @@ func (c *Client) doRequest(ctx context.Context, method, path string, body io.Rea
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.token.AccessToken)
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", "Tesgazer/1.0")
+	setIdentityHeaders(req.Header, c.userAgent, c.teslaUserAgent)
+
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	c.breaker.record(isBreakerFailure(ctx, resp, err))
+	if err != nil {
+		return nil, err
+	}
 
-	return c.httpClient.Do(req)
+	// 429 统一转换为 RateLimitError，携带 Tesla 返回的限流信息
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rlErr := parseRateLimit(resp.Header)
+		resp.Body.Close()
+		return nil, rlErr
+	}
+
+	return resp, nil
+}
+
+// parseRateLimit 从响应头解析限流信息
+// Retry-After 可以是秒数或 HTTP 日期；没有 Retry-After 时使用 RateLimit-Reset (秒)
+func parseRateLimit(h http.Header) *RateLimitError {
+	header := func(names ...string) string {
+		for _, name := range names {
+			if v := h.Get(name); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	e := &RateLimitError{
+		Limit:     header("RateLimit-Limit", "X-RateLimit-Limit"),
+		Remaining: header("RateLimit-Remaining", "X-RateLimit-Remaining"),
+		Reset:     header("RateLimit-Reset", "X-RateLimit-Reset"),
+	}
+
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = time.Until(t)
+		}
+	} else if secs, err := strconv.Atoi(e.Reset); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	if e.RetryAfter < 0 {
+		e.RetryAfter = 0
+	}
+
+	return e
 }
 
 // apiResponse 通用 API 响应结构
@@ -173,6 +381,27 @@ func (c *Client) ListVehicles(ctx context.Context) ([]Vehicle, error) {
 	return vehicles, nil
 }
 
+// VerifyToken 请求产品列表 (不会唤醒车辆) 校验当前令牌是否可用，访问令牌过期时先用 Refresh Token 刷新
+// 令牌无效返回 ErrUnauthorized，权限不足返回 ErrForbidden
+func (c *Client) VerifyToken(ctx context.Context) error {
+	resp, err := c.doRequest(ctx, "GET", "/api/1/products", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrForbidden
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return fmt.Errorf("verify token failed: status=%d body=%s", resp.StatusCode, string(body))
+}
+
 // GetVehicle 获取单个车辆信息
 func (c *Client) GetVehicle(ctx context.Context, id int64) (*Vehicle, error) {
 	resp, err := c.doRequest(ctx, "GET", fmt.Sprintf("/api/1/vehicles/%d", id), nil)
@@ -218,8 +447,6 @@ func (c *Client) GetVehicleData(ctx context.Context, id int64) (*VehicleData, er
 		return nil, ErrVehicleUnavailable
 	case http.StatusUnauthorized:
 		return nil, ErrUnauthorized
-	case http.StatusTooManyRequests:
-		return nil, ErrRateLimited
 	default:
 		body, _ := io.ReadAll(resp.Body)
 		return nil, fmt.Errorf("get vehicle data failed: status=%d body=%s", resp.StatusCode, string(body))
@@ -234,6 +461,7 @@ func (c *Client) GetVehicleData(ctx context.Context, id int64) (*VehicleData, er
 	if err := json.Unmarshal(apiResp.Response, &data); err != nil {
 		return nil, fmt.Errorf("decode vehicle data: %w", err)
 	}
+	data.Raw = apiResp.Response
 
 	return &data, nil
 }
@@ -258,5 +486,26 @@ func (c *Client) WakeUp(ctx context.Context, id int64) error {
 var (
 	ErrVehicleUnavailable = fmt.Errorf("vehicle unavailable")
 	ErrUnauthorized       = fmt.Errorf("unauthorized")
+	ErrForbidden          = fmt.Errorf("forbidden")
 	ErrRateLimited        = fmt.Errorf("rate limited")
 )
+
+// RateLimitError 请求被限流 (429)，errors.Is(err, ErrRateLimited) 为 true
+type RateLimitError struct {
+	RetryAfter time.Duration // 建议的重试等待时间，未知时为 0
+	Limit      string        // RateLimit-Limit
+	Remaining  string        // RateLimit-Remaining
+	Reset      string        // RateLimit-Reset
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("rate limited: retry after %s", e.RetryAfter)
+	}
+	return "rate limited"
+}
+
+// Is 使 errors.Is(err, ErrRateLimited) 成立
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"strconv"
 	"strings"
 	"sync"
@@ -27,7 +28,7 @@ type StreamData struct {
 
 	// 解析后的字段
 	Timestamp  int64   `json:"-"` // 时间戳 (毫秒)
-	Speed      int     `json:"-"` // 速度 (mph)
+	Speed      *int    `json:"-"` // 速度 (mph)，字段为空时为 nil (无数据)，停车时为 0
 	Odometer   float64 `json:"-"` // 里程 (miles)
 	SOC        int     `json:"-"` // 电量百分比
 	Elevation  int     `json:"-"` // 海拔 (m)
@@ -39,6 +40,14 @@ type StreamData struct {
 	Range      int     `json:"-"` // 续航 (miles)
 	EstRange   int     `json:"-"` // 估计续航 (miles)
 	Heading    int     `json:"-"` // 航向角
+
+	hasLat, hasLng bool // est_lat / est_lng 是否有值
+}
+
+// HasLocation 是否包含有效坐标：est_lat 和 est_lng 均有值，且不是缺少 GPS 定位时上报的 (0, 0)
+// 赤道或本初子午线附近只有一个分量为 0 的坐标仍然有效
+func (d *StreamData) HasLocation() bool {
+	return d.hasLat && d.hasLng && !(d.EstLat == 0 && d.EstLng == 0)
 }
 
 // StreamingCallbacks 流数据回调函数
@@ -47,6 +56,7 @@ type StreamingCallbacks struct {
 	OnConnect       func(vehicleID int64)                   // 连接成功
 	OnDisconnect    func(vehicleID int64, err error)        // 断开连接
 	OnVehicleOffline func(vehicleID int64)                  // 车辆离线，停止重连
+	OnFailed        func(vehicleID int64, attempts int)     // 连续重连失败次数达到上限，降级为仅轮询
 }
 
 // StreamingClient Tesla Streaming WebSocket 客户端
@@ -55,10 +65,16 @@ type StreamingClient struct {
 	vehicleID    int64
 	accessToken  string
 	host         string
+	fields       []string // 订阅字段，推送值按该顺序解析
 	conn         *websocket.Conn
 	callbacks    StreamingCallbacks
 
+	// 请求标识 (与 RESTful 客户端一致)
+	userAgent      string
+	teslaUserAgent string
+
 	mu              sync.RWMutex
+	writeMu         sync.Mutex // 串行化 WebSocket 写入 (连接时订阅与更新令牌后重新订阅)
 	connected       bool
 	vehicleOffline  bool // 车辆离线标记，停止自动重连
 	stopCh          chan struct{}
@@ -68,6 +84,15 @@ type StreamingClient struct {
 	reconnectDelay    time.Duration
 	maxReconnectDelay time.Duration
 	currentDelay      time.Duration
+
+	// 重连失败上限 (maxAttempts 为 0 表示不限制)
+	maxAttempts   int
+	retryCooldown time.Duration // 达到上限后等待该时长再重新尝试 (0 表示不再自动重试)
+	attempts      int           // 连续失败的连接次数，收到数据后清零
+	gotData       bool          // 当前连接是否收到过数据
+	failed        bool          // 已达到重连失败上限，收到数据后恢复
+	failedAt      time.Time     // 标记失败的时间
+	running       bool          // 重连循环是否在运行
 }
 
 // NewStreamingClient 创建 Streaming 客户端
@@ -77,6 +102,8 @@ func NewStreamingClient(logger *zap.Logger, vehicleID int64, accessToken string)
 		vehicleID:         vehicleID,
 		accessToken:       accessToken,
 		host:              StreamingHost,
+		fields:            DefaultStreamingFields,
+		userAgent:         DefaultUserAgent,
 		stopCh:            make(chan struct{}),
 		reconnectCh:       make(chan struct{}, 1),
 		reconnectDelay:    1 * time.Second,
@@ -90,11 +117,75 @@ func (c *StreamingClient) SetCallbacks(callbacks StreamingCallbacks) {
 	c.callbacks = callbacks
 }
 
+// SetReconnectLimit 设置重连失败上限
+// 连续 maxAttempts 次连接失败 (或连接后没有收到任何数据就断开) 时标记为失败并通知 OnFailed，
+// 等待 cooldown 后重新开始尝试；cooldown 为 0 时停止重连循环
+func (c *StreamingClient) SetReconnectLimit(maxAttempts int, cooldown time.Duration) {
+	c.mu.Lock()
+	c.maxAttempts = maxAttempts
+	c.retryCooldown = cooldown
+	c.mu.Unlock()
+}
+
 // SetHost 设置自定义 host (用于测试)
 func (c *StreamingClient) SetHost(host string) {
 	c.host = host
 }
 
+// SetFields 设置订阅字段 (为空时使用 DefaultStreamingFields)，在下次连接或重新订阅时生效
+func (c *StreamingClient) SetFields(fields []string) error {
+	if len(fields) == 0 {
+		fields = DefaultStreamingFields
+	}
+	if err := ValidateStreamingFields(fields); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.fields = append([]string(nil), fields...)
+	c.mu.Unlock()
+	return nil
+}
+
+// SetUserAgent 设置连接时的 User-Agent 和 X-Tesla-User-Agent，规则同 Client.SetUserAgent
+func (c *StreamingClient) SetUserAgent(userAgent, teslaUserAgent string) {
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	c.userAgent = userAgent
+	c.teslaUserAgent = teslaUserAgent
+}
+
+// SetAccessToken 更新访问令牌 (RESTful 客户端刷新 token 后调用)
+// 已连接时在当前连接上用新令牌重新订阅，不断开连接；重新订阅失败则断开并重连。
+// 未连接时新令牌在下次重连时使用
+func (c *StreamingClient) SetAccessToken(accessToken string) {
+	c.mu.Lock()
+	if c.accessToken == accessToken {
+		c.mu.Unlock()
+		return
+	}
+	c.accessToken = accessToken
+	connected := c.connected
+	c.mu.Unlock()
+
+	if !connected {
+		c.logger.Debug("Streaming token updated, will be used on next connect",
+			zap.Int64("vehicle_id", c.vehicleID))
+		return
+	}
+
+	if err := c.subscribe(); err != nil {
+		c.logger.Warn("Failed to resubscribe with new token, reconnecting",
+			zap.Int64("vehicle_id", c.vehicleID),
+			zap.Error(err))
+		c.triggerReconnect()
+		return
+	}
+
+	c.logger.Info("Streaming resubscribed with refreshed token",
+		zap.Int64("vehicle_id", c.vehicleID))
+}
+
 // Connect 连接到 Streaming API
 func (c *StreamingClient) Connect(ctx context.Context) error {
 	c.mu.Lock()
@@ -109,7 +200,9 @@ func (c *StreamingClient) Connect(ctx context.Context) error {
 		HandshakeTimeout: 10 * time.Second,
 	}
 
-	conn, _, err := dialer.DialContext(ctx, c.host, nil)
+	header := http.Header{}
+	setIdentityHeaders(header, c.userAgent, c.teslaUserAgent)
+	conn, _, err := dialer.DialContext(ctx, c.host, header)
 	if err != nil {
 		return fmt.Errorf("dial streaming: %w", err)
 	}
@@ -117,7 +210,7 @@ func (c *StreamingClient) Connect(ctx context.Context) error {
 	c.mu.Lock()
 	c.conn = conn
 	c.connected = true
-	c.currentDelay = c.reconnectDelay // 重置重连延迟
+	c.gotData = false
 	c.mu.Unlock()
 
 	// 发送订阅消息
@@ -165,6 +258,27 @@ func (c *StreamingClient) Close() error {
 	return nil
 }
 
+// IsFailed 检查是否已达到重连失败上限 (降级为仅轮询)，返回标记失败的时间
+func (c *StreamingClient) IsFailed() (bool, time.Time) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.failed, c.failedAt
+}
+
+// IsRunning 检查重连循环是否在运行
+func (c *StreamingClient) IsRunning() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.running
+}
+
+// ReconnectAttempts 返回连续失败的连接次数
+func (c *StreamingClient) ReconnectAttempts() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.attempts
+}
+
 // IsConnected 检查连接状态
 func (c *StreamingClient) IsConnected() bool {
 	c.mu.RLock()
@@ -174,23 +288,26 @@ func (c *StreamingClient) IsConnected() bool {
 
 // subscribe 发送订阅消息
 func (c *StreamingClient) subscribe() error {
-	// Tesla Streaming API 订阅格式
-	// 字段顺序: speed,odometer,soc,elevation,est_heading,est_lat,est_lng,power,shift_state,range,est_range,heading
+	// Tesla Streaming API 订阅格式，推送值按订阅字段的顺序返回
+	c.mu.RLock()
+	conn := c.conn
+	accessToken := c.accessToken
+	fields := c.fields
+	c.mu.RUnlock()
+
 	subscribeMsg := map[string]interface{}{
 		"msg_type":  "data:subscribe_oauth",
-		"token":     c.accessToken,
-		"value":     "speed,odometer,soc,elevation,est_heading,est_lat,est_lng,power,shift_state,range,est_range,heading",
+		"token":     accessToken,
+		"value":     strings.Join(fields, ","),
 		"tag":       strconv.FormatInt(c.vehicleID, 10),
 	}
 
-	c.mu.RLock()
-	conn := c.conn
-	c.mu.RUnlock()
-
 	if conn == nil {
 		return fmt.Errorf("not connected")
 	}
 
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
 	return conn.WriteJSON(subscribeMsg)
 }
 
@@ -263,12 +380,13 @@ func (c *StreamingClient) handleMessage(data *StreamData) {
 	case "data:update":
 		// 解析逗号分隔的值
 		c.parseDataValue(data)
+		c.markHealthy()
 
 		c.logger.Debug("Streaming data received",
 			zap.Int64("vehicle_id", c.vehicleID),
 			zap.String("shift_state", data.ShiftState),
 			zap.Int("power", data.Power),
-			zap.Int("speed", data.Speed),
+			zap.Intp("speed", data.Speed),
 			zap.Int("soc", data.SOC))
 
 		// 触发回调
@@ -316,34 +434,50 @@ func (c *StreamingClient) handleMessage(data *StreamData) {
 }
 
 // parseDataValue 解析逗号分隔的值
-// 字段顺序: timestamp,speed,odometer,soc,elevation,est_heading,est_lat,est_lng,power,shift_state,range,est_range,heading
+// 首位为时间戳，其余按订阅字段的顺序依次对应，未订阅的字段保持零值
 func (c *StreamingClient) parseDataValue(data *StreamData) {
 	if data.Value == "" {
 		return
 	}
 
+	c.mu.RLock()
+	fields := c.fields
+	c.mu.RUnlock()
+
 	parts := strings.Split(data.Value, ",")
-	if len(parts) < 13 {
+	if len(parts) < len(fields)+1 {
 		c.logger.Warn("Incomplete streaming data",
 			zap.Int64("vehicle_id", c.vehicleID),
-			zap.Int("parts_count", len(parts)))
+			zap.Int("parts_count", len(parts)),
+			zap.Int("fields_count", len(fields)))
 		return
 	}
 
 	// 解析各字段（忽略错误，使用默认值）
 	data.Timestamp, _ = strconv.ParseInt(parts[0], 10, 64)
-	data.Speed, _ = strconv.Atoi(parts[1])
-	data.Odometer, _ = strconv.ParseFloat(parts[2], 64)
-	data.SOC, _ = strconv.Atoi(parts[3])
-	data.Elevation, _ = strconv.Atoi(parts[4])
-	data.EstHeading, _ = strconv.Atoi(parts[5])
-	data.EstLat, _ = strconv.ParseFloat(parts[6], 64)
-	data.EstLng, _ = strconv.ParseFloat(parts[7], 64)
-	data.Power, _ = strconv.Atoi(parts[8])
-	data.ShiftState = parts[9]
-	data.Range, _ = strconv.Atoi(parts[10])
-	data.EstRange, _ = strconv.Atoi(parts[11])
-	data.Heading, _ = strconv.Atoi(parts[12])
+	for i, name := range fields {
+		if parse, ok := streamFieldParsers[name]; ok {
+			parse(data, parts[i+1])
+		}
+	}
+}
+
+// parseOptionalInt 解析可能为空的整数字段，空值或无法解析时返回 nil
+func parseOptionalInt(s string) *int {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// parseOptionalFloat 解析可能为空的浮点字段，第二个返回值表示是否有值
+func parseOptionalFloat(s string) (float64, bool) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
 }
 
 // triggerReconnect 触发重连
@@ -357,7 +491,17 @@ func (c *StreamingClient) triggerReconnect() {
 
 // StartWithReconnect 启动并自动重连
 func (c *StreamingClient) StartWithReconnect(ctx context.Context) {
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+
 	go func() {
+		defer func() {
+			c.mu.Lock()
+			c.running = false
+			c.mu.Unlock()
+		}()
+
 		for {
 			select {
 			case <-ctx.Done():
@@ -380,24 +524,8 @@ func (c *StreamingClient) StartWithReconnect(ctx context.Context) {
 
 			// 尝试连接
 			if err := c.Connect(ctx); err != nil {
-				c.logger.Warn("Streaming connect failed, will retry",
-					zap.Int64("vehicle_id", c.vehicleID),
-					zap.Duration("delay", c.currentDelay),
-					zap.Error(err))
-
-				// 等待重连延迟
-				select {
-				case <-ctx.Done():
-					return
-				case <-c.stopCh:
+				if !c.retryAfterFailure(ctx, err) {
 					return
-				case <-time.After(c.currentDelay):
-				}
-
-				// 指数退避
-				c.currentDelay *= 2
-				if c.currentDelay > c.maxReconnectDelay {
-					c.currentDelay = c.maxReconnectDelay
 				}
 				continue
 			}
@@ -427,12 +555,96 @@ func (c *StreamingClient) StartWithReconnect(ctx context.Context) {
 				// 重置 stopCh 和 connected 状态
 				c.mu.Lock()
 				c.stopCh = make(chan struct{})
+				gotData := c.gotData
 				c.mu.Unlock()
+
+				// 连接后没有收到任何数据就断开，同样算作一次失败 (例如协议变更或订阅被拒绝)
+				if !gotData && !c.retryAfterFailure(ctx, fmt.Errorf("disconnected before receiving data")) {
+					return
+				}
 			}
 		}
 	}()
 }
 
+// retryAfterFailure 记录一次失败的连接并等待重连延迟 (指数退避)
+// 连续失败次数达到上限时标记为失败并通知上层，之后等待冷却时间再重新尝试；
+// 返回 false 表示应退出重连循环
+func (c *StreamingClient) retryAfterFailure(ctx context.Context, err error) bool {
+	c.mu.Lock()
+	c.attempts++
+	attempts := c.attempts
+	delay := c.currentDelay
+	exhausted := c.maxAttempts > 0 && attempts >= c.maxAttempts
+	if exhausted {
+		if !c.failed {
+			c.failed = true
+			c.failedAt = time.Now()
+		}
+		delay = c.retryCooldown
+	} else {
+		// 指数退避
+		c.currentDelay *= 2
+		if c.currentDelay > c.maxReconnectDelay {
+			c.currentDelay = c.maxReconnectDelay
+		}
+	}
+	c.mu.Unlock()
+
+	if !exhausted {
+		c.logger.Warn("Streaming connect failed, will retry",
+			zap.Int64("vehicle_id", c.vehicleID),
+			zap.Int("attempt", attempts),
+			zap.Duration("delay", delay),
+			zap.Error(err))
+	} else {
+		c.logger.Warn("Streaming reconnect attempts exhausted, falling back to polling only",
+			zap.Int64("vehicle_id", c.vehicleID),
+			zap.Int("attempts", attempts),
+			zap.Duration("retry_after", delay),
+			zap.Error(err))
+		if c.callbacks.OnFailed != nil {
+			c.callbacks.OnFailed(c.vehicleID, attempts)
+		}
+		if delay <= 0 {
+			return false
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-c.stopCh:
+		return false
+	case <-time.After(delay):
+	}
+
+	// 冷却结束，重新开始计数
+	if exhausted {
+		c.mu.Lock()
+		c.attempts = 0
+		c.currentDelay = c.reconnectDelay
+		c.mu.Unlock()
+	}
+	return true
+}
+
+// markHealthy 收到数据后清零失败计数和重连延迟，并清除失败标记
+func (c *StreamingClient) markHealthy() {
+	c.mu.Lock()
+	recovered := c.failed
+	c.gotData = true
+	c.attempts = 0
+	c.currentDelay = c.reconnectDelay
+	c.failed = false
+	c.mu.Unlock()
+
+	if recovered {
+		c.logger.Info("Streaming recovered",
+			zap.Int64("vehicle_id", c.vehicleID))
+	}
+}
+
 // Stop 停止客户端（包括重连循环）
 func (c *StreamingClient) Stop() {
 	c.Close()
@@ -450,6 +662,7 @@ func (c *StreamingClient) ResetAndRestart(ctx context.Context) {
 	c.mu.Lock()
 	c.vehicleOffline = false
 	c.currentDelay = c.reconnectDelay
+	c.attempts = 0
 	c.stopCh = make(chan struct{})
 	c.mu.Unlock()
 
@@ -0,0 +1,46 @@
+package tesla
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DefaultStreamingFields 默认订阅的 Streaming 字段 (顺序即推送值的顺序，推送值首位固定为时间戳)
+var DefaultStreamingFields = []string{
+	"speed", "odometer", "soc", "elevation", "est_heading", "est_lat", "est_lng",
+	"power", "shift_state", "range", "est_range", "heading",
+}
+
+// streamFieldParsers 各订阅字段的解析函数 (忽略解析错误，使用零值)
+var streamFieldParsers = map[string]func(data *StreamData, v string){
+	"speed":       func(d *StreamData, v string) { d.Speed = parseOptionalInt(v) },
+	"odometer":    func(d *StreamData, v string) { d.Odometer, _ = strconv.ParseFloat(v, 64) },
+	"soc":         func(d *StreamData, v string) { d.SOC, _ = strconv.Atoi(v) },
+	"elevation":   func(d *StreamData, v string) { d.Elevation, _ = strconv.Atoi(v) },
+	"est_heading": func(d *StreamData, v string) { d.EstHeading, _ = strconv.Atoi(v) },
+	"est_lat":     func(d *StreamData, v string) { d.EstLat, d.hasLat = parseOptionalFloat(v) },
+	"est_lng":     func(d *StreamData, v string) { d.EstLng, d.hasLng = parseOptionalFloat(v) },
+	"power":       func(d *StreamData, v string) { d.Power, _ = strconv.Atoi(v) },
+	"shift_state": func(d *StreamData, v string) { d.ShiftState = v },
+	"range":       func(d *StreamData, v string) { d.Range, _ = strconv.Atoi(v) },
+	"est_range":   func(d *StreamData, v string) { d.EstRange, _ = strconv.Atoi(v) },
+	"heading":     func(d *StreamData, v string) { d.Heading, _ = strconv.Atoi(v) },
+}
+
+// ValidateStreamingFields 检查订阅字段：不能为空，不能包含不支持或重复的字段
+func ValidateStreamingFields(fields []string) error {
+	if len(fields) == 0 {
+		return fmt.Errorf("no streaming fields")
+	}
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if _, ok := streamFieldParsers[f]; !ok {
+			return fmt.Errorf("unsupported streaming field %q", f)
+		}
+		if seen[f] {
+			return fmt.Errorf("duplicate streaming field %q", f)
+		}
+		seen[f] = true
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package tesla
+
+import "testing"
+
+func TestParseOptionalInt(t *testing.T) {
+	tests := []struct {
+		in   string
+		want *int
+	}{
+		{in: "", want: nil},
+		{in: "abc", want: nil},
+		{in: "0", want: func() *int { v := 0; return &v }()},
+		{in: "42", want: func() *int { v := 42; return &v }()},
+	}
+
+	for _, tt := range tests {
+		got := parseOptionalInt(tt.in)
+		switch {
+		case got == nil && tt.want != nil:
+			t.Errorf("parseOptionalInt(%q) = nil, want %d", tt.in, *tt.want)
+		case got != nil && tt.want == nil:
+			t.Errorf("parseOptionalInt(%q) = %d, want nil", tt.in, *got)
+		case got != nil && *got != *tt.want:
+			t.Errorf("parseOptionalInt(%q) = %d, want %d", tt.in, *got, *tt.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package tesla
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Fleet Telemetry 数据格式 (teslamotors/fleet-telemetry protos/vehicle_data.proto)
+// 只解码需要的字段，直接按字段编号读取，不依赖生成的代码：
+//
+//	message Payload       { repeated Datum data = 1; Timestamp created_at = 2; string vin = 3; }
+//	message Datum         { Field key = 1; Value value = 2; }
+//	message Value         { oneof value { string string_value = 1; int32 int_value = 2; int64 long_value = 3;
+//	                        float float_value = 4; double double_value = 5; bool boolean_value = 6;
+//	                        LocationValue location_value = 7; ...; ShiftState shift_state_value = 9; bool invalid = 10; } }
+//	message LocationValue { double latitude = 1; double longitude = 2; }
+
+// Payload / Datum / Value 的字段编号
+const (
+	telemetryPayloadData      protowire.Number = 1
+	telemetryPayloadCreatedAt protowire.Number = 2
+	telemetryPayloadVIN       protowire.Number = 3
+
+	telemetryDatumKey   protowire.Number = 1
+	telemetryDatumValue protowire.Number = 2
+
+	telemetryValueString     protowire.Number = 1
+	telemetryValueInt        protowire.Number = 2
+	telemetryValueLong       protowire.Number = 3
+	telemetryValueFloat      protowire.Number = 4
+	telemetryValueDouble     protowire.Number = 5
+	telemetryValueBool       protowire.Number = 6
+	telemetryValueLocation   protowire.Number = 7
+	telemetryValueShiftState protowire.Number = 9
+	telemetryValueInvalid    protowire.Number = 10
+)
+
+// TelemetryField Fleet Telemetry 字段 (Field 枚举中用到的部分)
+type TelemetryField int32
+
+const (
+	TelemetryFieldVehicleSpeed    TelemetryField = 4  // 车速 (mph)
+	TelemetryFieldOdometer        TelemetryField = 5  // 里程 (miles)
+	TelemetryFieldPackVoltage     TelemetryField = 6  // 电池包电压 (V)
+	TelemetryFieldPackCurrent     TelemetryField = 7  // 电池包电流 (A)，正值为放电
+	TelemetryFieldSoc             TelemetryField = 8  // BMS 电量 (%)
+	TelemetryFieldGear            TelemetryField = 10 // 挡位
+	TelemetryFieldLocation        TelemetryField = 21 // 位置
+	TelemetryFieldGpsHeading      TelemetryField = 23 // 航向角
+	TelemetryFieldDCChargingPower TelemetryField = 35 // 直流充电功率 (kW)
+	TelemetryFieldACChargingPower TelemetryField = 37 // 交流充电功率 (kW)
+	TelemetryFieldEstBatteryRange TelemetryField = 40 // 估计续航 (miles)
+	TelemetryFieldBatteryLevel    TelemetryField = 42 // 显示电量 (%)
+)
+
+// ShiftState 枚举值 (ShiftStateUnknown = 0, ShiftStateInvalid = 1, 之后依次为 P/R/N/D/SNA)
+var telemetryShiftStates = map[int64]string{2: "P", 3: "R", 4: "N", 5: "D"}
+
+// ErrInvalidTelemetry Fleet Telemetry 消息无法解码
+var ErrInvalidTelemetry = errors.New("invalid telemetry payload")
+
+// TelemetryValue 解码后的字段值
+type TelemetryValue struct {
+	Number    *float64 // 数值类型 (int/long/float/double/bool，或可解析为数字的字符串)
+	Text      string   // 字符串或枚举名
+	Latitude  float64
+	Longitude float64
+	Location  bool // 是否为位置值
+}
+
+// TelemetryPayload 解码后的 Fleet Telemetry 消息
+type TelemetryPayload struct {
+	VIN       string
+	CreatedAt time.Time
+	Values    map[TelemetryField]TelemetryValue
+}
+
+// DecodeTelemetryPayload 解码 Fleet Telemetry 的 Payload 消息 (protobuf 二进制)
+func DecodeTelemetryPayload(b []byte) (*TelemetryPayload, error) {
+	p := &TelemetryPayload{Values: make(map[TelemetryField]TelemetryValue)}
+
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == telemetryPayloadData && typ == protowire.BytesType:
+			return p.decodeDatum(v)
+		case num == telemetryPayloadCreatedAt && typ == protowire.BytesType:
+			return decodeTimestamp(v, &p.CreatedAt)
+		case num == telemetryPayloadVIN && typ == protowire.BytesType:
+			p.VIN = string(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.VIN == "" {
+		return nil, fmt.Errorf("%w: missing vin", ErrInvalidTelemetry)
+	}
+	return p, nil
+}
+
+// decodeDatum 解码单个字段
+func (p *TelemetryPayload) decodeDatum(b []byte) error {
+	var key TelemetryField
+	var value TelemetryValue
+	var hasValue, invalid bool
+
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch {
+		case num == telemetryDatumKey && typ == protowire.VarintType:
+			key = TelemetryField(int32(n))
+		case num == telemetryDatumValue && typ == protowire.BytesType:
+			hasValue = true
+			var err error
+			value, invalid, err = decodeTelemetryValue(v)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 车辆在字段无效时会发送 invalid，例如熄火后的车速
+	if hasValue && !invalid {
+		p.Values[key] = value
+	}
+	return nil
+}
+
+// decodeTelemetryValue 解码 Value (oneof)
+func decodeTelemetryValue(b []byte) (TelemetryValue, bool, error) {
+	var tv TelemetryValue
+	invalid := false
+	number := func(f float64) { tv.Number = &f }
+
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error {
+		switch num {
+		case telemetryValueString:
+			tv.Text = string(v)
+			if f, err := strconv.ParseFloat(tv.Text, 64); err == nil {
+				number(f)
+			}
+		case telemetryValueInt:
+			number(float64(int32(n)))
+		case telemetryValueLong:
+			number(float64(int64(n)))
+		case telemetryValueFloat:
+			number(float64(math.Float32frombits(uint32(n))))
+		case telemetryValueDouble:
+			number(math.Float64frombits(n))
+		case telemetryValueBool:
+			number(float64(n))
+		case telemetryValueLocation:
+			tv.Location = true
+			return walkMessage(v, func(num protowire.Number, typ protowire.Type, _ []byte, n uint64) error {
+				switch num {
+				case 1:
+					tv.Latitude = math.Float64frombits(n)
+				case 2:
+					tv.Longitude = math.Float64frombits(n)
+				}
+				return nil
+			})
+		case telemetryValueShiftState:
+			tv.Text = telemetryShiftStates[int64(n)]
+		case telemetryValueInvalid:
+			invalid = n != 0
+		}
+		return nil
+	})
+	return tv, invalid, err
+}
+
+// decodeTimestamp 解码 google.protobuf.Timestamp
+func decodeTimestamp(b []byte, t *time.Time) error {
+	var seconds, nanos int64
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, _ []byte, n uint64) error {
+		switch num {
+		case 1:
+			seconds = int64(n)
+		case 2:
+			nanos = int64(int32(n))
+		}
+		return nil
+	})
+	if err == nil {
+		*t = time.Unix(seconds, nanos)
+	}
+	return err
+}
+
+// walkMessage 依次读取消息中的字段
+// varint/fixed 类型的值通过 n 传入，bytes 类型通过 v 传入
+func walkMessage(b []byte, fn func(num protowire.Number, typ protowire.Type, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, l := protowire.ConsumeTag(b)
+		if l < 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidTelemetry, protowire.ParseError(l))
+		}
+		b = b[l:]
+
+		var v []byte
+		var n uint64
+		switch typ {
+		case protowire.VarintType:
+			n, l = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, l = protowire.ConsumeFixed32(b)
+			n = uint64(x)
+		case protowire.Fixed64Type:
+			n, l = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v, l = protowire.ConsumeBytes(b)
+		default:
+			l = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if l < 0 {
+			return fmt.Errorf("%w: %v", ErrInvalidTelemetry, protowire.ParseError(l))
+		}
+		b = b[l:]
+
+		if err := fn(num, typ, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StreamData 将 Fleet Telemetry 数据转换为与 Streaming API 相同的格式，复用同一处理流程
+// 单位与 Streaming API 一致 (mph / miles)；消息中没有的字段保持零值
+func (p *TelemetryPayload) StreamData() *StreamData {
+	data := &StreamData{MsgType: "data:update", Timestamp: p.CreatedAt.UnixMilli()}
+
+	if v, ok := p.number(TelemetryFieldVehicleSpeed); ok {
+		speed := int(math.Round(v))
+		data.Speed = &speed
+	}
+	if v, ok := p.number(TelemetryFieldOdometer); ok {
+		data.Odometer = v
+	}
+	if v, ok := p.number(TelemetryFieldBatteryLevel); ok {
+		data.SOC = int(math.Round(v))
+	} else if v, ok := p.number(TelemetryFieldSoc); ok {
+		data.SOC = int(math.Round(v))
+	}
+	if v, ok := p.Values[TelemetryFieldLocation]; ok && v.Location {
+		data.EstLat = v.Latitude
+		data.EstLng = v.Longitude
+		data.hasLat, data.hasLng = true, true
+	}
+	if v, ok := p.number(TelemetryFieldGpsHeading); ok {
+		data.Heading = int(math.Round(v))
+		data.EstHeading = data.Heading
+	}
+	if v, ok := p.number(TelemetryFieldEstBatteryRange); ok {
+		data.EstRange = int(math.Round(v))
+		data.Range = data.EstRange
+	}
+	if v, ok := p.Values[TelemetryFieldGear]; ok {
+		// 旧版本以字符串发送 (如 "ShiftStateD" 或 "D")
+		data.ShiftState = strings.TrimPrefix(v.Text, "ShiftState")
+	}
+
+	// 功率：充电时取充电功率 (负值)，否则按电池包电压×电流计算
+	// 动能回收时电流为负，但负功率在处理流程中表示充电，因此不低于 0
+	chargingPower := 0.0
+	if v, ok := p.number(TelemetryFieldDCChargingPower); ok && v > chargingPower {
+		chargingPower = v
+	}
+	if v, ok := p.number(TelemetryFieldACChargingPower); ok && v > chargingPower {
+		chargingPower = v
+	}
+	if chargingPower > 0 {
+		data.Power = -int(math.Round(chargingPower))
+	} else {
+		voltage, okV := p.number(TelemetryFieldPackVoltage)
+		current, okC := p.number(TelemetryFieldPackCurrent)
+		if okV && okC {
+			data.Power = int(math.Round(math.Max(voltage*current/1000, 0)))
+		}
+	}
+
+	return data
+}
+
+// number 获取数值字段
+func (p *TelemetryPayload) number(field TelemetryField) (float64, bool) {
+	v, ok := p.Values[field]
+	if !ok || v.Number == nil {
+		return 0, false
+	}
+	return *v.Number, true
+}
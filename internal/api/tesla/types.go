@@ -1,6 +1,10 @@
 package tesla
 
-import "time"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 // Vehicle 车辆基础信息
 type Vehicle struct {
@@ -26,6 +30,10 @@ type VehicleData struct {
 	DriveState    *DriveState    `json:"drive_state,omitempty"`
 	VehicleState  *VehicleState  `json:"vehicle_state,omitempty"`
 	VehicleConfig *VehicleConfig `json:"vehicle_config,omitempty"`
+	ClosuresState *ClosuresState `json:"closures_state,omitempty"` // Fleet API 单独返回的车门、车窗状态
+	GuiSettings   *GuiSettings   `json:"gui_settings,omitempty"`   // 车机显示设置 (单位偏好)
+
+	Raw json.RawMessage `json:"-"` // Tesla 返回的原始 response (调试用)
 }
 
 // ChargeState 充电状态
@@ -46,6 +54,8 @@ type ChargeState struct {
 	ChargerVoltage         int       `json:"charger_voltage"`
 	ChargerActualCurrent   int       `json:"charger_actual_current"`
 	ChargerPilotCurrent    int       `json:"charger_pilot_current"`
+	ChargerPhases          *int      `json:"charger_phases"`       // 交流充电相数，直流充电时为 null
+	FastChargerPresent     bool      `json:"fast_charger_present"` // 是否连接直流快充
 	ChargeCurrentRequest   int       `json:"charge_current_request"`
 	ChargeCurrentRequestMax int      `json:"charge_current_request_max"`
 	ChargeEnergyAdded      float64   `json:"charge_energy_added"` // kWh
@@ -104,16 +114,7 @@ type VehicleState struct {
 	SoftwareUpdate          *SoftwareUpdate `json:"software_update,omitempty"`
 	SpeedLimitMode          *SpeedLimitMode `json:"speed_limit_mode,omitempty"`
 	CenterDisplayState      int     `json:"center_display_state"`
-	DriverDoorOpen          int     `json:"df"` // driver front (0=closed, non-0=open)
-	PassengerDoorOpen       int     `json:"pf"` // passenger front
-	DriverRearDoorOpen      int     `json:"dr"` // driver rear
-	PassengerRearDoorOpen   int     `json:"pr"` // passenger rear
-	FrunkOpen               int     `json:"ft"` // front trunk
-	TrunkOpen               int     `json:"rt"` // rear trunk
-	DriverWindowOpen        int     `json:"fd_window"`
-	PassengerWindowOpen     int     `json:"fp_window"`
-	DriverRearWindowOpen    int     `json:"rd_window"`
-	PassengerRearWindowOpen int     `json:"rp_window"`
+	ClosuresState                   // 车门、车窗 (owner API 合并在 vehicle_state 中返回)
 	IsUserPresent           bool    `json:"is_user_present"`
 	VehicleName             string  `json:"vehicle_name"`
 	// TPMS 胎压数据 (bar)
@@ -124,6 +125,22 @@ type VehicleState struct {
 	Timestamp               int64   `json:"timestamp"`
 }
 
+// ClosuresState 车门、车窗、前后备箱和天窗状态
+// 车门和前后备箱为 0 关闭、非 0 打开；车窗为 Tesla 上报的开度，0 为关闭 (部分车型/固件上报 1=通风、2=打开，新固件为开度百分比)
+type ClosuresState struct {
+	DriverDoorOpen          int  `json:"df"` // driver front (0=closed, non-0=open)
+	PassengerDoorOpen       int  `json:"pf"` // passenger front
+	DriverRearDoorOpen      int  `json:"dr"` // driver rear
+	PassengerRearDoorOpen   int  `json:"pr"` // passenger rear
+	FrunkOpen               int  `json:"ft"` // front trunk
+	TrunkOpen               int  `json:"rt"` // rear trunk
+	DriverWindowOpen        int  `json:"fd_window"`
+	PassengerWindowOpen     int  `json:"fp_window"`
+	DriverRearWindowOpen    int  `json:"rd_window"`
+	PassengerRearWindowOpen int  `json:"rp_window"`
+	SunRoofPercentOpen      *int `json:"sun_roof_percent_open,omitempty"` // 天窗开度 (%)，无天窗的车型不返回
+}
+
 // SoftwareUpdate 软件更新信息
 type SoftwareUpdate struct {
 	DownloadPerc        int    `json:"download_perc"`
@@ -163,6 +180,50 @@ type VehicleConfig struct {
 	WheelType           string `json:"wheel_type"`
 }
 
+// GuiSettings 车机显示设置
+type GuiSettings struct {
+	Gui24HourTime        bool   `json:"gui_24_hour_time"`
+	GuiChargeRateUnits   string `json:"gui_charge_rate_units"`  // kW 或 mi/hr、km/hr
+	GuiDistanceUnits     string `json:"gui_distance_units"`     // mi/hr 或 km/hr
+	GuiRangeDisplay      string `json:"gui_range_display"`      // Rated 或 Ideal
+	GuiTemperatureUnits  string `json:"gui_temperature_units"`  // F 或 C
+	GuiTirepressureUnits string `json:"gui_tirepressure_units"` // Psi、Bar 或 kPa
+	ShowRangeUnits       bool   `json:"show_range_units"`
+	Timestamp            int64  `json:"timestamp"`
+}
+
+// DistanceUnit 车机设置的距离单位: km 或 mi，无法识别时为空
+func (g *GuiSettings) DistanceUnit() string {
+	switch {
+	case strings.HasPrefix(g.GuiDistanceUnits, "mi"):
+		return "mi"
+	case strings.HasPrefix(g.GuiDistanceUnits, "km"):
+		return "km"
+	}
+	return ""
+}
+
+// TemperatureUnit 车机设置的温度单位: C 或 F，无法识别时为空
+func (g *GuiSettings) TemperatureUnit() string {
+	switch g.GuiTemperatureUnits {
+	case "C", "F":
+		return g.GuiTemperatureUnits
+	}
+	return ""
+}
+
+// ACPowerKw 按电压×电流×相数计算交流充电功率 (kW)
+// charger_power 为取整后的值，三相充电时 charger_voltage/charger_actual_current 为单相数值；
+// 直流充电或缺少相数时返回 nil
+func (cs *ChargeState) ACPowerKw() *float64 {
+	if cs.FastChargerPresent || cs.ChargerPhases == nil || *cs.ChargerPhases <= 0 ||
+		cs.ChargerVoltage <= 0 || cs.ChargerActualCurrent <= 0 {
+		return nil
+	}
+	power := float64(cs.ChargerVoltage*cs.ChargerActualCurrent**cs.ChargerPhases) / 1000
+	return &power
+}
+
 // Helper functions
 
 // MilesToKm 英里转公里
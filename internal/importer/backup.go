@@ -0,0 +1,67 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// BackupSchemaVersion 备份文件格式版本
+// 字段或记录类型发生不兼容变化时递增，导入时拒绝更高版本的文件
+const BackupSchemaVersion = 1
+
+// 备份文件中的记录类型
+// 文件为 NDJSON (每行一个 BackupRecord)，按以下顺序写出：
+// header → drive → charging_process → charge → parking → parking_event → position → footer
+// 位置记录数据量最大，放在最后，导入时其余记录的 ID 映射已经就绪
+const (
+	BackupRecordHeader          = "header"
+	BackupRecordDrive           = "drive"
+	BackupRecordChargingProcess = "charging_process"
+	BackupRecordCharge          = "charge"
+	BackupRecordParking         = "parking"
+	BackupRecordParkingEvent    = "parking_event"
+	BackupRecordPosition        = "position"
+	BackupRecordFooter          = "footer"
+)
+
+var (
+	// ErrBackupMissingHeader 备份文件第一行不是 header
+	ErrBackupMissingHeader = errors.New("backup header missing")
+	// ErrBackupUnsupportedVersion 备份文件版本高于当前支持的版本
+	ErrBackupUnsupportedVersion = errors.New("unsupported backup schema version")
+	// ErrBackupIncomplete 备份文件缺少 footer 或记录数与 footer 不一致 (导出中断)
+	ErrBackupIncomplete = errors.New("backup file incomplete")
+)
+
+// BackupRecord 备份文件中的一行
+type BackupRecord struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// BackupHeader 备份文件头，包含格式版本和车辆信息
+type BackupHeader struct {
+	SchemaVersion int         `json:"schema_version"`
+	ExportedAt    time.Time   `json:"exported_at"`
+	From          *time.Time  `json:"from,omitempty"`
+	To            *time.Time  `json:"to,omitempty"`
+	Car           *models.Car `json:"car"`
+}
+
+// BackupFooter 备份文件尾，记录各类记录数用于校验完整性
+type BackupFooter struct {
+	Counts BackupStats `json:"counts"`
+}
+
+// BackupStats 各类记录数量
+type BackupStats struct {
+	Drives            int `json:"drives"`
+	ChargingProcesses int `json:"charging_processes"`
+	Charges           int `json:"charges"`
+	Parkings          int `json:"parkings"`
+	ParkingEvents     int `json:"parking_events"`
+	Positions         int `json:"positions"`
+}
@@ -0,0 +1,278 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// backupFlushEvery 每写出多少行调用一次 flush
+const backupFlushEvery = 500
+
+// BackupExporter 将单辆车的数据导出为 NDJSON 备份文件
+// 逐行读取逐行写出，不在内存中缓存记录，适合导出大量位置数据
+type BackupExporter struct {
+	db *repository.DB
+}
+
+// NewBackupExporter 创建备份导出器
+func NewBackupExporter(db *repository.DB) *BackupExporter {
+	return &BackupExporter{db: db}
+}
+
+// backupWriter 写出备份记录并计数
+type backupWriter struct {
+	enc     *json.Encoder
+	flush   func()
+	written int
+}
+
+// write 写出一行记录
+func (w *backupWriter) write(recordType string, data interface{}) error {
+	record := struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{recordType, data}
+	if err := w.enc.Encode(record); err != nil {
+		return fmt.Errorf("write %s: %w", recordType, err)
+	}
+	w.written++
+	if w.flush != nil && w.written%backupFlushEvery == 0 {
+		w.flush()
+	}
+	return nil
+}
+
+// Export 导出车辆在 [from, to) 时间范围内的行程、充电、停车和位置记录
+// from/to 为 nil 时不限制；行程、充电、停车按开始时间过滤，位置按记录时间过滤
+// flush 可为 nil，用于 HTTP 流式响应时及时把数据推给客户端
+func (e *BackupExporter) Export(ctx context.Context, w io.Writer, car *models.Car, from, to *time.Time, flush func()) (*BackupStats, error) {
+	bw := &backupWriter{enc: json.NewEncoder(w), flush: flush}
+	stats := &BackupStats{}
+	var err error
+
+	header := &BackupHeader{
+		SchemaVersion: BackupSchemaVersion,
+		ExportedAt:    time.Now(),
+		From:          from,
+		To:            to,
+		Car:           car,
+	}
+	if err := bw.write(BackupRecordHeader, header); err != nil {
+		return stats, err
+	}
+
+	if stats.Drives, err = e.exportDrives(ctx, bw, car.ID, from, to); err != nil {
+		return stats, fmt.Errorf("export drives: %w", err)
+	}
+	if stats.ChargingProcesses, err = e.exportChargingProcesses(ctx, bw, car.ID, from, to); err != nil {
+		return stats, fmt.Errorf("export charging processes: %w", err)
+	}
+	if stats.Charges, err = e.exportCharges(ctx, bw, car.ID, from, to); err != nil {
+		return stats, fmt.Errorf("export charges: %w", err)
+	}
+	if stats.Parkings, err = e.exportParkings(ctx, bw, car.ID, from, to); err != nil {
+		return stats, fmt.Errorf("export parkings: %w", err)
+	}
+	if stats.ParkingEvents, err = e.exportParkingEvents(ctx, bw, car.ID, from, to); err != nil {
+		return stats, fmt.Errorf("export parking events: %w", err)
+	}
+	if stats.Positions, err = e.exportPositions(ctx, bw, car.ID, from, to); err != nil {
+		return stats, fmt.Errorf("export positions: %w", err)
+	}
+
+	if err := bw.write(BackupRecordFooter, &BackupFooter{Counts: *stats}); err != nil {
+		return stats, err
+	}
+	if flush != nil {
+		flush()
+	}
+	return stats, nil
+}
+
+// rangeFilter 生成按时间列过滤的条件，参数 $2/$3 为可空的 from/to
+func rangeFilter(column string) string {
+	return fmt.Sprintf("($2::timestamptz IS NULL OR %[1]s >= $2) AND ($3::timestamptz IS NULL OR %[1]s < $3)", column)
+}
+
+// exportRows 执行查询并逐行写出
+func (e *BackupExporter) exportRows(ctx context.Context, bw *backupWriter, recordType, query string, args []interface{}, scan func(rows pgx.Rows) (interface{}, error)) (int, error) {
+	rows, err := e.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		record, err := scan(rows)
+		if err != nil {
+			return n, fmt.Errorf("scan %s: %w", recordType, err)
+		}
+		if err := bw.write(recordType, record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+// exportDrives 导出行程
+func (e *BackupExporter) exportDrives(ctx context.Context, bw *backupWriter, carID int64, from, to *time.Time) (int, error) {
+	query := `
+		SELECT id, car_id, start_time, end_time, start_position_id, end_position_id, start_geofence_id, end_geofence_id,
+			distance_km, duration_min, start_battery_level, end_battery_level, start_range_km, end_range_km,
+			start_odometer_km, end_odometer_km, speed_max, power_max, power_min, inside_temp_avg, outside_temp_avg,
+			energy_used_kwh, energy_regen_kwh, energy_soc_kwh, energy_climate_kwh,
+			start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude,
+			accel_max_g, decel_max_g, harsh_accel_count, harsh_brake_count
+		FROM drives WHERE car_id = $1 AND ` + rangeFilter("start_time") + `
+		ORDER BY start_time
+	`
+	return e.exportRows(ctx, bw, BackupRecordDrive, query, []interface{}{carID, from, to}, func(rows pgx.Rows) (interface{}, error) {
+		d := &models.Drive{}
+		err := rows.Scan(
+			&d.ID, &d.CarID, &d.StartTime, &d.EndTime, &d.StartPositionID, &d.EndPositionID, &d.StartGeofenceID, &d.EndGeofenceID,
+			&d.DistanceKm, &d.DurationMin, &d.StartBatteryLevel, &d.EndBatteryLevel, &d.StartRangeKm, &d.EndRangeKm,
+			&d.StartOdometerKm, &d.EndOdometerKm, &d.SpeedMax, &d.PowerMax, &d.PowerMin, &d.InsideTempAvg, &d.OutsideTempAvg,
+			&d.EnergyUsedKwh, &d.EnergyRegenKwh, &d.EnergySocKwh, &d.EnergyClimateKwh,
+			&d.StartAddress, &d.EndAddress, &d.StartLatitude, &d.StartLongitude, &d.EndLatitude, &d.EndLongitude,
+			&d.AccelMaxG, &d.DecelMaxG, &d.HarshAccelCount, &d.HarshBrakeCount,
+		)
+		d.IsActive = d.EndTime == nil
+		return d, err
+	})
+}
+
+// exportChargingProcesses 导出充电过程
+func (e *BackupExporter) exportChargingProcesses(ctx context.Context, bw *backupWriter, carID int64, from, to *time.Time) (int, error) {
+	query := `
+		SELECT id, car_id, position_id, geofence_id, start_time, end_time, start_battery_level, end_battery_level,
+			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address,
+			charge_limit_soc, scheduled_mode, charge_energy_reported, charge_energy_estimated, outside_temp_samples, end_charging_state,
+			battery_heater_min
+		FROM charging_processes WHERE car_id = $1 AND ` + rangeFilter("start_time") + `
+		ORDER BY start_time
+	`
+	return e.exportRows(ctx, bw, BackupRecordChargingProcess, query, []interface{}{carID, from, to}, func(rows pgx.Rows) (interface{}, error) {
+		cp := &models.ChargingProcess{}
+		err := rows.Scan(
+			&cp.ID, &cp.CarID, &cp.PositionID, &cp.GeofenceID, &cp.StartTime, &cp.EndTime, &cp.StartBatteryLevel, &cp.EndBatteryLevel,
+			&cp.StartRangeKm, &cp.EndRangeKm, &cp.ChargeEnergyAdded, &cp.ChargerPowerMax, &cp.DurationMin, &cp.OutsideTempAvg, &cp.Cost, &cp.Address,
+			&cp.ChargeLimitSoc, &cp.ScheduledMode, &cp.EnergyReported, &cp.EnergyEstimated, &cp.OutsideTempCount, &cp.EndChargingState,
+			&cp.BatteryHeaterMin,
+		)
+		return cp, err
+	})
+}
+
+// exportCharges 导出充电详情 (属于已导出充电过程的)
+func (e *BackupExporter) exportCharges(ctx context.Context, bw *backupWriter, carID int64, from, to *time.Time) (int, error) {
+	query := `
+		SELECT c.id, c.charging_process_id, c.battery_level, c.usable_battery_level, c.range_km, c.charger_power,
+			c.charger_voltage, c.charger_current, c.charge_energy_added, c.outside_temp, c.recorded_at,
+			c.charger_phases, c.ac_power_kw, c.battery_heater
+		FROM charges c
+		JOIN charging_processes cp ON cp.id = c.charging_process_id
+		WHERE cp.car_id = $1 AND ` + rangeFilter("cp.start_time") + `
+		ORDER BY c.charging_process_id, c.recorded_at
+	`
+	return e.exportRows(ctx, bw, BackupRecordCharge, query, []interface{}{carID, from, to}, func(rows pgx.Rows) (interface{}, error) {
+		c := &models.Charge{}
+		err := rows.Scan(
+			&c.ID, &c.ChargingProcessID, &c.BatteryLevel, &c.UsableBatteryLevel, &c.RangeKm, &c.ChargerPower,
+			&c.ChargerVoltage, &c.ChargerCurrent, &c.ChargeEnergyAdded, &c.OutsideTemp, &c.RecordedAt,
+			&c.ChargerPhases, &c.ACPowerKw, &c.BatteryHeater,
+		)
+		return c, err
+	})
+}
+
+// exportParkings 导出停车记录
+func (e *BackupExporter) exportParkings(ctx context.Context, bw *backupWriter, carID int64, from, to *time.Time) (int, error) {
+	query := `
+		SELECT id, car_id, position_id, geofence_id, start_time, end_time, duration_min,
+			latitude, longitude,
+			start_battery_level, end_battery_level, start_range_km, end_range_km,
+			start_odometer, end_odometer, energy_used_kwh,
+			start_inside_temp, end_inside_temp, start_outside_temp, end_outside_temp,
+			inside_temp_avg, outside_temp_avg,
+			climate_used_min, sentry_mode_used_min, preconditioning_used_min,
+			seat_heater_used_min, defroster_used_min,
+			start_locked, start_sentry_mode, start_doors_open, start_windows_open,
+			start_frunk_open, start_trunk_open, start_is_climate_on, start_is_user_present,
+			end_locked, end_sentry_mode, end_doors_open, end_windows_open,
+			end_frunk_open, end_trunk_open, end_is_climate_on, end_is_user_present,
+			start_tpms_pressure_fl, start_tpms_pressure_fr, start_tpms_pressure_rl, start_tpms_pressure_rr,
+			end_tpms_pressure_fl, end_tpms_pressure_fr, end_tpms_pressure_rl, end_tpms_pressure_rr,
+			COALESCE(car_version, ''), address, start_closures, end_closures
+		FROM parkings WHERE car_id = $1 AND ` + rangeFilter("start_time") + `
+		ORDER BY start_time
+	`
+	return e.exportRows(ctx, bw, BackupRecordParking, query, []interface{}{carID, from, to}, func(rows pgx.Rows) (interface{}, error) {
+		p := &models.Parking{}
+		err := rows.Scan(
+			&p.ID, &p.CarID, &p.PositionID, &p.GeofenceID, &p.StartTime, &p.EndTime, &p.DurationMin,
+			&p.Latitude, &p.Longitude,
+			&p.StartBatteryLevel, &p.EndBatteryLevel, &p.StartRangeKm, &p.EndRangeKm,
+			&p.StartOdometer, &p.EndOdometer, &p.EnergyUsedKwh,
+			&p.StartInsideTemp, &p.EndInsideTemp, &p.StartOutsideTemp, &p.EndOutsideTemp,
+			&p.InsideTempAvg, &p.OutsideTempAvg,
+			&p.ClimateUsedMin, &p.SentryModeUsedMin, &p.PreconditioningUsedMin,
+			&p.SeatHeaterUsedMin, &p.DefrosterUsedMin,
+			&p.StartLocked, &p.StartSentryMode, &p.StartDoorsOpen, &p.StartWindowsOpen,
+			&p.StartFrunkOpen, &p.StartTrunkOpen, &p.StartIsClimateOn, &p.StartIsUserPresent,
+			&p.EndLocked, &p.EndSentryMode, &p.EndDoorsOpen, &p.EndWindowsOpen,
+			&p.EndFrunkOpen, &p.EndTrunkOpen, &p.EndIsClimateOn, &p.EndIsUserPresent,
+			&p.StartTpmsPressureFL, &p.StartTpmsPressureFR, &p.StartTpmsPressureRL, &p.StartTpmsPressureRR,
+			&p.EndTpmsPressureFL, &p.EndTpmsPressureFR, &p.EndTpmsPressureRL, &p.EndTpmsPressureRR,
+			&p.CarVersion, &p.Address, &p.StartClosures, &p.EndClosures,
+		)
+		return p, err
+	})
+}
+
+// exportParkingEvents 导出停车事件 (属于已导出停车记录的)
+func (e *BackupExporter) exportParkingEvents(ctx context.Context, bw *backupWriter, carID int64, from, to *time.Time) (int, error) {
+	query := `
+		SELECT pe.id, pe.parking_id, pe.event_type, pe.event_time, pe.details
+		FROM parking_events pe
+		JOIN parkings p ON p.id = pe.parking_id
+		WHERE p.car_id = $1 AND ` + rangeFilter("p.start_time") + `
+		ORDER BY pe.parking_id, pe.event_time
+	`
+	return e.exportRows(ctx, bw, BackupRecordParkingEvent, query, []interface{}{carID, from, to}, func(rows pgx.Rows) (interface{}, error) {
+		ev := &models.ParkingEvent{}
+		err := rows.Scan(&ev.ID, &ev.ParkingID, &ev.EventType, &ev.EventTime, &ev.Details)
+		return ev, err
+	})
+}
+
+// exportPositions 导出位置记录
+func (e *BackupExporter) exportPositions(ctx context.Context, bw *backupWriter, carID int64, from, to *time.Time) (int, error) {
+	query := `
+		SELECT id, car_id, drive_id, latitude, longitude, COALESCE(heading, 0), speed, COALESCE(power, 0),
+			COALESCE(odometer, 0), COALESCE(battery_level, 0), COALESCE(range_km, 0), inside_temp, outside_temp, elevation,
+			tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, closures, recorded_at,
+			interpolated
+		FROM positions WHERE car_id = $1 AND ` + rangeFilter("recorded_at") + `
+		ORDER BY recorded_at
+	`
+	return e.exportRows(ctx, bw, BackupRecordPosition, query, []interface{}{carID, from, to}, func(rows pgx.Rows) (interface{}, error) {
+		p := &models.Position{}
+		err := rows.Scan(
+			&p.ID, &p.CarID, &p.DriveID, &p.Latitude, &p.Longitude, &p.Heading, &p.Speed, &p.Power,
+			&p.Odometer, &p.BatteryLevel, &p.RangeKm, &p.InsideTemp, &p.OutsideTemp, &p.Elevation,
+			&p.TpmsPressureFL, &p.TpmsPressureFR, &p.TpmsPressureRL, &p.TpmsPressureRR, &p.Closures, &p.RecordedAt,
+			&p.Interpolated,
+		)
+		return p, err
+	})
+}
@@ -0,0 +1,406 @@
+package importer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// BackupImporter 从 NDJSON 备份文件重建车辆数据
+// 车辆按 VIN 匹配，不存在时创建；其余记录按自然键跳过已存在的，可重复导入
+// 地理围栏不在备份范围内，导入的记录不关联地理围栏
+type BackupImporter struct {
+	logger    *zap.Logger
+	dst       *repository.DB
+	batchSize int
+
+	carID int64
+
+	// 备份文件中的 ID -> 当前数据库 ID 映射
+	driveIDs   map[int64]int64
+	processIDs map[int64]int64
+	parkingIDs map[int64]int64
+
+	// 位置记录在最后导入，先记下引用位置的记录，导入位置后再回填
+	positionRefs  map[int64][]positionRef // 备份中的位置 ID -> 引用它的记录
+	positionTimes map[int64]time.Time     // 被引用的位置 ID -> 记录时间 (位置的自然键)
+}
+
+// positionRef 引用位置记录的字段
+type positionRef struct {
+	table  string
+	column string
+	id     int64
+}
+
+// BackupImportResult 导入结果
+type BackupImportResult struct {
+	CarID    int64       `json:"car_id"`
+	Read     BackupStats `json:"read"`     // 备份文件中读取的记录数
+	Imported BackupStats `json:"imported"` // 实际新增的记录数 (已存在的记录会跳过)
+}
+
+// NewBackupImporter 创建备份导入器
+func NewBackupImporter(logger *zap.Logger, dst *repository.DB, batchSize int) *BackupImporter {
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	return &BackupImporter{
+		logger:        logger,
+		dst:           dst,
+		batchSize:     batchSize,
+		driveIDs:      make(map[int64]int64),
+		processIDs:    make(map[int64]int64),
+		parkingIDs:    make(map[int64]int64),
+		positionRefs:  make(map[int64][]positionRef),
+		positionTimes: make(map[int64]time.Time),
+	}
+}
+
+// Run 逐行读取备份文件并导入
+// 文件被截断时已读取的记录仍会导入，返回 ErrBackupIncomplete，补全文件后重新导入即可
+func (im *BackupImporter) Run(ctx context.Context, r io.Reader) (*BackupImportResult, error) {
+	result := &BackupImportResult{}
+	dec := json.NewDecoder(r)
+
+	var header BackupRecord
+	if err := dec.Decode(&header); err != nil || header.Type != BackupRecordHeader {
+		return result, ErrBackupMissingHeader
+	}
+	if err := im.importHeader(ctx, header.Data); err != nil {
+		return result, err
+	}
+	result.CarID = im.carID
+
+	charges := newBatchWriter(im.dst.Pool, im.batchSize)
+	events := newBatchWriter(im.dst.Pool, im.batchSize)
+	positions := newBatchWriter(im.dst.Pool, im.batchSize)
+
+	var footer *BackupFooter
+	for footer == nil {
+		var record BackupRecord
+		if err := dec.Decode(&record); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return result, fmt.Errorf("decode record: %w", err)
+		}
+
+		var err error
+		switch record.Type {
+		case BackupRecordDrive:
+			result.Read.Drives++
+			err = im.importDrive(ctx, record.Data, &result.Imported)
+		case BackupRecordChargingProcess:
+			result.Read.ChargingProcesses++
+			err = im.importChargingProcess(ctx, record.Data, &result.Imported)
+		case BackupRecordCharge:
+			result.Read.Charges++
+			err = im.queueCharge(ctx, charges, record.Data)
+		case BackupRecordParking:
+			result.Read.Parkings++
+			err = im.importParking(ctx, record.Data, &result.Imported)
+		case BackupRecordParkingEvent:
+			result.Read.ParkingEvents++
+			err = im.queueParkingEvent(ctx, events, record.Data)
+		case BackupRecordPosition:
+			result.Read.Positions++
+			err = im.queuePosition(ctx, positions, record.Data)
+		case BackupRecordFooter:
+			footer = &BackupFooter{}
+			err = json.Unmarshal(record.Data, footer)
+		default:
+			// 更新版本可能新增的记录类型，跳过
+			im.logger.Debug("Skipping unknown backup record", zap.String("type", record.Type))
+		}
+		if err != nil {
+			return result, fmt.Errorf("import %s: %w", record.Type, err)
+		}
+	}
+
+	for _, w := range []*batchWriter{charges, events, positions} {
+		if err := w.flush(ctx); err != nil {
+			return result, err
+		}
+	}
+	result.Imported.Charges = charges.inserted
+	result.Imported.ParkingEvents = events.inserted
+	result.Imported.Positions = positions.inserted
+
+	if err := im.resolvePositionRefs(ctx); err != nil {
+		return result, fmt.Errorf("resolve position references: %w", err)
+	}
+
+	im.logger.Info("Imported backup",
+		zap.Int64("car_id", im.carID),
+		zap.Any("read", result.Read),
+		zap.Any("imported", result.Imported))
+
+	if footer == nil || footer.Counts != result.Read {
+		return result, ErrBackupIncomplete
+	}
+	return result, nil
+}
+
+// importHeader 校验版本并导入车辆，自然键: vin
+func (im *BackupImporter) importHeader(ctx context.Context, data json.RawMessage) error {
+	var header BackupHeader
+	if err := json.Unmarshal(data, &header); err != nil || header.Car == nil || header.Car.VIN == "" {
+		return ErrBackupMissingHeader
+	}
+	if header.SchemaVersion < 1 || header.SchemaVersion > BackupSchemaVersion {
+		return fmt.Errorf("%w: %d", ErrBackupUnsupportedVersion, header.SchemaVersion)
+	}
+
+	// 已存在的车辆保留原有数据，只取回 ID
+	car := header.Car
+	err := im.dst.Pool.QueryRow(ctx, `
+		INSERT INTO cars (tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type,
+			display_color, icon, distance_unit, temperature_unit, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NOW(), NOW())
+		ON CONFLICT (vin) DO UPDATE SET vin = EXCLUDED.vin
+		RETURNING id
+	`, car.TeslaID, car.TeslaVehicleID, car.VIN, car.Name, car.Model,
+		car.TrimBadging, car.ExteriorColor, car.WheelType, car.DisplayColor, car.Icon,
+		car.DistanceUnit, car.TemperatureUnit).Scan(&im.carID)
+	if err != nil {
+		return fmt.Errorf("upsert car %s: %w", car.VIN, err)
+	}
+	return nil
+}
+
+// importDrive 导入行程，自然键: (car_id, start_time)
+func (im *BackupImporter) importDrive(ctx context.Context, data json.RawMessage, imported *BackupStats) error {
+	var d models.Drive
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+
+	id, inserted, err := insertIfAbsent(ctx, im.dst.Pool,
+		`SELECT id FROM drives WHERE car_id = $1 AND start_time = $2 LIMIT 1`,
+		[]interface{}{im.carID, d.StartTime}, `
+		INSERT INTO drives (car_id, start_time, end_time,
+			distance_km, duration_min, start_battery_level, end_battery_level, start_range_km, end_range_km,
+			start_odometer_km, end_odometer_km, speed_max, power_max, power_min, inside_temp_avg, outside_temp_avg,
+			energy_used_kwh, energy_regen_kwh, energy_soc_kwh, energy_climate_kwh,
+			start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude,
+			accel_max_g, decel_max_g, harsh_accel_count, harsh_brake_count)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26,
+			$27, $28, $29, $30)
+		RETURNING id
+	`, im.carID, d.StartTime, d.EndTime,
+		d.DistanceKm, d.DurationMin, d.StartBatteryLevel, d.EndBatteryLevel, d.StartRangeKm, d.EndRangeKm,
+		d.StartOdometerKm, d.EndOdometerKm, d.SpeedMax, d.PowerMax, d.PowerMin, d.InsideTempAvg, d.OutsideTempAvg,
+		d.EnergyUsedKwh, d.EnergyRegenKwh, d.EnergySocKwh, d.EnergyClimateKwh,
+		d.StartAddress, d.EndAddress, d.StartLatitude, d.StartLongitude, d.EndLatitude, d.EndLongitude,
+		d.AccelMaxG, d.DecelMaxG, d.HarshAccelCount, d.HarshBrakeCount)
+	if err != nil {
+		return fmt.Errorf("insert drive %d: %w", d.ID, err)
+	}
+	im.driveIDs[d.ID] = id
+	im.addPositionRef(d.StartPositionID, "drives", "start_position_id", id)
+	im.addPositionRef(d.EndPositionID, "drives", "end_position_id", id)
+	if inserted {
+		imported.Drives++
+	}
+	return nil
+}
+
+// importChargingProcess 导入充电过程，自然键: (car_id, start_time)
+func (im *BackupImporter) importChargingProcess(ctx context.Context, data json.RawMessage, imported *BackupStats) error {
+	var cp models.ChargingProcess
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return err
+	}
+
+	id, inserted, err := insertIfAbsent(ctx, im.dst.Pool,
+		`SELECT id FROM charging_processes WHERE car_id = $1 AND start_time = $2 LIMIT 1`,
+		[]interface{}{im.carID, cp.StartTime}, `
+		INSERT INTO charging_processes (car_id, start_time, end_time,
+			start_battery_level, end_battery_level, start_range_km, end_range_km,
+			charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address,
+			charge_limit_soc, scheduled_mode, charge_energy_reported, charge_energy_estimated, outside_temp_samples, end_charging_state,
+			battery_heater_min)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		RETURNING id
+	`, im.carID, cp.StartTime, cp.EndTime,
+		cp.StartBatteryLevel, cp.EndBatteryLevel, cp.StartRangeKm, cp.EndRangeKm,
+		cp.ChargeEnergyAdded, cp.ChargerPowerMax, cp.DurationMin, cp.OutsideTempAvg, cp.Cost, cp.Address,
+		cp.ChargeLimitSoc, cp.ScheduledMode, cp.EnergyReported, cp.EnergyEstimated, cp.OutsideTempCount, cp.EndChargingState,
+		cp.BatteryHeaterMin)
+	if err != nil {
+		return fmt.Errorf("insert charging process %d: %w", cp.ID, err)
+	}
+	im.processIDs[cp.ID] = id
+	im.addPositionRef(cp.PositionID, "charging_processes", "position_id", id)
+	if inserted {
+		imported.ChargingProcesses++
+	}
+	return nil
+}
+
+// queueCharge 导入充电详情，自然键: (charging_process_id, recorded_at)
+func (im *BackupImporter) queueCharge(ctx context.Context, w *batchWriter, data json.RawMessage) error {
+	var c models.Charge
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	processID, ok := im.processIDs[c.ChargingProcessID]
+	if !ok {
+		return nil
+	}
+
+	return w.queue(ctx, `
+		INSERT INTO charges (charging_process_id, battery_level, usable_battery_level, range_km, charger_power,
+			charger_voltage, charger_current, charge_energy_added, outside_temp, recorded_at, charger_phases, ac_power_kw, battery_heater)
+		SELECT $1::bigint, $2::int, $3::int, $4::float8, $5::int, $6::int, $7::int, $8::float8, $9::float8, $10::timestamptz,
+			$11::int, $12::float8, $13::boolean
+		WHERE NOT EXISTS (SELECT 1 FROM charges WHERE charging_process_id = $1 AND recorded_at = $10)
+	`, processID, c.BatteryLevel, c.UsableBatteryLevel, c.RangeKm, c.ChargerPower,
+		c.ChargerVoltage, c.ChargerCurrent, c.ChargeEnergyAdded, c.OutsideTemp, c.RecordedAt, c.ChargerPhases, c.ACPowerKw, c.BatteryHeater)
+}
+
+// importParking 导入停车记录，自然键: (car_id, start_time)
+func (im *BackupImporter) importParking(ctx context.Context, data json.RawMessage, imported *BackupStats) error {
+	var p models.Parking
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+
+	id, inserted, err := insertIfAbsent(ctx, im.dst.Pool,
+		`SELECT id FROM parkings WHERE car_id = $1 AND start_time = $2 LIMIT 1`,
+		[]interface{}{im.carID, p.StartTime}, `
+		INSERT INTO parkings (car_id, start_time, end_time, duration_min, latitude, longitude,
+			start_battery_level, end_battery_level, start_range_km, end_range_km,
+			start_odometer, end_odometer, energy_used_kwh,
+			start_inside_temp, end_inside_temp, start_outside_temp, end_outside_temp,
+			inside_temp_avg, outside_temp_avg,
+			climate_used_min, sentry_mode_used_min, preconditioning_used_min,
+			seat_heater_used_min, defroster_used_min,
+			start_locked, start_sentry_mode, start_doors_open, start_windows_open,
+			start_frunk_open, start_trunk_open, start_is_climate_on, start_is_user_present,
+			end_locked, end_sentry_mode, end_doors_open, end_windows_open,
+			end_frunk_open, end_trunk_open, end_is_climate_on, end_is_user_present,
+			start_tpms_pressure_fl, start_tpms_pressure_fr, start_tpms_pressure_rl, start_tpms_pressure_rr,
+			end_tpms_pressure_fl, end_tpms_pressure_fr, end_tpms_pressure_rl, end_tpms_pressure_rr,
+			car_version, address, start_closures, end_closures)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20,
+			$21, $22, $23, $24, $25, $26, $27, $28, $29, $30, $31, $32, $33, $34, $35, $36, $37, $38, $39, $40,
+			$41, $42, $43, $44, $45, $46, $47, $48, $49, $50, $51, $52)
+		RETURNING id
+	`, im.carID, p.StartTime, p.EndTime, p.DurationMin, p.Latitude, p.Longitude,
+		p.StartBatteryLevel, p.EndBatteryLevel, p.StartRangeKm, p.EndRangeKm,
+		p.StartOdometer, p.EndOdometer, p.EnergyUsedKwh,
+		p.StartInsideTemp, p.EndInsideTemp, p.StartOutsideTemp, p.EndOutsideTemp,
+		p.InsideTempAvg, p.OutsideTempAvg,
+		p.ClimateUsedMin, p.SentryModeUsedMin, p.PreconditioningUsedMin,
+		p.SeatHeaterUsedMin, p.DefrosterUsedMin,
+		p.StartLocked, p.StartSentryMode, p.StartDoorsOpen, p.StartWindowsOpen,
+		p.StartFrunkOpen, p.StartTrunkOpen, p.StartIsClimateOn, p.StartIsUserPresent,
+		p.EndLocked, p.EndSentryMode, p.EndDoorsOpen, p.EndWindowsOpen,
+		p.EndFrunkOpen, p.EndTrunkOpen, p.EndIsClimateOn, p.EndIsUserPresent,
+		p.StartTpmsPressureFL, p.StartTpmsPressureFR, p.StartTpmsPressureRL, p.StartTpmsPressureRR,
+		p.EndTpmsPressureFL, p.EndTpmsPressureFR, p.EndTpmsPressureRL, p.EndTpmsPressureRR,
+		p.CarVersion, p.Address, p.StartClosures, p.EndClosures)
+	if err != nil {
+		return fmt.Errorf("insert parking %d: %w", p.ID, err)
+	}
+	im.parkingIDs[p.ID] = id
+	im.addPositionRef(p.PositionID, "parkings", "position_id", id)
+	if inserted {
+		imported.Parkings++
+	}
+	return nil
+}
+
+// queueParkingEvent 导入停车事件，自然键: (parking_id, event_type, event_time)
+func (im *BackupImporter) queueParkingEvent(ctx context.Context, w *batchWriter, data json.RawMessage) error {
+	var ev models.ParkingEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return err
+	}
+	parkingID, ok := im.parkingIDs[ev.ParkingID]
+	if !ok {
+		return nil
+	}
+
+	return w.queue(ctx, `
+		INSERT INTO parking_events (parking_id, event_type, event_time, details)
+		SELECT $1::bigint, $2::varchar, $3::timestamptz, $4::jsonb
+		WHERE NOT EXISTS (SELECT 1 FROM parking_events WHERE parking_id = $1 AND event_type = $2 AND event_time = $3)
+	`, parkingID, string(ev.EventType), ev.EventTime, ev.Details)
+}
+
+// queuePosition 导入位置记录，自然键: (car_id, recorded_at)
+func (im *BackupImporter) queuePosition(ctx context.Context, w *batchWriter, data json.RawMessage) error {
+	var p models.Position
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	var driveID *int64
+	if p.DriveID != nil {
+		if id, ok := im.driveIDs[*p.DriveID]; ok {
+			driveID = &id
+		}
+	}
+	if _, ok := im.positionRefs[p.ID]; ok {
+		im.positionTimes[p.ID] = p.RecordedAt
+	}
+
+	return w.queue(ctx, `
+		INSERT INTO positions (car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km,
+			inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr,
+			recorded_at, closures, interpolated)
+		SELECT $1::bigint, $2::bigint, $3::float8, $4::float8, $5::int, $6::int, $7::int, $8::float8, $9::int, $10::float8,
+			$11::float8, $12::float8, $13::int, $14::float8, $15::float8, $16::float8, $17::float8, $18::timestamptz, $19::jsonb,
+			$20::boolean
+		WHERE NOT EXISTS (SELECT 1 FROM positions WHERE car_id = $1 AND recorded_at = $18)
+	`, im.carID, driveID, p.Latitude, p.Longitude, p.Heading, p.Speed, p.Power, p.Odometer, p.BatteryLevel, p.RangeKm,
+		p.InsideTemp, p.OutsideTemp, p.Elevation, p.TpmsPressureFL, p.TpmsPressureFR, p.TpmsPressureRL, p.TpmsPressureRR,
+		p.RecordedAt, p.Closures, p.Interpolated)
+}
+
+// addPositionRef 记录引用位置的字段，等位置导入后回填
+func (im *BackupImporter) addPositionRef(srcPositionID *int64, table, column string, id int64) {
+	if srcPositionID == nil {
+		return
+	}
+	im.positionRefs[*srcPositionID] = append(im.positionRefs[*srcPositionID], positionRef{table: table, column: column, id: id})
+}
+
+// resolvePositionRefs 按位置的自然键查回新 ID，回填行程、充电、停车记录的位置引用
+// 只回填为空的字段，重复导入不会覆盖已有引用
+func (im *BackupImporter) resolvePositionRefs(ctx context.Context) error {
+	for srcID, refs := range im.positionRefs {
+		recordedAt, ok := im.positionTimes[srcID]
+		if !ok {
+			// 被引用的位置不在备份范围内
+			continue
+		}
+
+		var positionID int64
+		err := im.dst.Pool.QueryRow(ctx,
+			`SELECT id FROM positions WHERE car_id = $1 AND recorded_at = $2 ORDER BY id LIMIT 1`,
+			im.carID, recordedAt).Scan(&positionID)
+		if err != nil {
+			return fmt.Errorf("lookup position %d: %w", srcID, err)
+		}
+
+		for _, ref := range refs {
+			// table/column 来自固定的引用字段列表，不是用户输入
+			query := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE id = $2 AND %s IS NULL`, ref.table, ref.column, ref.column)
+			if _, err := im.dst.Pool.Exec(ctx, query, positionID, ref.id); err != nil {
+				return fmt.Errorf("update %s.%s: %w", ref.table, ref.column, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,581 @@
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// TeslaExportImporter 从 Tesla 账户数据导出 (隐私中心的"下载数据"、App 导出的充电记录) 的 CSV/JSON 文件导入历史行程和充电
+// 导出文件的列名不固定，按别名识别 (忽略大小写、空格和标点)；有距离或里程表列的行视为行程，有充电量列的行视为充电。
+// 与已有记录时间重叠的行视为重复跳过，导入可重复执行。导出数据没有轨迹和充电过程采样，只能重建汇总字段
+type TeslaExportImporter struct {
+	logger       *zap.Logger
+	dst          *repository.DB
+	cars         *repository.CarRepository
+	geofences    *repository.GeofenceRepository
+	loc          *time.Location // 不带时区的时间按该时区解析
+	defaultCarID int64          // 行中没有 VIN 时导入到的车辆 (0 表示跳过没有 VIN 的行)
+
+	carIDs map[string]int64 // VIN -> car_id
+}
+
+// TeslaExportStats Tesla 数据导出的导入统计
+type TeslaExportStats struct {
+	Files             int `json:"files"`
+	Drives            int `json:"drives"`
+	ChargingProcesses int `json:"charging_processes"`
+	Duplicates        int `json:"duplicates"` // 与已有记录时间重叠而跳过的行
+	Skipped           int `json:"skipped"`    // 无法识别、缺少时间或车辆不存在而跳过的行
+}
+
+// exportRecord 导出文件中的一行，键为归一化后的列名
+type exportRecord map[string]string
+
+// 列别名 (归一化后)
+var (
+	exportVINKeys          = []string{"vin"}
+	exportStartTimeKeys    = []string{"starttime", "startdate", "startdatetime", "start", "tripstart", "tripstarttime", "chargestartdatetime", "chargestarttime", "chargestart", "sessionstart", "sessionstarttime"}
+	exportEndTimeKeys      = []string{"endtime", "enddate", "enddatetime", "end", "tripend", "tripendtime", "chargestopdatetime", "chargestoptime", "chargeendtime", "chargeend", "sessionend", "sessionendtime"}
+	exportDurationKeys     = []string{"durationmin", "durationminutes", "duration"}
+	exportDistanceKmKeys   = []string{"distancekm", "distance", "tripdistancekm", "tripdistance"}
+	exportDistanceMiKeys   = []string{"distancemi", "distancemiles", "tripdistancemi", "tripdistancemiles"}
+	exportStartOdoKmKeys   = []string{"startodometerkm", "startodometer", "odometerstartkm", "odometerstart"}
+	exportStartOdoMiKeys   = []string{"startodometermi", "startodometermiles", "odometerstartmi"}
+	exportEndOdoKmKeys     = []string{"endodometerkm", "endodometer", "odometerendkm", "odometerend"}
+	exportEndOdoMiKeys     = []string{"endodometermi", "endodometermiles", "odometerendmi"}
+	exportStartLevelKeys   = []string{"startbatterylevel", "startsoc", "startbattery", "startingbattery", "startstateofcharge"}
+	exportEndLevelKeys     = []string{"endbatterylevel", "endsoc", "endbattery", "endingbattery", "endstateofcharge"}
+	exportEnergyUsedKeys   = []string{"energyusedkwh", "energyused", "energyconsumedkwh", "energyconsumed"}
+	exportEnergyAddedKeys  = []string{"energyaddedkwh", "energyadded", "chargeenergyaddedkwh", "chargeenergyadded", "kwhadded", "quantitybase"}
+	exportEnergyUnitKeys   = []string{"unitofmeasure", "unit"}
+	exportCostKeys         = []string{"cost", "totalcost", "totalincvat", "total", "amount"}
+	exportPowerMaxKeys     = []string{"chargerpowermaxkw", "chargerpowermax", "maxpowerkw", "maxpower", "peakpowerkw", "peakpower"}
+	exportStartAddressKeys = []string{"startaddress", "startlocation", "origin"}
+	exportEndAddressKeys   = []string{"endaddress", "endlocation", "destination"}
+	exportStartLatKeys     = []string{"startlatitude", "startlat"}
+	exportStartLngKeys     = []string{"startlongitude", "startlng", "startlon"}
+	exportEndLatKeys       = []string{"endlatitude", "endlat"}
+	exportEndLngKeys       = []string{"endlongitude", "endlng", "endlon"}
+	exportAddressKeys      = []string{"address", "location", "sitelocationname", "sitename", "site"}
+	exportLatKeys          = []string{"latitude", "lat"}
+	exportLngKeys          = []string{"longitude", "lng", "lon"}
+)
+
+// exportTimeLayouts 支持的时间格式 (不带时区的按 loc 解析)
+var exportTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"01/02/2006 15:04:05",
+	"01/02/2006 15:04",
+	"2006-01-02",
+}
+
+// exportNumberPattern 从带单位或货币符号的值中提取数值 (如 "12.5 kWh"、"¥36.00"、"80%")
+var exportNumberPattern = regexp.MustCompile(`-?\d+(\.\d+)?`)
+
+// exportClockDurationPattern 时钟格式的时长 (如 "1:05:30"、"0:42")，按 时:分[:秒] 解析
+var exportClockDurationPattern = regexp.MustCompile(`^(\d+):([0-5]?\d)(?::([0-5]?\d(?:\.\d+)?))?$`)
+
+// NewTeslaExportImporter 创建 Tesla 数据导出导入器
+// defaultCarID 为行中没有 VIN 时导入到的车辆，loc 为不带时区的时间所在的时区
+func NewTeslaExportImporter(logger *zap.Logger, dst *repository.DB, loc *time.Location, defaultCarID int64) *TeslaExportImporter {
+	if loc == nil {
+		loc = time.Local
+	}
+	return &TeslaExportImporter{
+		logger:       logger,
+		dst:          dst,
+		cars:         repository.NewCarRepository(dst),
+		geofences:    repository.NewGeofenceRepository(dst),
+		loc:          loc,
+		defaultCarID: defaultCarID,
+		carIDs:       make(map[string]int64),
+	}
+}
+
+// Run 导入指定的文件或目录 (递归查找 .csv 和 .json 文件)
+func (im *TeslaExportImporter) Run(ctx context.Context, paths []string) (*TeslaExportStats, error) {
+	stats := &TeslaExportStats{}
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".csv", ".json":
+			default:
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := im.importFile(ctx, path, stats); err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			stats.Files++
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// importFile 导入单个导出文件
+func (im *TeslaExportImporter) importFile(ctx context.Context, path string, stats *TeslaExportStats) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var records []exportRecord
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		records, err = readExportJSON(f)
+	} else {
+		records, err = readExportCSV(f)
+	}
+	if err != nil {
+		return err
+	}
+
+	var drives, charges, duplicates, skipped int
+	for _, rec := range records {
+		var imported bool
+		switch {
+		case rec.has(exportDistanceKmKeys, exportDistanceMiKeys, exportStartOdoKmKeys, exportStartOdoMiKeys):
+			imported, err = im.importDrive(ctx, rec)
+			if imported {
+				drives++
+			}
+		case rec.has(exportEnergyAddedKeys):
+			imported, err = im.importCharge(ctx, rec)
+			if imported {
+				charges++
+			}
+		default:
+			skipped++
+			continue
+		}
+		if errors.Is(err, errExportRowSkipped) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !imported {
+			duplicates++
+		}
+	}
+
+	stats.Drives += drives
+	stats.ChargingProcesses += charges
+	stats.Duplicates += duplicates
+	stats.Skipped += skipped
+	im.logger.Info("Imported Tesla export file",
+		zap.String("file", path),
+		zap.Int("rows", len(records)),
+		zap.Int("drives", drives),
+		zap.Int("charging_processes", charges),
+		zap.Int("duplicates", duplicates),
+		zap.Int("skipped", skipped))
+	return nil
+}
+
+// errExportRowSkipped 行缺少必要字段或找不到车辆
+var errExportRowSkipped = errors.New("export row skipped")
+
+// importDrive 导入一行行程，与已有行程时间重叠时跳过 (返回 false)
+func (im *TeslaExportImporter) importDrive(ctx context.Context, rec exportRecord) (bool, error) {
+	carID, err := im.resolveCar(ctx, rec)
+	if err != nil {
+		return false, err
+	}
+	start, end, ok := rec.timeRange(im.loc)
+	if !ok {
+		return false, errExportRowSkipped
+	}
+
+	startOdo := rec.number(exportStartOdoKmKeys...)
+	if v := rec.number(exportStartOdoMiKeys...); startOdo == nil && v != nil {
+		km := tesla.MilesToKm(*v)
+		startOdo = &km
+	}
+	endOdo := rec.number(exportEndOdoKmKeys...)
+	if v := rec.number(exportEndOdoMiKeys...); endOdo == nil && v != nil {
+		km := tesla.MilesToKm(*v)
+		endOdo = &km
+	}
+	distance := rec.number(exportDistanceKmKeys...)
+	if v := rec.number(exportDistanceMiKeys...); distance == nil && v != nil {
+		km := tesla.MilesToKm(*v)
+		distance = &km
+	}
+	if distance == nil && startOdo != nil && endOdo != nil && *endOdo >= *startOdo {
+		d := *endOdo - *startOdo
+		distance = &d
+	}
+
+	startLat, startLng := rec.number(exportStartLatKeys...), rec.number(exportStartLngKeys...)
+	endLat, endLng := rec.number(exportEndLatKeys...), rec.number(exportEndLngKeys...)
+	var startGeofenceID, endGeofenceID *int64
+	if startLat != nil && startLng != nil {
+		startGeofenceID = im.matchGeofence(ctx, *startLat, *startLng)
+	}
+	if endLat != nil && endLng != nil {
+		endGeofenceID = im.matchGeofence(ctx, *endLat, *endLng)
+	}
+
+	_, inserted, err := insertIfAbsent(ctx, im.dst.Pool,
+		`SELECT id FROM drives WHERE car_id = $1 AND start_time <= $3 AND COALESCE(end_time, 'infinity') >= $2 LIMIT 1`,
+		[]interface{}{carID, start, end}, `
+		INSERT INTO drives (car_id, start_time, end_time, distance_km, duration_min,
+			start_battery_level, end_battery_level, start_range_km, start_odometer_km, end_odometer_km, energy_used_kwh,
+			start_latitude, start_longitude, end_latitude, end_longitude, start_geofence_id, end_geofence_id,
+			start_address, end_address)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 0, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING id
+	`, carID, start, end, valueOrZero(distance), end.Sub(start).Minutes(),
+		intOrZero(rec.number(exportStartLevelKeys...)), intPtr(rec.number(exportEndLevelKeys...)),
+		valueOrZero(startOdo), endOdo, rec.number(exportEnergyUsedKeys...),
+		startLat, startLng, endLat, endLng, startGeofenceID, endGeofenceID,
+		rec.address(exportStartAddressKeys...), rec.address(exportEndAddressKeys...))
+	if err != nil {
+		return false, fmt.Errorf("insert drive at %s: %w", start.Format(time.RFC3339), err)
+	}
+	return inserted, nil
+}
+
+// importCharge 导入一行充电，与已有充电时间重叠时跳过 (返回 false)
+// 计量单位列 (如超充账单的 UnitOfMeasure) 不是 kWh 时 (按分钟计费) 充电量记为 0
+func (im *TeslaExportImporter) importCharge(ctx context.Context, rec exportRecord) (bool, error) {
+	carID, err := im.resolveCar(ctx, rec)
+	if err != nil {
+		return false, err
+	}
+	start, end, ok := rec.timeRange(im.loc)
+	if !ok {
+		return false, errExportRowSkipped
+	}
+
+	energy := rec.number(exportEnergyAddedKeys...)
+	if unit := strings.ToLower(rec.value(exportEnergyUnitKeys...)); unit != "" && unit != "kwh" {
+		energy = nil
+	}
+	var geofenceID *int64
+	if lat, lng := rec.number(exportLatKeys...), rec.number(exportLngKeys...); lat != nil && lng != nil {
+		geofenceID = im.matchGeofence(ctx, *lat, *lng)
+	}
+
+	_, inserted, err := insertIfAbsent(ctx, im.dst.Pool,
+		`SELECT id FROM charging_processes WHERE car_id = $1 AND start_time <= $3 AND COALESCE(end_time, 'infinity') >= $2 LIMIT 1`,
+		[]interface{}{carID, start, end}, `
+		INSERT INTO charging_processes (car_id, start_time, end_time, start_battery_level, end_battery_level, start_range_km,
+			charge_energy_added, charger_power_max, duration_min, cost, geofence_id, address)
+		VALUES ($1, $2, $3, $4, $5, 0, $6, $7, $8, $9, $10, $11)
+		RETURNING id
+	`, carID, start, end, intOrZero(rec.number(exportStartLevelKeys...)), intPtr(rec.number(exportEndLevelKeys...)),
+		valueOrZero(energy), intPtr(rec.number(exportPowerMaxKeys...)), end.Sub(start).Minutes(),
+		rec.number(exportCostKeys...), geofenceID, rec.address(exportAddressKeys...))
+	if err != nil {
+		return false, fmt.Errorf("insert charging process at %s: %w", start.Format(time.RFC3339), err)
+	}
+	return inserted, nil
+}
+
+// resolveCar 按行中的 VIN 查找车辆，没有 VIN 时使用默认车辆
+func (im *TeslaExportImporter) resolveCar(ctx context.Context, rec exportRecord) (int64, error) {
+	vin := strings.ToUpper(rec.value(exportVINKeys...))
+	if vin == "" {
+		if im.defaultCarID == 0 {
+			return 0, errExportRowSkipped
+		}
+		return im.defaultCarID, nil
+	}
+
+	id, ok := im.carIDs[vin]
+	if !ok {
+		car, err := im.cars.GetByVIN(ctx, vin)
+		if err != nil {
+			return 0, err
+		}
+		if car == nil {
+			im.logger.Warn("Skipping rows of unknown vehicle", zap.String("vin", vin))
+		} else {
+			id = car.ID
+		}
+		im.carIDs[vin] = id
+	}
+	if id == 0 {
+		return 0, errExportRowSkipped
+	}
+	return id, nil
+}
+
+// matchGeofence 匹配坐标所在的地理围栏，查询失败时不关联
+func (im *TeslaExportImporter) matchGeofence(ctx context.Context, lat, lng float64) *int64 {
+	g, err := im.geofences.FindContaining(ctx, lat, lng)
+	if err != nil {
+		im.logger.Warn("Failed to match geofence", zap.Error(err))
+		return nil
+	}
+	if g == nil {
+		return nil
+	}
+	return &g.ID
+}
+
+// readExportCSV 读取 CSV 导出文件，首行为列名
+func readExportCSV(r io.Reader) ([]exportRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read csv header: %w", err)
+	}
+	keys := make([]string, len(header))
+	for i, h := range header {
+		keys[i] = normalizeExportKey(h)
+	}
+
+	var records []exportRecord
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read csv: %w", err)
+		}
+		rec := make(exportRecord, len(row))
+		for i, v := range row {
+			if i < len(keys) && keys[i] != "" {
+				rec[keys[i]] = strings.TrimSpace(v)
+			}
+		}
+		records = append(records, rec)
+	}
+}
+
+// readExportJSON 读取 JSON 导出文件
+// 支持对象数组、单个对象，以及值为对象数组的对象 (如 {"drives": [...], "charges": [...]})；只读取标量字段
+func readExportJSON(r io.Reader) ([]exportRecord, error) {
+	var doc interface{}
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode json: %w", err)
+	}
+
+	var records []exportRecord
+	var collect func(v interface{}, depth int)
+	collect = func(v interface{}, depth int) {
+		switch t := v.(type) {
+		case []interface{}:
+			for _, item := range t {
+				collect(item, depth+1)
+			}
+		case map[string]interface{}:
+			rec := make(exportRecord)
+			for k, field := range t {
+				switch fv := field.(type) {
+				case string:
+					rec[normalizeExportKey(k)] = strings.TrimSpace(fv)
+				case float64:
+					rec[normalizeExportKey(k)] = strconv.FormatFloat(fv, 'f', -1, 64)
+				case bool:
+					rec[normalizeExportKey(k)] = strconv.FormatBool(fv)
+				case []interface{}, map[string]interface{}:
+					if depth < 2 {
+						collect(fv, depth+1)
+					}
+				}
+			}
+			if len(rec) > 0 {
+				records = append(records, rec)
+			}
+		}
+	}
+	collect(doc, 0)
+	return records, nil
+}
+
+// normalizeExportKey 归一化列名：转小写，只保留字母和数字 ("Distance (km)" -> "distancekm")
+func normalizeExportKey(key string) string {
+	key = strings.TrimPrefix(key, "\ufeff")
+	var b strings.Builder
+	for _, r := range strings.ToLower(key) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// has 是否包含任一别名的列 (值可以为空)
+func (rec exportRecord) has(aliases ...[]string) bool {
+	for _, keys := range aliases {
+		for _, k := range keys {
+			if _, ok := rec[k]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// value 取第一个有值的别名列
+func (rec exportRecord) value(keys ...string) string {
+	for _, k := range keys {
+		if v := rec[k]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// number 取第一个有值的别名列并提取数值，没有值或无法解析时返回 nil
+func (rec exportRecord) number(keys ...string) *float64 {
+	s := strings.ReplaceAll(rec.value(keys...), ",", "")
+	if s == "" {
+		return nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		m := exportNumberPattern.FindString(s)
+		if m == "" {
+			return nil
+		}
+		if v, err = strconv.ParseFloat(m, 64); err != nil {
+			return nil
+		}
+	}
+	return &v
+}
+
+// durationMin 取时长列并换算为分钟，没有值或无法解析时返回 nil
+// 支持 时:分[:秒] 格式、Go 时长格式 (如 "1h5m") 和按分钟计的数值 (如 "65 min")；
+// 时钟格式不能交给 number 解析，否则只会取到小时数
+func (rec exportRecord) durationMin(keys ...string) *float64 {
+	s := strings.TrimSpace(rec.value(keys...))
+	if s == "" {
+		return nil
+	}
+	if m := exportClockDurationPattern.FindStringSubmatch(s); m != nil {
+		hours, _ := strconv.ParseFloat(m[1], 64)
+		minutes, _ := strconv.ParseFloat(m[2], 64)
+		var seconds float64
+		if m[3] != "" {
+			seconds, _ = strconv.ParseFloat(m[3], 64)
+		}
+		v := hours*60 + minutes + seconds/60
+		return &v
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		v := d.Minutes()
+		return &v
+	}
+	return rec.number(keys...)
+}
+
+// address 取地址列，为空时返回 nil
+func (rec exportRecord) address(keys ...string) *models.Address {
+	if v := rec.value(keys...); v != "" {
+		return &models.Address{FormattedAddress: v}
+	}
+	return nil
+}
+
+// timeRange 解析开始和结束时间，没有结束时间时按时长计算
+// 缺少开始时间或无法确定结束时间时返回 false (不能导入为进行中的记录)
+func (rec exportRecord) timeRange(loc *time.Location) (time.Time, time.Time, bool) {
+	start, ok := parseExportTime(rec.value(exportStartTimeKeys...), loc)
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	end, ok := parseExportTime(rec.value(exportEndTimeKeys...), loc)
+	if !ok {
+		duration := rec.durationMin(exportDurationKeys...)
+		if duration == nil {
+			return time.Time{}, time.Time{}, false
+		}
+		end = start.Add(time.Duration(*duration * float64(time.Minute)))
+	}
+	if end.Before(start) {
+		return time.Time{}, time.Time{}, false
+	}
+	return start, end, true
+}
+
+// parseExportTime 解析导出文件中的时间，支持常见格式和 Unix 时间戳 (秒或毫秒)
+func parseExportTime(s string, loc *time.Location) (time.Time, bool) {
+	if s == "" {
+		return time.Time{}, false
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if n > 1e12 {
+			return time.UnixMilli(n), true
+		}
+		return time.Unix(n, 0), true
+	}
+	for _, layout := range exportTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// valueOrZero 可空数值，为空时返回 0
+func valueOrZero(v *float64) float64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+// intOrZero 可空数值取整，为空时返回 0
+func intOrZero(v *float64) int {
+	if v == nil {
+		return 0
+	}
+	return int(*v + 0.5)
+}
+
+// intPtr 可空数值取整
+func intPtr(v *float64) *int {
+	if v == nil {
+		return nil
+	}
+	n := int(*v + 0.5)
+	return &n
+}
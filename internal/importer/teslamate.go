@@ -0,0 +1,702 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// TeslaMateImporter 从 TeslaMate 数据库导入历史数据
+// TeslaMate 使用公制单位存储 (km, km/h, °C, bar)，与 tesgazer 一致，无需换算
+// 导入是幂等的：每张表按自然键跳过已导入的记录，可重复执行
+type TeslaMateImporter struct {
+	logger    *zap.Logger
+	src       *pgxpool.Pool
+	dst       *repository.DB
+	batchSize int
+
+	// TeslaMate ID -> tesgazer ID 映射
+	carIDs      map[int64]int64
+	geofenceIDs map[int64]int64
+	driveIDs    map[int64]int64
+	processIDs  map[int64]int64
+}
+
+// ImportStats 导入统计
+type ImportStats struct {
+	Cars              int `json:"cars"`
+	Geofences         int `json:"geofences"`
+	Drives            int `json:"drives"`
+	ChargingProcesses int `json:"charging_processes"`
+	Charges           int `json:"charges"`
+	Positions         int `json:"positions"`
+}
+
+// NewTeslaMateImporter 创建导入器
+func NewTeslaMateImporter(logger *zap.Logger, src *pgxpool.Pool, dst *repository.DB, batchSize int) *TeslaMateImporter {
+	if batchSize < 1 {
+		batchSize = 1000
+	}
+	return &TeslaMateImporter{
+		logger:      logger,
+		src:         src,
+		dst:         dst,
+		batchSize:   batchSize,
+		carIDs:      make(map[int64]int64),
+		geofenceIDs: make(map[int64]int64),
+		driveIDs:    make(map[int64]int64),
+		processIDs:  make(map[int64]int64),
+	}
+}
+
+// Run 按依赖顺序导入所有数据
+func (im *TeslaMateImporter) Run(ctx context.Context) (*ImportStats, error) {
+	stats := &ImportStats{}
+	var err error
+
+	if stats.Cars, err = im.importCars(ctx); err != nil {
+		return stats, fmt.Errorf("import cars: %w", err)
+	}
+	if stats.Geofences, err = im.importGeofences(ctx); err != nil {
+		return stats, fmt.Errorf("import geofences: %w", err)
+	}
+	if stats.Drives, err = im.importDrives(ctx); err != nil {
+		return stats, fmt.Errorf("import drives: %w", err)
+	}
+	if stats.ChargingProcesses, err = im.importChargingProcesses(ctx); err != nil {
+		return stats, fmt.Errorf("import charging processes: %w", err)
+	}
+	if stats.Charges, err = im.importCharges(ctx); err != nil {
+		return stats, fmt.Errorf("import charges: %w", err)
+	}
+	if stats.Positions, err = im.importPositions(ctx); err != nil {
+		return stats, fmt.Errorf("import positions: %w", err)
+	}
+
+	return stats, nil
+}
+
+// importCars 导入车辆，自然键: vin
+func (im *TeslaMateImporter) importCars(ctx context.Context) (int, error) {
+	rows, err := im.src.Query(ctx, `
+		SELECT id, eid, vid, vin, COALESCE(name, ''), COALESCE(model, ''),
+			COALESCE(trim_badging, ''), COALESCE(exterior_color, ''), COALESCE(wheel_type, '')
+		FROM cars ORDER BY id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query source cars: %w", err)
+	}
+	defer rows.Close()
+
+	var cars []struct {
+		srcID int64
+		car   models.Car
+	}
+	for rows.Next() {
+		var srcID int64
+		var car models.Car
+		if err := rows.Scan(&srcID, &car.TeslaID, &car.TeslaVehicleID, &car.VIN, &car.Name, &car.Model,
+			&car.TrimBadging, &car.ExteriorColor, &car.WheelType); err != nil {
+			return 0, fmt.Errorf("scan source car: %w", err)
+		}
+		cars = append(cars, struct {
+			srcID int64
+			car   models.Car
+		}{srcID, car})
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate source cars: %w", err)
+	}
+
+	imported := 0
+	for _, c := range cars {
+		var id int64
+		var inserted bool
+		// 已存在的车辆保留原有数据，只取回 ID
+		err := im.dst.Pool.QueryRow(ctx, `
+			INSERT INTO cars (tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW())
+			ON CONFLICT (vin) DO UPDATE SET vin = EXCLUDED.vin
+			RETURNING id, (xmax = 0)
+		`, c.car.TeslaID, c.car.TeslaVehicleID, c.car.VIN, c.car.Name, c.car.Model,
+			c.car.TrimBadging, c.car.ExteriorColor, c.car.WheelType).Scan(&id, &inserted)
+		if err != nil {
+			return imported, fmt.Errorf("upsert car %s: %w", c.car.VIN, err)
+		}
+		im.carIDs[c.srcID] = id
+		if inserted {
+			imported++
+		}
+	}
+
+	im.logger.Info("Imported cars", zap.Int("source", len(cars)), zap.Int("imported", imported))
+	return imported, nil
+}
+
+// importGeofences 导入地理围栏，自然键: (name, latitude, longitude)
+func (im *TeslaMateImporter) importGeofences(ctx context.Context) (int, error) {
+	rows, err := im.src.Query(ctx, `
+		SELECT id, name, latitude::float8, longitude::float8, radius
+		FROM geofences ORDER BY id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query source geofences: %w", err)
+	}
+	defer rows.Close()
+
+	var geofences []models.Geofence
+	for rows.Next() {
+		var g models.Geofence
+		if err := rows.Scan(&g.ID, &g.Name, &g.Latitude, &g.Longitude, &g.Radius); err != nil {
+			return 0, fmt.Errorf("scan source geofence: %w", err)
+		}
+		geofences = append(geofences, g)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate source geofences: %w", err)
+	}
+
+	const insertGeofence = `
+		WITH existing AS (
+			SELECT id FROM geofences WHERE name = $1 AND latitude = $2 AND longitude = $3 LIMIT 1
+		), inserted AS (
+			INSERT INTO geofences (name, latitude, longitude, radius)
+			SELECT $1::text, $2::float8, $3::float8, $4::int
+			WHERE NOT EXISTS (SELECT 1 FROM existing)
+			RETURNING id
+		)
+		SELECT id, true FROM inserted UNION ALL SELECT id, false FROM existing
+	`
+
+	w := newIDBatchWriter(im.dst.Pool, im.batchSize)
+	for _, g := range geofences {
+		srcID := g.ID
+		if err := w.queue(ctx, insertGeofence, func(id int64) { im.geofenceIDs[srcID] = id },
+			g.Name, g.Latitude, g.Longitude, g.Radius); err != nil {
+			return w.inserted, fmt.Errorf("insert geofences: %w", err)
+		}
+	}
+	if err := w.flush(ctx); err != nil {
+		return w.inserted, fmt.Errorf("insert geofences: %w", err)
+	}
+	imported := w.inserted
+
+	im.logger.Info("Imported geofences", zap.Int("source", len(geofences)), zap.Int("imported", imported))
+	return imported, nil
+}
+
+// importDrives 导入行程，自然键: (car_id, start_time)
+// 起止坐标和电量取自 TeslaMate 的起止位置记录，地址取自 addresses 表
+func (im *TeslaMateImporter) importDrives(ctx context.Context) (int, error) {
+	rows, err := im.src.Query(ctx, `
+		SELECT d.id, d.car_id, d.start_date, d.end_date, d.start_geofence_id, d.end_geofence_id,
+			COALESCE(d.distance, 0)::float8, COALESCE(d.duration_min, 0)::float8,
+			COALESCE(sp.battery_level, 0), ep.battery_level,
+			COALESCE(sp.est_battery_range_km, d.start_rated_range_km, d.start_ideal_range_km, 0)::float8,
+			COALESCE(ep.est_battery_range_km, d.end_rated_range_km, d.end_ideal_range_km)::float8,
+			COALESCE(d.start_km, 0)::float8, d.end_km::float8,
+			d.speed_max, d.power_max, d.power_min,
+			d.inside_temp_avg::float8, d.outside_temp_avg::float8,
+			sp.latitude::float8, sp.longitude::float8, ep.latitude::float8, ep.longitude::float8,
+			`+addressColumns("sa")+`,
+			`+addressColumns("ea")+`
+		FROM drives d
+		LEFT JOIN positions sp ON sp.id = d.start_position_id
+		LEFT JOIN positions ep ON ep.id = d.end_position_id
+		LEFT JOIN addresses sa ON sa.id = d.start_address_id
+		LEFT JOIN addresses ea ON ea.id = d.end_address_id
+		ORDER BY d.id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query source drives: %w", err)
+	}
+	defer rows.Close()
+
+	type srcDrive struct {
+		srcID           int64
+		drive           models.Drive
+		startGeofenceID *int64
+		endGeofenceID   *int64
+	}
+
+	var drives []srcDrive
+	for rows.Next() {
+		var d srcDrive
+		var srcCarID int64
+		var startAddr, endAddr addressFields
+		if err := rows.Scan(
+			&d.srcID, &srcCarID, &d.drive.StartTime, &d.drive.EndTime, &d.startGeofenceID, &d.endGeofenceID,
+			&d.drive.DistanceKm, &d.drive.DurationMin,
+			&d.drive.StartBatteryLevel, &d.drive.EndBatteryLevel,
+			&d.drive.StartRangeKm, &d.drive.EndRangeKm,
+			&d.drive.StartOdometerKm, &d.drive.EndOdometerKm,
+			&d.drive.SpeedMax, &d.drive.PowerMax, &d.drive.PowerMin,
+			&d.drive.InsideTempAvg, &d.drive.OutsideTempAvg,
+			&d.drive.StartLatitude, &d.drive.StartLongitude, &d.drive.EndLatitude, &d.drive.EndLongitude,
+			&startAddr.display, &startAddr.country, &startAddr.state, &startAddr.city,
+			&startAddr.county, &startAddr.neighbourhood, &startAddr.road, &startAddr.houseNumber,
+			&endAddr.display, &endAddr.country, &endAddr.state, &endAddr.city,
+			&endAddr.county, &endAddr.neighbourhood, &endAddr.road, &endAddr.houseNumber,
+		); err != nil {
+			return 0, fmt.Errorf("scan source drive: %w", err)
+		}
+		carID, ok := im.carIDs[srcCarID]
+		if !ok {
+			continue
+		}
+		d.drive.CarID = carID
+		d.drive.StartAddress = startAddr.toAddress()
+		d.drive.EndAddress = endAddr.toAddress()
+		drives = append(drives, d)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate source drives: %w", err)
+	}
+
+	const insertDrive = `
+		WITH existing AS (
+			SELECT id FROM drives WHERE car_id = $1 AND start_time = $2 LIMIT 1
+		), inserted AS (
+			INSERT INTO drives (car_id, start_time, end_time, start_geofence_id, end_geofence_id,
+				distance_km, duration_min, start_battery_level, end_battery_level, start_range_km, end_range_km,
+				start_odometer_km, end_odometer_km, speed_max, power_max, power_min, inside_temp_avg, outside_temp_avg,
+				start_latitude, start_longitude, end_latitude, end_longitude, start_address, end_address)
+			SELECT $1::bigint, $2::timestamptz, $3::timestamptz, $4::bigint, $5::bigint,
+				$6::float8, $7::float8, $8::int, $9::int, $10::float8, $11::float8,
+				$12::float8, $13::float8, $14::int, $15::int, $16::int, $17::float8, $18::float8,
+				$19::float8, $20::float8, $21::float8, $22::float8, $23::jsonb, $24::jsonb
+			WHERE NOT EXISTS (SELECT 1 FROM existing)
+			RETURNING id
+		)
+		SELECT id, true FROM inserted UNION ALL SELECT id, false FROM existing
+	`
+
+	w := newIDBatchWriter(im.dst.Pool, im.batchSize)
+	for _, d := range drives {
+		dr := d.drive
+		srcID := d.srcID
+		if err := w.queue(ctx, insertDrive, func(id int64) { im.driveIDs[srcID] = id },
+			dr.CarID, dr.StartTime, dr.EndTime, im.mapGeofence(d.startGeofenceID), im.mapGeofence(d.endGeofenceID),
+			dr.DistanceKm, dr.DurationMin, dr.StartBatteryLevel, dr.EndBatteryLevel, dr.StartRangeKm, dr.EndRangeKm,
+			dr.StartOdometerKm, dr.EndOdometerKm, dr.SpeedMax, dr.PowerMax, dr.PowerMin, dr.InsideTempAvg, dr.OutsideTempAvg,
+			dr.StartLatitude, dr.StartLongitude, dr.EndLatitude, dr.EndLongitude, dr.StartAddress, dr.EndAddress); err != nil {
+			return w.inserted, fmt.Errorf("insert drives: %w", err)
+		}
+	}
+	if err := w.flush(ctx); err != nil {
+		return w.inserted, fmt.Errorf("insert drives: %w", err)
+	}
+	imported := w.inserted
+
+	im.logger.Info("Imported drives", zap.Int("source", len(drives)), zap.Int("imported", imported))
+	return imported, nil
+}
+
+// importChargingProcesses 导入充电过程，自然键: (car_id, start_time)
+func (im *TeslaMateImporter) importChargingProcesses(ctx context.Context) (int, error) {
+	rows, err := im.src.Query(ctx, `
+		SELECT cp.id, cp.car_id, cp.geofence_id, cp.start_date, cp.end_date,
+			COALESCE(cp.start_battery_level, 0), cp.end_battery_level,
+			COALESCE(cp.start_rated_range_km, cp.start_ideal_range_km, 0)::float8,
+			COALESCE(cp.end_rated_range_km, cp.end_ideal_range_km)::float8,
+			COALESCE(cp.charge_energy_added, 0)::float8, COALESCE(cp.duration_min, 0)::float8,
+			cp.outside_temp_avg::float8, cp.cost::float8,
+			(SELECT MAX(c.charger_power) FROM charges c WHERE c.charging_process_id = cp.id)::int,
+			`+addressColumns("a")+`
+		FROM charging_processes cp
+		LEFT JOIN addresses a ON a.id = cp.address_id
+		ORDER BY cp.id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query source charging processes: %w", err)
+	}
+	defer rows.Close()
+
+	type srcProcess struct {
+		srcID      int64
+		process    models.ChargingProcess
+		geofenceID *int64
+	}
+
+	var processes []srcProcess
+	for rows.Next() {
+		var p srcProcess
+		var srcCarID int64
+		var addr addressFields
+		if err := rows.Scan(
+			&p.srcID, &srcCarID, &p.geofenceID, &p.process.StartTime, &p.process.EndTime,
+			&p.process.StartBatteryLevel, &p.process.EndBatteryLevel,
+			&p.process.StartRangeKm, &p.process.EndRangeKm,
+			&p.process.ChargeEnergyAdded, &p.process.DurationMin,
+			&p.process.OutsideTempAvg, &p.process.Cost, &p.process.ChargerPowerMax,
+			&addr.display, &addr.country, &addr.state, &addr.city,
+			&addr.county, &addr.neighbourhood, &addr.road, &addr.houseNumber,
+		); err != nil {
+			return 0, fmt.Errorf("scan source charging process: %w", err)
+		}
+		carID, ok := im.carIDs[srcCarID]
+		if !ok {
+			continue
+		}
+		p.process.CarID = carID
+		p.process.Address = addr.toAddress()
+		processes = append(processes, p)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("iterate source charging processes: %w", err)
+	}
+
+	const insertProcess = `
+		WITH existing AS (
+			SELECT id FROM charging_processes WHERE car_id = $1 AND start_time = $2 LIMIT 1
+		), inserted AS (
+			INSERT INTO charging_processes (car_id, start_time, geofence_id, end_time,
+				start_battery_level, end_battery_level, start_range_km, end_range_km,
+				charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address)
+			SELECT $1::bigint, $2::timestamptz, $3::bigint, $4::timestamptz,
+				$5::int, $6::int, $7::float8, $8::float8,
+				$9::float8, $10::int, $11::float8, $12::float8, $13::float8, $14::jsonb
+			WHERE NOT EXISTS (SELECT 1 FROM existing)
+			RETURNING id
+		)
+		SELECT id, true FROM inserted UNION ALL SELECT id, false FROM existing
+	`
+
+	w := newIDBatchWriter(im.dst.Pool, im.batchSize)
+	for _, p := range processes {
+		cp := p.process
+		srcID := p.srcID
+		if err := w.queue(ctx, insertProcess, func(id int64) { im.processIDs[srcID] = id },
+			cp.CarID, cp.StartTime, im.mapGeofence(p.geofenceID), cp.EndTime,
+			cp.StartBatteryLevel, cp.EndBatteryLevel, cp.StartRangeKm, cp.EndRangeKm,
+			cp.ChargeEnergyAdded, cp.ChargerPowerMax, cp.DurationMin, cp.OutsideTempAvg, cp.Cost, cp.Address); err != nil {
+			return w.inserted, fmt.Errorf("insert charging processes: %w", err)
+		}
+	}
+	if err := w.flush(ctx); err != nil {
+		return w.inserted, fmt.Errorf("insert charging processes: %w", err)
+	}
+	imported := w.inserted
+
+	im.logger.Info("Imported charging processes", zap.Int("source", len(processes)), zap.Int("imported", imported))
+	return imported, nil
+}
+
+// importCharges 导入充电详情，自然键: (charging_process_id, recorded_at)
+func (im *TeslaMateImporter) importCharges(ctx context.Context) (int, error) {
+	rows, err := im.src.Query(ctx, `
+		SELECT charging_process_id, date,
+			COALESCE(battery_level, 0), COALESCE(usable_battery_level, 0),
+			COALESCE(rated_battery_range_km, ideal_battery_range_km, 0)::float8,
+			COALESCE(charger_power, 0), COALESCE(charger_voltage, 0), COALESCE(charger_actual_current, 0),
+			COALESCE(charge_energy_added, 0)::float8, outside_temp::float8, charger_phases::int
+		FROM charges ORDER BY id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query source charges: %w", err)
+	}
+	defer rows.Close()
+
+	const insertCharge = `
+		INSERT INTO charges (charging_process_id, battery_level, usable_battery_level, range_km, charger_power,
+			charger_voltage, charger_current, charge_energy_added, outside_temp, recorded_at, charger_phases)
+		SELECT $1::bigint, $2::int, $3::int, $4::float8, $5::int, $6::int, $7::int, $8::float8, $9::float8, $10::timestamptz, $11::int
+		WHERE NOT EXISTS (SELECT 1 FROM charges WHERE charging_process_id = $1 AND recorded_at = $10)
+	`
+
+	w := newBatchWriter(im.dst.Pool, im.batchSize)
+	for rows.Next() {
+		var srcProcessID int64
+		var c models.Charge
+		if err := rows.Scan(&srcProcessID, &c.RecordedAt, &c.BatteryLevel, &c.UsableBatteryLevel, &c.RangeKm,
+			&c.ChargerPower, &c.ChargerVoltage, &c.ChargerCurrent, &c.ChargeEnergyAdded, &c.OutsideTemp, &c.ChargerPhases); err != nil {
+			return w.inserted, fmt.Errorf("scan source charge: %w", err)
+		}
+		processID, ok := im.processIDs[srcProcessID]
+		if !ok {
+			continue
+		}
+		if err := w.queue(ctx, insertCharge, processID, c.BatteryLevel, c.UsableBatteryLevel, c.RangeKm,
+			c.ChargerPower, c.ChargerVoltage, c.ChargerCurrent, c.ChargeEnergyAdded, c.OutsideTemp, c.RecordedAt, c.ChargerPhases); err != nil {
+			return w.inserted, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return w.inserted, fmt.Errorf("iterate source charges: %w", err)
+	}
+	if err := w.flush(ctx); err != nil {
+		return w.inserted, err
+	}
+
+	im.logger.Info("Imported charges", zap.Int("read", w.queued), zap.Int("imported", w.inserted))
+	return w.inserted, nil
+}
+
+// importPositions 导入位置记录，自然键: (car_id, recorded_at)，去重查询使用 idx_positions_car_id_recorded_at
+// 数据量最大的一张表，流式读取并分批写入
+func (im *TeslaMateImporter) importPositions(ctx context.Context) (int, error) {
+	rows, err := im.src.Query(ctx, `
+		SELECT car_id, drive_id, date, latitude::float8, longitude::float8,
+			speed, COALESCE(power, 0), COALESCE(odometer, 0)::float8,
+			COALESCE(battery_level, 0), COALESCE(est_battery_range_km, rated_battery_range_km, ideal_battery_range_km, 0)::float8,
+			inside_temp::float8, outside_temp::float8, elevation,
+			tpms_pressure_fl::float8, tpms_pressure_fr::float8, tpms_pressure_rl::float8, tpms_pressure_rr::float8
+		FROM positions ORDER BY id
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("query source positions: %w", err)
+	}
+	defer rows.Close()
+
+	const insertPosition = `
+		INSERT INTO positions (car_id, drive_id, latitude, longitude, speed, power, odometer, battery_level, range_km,
+			inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr,
+			heading, recorded_at)
+		SELECT $1::bigint, $2::bigint, $3::float8, $4::float8, $5::int, $6::int, $7::float8, $8::int, $9::float8,
+			$10::float8, $11::float8, $12::int, $13::float8, $14::float8, $15::float8, $16::float8, 0, $17::timestamptz
+		WHERE NOT EXISTS (SELECT 1 FROM positions WHERE car_id = $1 AND recorded_at = $17)
+	`
+
+	w := newBatchWriter(im.dst.Pool, im.batchSize)
+	lastLog := time.Now()
+	for rows.Next() {
+		var srcCarID int64
+		var srcDriveID *int64
+		var p models.Position
+		if err := rows.Scan(&srcCarID, &srcDriveID, &p.RecordedAt, &p.Latitude, &p.Longitude,
+			&p.Speed, &p.Power, &p.Odometer, &p.BatteryLevel, &p.RangeKm,
+			&p.InsideTemp, &p.OutsideTemp, &p.Elevation,
+			&p.TpmsPressureFL, &p.TpmsPressureFR, &p.TpmsPressureRL, &p.TpmsPressureRR); err != nil {
+			return w.inserted, fmt.Errorf("scan source position: %w", err)
+		}
+		carID, ok := im.carIDs[srcCarID]
+		if !ok {
+			continue
+		}
+		if srcDriveID != nil {
+			if id, ok := im.driveIDs[*srcDriveID]; ok {
+				p.DriveID = &id
+			}
+		}
+		if err := w.queue(ctx, insertPosition, carID, p.DriveID, p.Latitude, p.Longitude, p.Speed, p.Power,
+			p.Odometer, p.BatteryLevel, p.RangeKm, p.InsideTemp, p.OutsideTemp, p.Elevation,
+			p.TpmsPressureFL, p.TpmsPressureFR, p.TpmsPressureRL, p.TpmsPressureRR, p.RecordedAt); err != nil {
+			return w.inserted, err
+		}
+
+		if time.Since(lastLog) > 10*time.Second {
+			im.logger.Info("Importing positions...", zap.Int("read", w.queued), zap.Int("imported", w.inserted))
+			lastLog = time.Now()
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return w.inserted, fmt.Errorf("iterate source positions: %w", err)
+	}
+	if err := w.flush(ctx); err != nil {
+		return w.inserted, err
+	}
+
+	im.logger.Info("Imported positions", zap.Int("read", w.queued), zap.Int("imported", w.inserted))
+	return w.inserted, nil
+}
+
+// mapGeofence 将 TeslaMate 地理围栏 ID 映射为 tesgazer ID
+func (im *TeslaMateImporter) mapGeofence(srcID *int64) *int64 {
+	if srcID == nil {
+		return nil
+	}
+	if id, ok := im.geofenceIDs[*srcID]; ok {
+		return &id
+	}
+	return nil
+}
+
+// insertIfAbsent 按自然键查找记录，已存在则返回原 ID，否则插入并返回新 ID
+func insertIfAbsent(ctx context.Context, pool *pgxpool.Pool, lookup string, lookupArgs []interface{}, insert string, insertArgs ...interface{}) (int64, bool, error) {
+	var id int64
+	err := pool.QueryRow(ctx, lookup, lookupArgs...).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, err
+	}
+
+	if err := pool.QueryRow(ctx, insert, insertArgs...).Scan(&id); err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// batchWriter 将插入语句攒批，每批在一个事务中提交
+type batchWriter struct {
+	pool     *pgxpool.Pool
+	size     int
+	batch    *pgx.Batch
+	queued   int
+	inserted int
+}
+
+func newBatchWriter(pool *pgxpool.Pool, size int) *batchWriter {
+	return &batchWriter{pool: pool, size: size, batch: &pgx.Batch{}}
+}
+
+// queue 加入一条语句，达到批大小时自动提交
+func (w *batchWriter) queue(ctx context.Context, query string, args ...interface{}) error {
+	w.batch.Queue(query, args...)
+	w.queued++
+	if w.batch.Len() >= w.size {
+		return w.flush(ctx)
+	}
+	return nil
+}
+
+// flush 在事务中提交当前批次
+func (w *batchWriter) flush(ctx context.Context) error {
+	if w.batch.Len() == 0 {
+		return nil
+	}
+
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin batch: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	n := w.batch.Len()
+	results := tx.SendBatch(ctx, w.batch)
+	inserted := 0
+	for i := 0; i < n; i++ {
+		tag, err := results.Exec()
+		if err != nil {
+			results.Close()
+			return fmt.Errorf("exec batch: %w", err)
+		}
+		inserted += int(tag.RowsAffected())
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("close batch: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+
+	w.inserted += inserted
+	w.batch = &pgx.Batch{}
+	return nil
+}
+
+// idBatchWriter 将"不存在则插入"的语句攒批，每批在一个事务中提交
+// 每条语句返回 (id, inserted)：已存在时为原 ID，否则为新插入的 ID；提交成功后通过回调记录 ID 映射
+type idBatchWriter struct {
+	pool      *pgxpool.Pool
+	size      int
+	batch     *pgx.Batch
+	callbacks []func(id int64)
+	inserted  int
+}
+
+func newIDBatchWriter(pool *pgxpool.Pool, size int) *idBatchWriter {
+	return &idBatchWriter{pool: pool, size: size, batch: &pgx.Batch{}}
+}
+
+// queue 加入一条语句，达到批大小时自动提交
+func (w *idBatchWriter) queue(ctx context.Context, query string, onID func(id int64), args ...interface{}) error {
+	w.batch.Queue(query, args...)
+	w.callbacks = append(w.callbacks, onID)
+	if w.batch.Len() >= w.size {
+		return w.flush(ctx)
+	}
+	return nil
+}
+
+// flush 在事务中提交当前批次，提交成功后才回调 ID，避免记录已回滚的 ID
+func (w *idBatchWriter) flush(ctx context.Context) error {
+	if w.batch.Len() == 0 {
+		return nil
+	}
+
+	tx, err := w.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin batch: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	n := w.batch.Len()
+	ids := make([]int64, n)
+	results := tx.SendBatch(ctx, w.batch)
+	inserted := 0
+	for i := 0; i < n; i++ {
+		var isNew bool
+		if err := results.QueryRow().Scan(&ids[i], &isNew); err != nil {
+			results.Close()
+			return fmt.Errorf("exec batch: %w", err)
+		}
+		if isNew {
+			inserted++
+		}
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("close batch: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit batch: %w", err)
+	}
+
+	for i, onID := range w.callbacks {
+		onID(ids[i])
+	}
+	w.inserted += inserted
+	w.batch = &pgx.Batch{}
+	w.callbacks = nil
+	return nil
+}
+
+// addressFields TeslaMate addresses 表字段
+type addressFields struct {
+	display       *string
+	country       *string
+	state         *string
+	city          *string
+	county        *string
+	neighbourhood *string
+	road          *string
+	houseNumber   *string
+}
+
+// addressColumns 生成 addresses 表的查询列（顺序与 addressFields 扫描顺序一致）
+func addressColumns(alias string) string {
+	return fmt.Sprintf("%[1]s.display_name, %[1]s.country, %[1]s.state, %[1]s.city, "+
+		"%[1]s.county, %[1]s.neighbourhood, %[1]s.road, %[1]s.house_number", alias)
+}
+
+// toAddress 转换为结构化地址，没有地址时返回 nil
+func (a addressFields) toAddress() *models.Address {
+	if a.display == nil {
+		return nil
+	}
+	deref := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	return &models.Address{
+		FormattedAddress: deref(a.display),
+		Country:          deref(a.country),
+		Province:         deref(a.state),
+		City:             deref(a.city),
+		District:         deref(a.county),
+		Township:         deref(a.neighbourhood),
+		Street:           deref(a.road),
+		StreetNumber:     deref(a.houseNumber),
+	}
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"database/sql/driver"
 	"encoding/json"
+	"time"
 )
 
 // Address 结构化地址信息（用于逆地理编码结果）
@@ -41,4 +42,36 @@ type Geofence struct {
 	Latitude  float64 `json:"latitude" db:"latitude"`
 	Longitude float64 `json:"longitude" db:"longitude"`
 	Radius    int     `json:"radius" db:"radius"` // 米
+	// 隐私区域：区域内的坐标在 API 和 WebSocket 输出中替换为围栏中心 (数据库中仍保存实际坐标)
+	Privacy bool `json:"privacy" db:"privacy"`
+}
+
+// FrequentLocation 常去地点 (由停车位置聚类得到的候选地点)
+type FrequentLocation struct {
+	Latitude     float64   `json:"latitude"`              // 聚类中心
+	Longitude    float64   `json:"longitude"`             // 聚类中心
+	RadiusM      int       `json:"radius_m"`              // 覆盖所有停车位置的半径 (米)，可直接作为地理围栏半径
+	VisitCount   int       `json:"visit_count"`           // 停车次数
+	AvgStayMin   float64   `json:"avg_stay_min"`          // 平均停留时长 (分钟)
+	TotalStayMin float64   `json:"total_stay_min"`        // 累计停留时长 (分钟)
+	LastVisit    time.Time `json:"last_visit"`            // 最近一次停车开始时间
+	Address      *Address  `json:"address,omitempty"`     // 出现次数最多的地址
+	GeofenceID   *int64    `json:"geofence_id,omitempty"` // 中心已在某个地理围栏内时为该围栏
+	GeofenceName *string   `json:"geofence_name,omitempty"`
+}
+
+// ParkingStay 停车位置和停留时长 (常去地点聚类的输入)
+type ParkingStay struct {
+	Latitude    float64
+	Longitude   float64
+	StartTime   time.Time
+	DurationMin float64
+	Address     *Address
+}
+
+// GeofenceAssignResult 新建地理围栏后关联到已有记录的数量
+type GeofenceAssignResult struct {
+	Parkings int64 `json:"parkings"`
+	Charges  int64 `json:"charges"`
+	Drives   int64 `json:"drives"` // 关联的行程起点和终点数
 }
@@ -13,8 +13,21 @@ type Car struct {
 	TrimBadging    string    `json:"trim_badging" db:"trim_badging"`
 	ExteriorColor  string    `json:"exterior_color" db:"exterior_color"`
 	WheelType      string    `json:"wheel_type" db:"wheel_type"`
+	DisplayColor   string    `json:"display_color" db:"display_color"`     // 用户设置的显示颜色，与 Tesla 同步的 exterior_color 分开保存
+	Icon           string    `json:"icon" db:"icon"`                       // 用户设置的显示图标
+	AccountID      *int64    `json:"account_id,omitempty" db:"account_id"` // 所属 Tesla 账号
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at" db:"updated_at"`
+	// 车机设置的单位偏好 (从 gui_settings 同步，未获取时为空)，前端可作为该车的默认显示单位
+	DistanceUnit    string `json:"distance_unit" db:"distance_unit"`       // km 或 mi
+	TemperatureUnit string `json:"temperature_unit" db:"temperature_unit"` // C 或 F
+}
+
+// Account Tesla 账号 (一个实例可以同时记录多个账号下的车辆)
+type Account struct {
+	ID        int64     `json:"id" db:"id"`
+	Label     string    `json:"label" db:"label"` // 账号标识，与 Token 文件中的 key 对应
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
 // Settings 设置
@@ -15,11 +15,20 @@ type ChargingProcess struct {
 	EndBatteryLevel   *int       `json:"end_battery_level,omitempty" db:"end_battery_level"`
 	StartRangeKm      float64    `json:"start_range_km" db:"start_range_km"`
 	EndRangeKm        *float64   `json:"end_range_km,omitempty" db:"end_range_km"`
-	ChargeEnergyAdded float64    `json:"charge_energy_added" db:"charge_energy_added"` // kWh
+	ChargeEnergyAdded float64    `json:"charge_energy_added" db:"charge_energy_added"`                   // kWh，经过校验（剔除重置/跳变）
+	EnergyReported    *float64   `json:"charge_energy_reported,omitempty" db:"charge_energy_reported"`   // Tesla 上报的原始充电量 (kWh)
+	EnergyEstimated   *float64   `json:"charge_energy_estimated,omitempty" db:"charge_energy_estimated"` // 按电量变化和电池容量估算的充电量 (kWh)
 	ChargerPowerMax   *int       `json:"charger_power_max,omitempty" db:"charger_power_max"`
 	DurationMin       float64    `json:"duration_min" db:"duration_min"`
-	OutsideTempAvg    *float64   `json:"outside_temp_avg,omitempty" db:"outside_temp_avg"`
+	OutsideTempAvg    *float64   `json:"outside_temp_avg,omitempty" db:"outside_temp_avg"` // 按采样间隔加权的平均车外温度
+	OutsideTempCount  int        `json:"outside_temp_samples" db:"outside_temp_samples"`   // 参与平均的车外温度采样数
+	OutsideTempWeight float64    `json:"-" db:"outside_temp_weight_min"`                   // 已累计的采样权重 (分钟)
 	Cost              *float64   `json:"cost,omitempty" db:"cost"`
+	ChargeLimitSoc    *int       `json:"charge_limit_soc,omitempty" db:"charge_limit_soc"`     // 充电上限 (%)，充电中调整会同步更新
+	ScheduledMode     *string    `json:"scheduled_mode,omitempty" db:"scheduled_mode"`         // 预约充电模式: Off, StartAt, DepartBy
+	EndChargingState  *string    `json:"end_charging_state,omitempty" db:"end_charging_state"` // 结束时的充电状态: Complete (充到上限), Stopped (手动/预约停止), Disconnected (充电中拔枪) 等
+	BatteryHeaterMin  float64    `json:"battery_heater_min" db:"battery_heater_min"`           // 充电期间电池加热的累计时长 (分钟)
+	ChargerPhases     *int       `json:"charger_phases,omitempty"`                             // 交流充电相数 (取充电详情中的最大值，仅详情接口返回)
 }
 
 // Charge 充电详情 (每分钟记录)
@@ -32,7 +41,84 @@ type Charge struct {
 	ChargerPower       int       `json:"charger_power" db:"charger_power"`
 	ChargerVoltage     int       `json:"charger_voltage" db:"charger_voltage"`
 	ChargerCurrent     int       `json:"charger_current" db:"charger_current"`
+	ChargerPhases      *int      `json:"charger_phases,omitempty" db:"charger_phases"` // 交流充电相数 (直流充电为空)
+	ACPowerKw          *float64  `json:"ac_power_kw,omitempty" db:"ac_power_kw"`       // 电压×电流×相数计算的交流充电功率 (kW)
 	ChargeEnergyAdded  float64   `json:"charge_energy_added" db:"charge_energy_added"`
 	OutsideTemp        *float64  `json:"outside_temp,omitempty" db:"outside_temp"`
+	BatteryHeater      *bool     `json:"battery_heater,omitempty" db:"battery_heater"` // 采样时电池加热是否开启 (没有空调数据时为空)
 	RecordedAt         time.Time `json:"recorded_at" db:"recorded_at"`
 }
+
+// ChargeCurvePoint 充电曲线数据点（按电量分桶聚合）
+type ChargeCurvePoint struct {
+	SocStart             int     `json:"soc_start"`   // 分桶起始电量 (%)
+	SocEnd               int     `json:"soc_end"`     // 分桶结束电量 (%，不含)
+	PowerAvg             float64 `json:"power_avg"`   // 平均充电功率 (kW)
+	VoltageAvg           float64 `json:"voltage_avg"` // 平均电压 (V)
+	CurrentAvg           float64 `json:"current_avg"` // 平均电流 (A)
+	SampleCount          int     `json:"sample_count"`
+	BatteryHeaterSamples int     `json:"battery_heater_samples"` // 电池加热开启的采样数 (大于 0 时该区间部分功率用于加热电池)
+}
+
+// ChargeCurve 充电曲线 (功率 vs 电量)
+type ChargeCurve struct {
+	ChargingProcessID int64              `json:"charging_process_id"`
+	BucketSize        int                `json:"bucket_size"`              // 分桶宽度 (%)
+	PeakPower         *int               `json:"peak_power,omitempty"`     // 峰值功率 (kW)
+	PeakPowerSoc      *int               `json:"peak_power_soc,omitempty"` // 峰值功率出现时的电量 (%)
+	Points            []ChargeCurvePoint `json:"points"`
+}
+
+// 充电类型 (按峰值功率区分)
+const (
+	ChargeTypeAC = "ac" // 交流慢充 (家充、目的地充电)
+	ChargeTypeDC = "dc" // 直流快充 (超充)
+)
+
+// ChargeTypeStats 单类充电的统计
+type ChargeTypeStats struct {
+	Sessions            int64    `json:"sessions"`                    // 充电次数
+	EnergyAddedKwh      float64  `json:"energy_added_kwh"`            // 总充电量 (kWh)
+	AvgSessionEnergyKwh float64  `json:"avg_session_energy_kwh"`      // 平均每次充电量 (kWh)
+	AvgPeakPowerKw      *float64 `json:"avg_peak_power_kw,omitempty"` // 平均峰值功率 (kW)
+}
+
+// ChargeStats 按交流/直流分类的充电统计
+type ChargeStats struct {
+	Start       time.Time       `json:"start"`
+	End         time.Time       `json:"end"`
+	ThresholdKw int             `json:"threshold_kw"` // 峰值功率达到该值视为直流快充
+	AC          ChargeTypeStats `json:"ac"`
+	DC          ChargeTypeStats `json:"dc"`
+}
+
+// CapacitySample 一次充电按充电量和电量变化估算的电池容量 (电池健康度的样本)
+type CapacitySample struct {
+	ChargingProcessID int64
+	StartTime         time.Time
+	StartLevel        int      // 首个采样的可用电量 (%)
+	EndLevel          int      // 最后一个采样的可用电量 (%)
+	EnergyAddedKwh    float64  // 首尾采样之间的充电量 (kWh)
+	FullRangeKm       *float64 // 按最后一个采样的续航换算到 100% 的续航 (km)
+}
+
+// BatteryHealthMonth 电池健康度的月度趋势
+type BatteryHealthMonth struct {
+	Month       string   `json:"month"`                   // 2024-01
+	Sessions    int      `json:"sessions"`                // 参与估算的充电次数
+	CapacityKwh float64  `json:"capacity_kwh"`            // 估算容量 (kWh，中位数)
+	FullRangeKm *float64 `json:"full_range_km,omitempty"` // 满电续航 (km，平均值)
+}
+
+// BatteryHealth 电池健康度估算
+type BatteryHealth struct {
+	EstimatedCapacityKwh *float64              `json:"estimated_capacity_kwh"` // 最近若干次充电估算容量的中位数，没有样本时为空
+	OriginalCapacityKwh  *float64              `json:"original_capacity_kwh"`  // 基准容量
+	BaselineSource       string                `json:"baseline_source"`        // setting (车辆设置 battery_capacity_kwh) 或 earliest (最早若干次充电的估算)
+	HealthPct            *float64              `json:"health_pct"`             // 估算容量占基准容量的百分比
+	Confidence           string                `json:"confidence"`             // none, low, medium, high，按最近参与估算的充电次数
+	SampleCount          int                   `json:"sample_count"`           // 参与估算的充电总次数
+	RecentSampleCount    int                   `json:"recent_sample_count"`    // 计算当前容量使用的充电次数
+	FullRangeKm          *float64              `json:"full_range_km,omitempty"`
+	Monthly              []*BatteryHealthMonth `json:"monthly"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+)
+
+// Closures 车门、车窗、前后备箱和天窗的详细状态 (JSONB)
+// 车窗为 Tesla 上报的开度，0 为关闭；部分车型/固件上报 1 (通风) 或 2 (打开)，新固件为开度百分比
+type Closures struct {
+	DriverFrontDoor      bool `json:"driver_front_door"`               // 主驾驶侧前门
+	PassengerFrontDoor   bool `json:"passenger_front_door"`            // 副驾驶侧前门
+	DriverRearDoor       bool `json:"driver_rear_door"`                // 主驾驶侧后门
+	PassengerRearDoor    bool `json:"passenger_rear_door"`             // 副驾驶侧后门
+	Frunk                bool `json:"frunk"`                           // 前备箱
+	Trunk                bool `json:"trunk"`                           // 后备箱
+	DriverFrontWindow    int  `json:"driver_front_window"`             // 主驾驶侧前窗开度
+	PassengerFrontWindow int  `json:"passenger_front_window"`          // 副驾驶侧前窗开度
+	DriverRearWindow     int  `json:"driver_rear_window"`              // 主驾驶侧后窗开度
+	PassengerRearWindow  int  `json:"passenger_rear_window"`           // 副驾驶侧后窗开度
+	SunRoofPercentOpen   *int `json:"sun_roof_percent_open,omitempty"` // 天窗开度 (%)，无天窗的车型为空
+}
+
+// AllClosed 车门、车窗、前后备箱和天窗是否全部关闭
+func (c *Closures) AllClosed() bool {
+	return !c.DriverFrontDoor && !c.PassengerFrontDoor && !c.DriverRearDoor && !c.PassengerRearDoor &&
+		!c.Frunk && !c.Trunk &&
+		c.DriverFrontWindow == 0 && c.PassengerFrontWindow == 0 && c.DriverRearWindow == 0 && c.PassengerRearWindow == 0 &&
+		(c.SunRoofPercentOpen == nil || *c.SunRoofPercentOpen == 0)
+}
+
+// Value 实现 driver.Valuer 接口，用于存储到数据库
+func (c Closures) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan 实现 sql.Scanner 接口，用于从数据库读取
+func (c *Closures) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return nil
+	}
+	return json.Unmarshal(bytes, c)
+}
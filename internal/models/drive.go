@@ -8,6 +8,7 @@ type Drive struct {
 	CarID             int64      `json:"car_id" db:"car_id"`
 	StartTime         time.Time  `json:"start_time" db:"start_time"`
 	EndTime           *time.Time `json:"end_time,omitempty" db:"end_time"`
+	IsActive          bool       `json:"is_active" db:"-"` // 行程是否进行中 (end_time 为空)
 	StartPositionID   *int64     `json:"start_position_id,omitempty" db:"start_position_id"`
 	EndPositionID     *int64     `json:"end_position_id,omitempty" db:"end_position_id"`
 	StartGeofenceID   *int64     `json:"start_geofence_id,omitempty" db:"start_geofence_id"`
@@ -27,6 +28,14 @@ type Drive struct {
 	OutsideTempAvg    *float64   `json:"outside_temp_avg,omitempty" db:"outside_temp_avg"` // 平均车外温度
 	EnergyUsedKwh     *float64   `json:"energy_used_kwh,omitempty" db:"energy_used_kwh"`   // 总耗电量 (kWh)
 	EnergyRegenKwh    *float64   `json:"energy_regen_kwh,omitempty" db:"energy_regen_kwh"` // 动能回收电量 (kWh)
+	// 能耗拆分 (功率积分只反映驱动能耗，与电量变化的差值约为空调等附件耗电)
+	EnergySocKwh     *float64 `json:"energy_soc_kwh,omitempty" db:"energy_soc_kwh"`         // 按电量变化估算的净耗电量 (kWh)
+	EnergyClimateKwh *float64 `json:"energy_climate_kwh,omitempty" db:"energy_climate_kwh"` // 空调等附件耗电估算 (kWh)
+	// 加减速分析 (按相邻位置点的速度变化计算，单位 g)
+	AccelMaxG       *float64 `json:"accel_max_g,omitempty" db:"accel_max_g"`             // 最大加速度 (g)
+	DecelMaxG       *float64 `json:"decel_max_g,omitempty" db:"decel_max_g"`             // 最大减速度 (g，正值)
+	HarshAccelCount *int     `json:"harsh_accel_count,omitempty" db:"harsh_accel_count"` // 急加速次数
+	HarshBrakeCount *int     `json:"harsh_brake_count,omitempty" db:"harsh_brake_count"` // 急刹车次数
 	// 起止地址 (逆地理编码，结构化数据)
 	StartAddress *Address `json:"start_address,omitempty" db:"start_address"` // 起始地址
 	EndAddress   *Address `json:"end_address,omitempty" db:"end_address"`     // 结束地址
@@ -35,6 +44,8 @@ type Drive struct {
 	StartLongitude *float64 `json:"start_longitude,omitempty" db:"start_longitude"` // 起始经度
 	EndLatitude    *float64 `json:"end_latitude,omitempty" db:"end_latitude"`       // 结束纬度
 	EndLongitude   *float64 `json:"end_longitude,omitempty" db:"end_longitude"`     // 结束经度
+	// 缩略轨迹 (Google Encoded Polyline，精度 5)，仅行程列表 include=polyline 时返回
+	Polyline *string `json:"polyline,omitempty" db:"polyline"`
 }
 
 // Position 位置记录
@@ -58,7 +69,9 @@ type Position struct {
 	TpmsPressureFR *float64  `json:"tpms_pressure_fr,omitempty" db:"tpms_pressure_fr"` // 右前
 	TpmsPressureRL *float64  `json:"tpms_pressure_rl,omitempty" db:"tpms_pressure_rl"` // 左后
 	TpmsPressureRR *float64  `json:"tpms_pressure_rr,omitempty" db:"tpms_pressure_rr"` // 右后
+	Closures       *Closures `json:"closures,omitempty" db:"closures"`                 // 车门、车窗详细状态 (全部关闭或无数据时为空)
 	RecordedAt     time.Time `json:"recorded_at" db:"recorded_at"`
+	Interpolated   bool      `json:"interpolated,omitempty" db:"interpolated"` // 推送数据缺少坐标，沿用最近的坐标 (非实际 GPS 定位)
 }
 
 // DrivePath 行程轨迹简要信息 (用于足迹地图)
@@ -69,3 +82,46 @@ type DrivePath struct {
 	DistanceKm  float64      `json:"distance_km"`
 	Path        [][2]float64 `json:"path"` // [lat, lng]
 }
+
+// MatchedPath 纠偏后的行程轨迹
+type MatchedPath struct {
+	DriveID   int64        `json:"drive_id"`
+	Matched   bool         `json:"matched"`              // false 表示纠偏不可用或失败，path 为原始轨迹
+	Provider  string       `json:"provider,omitempty"`   // 纠偏服务: osrm 或 valhalla
+	MatchedAt *time.Time   `json:"matched_at,omitempty"` // 纠偏时间
+	Path      [][2]float64 `json:"path"`                 // [lat, lng]
+}
+
+// ReplayPoint 行程回放数据点 (按固定时间间隔重采样)
+type ReplayPoint struct {
+	Time      time.Time `json:"time"`
+	OffsetSec float64   `json:"offset_sec"` // 距行程第一个位置点的秒数
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Speed     *float64  `json:"speed,omitempty"` // km/h，前后任一点缺少速度时为空
+	Heading   int       `json:"heading"`
+	GapBefore bool      `json:"gap_before,omitempty"` // 与上一点之间有数据缺失 (如隧道)，回放时应直接跳转而不是连线
+}
+
+// DriveReplay 行程回放数据
+type DriveReplay struct {
+	DriveID     int64         `json:"drive_id"`
+	IntervalSec float64       `json:"interval_sec"`
+	MaxGapSec   float64       `json:"max_gap_sec"`
+	Points      []ReplayPoint `json:"points"`
+}
+
+// PositionCloud 按范围查询的位置点 (用于热力图)
+type PositionCloud struct {
+	Total  int64        `json:"total"`  // 符合条件的位置点总数 (抽样前)
+	Step   int          `json:"step"`   // 抽样间隔，按时间顺序每 step 个点保留一个
+	Points [][2]float64 `json:"points"` // [lat, lng]
+}
+
+// BoundingBox 经纬度矩形范围 (用于足迹地图按视野过滤)
+type BoundingBox struct {
+	MinLng float64
+	MinLat float64
+	MaxLng float64
+	MaxLat float64
+}
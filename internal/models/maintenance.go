@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// MaintenanceStatus 保养提醒状态
+type MaintenanceStatus string
+
+const (
+	MaintenanceStatusOK      MaintenanceStatus = "ok"       // 未到期
+	MaintenanceStatusDueSoon MaintenanceStatus = "due_soon" // 即将到期 (剩余里程或天数低于提前量)
+	MaintenanceStatusOverdue MaintenanceStatus = "overdue"  // 已超期
+)
+
+// MaintenanceReminder 保养提醒：按里程间隔和/或天数间隔 (先到为准)，从上次保养的里程和日期开始计算
+type MaintenanceReminder struct {
+	ID           int64      `json:"id" db:"id"`
+	CarID        int64      `json:"car_id" db:"car_id"`
+	Type         string     `json:"type" db:"type"` // 保养项目，如 tire_rotation、cabin_filter、brake_fluid
+	Note         string     `json:"note" db:"note"`
+	IntervalKm   *float64   `json:"interval_km,omitempty" db:"interval_km"`
+	IntervalDays *int       `json:"interval_days,omitempty" db:"interval_days"`
+	LastDoneKm   *float64   `json:"last_done_km,omitempty" db:"last_done_km"`
+	LastDoneAt   *time.Time `json:"last_done_at,omitempty" db:"last_done_at"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+
+	// NotifiedStatus 已推送过告警的状态 (状态恶化时才再次告警，标记完成后清空)
+	NotifiedStatus MaintenanceStatus `json:"-" db:"notified_status"`
+
+	// 以下为按当前里程和时间计算的字段
+	Status            MaintenanceStatus `json:"status" db:"-"`
+	CurrentOdometerKm *float64          `json:"current_odometer_km,omitempty" db:"-"`
+	DueKm             *float64          `json:"due_km,omitempty" db:"-"`         // 到期里程
+	RemainingKm       *float64          `json:"remaining_km,omitempty" db:"-"`   // 剩余里程 (超期为负)
+	DueAt             *time.Time        `json:"due_at,omitempty" db:"-"`         // 到期时间
+	RemainingDays     *float64          `json:"remaining_days,omitempty" db:"-"` // 剩余天数 (超期为负)
+}
@@ -37,7 +37,10 @@ type Parking struct {
 	OutsideTempAvg   *float64 `json:"outside_temp_avg,omitempty" db:"outside_temp_avg"`
 
 	// 空调使用情况
-	ClimateUsedMin *float64 `json:"climate_used_min,omitempty" db:"climate_used_min"` // 空调使用时长 (分钟)
+	ClimateUsedMin         *float64 `json:"climate_used_min,omitempty" db:"climate_used_min"`                 // 空调使用时长 (分钟，不含预热)
+	PreconditioningUsedMin *float64 `json:"preconditioning_used_min,omitempty" db:"preconditioning_used_min"` // 预热/预冷时长 (分钟)
+	SeatHeaterUsedMin      *float64 `json:"seat_heater_used_min,omitempty" db:"seat_heater_used_min"`         // 座椅加热使用时长 (分钟，任一座椅开启即计入)
+	DefrosterUsedMin       *float64 `json:"defroster_used_min,omitempty" db:"defroster_used_min"`             // 除雾使用时长 (分钟，前/后除雾任一开启即计入)
 
 	// 哨兵模式
 	SentryModeUsedMin *float64 `json:"sentry_mode_used_min,omitempty" db:"sentry_mode_used_min"` // 哨兵模式使用时长 (分钟)
@@ -52,6 +55,8 @@ type Parking struct {
 	StartIsClimateOn   bool `json:"start_is_climate_on" db:"start_is_climate_on"`
 	StartIsUserPresent bool `json:"start_is_user_present" db:"start_is_user_present"`
 
+	StartClosures *Closures `json:"start_closures,omitempty" db:"start_closures"` // 开始时车门、车窗的详细状态
+
 	// 结束状态快照
 	EndLocked        *bool `json:"end_locked,omitempty" db:"end_locked"`
 	EndSentryMode    *bool `json:"end_sentry_mode,omitempty" db:"end_sentry_mode"`
@@ -62,6 +67,8 @@ type Parking struct {
 	EndIsClimateOn   *bool `json:"end_is_climate_on,omitempty" db:"end_is_climate_on"`
 	EndIsUserPresent *bool `json:"end_is_user_present,omitempty" db:"end_is_user_present"`
 
+	EndClosures *Closures `json:"end_closures,omitempty" db:"end_closures"` // 结束时车门、车窗的详细状态
+
 	// 胎压 (开始)
 	StartTpmsPressureFL *float64 `json:"start_tpms_pressure_fl,omitempty" db:"start_tpms_pressure_fl"`
 	StartTpmsPressureFR *float64 `json:"start_tpms_pressure_fr,omitempty" db:"start_tpms_pressure_fr"`
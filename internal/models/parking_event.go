@@ -37,6 +37,19 @@ const (
 	// 用户在车内事件
 	EventUserPresent ParkingEventType = "user_present"
 	EventUserLeft    ParkingEventType = "user_left"
+
+	// 疑似入侵 (启发式判断：锁车且哨兵开启时车门/后备箱被打开)
+	EventPossibleTamper ParkingEventType = "possible_tamper"
+
+	// 软件更新 (停车期间车辆版本变化，details 中记录更新前后的版本)
+	EventSoftwareUpdate ParkingEventType = "software_update"
+
+	// 胎压过低 (连续多次轮询低于阈值，details 中记录轮胎位置和胎压)
+	EventLowTirePressure ParkingEventType = "low_tire_pressure"
+
+	// 代客模式事件
+	EventValetEnabled  ParkingEventType = "valet_enabled"
+	EventValetDisabled ParkingEventType = "valet_disabled"
 )
 
 // ParkingEvent 停车事件
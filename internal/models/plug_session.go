@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// PlugEventType 插枪会话事件类型
+type PlugEventType string
+
+const (
+	PlugEventPluggedIn     PlugEventType = "plugged_in"     // 插枪
+	PlugEventChargeStarted PlugEventType = "charge_started" // 开始充电 (含预约时间到达、暂停后恢复)
+	PlugEventChargeStopped PlugEventType = "charge_stopped" // 停止充电 (充满、暂停、等待预约等)
+	PlugEventUnplugged     PlugEventType = "unplugged"      // 拔枪
+)
+
+// PlugEvent 插枪会话内的事件
+type PlugEvent struct {
+	ID            int64         `json:"id" db:"id"`
+	PlugSessionID int64         `json:"plug_session_id" db:"plug_session_id"`
+	EventType     PlugEventType `json:"event_type" db:"event_type"`
+	EventTime     time.Time     `json:"event_time" db:"event_time"`
+	ChargingState string        `json:"charging_state" db:"charging_state"`         // 事件发生时 Tesla 上报的充电状态 (Charging, Stopped, Complete 等)
+	BatteryLevel  *int          `json:"battery_level,omitempty" db:"battery_level"` // 事件发生时的电量 (%)
+}
+
+// PlugSession 插枪会话：从插枪到拔枪的完整时段，包含等待预约充电、暂停等未充电的时间
+// 期间的充电过程仍记录在 charging_processes 中
+type PlugSession struct {
+	ID                int64      `json:"id" db:"id"`
+	CarID             int64      `json:"car_id" db:"car_id"`
+	GeofenceID        *int64     `json:"geofence_id,omitempty" db:"geofence_id"`
+	Latitude          *float64   `json:"latitude,omitempty" db:"latitude"`
+	Longitude         *float64   `json:"longitude,omitempty" db:"longitude"`
+	StartTime         time.Time  `json:"start_time" db:"start_time"`
+	EndTime           *time.Time `json:"end_time,omitempty" db:"end_time"`
+	DurationMin       float64    `json:"duration_min" db:"duration_min"` // 插枪时长 (分钟，结束时计算)
+	ChargingMin       float64    `json:"charging_min" db:"charging_min"` // 其中实际充电的时长 (分钟，结束时计算)
+	StartBatteryLevel *int       `json:"start_battery_level,omitempty" db:"start_battery_level"`
+	EndBatteryLevel   *int       `json:"end_battery_level,omitempty" db:"end_battery_level"`
+	ScheduledMode     *string    `json:"scheduled_mode,omitempty" db:"scheduled_mode"` // 插枪时的预约充电模式: Off, StartAt, DepartBy
+
+	Events  []*PlugEvent       `json:"events,omitempty"`  // 会话内事件 (仅详情接口返回)
+	Charges []*ChargingProcess `json:"charges,omitempty"` // 会话内的充电记录 (仅详情接口返回)
+}
@@ -0,0 +1,80 @@
+package models
+
+import "time"
+
+// FleetTotals 行程和充电汇总
+type FleetTotals struct {
+	DistanceKm       float64 `json:"distance_km"`        // 总行驶里程 (km)
+	DurationMin      float64 `json:"duration_min"`       // 总驾驶时长 (分钟)
+	DriveCount       int64   `json:"drive_count"`        // 行程数
+	EnergyChargedKwh float64 `json:"energy_charged_kwh"` // 总充电量 (kWh)
+	ChargeCost       float64 `json:"charge_cost"`        // 总充电费用 (未设置电价的充电不计入)
+	ChargeCount      int64   `json:"charge_count"`       // 充电次数
+}
+
+// FleetCarStats 单辆车的汇总
+type FleetCarStats struct {
+	CarID int64  `json:"car_id"`
+	Name  string `json:"name"`
+	FleetTotals
+}
+
+// FleetStats 所有车辆的汇总统计
+type FleetStats struct {
+	Period string           `json:"period"` // day, week, month, year, all
+	Since  *time.Time       `json:"since,omitempty"`
+	Cars   []*FleetCarStats `json:"cars"`
+	Total  FleetTotals      `json:"total"`
+}
+
+// Add 累加另一组汇总
+func (t *FleetTotals) Add(o FleetTotals) {
+	t.DistanceKm += o.DistanceKm
+	t.DurationMin += o.DurationMin
+	t.DriveCount += o.DriveCount
+	t.EnergyChargedKwh += o.EnergyChargedKwh
+	t.ChargeCost += o.ChargeCost
+	t.ChargeCount += o.ChargeCount
+}
+
+// GeofenceChargeStats 地理围栏内的充电记录及汇总 (如"本月在家充了多少电")
+type GeofenceChargeStats struct {
+	Geofence         *Geofence          `json:"geofence"`
+	Period           string             `json:"period"` // day, week, month, year, all
+	Since            *time.Time         `json:"since,omitempty"`
+	ChargeCount      int64              `json:"charge_count"`       // 充电次数
+	EnergyChargedKwh float64            `json:"energy_charged_kwh"` // 总充电量 (kWh)
+	ChargeCost       float64            `json:"charge_cost"`        // 总充电费用 (未设置电价的充电不计入)
+	Charges          []*ChargingProcess `json:"charges"`
+}
+
+// MonthlyReport 车辆的月度汇总报告
+type MonthlyReport struct {
+	CarID int64     `json:"car_id"`
+	Month string    `json:"month"` // 2024-01
+	Start time.Time `json:"start"` // 月初 (按 TIMEZONE 时区)
+	End   time.Time `json:"end"`   // 下月初
+	FleetTotals
+	ParkingDurationMin   float64           `json:"parking_duration_min"`            // 总停车时长 (分钟)
+	ParkingEnergyUsedKwh float64           `json:"parking_energy_used_kwh"`         // 停车期间耗电 (kWh)
+	LongestDrive         *Drive            `json:"longest_drive"`                   // 里程最长的行程，没有行程时为 null
+	MostVisitedLocation  *FrequentLocation `json:"most_visited_location,omitempty"` // 当月停车次数最多的地点
+}
+
+// OdometerPoint 里程表按周期汇总的一个点
+type OdometerPoint struct {
+	Period     time.Time `json:"period"`      // 周期开始时间 (按 TIMEZONE 时区对齐)
+	OdometerKm *float64  `json:"odometer_km"` // 周期结束时的里程表读数 (km)，周期内无读数时沿用上一周期，此前从无读数时为 null
+	DistanceKm float64   `json:"distance_km"` // 周期内行驶里程 (km)
+	HasData    bool      `json:"has_data"`    // 周期内是否有读数 (false 表示读数沿用上一周期)
+}
+
+// OdometerHistory 里程表随时间变化
+type OdometerHistory struct {
+	Granularity     string           `json:"granularity"` // day, week, month
+	From            time.Time        `json:"from"`
+	To              time.Time        `json:"to"`
+	StartOdometerKm *float64         `json:"start_odometer_km"` // 第一个周期开始前的最后一次读数 (km)
+	TotalDistanceKm float64          `json:"total_distance_km"` // 范围内总行驶里程 (km)
+	Points          []*OdometerPoint `json:"points"`
+}
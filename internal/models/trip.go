@@ -0,0 +1,79 @@
+package models
+
+import "time"
+
+// Trip 旅程 (由中途充电串联起来的多段行程，例如长途自驾中的多次超充)
+type Trip struct {
+	ID                int64       `json:"id" db:"id"`
+	CarID             int64       `json:"car_id" db:"car_id"`
+	StartTime         time.Time   `json:"start_time" db:"start_time"` // 第一段行程开始时间
+	EndTime           time.Time   `json:"end_time" db:"end_time"`     // 最后一段行程结束时间
+	LegCount          int         `json:"leg_count"`                  // 行程段数
+	DistanceKm        float64     `json:"distance_km"`                // 总里程 (km)
+	DrivingMin        float64     `json:"driving_min"`                // 驾驶时长 (分钟，不含中途停留)
+	DurationMin       float64     `json:"duration_min"`               // 总时长 (分钟，含中途停留)
+	EnergyUsedKwh     float64     `json:"energy_used_kwh"`            // 总耗电量 (kWh)
+	ChargeCount       int         `json:"charge_count"`               // 中途充电次数
+	ChargeEnergyAdded float64     `json:"charge_energy_added"`        // 中途充电量 (kWh)
+	ChargeCost        float64     `json:"charge_cost"`                // 中途充电费用 (未设置电价的充电不计入)
+	StartAddress      *Address    `json:"start_address,omitempty"`
+	EndAddress        *Address    `json:"end_address,omitempty"`
+	Legs              []*TripLeg  `json:"legs"`
+	Charges           []*TripStop `json:"charges"`
+}
+
+// TripLeg 旅程中的一段行程
+type TripLeg struct {
+	DriveID       int64     `json:"drive_id"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	DistanceKm    float64   `json:"distance_km"`
+	DurationMin   float64   `json:"duration_min"`
+	EnergyUsedKwh *float64  `json:"energy_used_kwh,omitempty"`
+	StartAddress  *Address  `json:"start_address,omitempty"`
+	EndAddress    *Address  `json:"end_address,omitempty"`
+	// 起止坐标只用于隐私区域脱敏地址，不输出
+	StartLatitude  *float64 `json:"-"`
+	StartLongitude *float64 `json:"-"`
+	EndLatitude    *float64 `json:"-"`
+	EndLongitude   *float64 `json:"-"`
+}
+
+// TripStop 旅程中两段行程之间的充电
+type TripStop struct {
+	ChargingProcessID int64     `json:"charging_process_id"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+	ChargeEnergyAdded float64   `json:"charge_energy_added"`
+	Cost              *float64  `json:"cost,omitempty"`
+	Address           *Address  `json:"address,omitempty"`
+	GeofenceID        *int64    `json:"-"` // 只用于隐私区域脱敏地址，不输出
+}
+
+// Summarize 根据各段行程和中途充电计算旅程汇总
+// Legs 需按开始时间升序排列
+func (t *Trip) Summarize() {
+	t.LegCount = len(t.Legs)
+	t.DistanceKm, t.DrivingMin, t.EnergyUsedKwh = 0, 0, 0
+	for _, leg := range t.Legs {
+		t.DistanceKm += leg.DistanceKm
+		t.DrivingMin += leg.DurationMin
+		if leg.EnergyUsedKwh != nil {
+			t.EnergyUsedKwh += *leg.EnergyUsedKwh
+		}
+	}
+	if len(t.Legs) > 0 {
+		t.StartAddress = t.Legs[0].StartAddress
+		t.EndAddress = t.Legs[len(t.Legs)-1].EndAddress
+	}
+	t.DurationMin = t.EndTime.Sub(t.StartTime).Minutes()
+
+	t.ChargeCount = len(t.Charges)
+	t.ChargeEnergyAdded, t.ChargeCost = 0, 0
+	for _, c := range t.Charges {
+		t.ChargeEnergyAdded += c.ChargeEnergyAdded
+		if c.Cost != nil {
+			t.ChargeCost += *c.Cost
+		}
+	}
+}
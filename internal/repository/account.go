@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// AccountRepository Tesla 账号仓库
+type AccountRepository struct {
+	db *DB
+}
+
+// NewAccountRepository 创建账号仓库
+func NewAccountRepository(db *DB) *AccountRepository {
+	return &AccountRepository{db: db}
+}
+
+// GetOrCreate 按标识获取账号，不存在则创建
+func (r *AccountRepository) GetOrCreate(ctx context.Context, label string) (*models.Account, error) {
+	query := `
+		INSERT INTO accounts (label) VALUES ($1)
+		ON CONFLICT (label) DO UPDATE SET label = EXCLUDED.label
+		RETURNING id, label, created_at
+	`
+	account := &models.Account{}
+	err := r.db.Pool.QueryRow(ctx, query, label).Scan(&account.ID, &account.Label, &account.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("get or create account: %w", err)
+	}
+	return account, nil
+}
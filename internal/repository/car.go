@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/langchou/tesgazer/internal/models"
 )
 
@@ -21,8 +24,8 @@ func NewCarRepository(db *DB) *CarRepository {
 // Create 创建车辆
 func (r *CarRepository) Create(ctx context.Context, car *models.Car) error {
 	query := `
-		INSERT INTO cars (tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO cars (tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, account_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING id
 	`
 	now := time.Now()
@@ -35,6 +38,7 @@ func (r *CarRepository) Create(ctx context.Context, car *models.Car) error {
 		car.TrimBadging,
 		car.ExteriorColor,
 		car.WheelType,
+		car.AccountID,
 		now,
 		now,
 	).Scan(&car.ID)
@@ -51,7 +55,8 @@ func (r *CarRepository) Create(ctx context.Context, car *models.Car) error {
 // GetByTeslaID 通过 Tesla ID 获取车辆
 func (r *CarRepository) GetByTeslaID(ctx context.Context, teslaID int64) (*models.Car, error) {
 	query := `
-		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, created_at, updated_at
+		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, display_color, icon, account_id,
+			distance_unit, temperature_unit, created_at, updated_at
 		FROM cars WHERE tesla_id = $1
 	`
 	car := &models.Car{}
@@ -65,6 +70,11 @@ func (r *CarRepository) GetByTeslaID(ctx context.Context, teslaID int64) (*model
 		&car.TrimBadging,
 		&car.ExteriorColor,
 		&car.WheelType,
+		&car.DisplayColor,
+		&car.Icon,
+		&car.AccountID,
+		&car.DistanceUnit,
+		&car.TemperatureUnit,
 		&car.CreatedAt,
 		&car.UpdatedAt,
 	)
@@ -74,10 +84,46 @@ func (r *CarRepository) GetByTeslaID(ctx context.Context, teslaID int64) (*model
 	return car, nil
 }
 
+// GetByVIN 通过 VIN 获取车辆，不存在时返回 nil
+func (r *CarRepository) GetByVIN(ctx context.Context, vin string) (*models.Car, error) {
+	query := `
+		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, display_color, icon, account_id,
+			distance_unit, temperature_unit, created_at, updated_at
+		FROM cars WHERE vin = $1
+	`
+	car := &models.Car{}
+	err := r.db.Pool.QueryRow(ctx, query, vin).Scan(
+		&car.ID,
+		&car.TeslaID,
+		&car.TeslaVehicleID,
+		&car.VIN,
+		&car.Name,
+		&car.Model,
+		&car.TrimBadging,
+		&car.ExteriorColor,
+		&car.WheelType,
+		&car.DisplayColor,
+		&car.Icon,
+		&car.AccountID,
+		&car.DistanceUnit,
+		&car.TemperatureUnit,
+		&car.CreatedAt,
+		&car.UpdatedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get car by vin: %w", err)
+	}
+	return car, nil
+}
+
 // GetByID 通过 ID 获取车辆
 func (r *CarRepository) GetByID(ctx context.Context, id int64) (*models.Car, error) {
 	query := `
-		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, created_at, updated_at
+		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, display_color, icon, account_id,
+			distance_unit, temperature_unit, created_at, updated_at
 		FROM cars WHERE id = $1
 	`
 	car := &models.Car{}
@@ -91,6 +137,11 @@ func (r *CarRepository) GetByID(ctx context.Context, id int64) (*models.Car, err
 		&car.TrimBadging,
 		&car.ExteriorColor,
 		&car.WheelType,
+		&car.DisplayColor,
+		&car.Icon,
+		&car.AccountID,
+		&car.DistanceUnit,
+		&car.TemperatureUnit,
 		&car.CreatedAt,
 		&car.UpdatedAt,
 	)
@@ -103,7 +154,8 @@ func (r *CarRepository) GetByID(ctx context.Context, id int64) (*models.Car, err
 // List 获取所有车辆
 func (r *CarRepository) List(ctx context.Context) ([]*models.Car, error) {
 	query := `
-		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, created_at, updated_at
+		SELECT id, tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, display_color, icon, account_id,
+			distance_unit, temperature_unit, created_at, updated_at
 		FROM cars ORDER BY id
 	`
 	rows, err := r.db.Pool.Query(ctx, query)
@@ -125,6 +177,11 @@ func (r *CarRepository) List(ctx context.Context) ([]*models.Car, error) {
 			&car.TrimBadging,
 			&car.ExteriorColor,
 			&car.WheelType,
+			&car.DisplayColor,
+			&car.Icon,
+			&car.AccountID,
+			&car.DistanceUnit,
+			&car.TemperatureUnit,
 			&car.CreatedAt,
 			&car.UpdatedAt,
 		)
@@ -140,7 +197,8 @@ func (r *CarRepository) List(ctx context.Context) ([]*models.Car, error) {
 // Update 更新车辆
 func (r *CarRepository) Update(ctx context.Context, car *models.Car) error {
 	query := `
-		UPDATE cars SET name = $1, model = $2, trim_badging = $3, exterior_color = $4, wheel_type = $5, updated_at = $6
+		UPDATE cars SET name = $1, model = $2, trim_badging = $3, exterior_color = $4, wheel_type = $5, updated_at = $6,
+			distance_unit = $8, temperature_unit = $9
 		WHERE id = $7
 	`
 	car.UpdatedAt = time.Now()
@@ -152,6 +210,8 @@ func (r *CarRepository) Update(ctx context.Context, car *models.Car) error {
 		car.WheelType,
 		car.UpdatedAt,
 		car.ID,
+		car.DistanceUnit,
+		car.TemperatureUnit,
 	)
 	if err != nil {
 		return fmt.Errorf("update car: %w", err)
@@ -159,17 +219,49 @@ func (r *CarRepository) Update(ctx context.Context, car *models.Car) error {
 	return nil
 }
 
+// UpdateDisplay 更新用户设置的显示颜色和图标，车辆不存在时返回 false
+func (r *CarRepository) UpdateDisplay(ctx context.Context, id int64, displayColor, icon string) (bool, error) {
+	tag, err := r.db.Pool.Exec(ctx, `
+		UPDATE cars SET display_color = $1, icon = $2, updated_at = NOW() WHERE id = $3
+	`, displayColor, icon, id)
+	if err != nil {
+		return false, fmt.Errorf("update car display: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RebindByVIN VIN 已存在但 tesla_id 不同时 (账号迁移、车辆过户后重新绑定)，将原有车辆记录改为新的 Tesla 标识，
+// 历史数据继续关联到该车辆，避免 Upsert 按 tesla_id 插入新记录时违反 VIN 唯一约束。
+// 返回原来的 tesla_id 和 tesla_vehicle_id，无需更新时返回 0
+func (r *CarRepository) RebindByVIN(ctx context.Context, car *models.Car) (int64, int64, error) {
+	var prevTeslaID, prevVehicleID int64
+	err := r.db.Pool.QueryRow(ctx, `
+		UPDATE cars c SET tesla_id = $1, tesla_vehicle_id = $2, updated_at = NOW()
+		FROM (SELECT id, tesla_id, tesla_vehicle_id FROM cars WHERE vin = $3 AND tesla_id <> $1 FOR UPDATE) old
+		WHERE c.id = old.id
+		RETURNING old.tesla_id, old.tesla_vehicle_id
+	`, car.TeslaID, car.TeslaVehicleID, car.VIN).Scan(&prevTeslaID, &prevVehicleID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("rebind car %s to tesla_id %d: %w", car.VIN, car.TeslaID, err)
+	}
+	return prevTeslaID, prevVehicleID, nil
+}
+
 // Upsert 创建或更新车辆
 func (r *CarRepository) Upsert(ctx context.Context, car *models.Car) error {
 	query := `
-		INSERT INTO cars (tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO cars (tesla_id, tesla_vehicle_id, vin, name, model, trim_badging, exterior_color, wheel_type, account_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		ON CONFLICT (tesla_id) DO UPDATE SET
 			name = EXCLUDED.name,
 			model = EXCLUDED.model,
 			trim_badging = EXCLUDED.trim_badging,
 			exterior_color = EXCLUDED.exterior_color,
 			wheel_type = EXCLUDED.wheel_type,
+			account_id = COALESCE(EXCLUDED.account_id, cars.account_id),
 			updated_at = EXCLUDED.updated_at
 		RETURNING id, created_at
 	`
@@ -183,6 +275,7 @@ func (r *CarRepository) Upsert(ctx context.Context, car *models.Car) error {
 		car.TrimBadging,
 		car.ExteriorColor,
 		car.WheelType,
+		car.AccountID,
 		now,
 		now,
 	).Scan(&car.ID, &car.CreatedAt)
@@ -194,3 +287,53 @@ func (r *CarRepository) Upsert(ctx context.Context, car *models.Car) error {
 	car.UpdatedAt = now
 	return nil
 }
+
+// GetFleetStats 按车辆汇总行程和充电统计 (与 DriveRepository/ChargeRepository.GetStats 口径一致)
+// 一次查询返回所有车辆，since 为 nil 时统计全部记录
+func (r *CarRepository) GetFleetStats(ctx context.Context, since *time.Time) ([]*models.FleetCarStats, error) {
+	query := `
+		SELECT c.id, c.name,
+			COALESCE(d.distance_km, 0), COALESCE(d.duration_min, 0), COALESCE(d.drive_count, 0),
+			COALESCE(cp.energy_added, 0), COALESCE(cp.cost, 0), COALESCE(cp.charge_count, 0)
+		FROM cars c
+		LEFT JOIN (
+			SELECT car_id, SUM(distance_km) AS distance_km, SUM(duration_min) AS duration_min, COUNT(*) AS drive_count
+			FROM drives
+			WHERE ($1::timestamptz IS NULL OR start_time >= $1) AND end_time IS NOT NULL
+			GROUP BY car_id
+		) d ON d.car_id = c.id
+		LEFT JOIN (
+			SELECT car_id, SUM(charge_energy_added) AS energy_added, SUM(cost) AS cost, COUNT(*) AS charge_count
+			FROM charging_processes
+			WHERE ($1::timestamptz IS NULL OR start_time >= $1) AND end_time IS NOT NULL
+			GROUP BY car_id
+		) cp ON cp.car_id = c.id
+		ORDER BY c.id
+	`
+	rows, err := r.db.Pool.Query(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("get fleet stats: %w", err)
+	}
+	defer rows.Close()
+
+	var stats []*models.FleetCarStats
+	for rows.Next() {
+		s := &models.FleetCarStats{}
+		err := rows.Scan(
+			&s.CarID,
+			&s.Name,
+			&s.DistanceKm,
+			&s.DurationMin,
+			&s.DriveCount,
+			&s.EnergyChargedKwh,
+			&s.ChargeCost,
+			&s.ChargeCount,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scan fleet stats: %w", err)
+		}
+		stats = append(stats, s)
+	}
+
+	return stats, nil
+}
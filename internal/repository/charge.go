@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/langchou/tesgazer/internal/models"
 )
 
@@ -21,8 +24,9 @@ func NewChargeRepository(db *DB) *ChargeRepository {
 // CreateProcess 创建充电过程
 func (r *ChargeRepository) CreateProcess(ctx context.Context, cp *models.ChargingProcess) error {
 	query := `
-		INSERT INTO charging_processes (car_id, position_id, geofence_id, start_time, start_battery_level, start_range_km, address)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		INSERT INTO charging_processes (car_id, position_id, geofence_id, start_time, start_battery_level, start_range_km, address,
+			charge_limit_soc, scheduled_mode, charge_energy_reported)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		RETURNING id
 	`
 	err := r.db.Pool.QueryRow(ctx, query,
@@ -33,6 +37,9 @@ func (r *ChargeRepository) CreateProcess(ctx context.Context, cp *models.Chargin
 		cp.StartBatteryLevel,
 		cp.StartRangeKm,
 		cp.Address,
+		cp.ChargeLimitSoc,
+		cp.ScheduledMode,
+		cp.EnergyReported,
 	).Scan(&cp.ID)
 
 	if err != nil {
@@ -51,8 +58,15 @@ func (r *ChargeRepository) CompleteProcess(ctx context.Context, cp *models.Charg
 			charge_energy_added = $4,
 			charger_power_max = $5,
 			duration_min = $6,
-			outside_temp_avg = $7
-		WHERE id = $8
+			outside_temp_avg = $7,
+			cost = COALESCE($8, cost),
+			charge_energy_reported = $10,
+			charge_energy_estimated = $11,
+			outside_temp_samples = $12,
+			outside_temp_weight_min = $13,
+			end_charging_state = $14,
+			battery_heater_min = $15
+		WHERE id = $9
 	`
 	_, err := r.db.Pool.Exec(ctx, query,
 		cp.EndTime,
@@ -62,7 +76,14 @@ func (r *ChargeRepository) CompleteProcess(ctx context.Context, cp *models.Charg
 		cp.ChargerPowerMax,
 		cp.DurationMin,
 		cp.OutsideTempAvg,
+		cp.Cost,
 		cp.ID,
+		cp.EnergyReported,
+		cp.EnergyEstimated,
+		cp.OutsideTempCount,
+		cp.OutsideTempWeight,
+		cp.EndChargingState,
+		cp.BatteryHeaterMin,
 	)
 	if err != nil {
 		return fmt.Errorf("complete charging process: %w", err)
@@ -79,7 +100,13 @@ func (r *ChargeRepository) UpdateSnapshot(ctx context.Context, cp *models.Chargi
 			charge_energy_added = $4,
 			charger_power_max = $5,
 			outside_temp_avg = $6,
-			duration_min = $7
+			duration_min = $7,
+			charge_limit_soc = COALESCE($8, charge_limit_soc),
+			charge_energy_reported = $9,
+			charge_energy_estimated = $10,
+			outside_temp_samples = $11,
+			outside_temp_weight_min = $12,
+			battery_heater_min = $13
 		WHERE id = $1 AND end_time IS NULL
 	`
 	_, err := r.db.Pool.Exec(ctx, query,
@@ -90,6 +117,12 @@ func (r *ChargeRepository) UpdateSnapshot(ctx context.Context, cp *models.Chargi
 		cp.ChargerPowerMax,
 		cp.OutsideTempAvg,
 		cp.DurationMin,
+		cp.ChargeLimitSoc,
+		cp.EnergyReported,
+		cp.EnergyEstimated,
+		cp.OutsideTempCount,
+		cp.OutsideTempWeight,
+		cp.BatteryHeaterMin,
 	)
 	if err != nil {
 		return fmt.Errorf("update charging snapshot: %w", err)
@@ -100,8 +133,9 @@ func (r *ChargeRepository) UpdateSnapshot(ctx context.Context, cp *models.Chargi
 // CreateCharge 创建充电详情记录
 func (r *ChargeRepository) CreateCharge(ctx context.Context, c *models.Charge) error {
 	query := `
-		INSERT INTO charges (charging_process_id, battery_level, usable_battery_level, range_km, charger_power, charger_voltage, charger_current, charge_energy_added, outside_temp, recorded_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO charges (charging_process_id, battery_level, usable_battery_level, range_km, charger_power, charger_voltage, charger_current, charge_energy_added, outside_temp, recorded_at,
+			charger_phases, ac_power_kw, battery_heater)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		RETURNING id
 	`
 	err := r.db.Pool.QueryRow(ctx, query,
@@ -115,6 +149,9 @@ func (r *ChargeRepository) CreateCharge(ctx context.Context, c *models.Charge) e
 		c.ChargeEnergyAdded,
 		c.OutsideTemp,
 		c.RecordedAt,
+		c.ChargerPhases,
+		c.ACPowerKw,
+		c.BatteryHeater,
 	).Scan(&c.ID)
 
 	if err != nil {
@@ -127,7 +164,9 @@ func (r *ChargeRepository) CreateCharge(ctx context.Context, c *models.Charge) e
 func (r *ChargeRepository) GetProcessByID(ctx context.Context, id int64) (*models.ChargingProcess, error) {
 	query := `
 		SELECT id, car_id, position_id, geofence_id, start_time, end_time, start_battery_level, end_battery_level,
-			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address
+			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address,
+			charge_limit_soc, scheduled_mode, charge_energy_reported, charge_energy_estimated, outside_temp_samples, outside_temp_weight_min,
+			end_charging_state, battery_heater_min, (SELECT MAX(charger_phases) FROM charges WHERE charging_process_id = charging_processes.id)
 		FROM charging_processes WHERE id = $1
 	`
 	cp := &models.ChargingProcess{}
@@ -148,6 +187,15 @@ func (r *ChargeRepository) GetProcessByID(ctx context.Context, id int64) (*model
 		&cp.OutsideTempAvg,
 		&cp.Cost,
 		&cp.Address,
+		&cp.ChargeLimitSoc,
+		&cp.ScheduledMode,
+		&cp.EnergyReported,
+		&cp.EnergyEstimated,
+		&cp.OutsideTempCount,
+		&cp.OutsideTempWeight,
+		&cp.EndChargingState,
+		&cp.BatteryHeaterMin,
+		&cp.ChargerPhases,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get charging process: %w", err)
@@ -155,54 +203,97 @@ func (r *ChargeRepository) GetProcessByID(ctx context.Context, id int64) (*model
 	return cp, nil
 }
 
-// ListProcessesByCarID 获取车辆充电记录列表
-func (r *ChargeRepository) ListProcessesByCarID(ctx context.Context, carID int64, limit, offset int) ([]*models.ChargingProcess, error) {
+// chargingProcessColumns 充电记录列表查询的列，与 scanChargingProcesses 的扫描顺序一致
+const chargingProcessColumns = `id, car_id, position_id, geofence_id, start_time, end_time, start_battery_level, end_battery_level,
+			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address,
+			charge_limit_soc, scheduled_mode, charge_energy_reported, charge_energy_estimated, outside_temp_samples, outside_temp_weight_min,
+			end_charging_state, battery_heater_min`
+
+// ListProcessesByCarID 获取车辆充电记录列表，before 不为 nil 时只返回开始时间早于 before 的充电 (游标分页)
+func (r *ChargeRepository) ListProcessesByCarID(ctx context.Context, carID int64, before *time.Time, limit, offset int) ([]*models.ChargingProcess, error) {
+	cond, args := beforeStartTime(before, []interface{}{carID, limit, offset})
 	query := `
-		SELECT id, car_id, position_id, geofence_id, start_time, end_time, start_battery_level, end_battery_level,
-			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address
-		FROM charging_processes WHERE car_id = $1 ORDER BY start_time DESC LIMIT $2 OFFSET $3
+		SELECT ` + chargingProcessColumns + `
+		FROM charging_processes WHERE car_id = $1` + cond + ` ORDER BY start_time DESC LIMIT $2 OFFSET $3
 	`
-	rows, err := r.db.Pool.Query(ctx, query, carID, limit, offset)
+	rows, err := r.db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list charging processes: %w", err)
 	}
+	return scanChargingProcesses(rows)
+}
+
+// ListProcessesByGeofence 获取开始位置在指定地理围栏内的充电记录 (按 geofence_id 匹配)
+// since 为 nil 时返回全部记录
+func (r *ChargeRepository) ListProcessesByGeofence(ctx context.Context, geofenceID int64, since *time.Time) ([]*models.ChargingProcess, error) {
+	query := `
+		SELECT ` + chargingProcessColumns + `
+		FROM charging_processes
+		WHERE geofence_id = $1 AND ($2::timestamptz IS NULL OR start_time >= $2)
+		ORDER BY start_time DESC
+	`
+	rows, err := r.db.Pool.Query(ctx, query, geofenceID, since)
+	if err != nil {
+		return nil, fmt.Errorf("list charging processes by geofence: %w", err)
+	}
+	return scanChargingProcesses(rows)
+}
+
+// scanChargingProcesses 扫描 chargingProcessColumns 查询的结果并关闭 rows
+func scanChargingProcesses(rows pgx.Rows) ([]*models.ChargingProcess, error) {
 	defer rows.Close()
 
 	var processes []*models.ChargingProcess
 	for rows.Next() {
 		cp := &models.ChargingProcess{}
-		err := rows.Scan(
-			&cp.ID,
-			&cp.CarID,
-			&cp.PositionID,
-			&cp.GeofenceID,
-			&cp.StartTime,
-			&cp.EndTime,
-			&cp.StartBatteryLevel,
-			&cp.EndBatteryLevel,
-			&cp.StartRangeKm,
-			&cp.EndRangeKm,
-			&cp.ChargeEnergyAdded,
-			&cp.ChargerPowerMax,
-			&cp.DurationMin,
-			&cp.OutsideTempAvg,
-			&cp.Cost,
-			&cp.Address,
-		)
+		err := rows.Scan(chargingProcessFields(cp)...)
 		if err != nil {
 			return nil, fmt.Errorf("scan charging process: %w", err)
 		}
 		processes = append(processes, cp)
 	}
 
-	return processes, nil
+	return processes, rows.Err()
+}
+
+// chargingProcessFields chargingProcessColumns 各列对应的扫描目标
+func chargingProcessFields(cp *models.ChargingProcess) []interface{} {
+	return []interface{}{
+		&cp.ID,
+		&cp.CarID,
+		&cp.PositionID,
+		&cp.GeofenceID,
+		&cp.StartTime,
+		&cp.EndTime,
+		&cp.StartBatteryLevel,
+		&cp.EndBatteryLevel,
+		&cp.StartRangeKm,
+		&cp.EndRangeKm,
+		&cp.ChargeEnergyAdded,
+		&cp.ChargerPowerMax,
+		&cp.DurationMin,
+		&cp.OutsideTempAvg,
+		&cp.Cost,
+		&cp.Address,
+		&cp.ChargeLimitSoc,
+		&cp.ScheduledMode,
+		&cp.EnergyReported,
+		&cp.EnergyEstimated,
+		&cp.OutsideTempCount,
+		&cp.OutsideTempWeight,
+		&cp.EndChargingState,
+		&cp.BatteryHeaterMin,
+	}
 }
 
 // GetActiveProcess 获取进行中的充电
+// 没有进行中的充电时返回 nil
 func (r *ChargeRepository) GetActiveProcess(ctx context.Context, carID int64) (*models.ChargingProcess, error) {
 	query := `
 		SELECT id, car_id, position_id, geofence_id, start_time, end_time, start_battery_level, end_battery_level,
-			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address
+			start_range_km, end_range_km, charge_energy_added, charger_power_max, duration_min, outside_temp_avg, cost, address,
+			charge_limit_soc, scheduled_mode, charge_energy_reported, charge_energy_estimated, outside_temp_samples, outside_temp_weight_min,
+			battery_heater_min
 		FROM charging_processes WHERE car_id = $1 AND end_time IS NULL ORDER BY start_time DESC LIMIT 1
 	`
 	cp := &models.ChargingProcess{}
@@ -223,9 +314,19 @@ func (r *ChargeRepository) GetActiveProcess(ctx context.Context, carID int64) (*
 		&cp.OutsideTempAvg,
 		&cp.Cost,
 		&cp.Address,
+		&cp.ChargeLimitSoc,
+		&cp.ScheduledMode,
+		&cp.EnergyReported,
+		&cp.EnergyEstimated,
+		&cp.OutsideTempCount,
+		&cp.OutsideTempWeight,
+		&cp.BatteryHeaterMin,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("get active charging process: %w", err)
 	}
 	return cp, nil
 }
@@ -233,7 +334,8 @@ func (r *ChargeRepository) GetActiveProcess(ctx context.Context, carID int64) (*
 // ListChargesByProcessID 获取充电详情列表
 func (r *ChargeRepository) ListChargesByProcessID(ctx context.Context, processID int64) ([]*models.Charge, error) {
 	query := `
-		SELECT id, charging_process_id, battery_level, usable_battery_level, range_km, charger_power, charger_voltage, charger_current, charge_energy_added, outside_temp, recorded_at
+		SELECT id, charging_process_id, battery_level, usable_battery_level, range_km, charger_power, charger_voltage, charger_current, charge_energy_added, outside_temp, recorded_at,
+			charger_phases, ac_power_kw, battery_heater
 		FROM charges WHERE charging_process_id = $1 ORDER BY recorded_at
 	`
 	rows, err := r.db.Pool.Query(ctx, query, processID)
@@ -257,6 +359,9 @@ func (r *ChargeRepository) ListChargesByProcessID(ctx context.Context, processID
 			&c.ChargeEnergyAdded,
 			&c.OutsideTemp,
 			&c.RecordedAt,
+			&c.ChargerPhases,
+			&c.ACPowerKw,
+			&c.BatteryHeater,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan charge: %w", err)
@@ -267,6 +372,81 @@ func (r *ChargeRepository) ListChargesByProcessID(ctx context.Context, processID
 	return charges, nil
 }
 
+// GetChargeCurve 获取充电曲线，按电量分桶聚合功率/电压/电流
+// bucketSize 为分桶宽度 (%)，需能整除 100；交流充电优先使用按相数计算的功率
+func (r *ChargeRepository) GetChargeCurve(ctx context.Context, processID int64, bucketSize int) (*models.ChargeCurve, error) {
+	curve := &models.ChargeCurve{
+		ChargingProcessID: processID,
+		BucketSize:        bucketSize,
+		Points:            []models.ChargeCurvePoint{},
+	}
+
+	// 上界取 100 + bucketSize，使 100% 落在最后一个独立分桶中
+	query := `
+		SELECT
+			width_bucket(battery_level, 0, 100 + $2, (100 + $2) / $2) AS bucket,
+			AVG(COALESCE(ac_power_kw, charger_power))::float8,
+			AVG(charger_voltage)::float8,
+			AVG(charger_current)::float8,
+			COUNT(*),
+			COUNT(*) FILTER (WHERE battery_heater)
+		FROM charges
+		WHERE charging_process_id = $1 AND battery_level IS NOT NULL
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+	rows, err := r.db.Pool.Query(ctx, query, processID, bucketSize)
+	if err != nil {
+		return nil, fmt.Errorf("query charge curve: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket int
+		var p models.ChargeCurvePoint
+		var powerAvg, voltageAvg, currentAvg *float64
+		if err := rows.Scan(&bucket, &powerAvg, &voltageAvg, &currentAvg, &p.SampleCount, &p.BatteryHeaterSamples); err != nil {
+			return nil, fmt.Errorf("scan charge curve: %w", err)
+		}
+		p.SocStart = (bucket - 1) * bucketSize
+		p.SocEnd = bucket * bucketSize
+		if powerAvg != nil {
+			p.PowerAvg = *powerAvg
+		}
+		if voltageAvg != nil {
+			p.VoltageAvg = *voltageAvg
+		}
+		if currentAvg != nil {
+			p.CurrentAvg = *currentAvg
+		}
+		curve.Points = append(curve.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate charge curve: %w", err)
+	}
+
+	// 峰值功率及对应电量
+	peakQuery := `
+		SELECT charger_power, battery_level
+		FROM charges
+		WHERE charging_process_id = $1 AND charger_power IS NOT NULL
+		ORDER BY charger_power DESC, recorded_at
+		LIMIT 1
+	`
+	// 电量可能为空 (采样缺少电量)，此时只返回峰值功率
+	var peakPower int
+	var peakSoc *int
+	err = r.db.Pool.QueryRow(ctx, peakQuery, processID).Scan(&peakPower, &peakSoc)
+	if err == nil {
+		curve.PeakPower = &peakPower
+		curve.PeakPowerSoc = peakSoc
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("query peak charge power: %w", err)
+	}
+
+	return curve, nil
+}
+
 // CountProcessesByCarID 统计车辆充电次数
 func (r *ChargeRepository) CountProcessesByCarID(ctx context.Context, carID int64) (int64, error) {
 	var count int64
@@ -277,15 +457,135 @@ func (r *ChargeRepository) CountProcessesByCarID(ctx context.Context, carID int6
 	return count, nil
 }
 
-// GetStats 获取充电统计
-func (r *ChargeRepository) GetStats(ctx context.Context, carID int64, since time.Time) (totalEnergy float64, count int64, err error) {
+// GetStats 获取 [start, end) 内开始的已结束充电统计 (没有保存费用的充电不计入总费用)
+func (r *ChargeRepository) GetStats(ctx context.Context, carID int64, start, end time.Time) (totalEnergy float64, totalCost float64, count int64, err error) {
 	query := `
-		SELECT COALESCE(SUM(charge_energy_added), 0), COUNT(*)
-		FROM charging_processes WHERE car_id = $1 AND start_time >= $2 AND end_time IS NOT NULL
+		SELECT COALESCE(SUM(charge_energy_added), 0), COALESCE(SUM(cost), 0), COUNT(*)
+		FROM charging_processes WHERE car_id = $1 AND start_time >= $2 AND start_time < $3 AND end_time IS NOT NULL
 	`
-	err = r.db.Pool.QueryRow(ctx, query, carID, since).Scan(&totalEnergy, &count)
+	err = r.db.Pool.QueryRow(ctx, query, carID, start, end).Scan(&totalEnergy, &totalCost, &count)
 	if err != nil {
 		err = fmt.Errorf("get charge stats: %w", err)
 	}
 	return
 }
+
+// ListMissingAddresses 获取 ID 大于 afterID、缺少地址的充电记录 (坐标取自关联的位置点)
+func (r *ChargeRepository) ListMissingAddresses(ctx context.Context, afterID int64, limit int) ([]*MissingAddress, error) {
+	query := `
+		SELECT cp.id, p.latitude, p.longitude
+		FROM charging_processes cp
+		JOIN positions p ON p.id = cp.position_id
+		WHERE cp.id > $1 AND cp.address IS NULL
+		ORDER BY cp.id
+		LIMIT $2
+	`
+	rows, err := r.db.Pool.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list charging processes missing address: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*MissingAddress
+	for rows.Next() {
+		item := &MissingAddress{}
+		if err := rows.Scan(&item.ID, &item.Latitude, &item.Longitude); err != nil {
+			return nil, fmt.Errorf("scan charging process missing address: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// UpdateAddress 补全充电记录地址，已有地址不会被覆盖
+func (r *ChargeRepository) UpdateAddress(ctx context.Context, id int64, address *models.Address) error {
+	query := `UPDATE charging_processes SET address = COALESCE(address, $1) WHERE id = $2`
+	if _, err := r.db.Pool.Exec(ctx, query, address, id); err != nil {
+		return fmt.Errorf("update charging process address: %w", err)
+	}
+	return nil
+}
+
+// GetStatsByType 按峰值功率将已完成的充电分为交流/直流并分别统计
+// charger_power_max >= thresholdKw 视为直流快充，没有功率记录的视为交流
+func (r *ChargeRepository) GetStatsByType(ctx context.Context, carID int64, start, end time.Time, thresholdKw int) (*models.ChargeStats, error) {
+	query := `
+		SELECT
+			CASE WHEN COALESCE(charger_power_max, 0) >= $4 THEN 'dc' ELSE 'ac' END AS charge_type,
+			COUNT(*),
+			COALESCE(SUM(charge_energy_added), 0)::float8,
+			COALESCE(AVG(charge_energy_added), 0)::float8,
+			AVG(charger_power_max)::float8
+		FROM charging_processes
+		WHERE car_id = $1 AND start_time >= $2 AND start_time < $3 AND end_time IS NOT NULL
+		GROUP BY charge_type
+	`
+	rows, err := r.db.Pool.Query(ctx, query, carID, start, end, thresholdKw)
+	if err != nil {
+		return nil, fmt.Errorf("get charge stats by type: %w", err)
+	}
+	defer rows.Close()
+
+	stats := &models.ChargeStats{
+		Start:       start,
+		End:         end,
+		ThresholdKw: thresholdKw,
+	}
+	for rows.Next() {
+		var chargeType string
+		var ts models.ChargeTypeStats
+		if err := rows.Scan(&chargeType, &ts.Sessions, &ts.EnergyAddedKwh, &ts.AvgSessionEnergyKwh, &ts.AvgPeakPowerKw); err != nil {
+			return nil, fmt.Errorf("scan charge stats: %w", err)
+		}
+		if chargeType == models.ChargeTypeDC {
+			stats.DC = ts
+		} else {
+			stats.AC = ts
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate charge stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// ListCapacitySamples 获取可用于估算电池容量的充电 (按开始时间升序)
+// 取每次已完成充电的首尾采样，可用电量变化不小于 minSocDelta 且结束电量不低于 minEndLevel (接近充满)
+func (r *ChargeRepository) ListCapacitySamples(ctx context.Context, carID int64, minSocDelta, minEndLevel int) ([]*models.CapacitySample, error) {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT cp.id, cp.start_time, f.usable_battery_level, l.usable_battery_level,
+			l.charge_energy_added - f.charge_energy_added,
+			CASE WHEN l.range_km > 0 AND l.battery_level > 0 THEN l.range_km / l.battery_level * 100 END
+		FROM charging_processes cp
+		JOIN LATERAL (
+			SELECT usable_battery_level, charge_energy_added FROM charges
+			WHERE charging_process_id = cp.id AND usable_battery_level IS NOT NULL AND charge_energy_added IS NOT NULL
+			ORDER BY recorded_at LIMIT 1
+		) f ON true
+		JOIN LATERAL (
+			SELECT usable_battery_level, battery_level, range_km, charge_energy_added FROM charges
+			WHERE charging_process_id = cp.id AND usable_battery_level IS NOT NULL AND charge_energy_added IS NOT NULL
+			ORDER BY recorded_at DESC LIMIT 1
+		) l ON true
+		WHERE cp.car_id = $1 AND cp.end_time IS NOT NULL
+			AND l.usable_battery_level - f.usable_battery_level >= $2
+			AND l.usable_battery_level >= $3
+		ORDER BY cp.start_time
+	`, carID, minSocDelta, minEndLevel)
+	if err != nil {
+		return nil, fmt.Errorf("list capacity samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []*models.CapacitySample
+	for rows.Next() {
+		s := &models.CapacitySample{}
+		if err := rows.Scan(&s.ChargingProcessID, &s.StartTime, &s.StartLevel, &s.EndLevel, &s.EnergyAddedKwh, &s.FullRangeKm); err != nil {
+			return nil, fmt.Errorf("scan capacity sample: %w", err)
+		}
+		samples = append(samples, s)
+	}
+	return samples, rows.Err()
+}
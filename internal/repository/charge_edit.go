@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DeleteProcess 删除已结束的充电记录及其充电详情
+// 充电记录仍在进行中时返回 false，不存在时返回 pgx.ErrNoRows
+func (r *ChargeRepository) DeleteProcess(ctx context.Context, id int64) (bool, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin delete charging process: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// 锁定充电记录，避免与正在结束的充电同时修改
+	var ended bool
+	err = tx.QueryRow(ctx, `SELECT end_time IS NOT NULL FROM charging_processes WHERE id = $1 FOR UPDATE`, id).Scan(&ended)
+	if err != nil {
+		return false, fmt.Errorf("lock charging process: %w", err)
+	}
+	if !ended {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM charges WHERE charging_process_id = $1`, id); err != nil {
+		return false, fmt.Errorf("delete charges: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM charging_processes WHERE id = $1`, id); err != nil {
+		return false, fmt.Errorf("delete charging process: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit delete charging process: %w", err)
+	}
+	return true, nil
+}
+
+// MergeProcesses 将同一车辆相邻的两条已结束充电记录合并为一条
+// 保留开始较早的记录：起始数据沿用前一条，结束数据取后一条，充电量和费用相加，峰值功率取较大值，
+// 车外温度按采样权重加权平均，电池加热时长相加，时长为前一条开始到后一条结束。后一条的充电详情归入保留的记录，之后删除后一条。
+// 返回保留的记录 ID；不属于同一车辆、仍在进行中或中间还有其他充电记录时返回 0，记录不存在时返回 pgx.ErrNoRows
+func (r *ChargeRepository) MergeProcesses(ctx context.Context, id, otherID int64) (int64, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin merge charging processes: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// 按开始时间锁定两条记录
+	rows, err := tx.Query(ctx, `
+		SELECT id, car_id, start_time, end_time IS NOT NULL
+		FROM charging_processes WHERE id IN ($1, $2)
+		ORDER BY start_time, id
+		FOR UPDATE
+	`, id, otherID)
+	if err != nil {
+		return 0, fmt.Errorf("lock charging processes: %w", err)
+	}
+	type lockedProcess struct {
+		id        int64
+		carID     int64
+		startTime time.Time
+		ended     bool
+	}
+	var locked []lockedProcess
+	for rows.Next() {
+		var p lockedProcess
+		if err := rows.Scan(&p.id, &p.carID, &p.startTime, &p.ended); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan charging process: %w", err)
+		}
+		locked = append(locked, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("lock charging processes: %w", err)
+	}
+	if len(locked) != 2 {
+		return 0, fmt.Errorf("lock charging processes: %w", pgx.ErrNoRows)
+	}
+	if locked[0].carID != locked[1].carID || !locked[0].ended || !locked[1].ended {
+		return 0, nil
+	}
+	first, second := locked[0], locked[1]
+
+	// 两条记录之间不能有其他充电记录
+	var between bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM charging_processes
+			WHERE car_id = $1 AND (start_time, id) > ($2, $3) AND (start_time, id) < ($4, $5)
+		)
+	`, first.carID, first.startTime, first.id, second.startTime, second.id).Scan(&between)
+	if err != nil {
+		return 0, fmt.Errorf("check adjacent charging processes: %w", err)
+	}
+	if between {
+		return 0, nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE charges SET charging_process_id = $1 WHERE charging_process_id = $2
+	`, first.id, second.id); err != nil {
+		return 0, fmt.Errorf("move charges to merged process: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE charging_processes f SET
+			end_time = s.end_time,
+			end_battery_level = s.end_battery_level,
+			end_range_km = s.end_range_km,
+			end_charging_state = s.end_charging_state,
+			charge_limit_soc = COALESCE(s.charge_limit_soc, f.charge_limit_soc),
+			duration_min = EXTRACT(EPOCH FROM (s.end_time - f.start_time)) / 60,
+			charge_energy_added = f.charge_energy_added + s.charge_energy_added,
+			charge_energy_reported = CASE WHEN f.charge_energy_reported IS NULL AND s.charge_energy_reported IS NULL
+				THEN NULL ELSE COALESCE(f.charge_energy_reported, 0) + COALESCE(s.charge_energy_reported, 0) END,
+			charge_energy_estimated = CASE WHEN f.charge_energy_estimated IS NULL AND s.charge_energy_estimated IS NULL
+				THEN NULL ELSE COALESCE(f.charge_energy_estimated, 0) + COALESCE(s.charge_energy_estimated, 0) END,
+			charger_power_max = GREATEST(f.charger_power_max, s.charger_power_max),
+			outside_temp_avg = CASE
+				WHEN f.outside_temp_avg IS NULL THEN s.outside_temp_avg
+				WHEN s.outside_temp_avg IS NULL THEN f.outside_temp_avg
+				WHEN f.outside_temp_weight_min + s.outside_temp_weight_min > 0 THEN
+					(f.outside_temp_avg * f.outside_temp_weight_min + s.outside_temp_avg * s.outside_temp_weight_min)
+						/ (f.outside_temp_weight_min + s.outside_temp_weight_min)
+				ELSE (f.outside_temp_avg + s.outside_temp_avg) / 2 END,
+			outside_temp_samples = f.outside_temp_samples + s.outside_temp_samples,
+			outside_temp_weight_min = f.outside_temp_weight_min + s.outside_temp_weight_min,
+			battery_heater_min = f.battery_heater_min + s.battery_heater_min,
+			cost = CASE WHEN f.cost IS NULL AND s.cost IS NULL
+				THEN NULL ELSE COALESCE(f.cost, 0) + COALESCE(s.cost, 0) END
+		FROM charging_processes s
+		WHERE f.id = $1 AND s.id = $2
+	`, first.id, second.id)
+	if err != nil {
+		return 0, fmt.Errorf("update merged charging process: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM charging_processes WHERE id = $1`, second.id); err != nil {
+		return 0, fmt.Errorf("delete merged charging process: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit merge charging processes: %w", err)
+	}
+	return first.id, nil
+}
@@ -0,0 +1,39 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// ForEachEndedProcess 按开始时间升序逐条读取车辆在 [from, to) 内开始的已结束充电记录 (附带地理围栏名称)，不在内存中缓存
+// from/to 为 nil 时不限制；fn 返回错误时停止读取并返回该错误
+func (r *ChargeRepository) ForEachEndedProcess(ctx context.Context, carID int64, from, to *time.Time, fn func(cp *models.ChargingProcess, geofenceName *string) error) error {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT `+chargingProcessColumns+`,
+			(SELECT name FROM geofences WHERE id = charging_processes.geofence_id)
+		FROM charging_processes
+		WHERE car_id = $1 AND end_time IS NOT NULL
+			AND ($2::timestamptz IS NULL OR start_time >= $2)
+			AND ($3::timestamptz IS NULL OR start_time < $3)
+		ORDER BY start_time ASC
+	`, carID, from, to)
+	if err != nil {
+		return fmt.Errorf("list charging processes for export: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		cp := &models.ChargingProcess{}
+		var geofenceName *string
+		if err := rows.Scan(append(chargingProcessFields(cp), &geofenceName)...); err != nil {
+			return fmt.Errorf("scan charging process: %w", err)
+		}
+		if err := fn(cp, geofenceName); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -2,18 +2,44 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 // DB 数据库连接池封装
 type DB struct {
-	Pool *pgxpool.Pool
+	Pool    *pgxpool.Pool
+	PostGIS bool // 是否已启用 PostGIS（空间查询走经纬度表达式的 GiST 索引）
+}
+
+// PoolConfig 连接池配置，MaxConns/MaxConnLifetime 为零时使用默认值
+type PoolConfig struct {
+	MaxConns        int            // 最大连接数 (默认 10)
+	MinConns        int            // 保持的最小连接数 (0 表示不保留空闲连接)
+	MaxConnLifetime time.Duration  // 连接最长存活时间，超过后关闭重建 (默认 1 小时)
+	Location        *time.Location // 会话时区，读取的时间也转换到该时区；为空时使用数据库默认时区和服务器本地时区
+}
+
+// PoolStats 连接池状态
+type PoolStats struct {
+	MaxConns             int32   `json:"max_conns"`
+	TotalConns           int32   `json:"total_conns"`            // 当前连接总数
+	AcquiredConns        int32   `json:"acquired_conns"`         // 正在使用的连接数
+	IdleConns            int32   `json:"idle_conns"`             // 空闲连接数
+	ConstructingConns    int32   `json:"constructing_conns"`     // 正在建立的连接数
+	AcquireCount         int64   `json:"acquire_count"`          // 累计获取连接次数
+	EmptyAcquireCount    int64   `json:"empty_acquire_count"`    // 累计因无空闲连接而等待的次数
+	CanceledAcquireCount int64   `json:"canceled_acquire_count"` // 累计等待连接时被取消的次数
+	AcquireWaitMs        float64 `json:"acquire_wait_ms"`        // 累计等待连接的时间 (毫秒)
 }
 
 // New 创建数据库连接
-func New(ctx context.Context, databaseURL string) (*DB, error) {
+func New(ctx context.Context, databaseURL string, poolCfg PoolConfig) (*DB, error) {
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse database url: %w", err)
@@ -21,7 +47,27 @@ func New(ctx context.Context, databaseURL string) (*DB, error) {
 
 	// 连接池配置
 	config.MaxConns = 10
-	config.MinConns = 2
+	if poolCfg.MaxConns > 0 {
+		config.MaxConns = int32(poolCfg.MaxConns)
+	}
+	config.MinConns = 0
+	if poolCfg.MinConns > 0 {
+		config.MinConns = int32(poolCfg.MinConns)
+	}
+	if config.MinConns > config.MaxConns {
+		config.MinConns = config.MaxConns
+	}
+	if poolCfg.MaxConnLifetime > 0 {
+		config.MaxConnLifetime = poolCfg.MaxConnLifetime
+	}
+	// 时间仍以 timestamptz (UTC) 存储，会话时区只影响 SQL 中的日期转换和截断
+	if loc := poolCfg.Location; loc != nil {
+		config.ConnConfig.RuntimeParams["timezone"] = loc.String()
+		config.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			registerLocation(conn, loc)
+			return nil
+		}
+	}
 
 	pool, err := pgxpool.NewWithConfig(ctx, config)
 	if err != nil {
@@ -36,11 +82,37 @@ func New(ctx context.Context, databaseURL string) (*DB, error) {
 	return &DB{Pool: pool}, nil
 }
 
+// IsDataError 判断错误是否由数据本身引起 (数据异常 22xxx 或违反约束 23xxx)
+// 这类错误重试也不会成功，其余错误 (连接断开、超时等) 视为暂时性错误
+func IsDataError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return len(pgErr.Code) >= 2 && (pgErr.Code[:2] == "22" || pgErr.Code[:2] == "23")
+}
+
 // Close 关闭连接池
 func (db *DB) Close() {
 	db.Pool.Close()
 }
 
+// Stats 获取连接池状态，用于调整连接池大小
+func (db *DB) Stats() PoolStats {
+	s := db.Pool.Stat()
+	return PoolStats{
+		MaxConns:             s.MaxConns(),
+		TotalConns:           s.TotalConns(),
+		AcquiredConns:        s.AcquiredConns(),
+		IdleConns:            s.IdleConns(),
+		ConstructingConns:    s.ConstructingConns(),
+		AcquireCount:         s.AcquireCount(),
+		EmptyAcquireCount:    s.EmptyAcquireCount(),
+		CanceledAcquireCount: s.CanceledAcquireCount(),
+		AcquireWaitMs:        float64(s.AcquireDuration().Microseconds()) / 1000,
+	}
+}
+
 // Migrate 执行数据库迁移
 func (db *DB) Migrate(ctx context.Context) error {
 	migrations := []string{
@@ -60,6 +132,30 @@ func (db *DB) Migrate(ctx context.Context) error {
 		migrationAddAddressToParkings,
 		migrationAddAddressToChargingProcesses,
 		migrationCreateParkingEvents,
+		migrationAddPreconditioningToParkings,
+		migrationAddChargeSettingsToChargingProcesses,
+		migrationCreateSettings,
+		migrationAddEnergyBreakdownToDrives,
+		migrationCreateAccounts,
+		migrationAddEnergyValidationToChargingProcesses,
+		migrationCreateTrips,
+		migrationAddChargerPhasesToCharges,
+		migrationAddOutsideTempSamplesToChargingProcesses,
+		migrationAddDisplayToCars,
+		migrationCreateDriveMatchedPaths,
+		migrationAddCarStartTimeIndexes,
+		migrationAddEndChargingStateToChargingProcesses,
+		migrationAddClosures,
+		migrationAddPositionsCarRecordedAtIndex,
+		migrationAddDriveAcceleration,
+		migrationAddParkingHeaterUsage,
+		migrationAddDrivePolyline,
+		migrationCreatePlugSessions,
+		migrationAddUnitsToCars,
+		migrationAddPrivacyToGeofences,
+		migrationAddBatteryHeaterToCharges,
+		migrationAddInterpolatedToPositions,
+		migrationCreateMaintenanceReminders,
 	}
 
 	for _, m := range migrations {
@@ -416,3 +512,216 @@ CREATE TABLE IF NOT EXISTS parking_events (
 CREATE INDEX IF NOT EXISTS idx_parking_events_parking_id ON parking_events(parking_id);
 CREATE INDEX IF NOT EXISTS idx_parking_events_event_time ON parking_events(event_time);
 `
+
+// 添加预热/预冷时长字段到 parkings 表（与手动空调使用分开统计）
+const migrationAddPreconditioningToParkings = `
+ALTER TABLE parkings ADD COLUMN IF NOT EXISTS preconditioning_used_min DOUBLE PRECISION;
+`
+
+// 添加充电上限和预约充电模式字段到 charging_processes 表
+const migrationAddChargeSettingsToChargingProcesses = `
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS charge_limit_soc INT;
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS scheduled_mode VARCHAR(20);
+`
+
+// 创建车辆设置表（按车覆盖全局配置）
+const migrationCreateSettings = `
+CREATE TABLE IF NOT EXISTS settings (
+    id BIGSERIAL PRIMARY KEY,
+    car_id BIGINT NOT NULL REFERENCES cars(id) ON DELETE CASCADE,
+    key VARCHAR(64) NOT NULL,
+    value TEXT NOT NULL,
+    UNIQUE (car_id, key)
+);
+`
+
+// 添加能耗拆分字段到 drives 表（电量变化估算的净耗电量、空调等附件耗电估算）
+const migrationAddEnergyBreakdownToDrives = `
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS energy_soc_kwh DOUBLE PRECISION;
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS energy_climate_kwh DOUBLE PRECISION;
+`
+
+// 创建 Tesla 账号表，车辆关联所属账号（多账号支持）
+// 升级前已有的车辆归属 default 账号
+const migrationCreateAccounts = `
+CREATE TABLE IF NOT EXISTS accounts (
+    id BIGSERIAL PRIMARY KEY,
+    label VARCHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS account_id BIGINT REFERENCES accounts(id) ON DELETE SET NULL;
+INSERT INTO accounts (label)
+SELECT 'default' WHERE EXISTS (SELECT 1 FROM cars WHERE account_id IS NULL)
+ON CONFLICT (label) DO NOTHING;
+UPDATE cars SET account_id = (SELECT id FROM accounts WHERE label = 'default') WHERE account_id IS NULL;
+`
+
+// 添加充电量校验字段到 charging_processes 表
+// charge_energy_added 为校验后的充电量，同时保存 Tesla 上报的原始值和按电量变化估算的值
+const migrationAddEnergyValidationToChargingProcesses = `
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS charge_energy_reported DOUBLE PRECISION;
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS charge_energy_estimated DOUBLE PRECISION;
+`
+
+// 创建旅程表（由中途充电串联的多段行程），drives.trip_id 关联所属旅程
+const migrationCreateTrips = `
+CREATE TABLE IF NOT EXISTS trips (
+    id BIGSERIAL PRIMARY KEY,
+    car_id BIGINT NOT NULL REFERENCES cars(id),
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_time TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_trips_car_id_start_time ON trips(car_id, start_time DESC);
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS trip_id BIGINT REFERENCES trips(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_drives_trip_id ON drives(trip_id);
+`
+
+// 添加交流充电相数和按相数计算的功率到 charges 表
+const migrationAddChargerPhasesToCharges = `
+ALTER TABLE charges ADD COLUMN IF NOT EXISTS charger_phases INT;
+ALTER TABLE charges ADD COLUMN IF NOT EXISTS ac_power_kw DOUBLE PRECISION;
+`
+
+// 添加车外温度加权平均的采样数和累计权重到 charging_processes 表
+// 采样数为 0 的充电 (升级前开始的) 从下一次采样重新计算平均值
+const migrationAddOutsideTempSamplesToChargingProcesses = `
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS outside_temp_samples INT NOT NULL DEFAULT 0;
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS outside_temp_weight_min DOUBLE PRECISION NOT NULL DEFAULT 0;
+`
+
+// 添加用户设置的显示颜色和图标到 cars 表，同步车辆信息时不覆盖
+const migrationAddDisplayToCars = `
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS display_color VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS icon VARCHAR(64) NOT NULL DEFAULT '';
+`
+
+// 创建纠偏轨迹表，保存行程吸附到路网后的路径 (原始位置点保留在 positions)
+const migrationCreateDriveMatchedPaths = `
+CREATE TABLE IF NOT EXISTS drive_matched_paths (
+    drive_id BIGINT PRIMARY KEY REFERENCES drives(id) ON DELETE CASCADE,
+    provider VARCHAR(16) NOT NULL,
+    path JSONB NOT NULL,
+    matched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+`
+
+// 添加 (car_id, start_time DESC) 复合索引，用于列表接口的游标分页 (start_time < after)
+const migrationAddCarStartTimeIndexes = `
+CREATE INDEX IF NOT EXISTS idx_drives_car_id_start_time ON drives(car_id, start_time DESC);
+CREATE INDEX IF NOT EXISTS idx_charging_processes_car_id_start_time ON charging_processes(car_id, start_time DESC);
+CREATE INDEX IF NOT EXISTS idx_parkings_car_id_start_time ON parkings(car_id, start_time DESC);
+`
+
+// 添加充电结束时的充电状态 (Complete / Stopped / Disconnected 等)，区分充满和中断
+const migrationAddEndChargingStateToChargingProcesses = `
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS end_charging_state VARCHAR(20);
+`
+
+// 添加车门、车窗详细状态 (各车门、车窗开度、天窗)，位置点只在有打开的车门/车窗时记录
+const migrationAddClosures = `
+ALTER TABLE positions ADD COLUMN IF NOT EXISTS closures JSONB;
+ALTER TABLE parkings ADD COLUMN IF NOT EXISTS start_closures JSONB;
+ALTER TABLE parkings ADD COLUMN IF NOT EXISTS end_closures JSONB;
+`
+
+// 按车辆和时间范围查询位置 (热力图) 使用的复合索引，也用于 TeslaMate 导入按 (car_id, recorded_at) 去重
+const migrationAddPositionsCarRecordedAtIndex = `
+CREATE INDEX IF NOT EXISTS idx_positions_car_id_recorded_at ON positions(car_id, recorded_at);
+`
+
+// 添加行程加减速分析字段 (最大加/减速度和急加速、急刹车次数)
+const migrationAddDriveAcceleration = `
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS accel_max_g DOUBLE PRECISION;
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS decel_max_g DOUBLE PRECISION;
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS harsh_accel_count INTEGER;
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS harsh_brake_count INTEGER;
+`
+
+// 添加停车期间座椅加热和除雾使用时长字段到 parkings 表
+const migrationAddParkingHeaterUsage = `
+ALTER TABLE parkings ADD COLUMN IF NOT EXISTS seat_heater_used_min DOUBLE PRECISION;
+ALTER TABLE parkings ADD COLUMN IF NOT EXISTS defroster_used_min DOUBLE PRECISION;
+`
+
+// 添加行程缩略轨迹字段 (行程结束时按位置点生成的 encoded polyline)
+const migrationAddDrivePolyline = `
+ALTER TABLE drives ADD COLUMN IF NOT EXISTS polyline TEXT;
+`
+
+// 创建插枪会话表及会话事件表 (插枪到拔枪，包含未充电的等待时段)
+const migrationCreatePlugSessions = `
+CREATE TABLE IF NOT EXISTS plug_sessions (
+    id BIGSERIAL PRIMARY KEY,
+    car_id BIGINT NOT NULL REFERENCES cars(id) ON DELETE CASCADE,
+    geofence_id BIGINT,
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_time TIMESTAMP WITH TIME ZONE,
+    duration_min DOUBLE PRECISION DEFAULT 0,
+    charging_min DOUBLE PRECISION DEFAULT 0,
+    start_battery_level INT,
+    end_battery_level INT,
+    scheduled_mode VARCHAR(20)
+);
+CREATE INDEX IF NOT EXISTS idx_plug_sessions_car_id_start_time ON plug_sessions(car_id, start_time);
+
+CREATE TABLE IF NOT EXISTS plug_session_events (
+    id BIGSERIAL PRIMARY KEY,
+    plug_session_id BIGINT NOT NULL REFERENCES plug_sessions(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    event_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    charging_state VARCHAR(32) NOT NULL DEFAULT '',
+    battery_level INT
+);
+CREATE INDEX IF NOT EXISTS idx_plug_session_events_session_id ON plug_session_events(plug_session_id);
+`
+
+// 添加车机设置的距离和温度单位到 cars 表 (从 gui_settings 同步)
+const migrationAddUnitsToCars = `
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS distance_unit VARCHAR(4) NOT NULL DEFAULT '';
+ALTER TABLE cars ADD COLUMN IF NOT EXISTS temperature_unit VARCHAR(4) NOT NULL DEFAULT '';
+`
+
+// 地理围栏增加隐私区域标记：区域内的坐标在 API 和 WebSocket 输出中替换为围栏中心
+const migrationAddPrivacyToGeofences = `
+ALTER TABLE geofences ADD COLUMN IF NOT EXISTS privacy BOOLEAN NOT NULL DEFAULT false;
+`
+
+// 充电记录增加电池加热时长，充电详情增加电池加热状态 (低温时部分充入电量用于加热电池)
+const migrationAddBatteryHeaterToCharges = `
+ALTER TABLE charging_processes ADD COLUMN IF NOT EXISTS battery_heater_min DOUBLE PRECISION NOT NULL DEFAULT 0;
+ALTER TABLE charges ADD COLUMN IF NOT EXISTS battery_heater BOOLEAN;
+`
+
+// 位置记录增加插值标记：推送数据缺少坐标时沿用最近的坐标记录
+const migrationAddInterpolatedToPositions = `
+ALTER TABLE positions ADD COLUMN IF NOT EXISTS interpolated BOOLEAN NOT NULL DEFAULT false;
+`
+
+// 创建保养提醒表 (按里程和/或天数间隔，notified_status 为已推送过告警的状态)
+const migrationCreateMaintenanceReminders = `
+CREATE TABLE IF NOT EXISTS maintenance_reminders (
+    id BIGSERIAL PRIMARY KEY,
+    car_id BIGINT NOT NULL REFERENCES cars(id) ON DELETE CASCADE,
+    type VARCHAR(64) NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    interval_km DOUBLE PRECISION,
+    interval_days INT,
+    last_done_km DOUBLE PRECISION,
+    last_done_at TIMESTAMP WITH TIME ZONE,
+    notified_status VARCHAR(16) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_maintenance_reminders_car_id ON maintenance_reminders(car_id);
+`
+
+// PostGIS 迁移：positions 按经纬度表达式建立 GiST 索引
+// 不增加生成列，避免大表重写并长时间持有排他锁；旧版本的 geog 列一并删除 (仅改元数据)
+// 查询需使用相同的表达式 ST_MakePoint(longitude, latitude)::geography 才能命中索引
+// 仅在 USE_POSTGIS 开启且扩展可用时执行
+const migrationAddGeographyToPositions = `
+ALTER TABLE positions DROP COLUMN IF EXISTS geog;
+CREATE INDEX IF NOT EXISTS idx_positions_geog ON positions USING GIST ((ST_MakePoint(longitude, latitude)::geography));
+`
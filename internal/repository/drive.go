@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/langchou/tesgazer/internal/models"
 )
 
@@ -64,11 +67,13 @@ func (r *DriveRepository) Complete(ctx context.Context, drive *models.Drive) err
 			end_odometer_km = $13,
 			energy_used_kwh = $14,
 			energy_regen_kwh = $15,
-			end_latitude = $16,
-			end_longitude = $17,
-			end_address = $18,
-			start_address = $19
-		WHERE id = $20
+			energy_soc_kwh = $16,
+			energy_climate_kwh = $17,
+			end_latitude = $18,
+			end_longitude = $19,
+			end_address = $20,
+			start_address = $21
+		WHERE id = $22
 	`
 	_, err := r.db.Pool.Exec(ctx, query,
 		drive.EndTime,
@@ -86,6 +91,8 @@ func (r *DriveRepository) Complete(ctx context.Context, drive *models.Drive) err
 		drive.EndOdometerKm,
 		drive.EnergyUsedKwh,
 		drive.EnergyRegenKwh,
+		drive.EnergySocKwh,
+		drive.EnergyClimateKwh,
 		drive.EndLatitude,
 		drive.EndLongitude,
 		drive.EndAddress,
@@ -98,13 +105,37 @@ func (r *DriveRepository) Complete(ctx context.Context, drive *models.Drive) err
 	return nil
 }
 
+// UpdateAcceleration 保存行程的加减速分析结果
+func (r *DriveRepository) UpdateAcceleration(ctx context.Context, drive *models.Drive) error {
+	_, err := r.db.Pool.Exec(ctx, `
+		UPDATE drives SET accel_max_g = $2, decel_max_g = $3, harsh_accel_count = $4, harsh_brake_count = $5
+		WHERE id = $1
+	`, drive.ID, drive.AccelMaxG, drive.DecelMaxG, drive.HarshAccelCount, drive.HarshBrakeCount)
+	if err != nil {
+		return fmt.Errorf("update drive acceleration: %w", err)
+	}
+	return nil
+}
+
+// UpdateDistance 保存行程距离和结束里程表读数
+func (r *DriveRepository) UpdateDistance(ctx context.Context, drive *models.Drive) error {
+	_, err := r.db.Pool.Exec(ctx, `
+		UPDATE drives SET distance_km = $2, end_odometer_km = $3 WHERE id = $1
+	`, drive.ID, drive.DistanceKm, drive.EndOdometerKm)
+	if err != nil {
+		return fmt.Errorf("update drive distance: %w", err)
+	}
+	return nil
+}
+
 // GetByID 获取行程
 func (r *DriveRepository) GetByID(ctx context.Context, id int64) (*models.Drive, error) {
 	query := `
 		SELECT id, car_id, start_time, end_time, start_position_id, end_position_id, start_geofence_id, end_geofence_id,
 			distance_km, duration_min, start_battery_level, end_battery_level, start_range_km, end_range_km,
 			start_odometer_km, end_odometer_km, speed_max, power_max, power_min, inside_temp_avg, outside_temp_avg,
-			energy_used_kwh, energy_regen_kwh,
+			energy_used_kwh, energy_regen_kwh, energy_soc_kwh, energy_climate_kwh,
+			accel_max_g, decel_max_g, harsh_accel_count, harsh_brake_count,
 			start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude
 		FROM drives WHERE id = $1
 	`
@@ -133,6 +164,12 @@ func (r *DriveRepository) GetByID(ctx context.Context, id int64) (*models.Drive,
 		&drive.OutsideTempAvg,
 		&drive.EnergyUsedKwh,
 		&drive.EnergyRegenKwh,
+		&drive.EnergySocKwh,
+		&drive.EnergyClimateKwh,
+		&drive.AccelMaxG,
+		&drive.DecelMaxG,
+		&drive.HarshAccelCount,
+		&drive.HarshBrakeCount,
 		&drive.StartAddress,
 		&drive.EndAddress,
 		&drive.StartLatitude,
@@ -143,20 +180,45 @@ func (r *DriveRepository) GetByID(ctx context.Context, id int64) (*models.Drive,
 	if err != nil {
 		return nil, fmt.Errorf("get drive by id: %w", err)
 	}
+	drive.IsActive = drive.EndTime == nil
 	return drive, nil
 }
 
-// ListByCarID 获取车辆的行程列表
-func (r *DriveRepository) ListByCarID(ctx context.Context, carID int64, limit, offset int) ([]*models.Drive, error) {
+// DriveFilter 行程列表的筛选条件，字段为 nil 时不筛选
+type DriveFilter struct {
+	StartGeofenceID *int64 // 起点所在地理围栏
+	EndGeofenceID   *int64 // 终点所在地理围栏
+}
+
+// where 追加参数并返回筛选条件 (" AND ...")
+func (f DriveFilter) where(args []interface{}) (string, []interface{}) {
+	var cond string
+	if f.StartGeofenceID != nil {
+		args = append(args, *f.StartGeofenceID)
+		cond += fmt.Sprintf(" AND start_geofence_id = $%d", len(args))
+	}
+	if f.EndGeofenceID != nil {
+		args = append(args, *f.EndGeofenceID)
+		cond += fmt.Sprintf(" AND end_geofence_id = $%d", len(args))
+	}
+	return cond, args
+}
+
+// ListByCarID 获取车辆符合筛选条件的行程列表，before 不为 nil 时只返回开始时间早于 before 的行程 (游标分页)
+func (r *DriveRepository) ListByCarID(ctx context.Context, carID int64, filter DriveFilter, before *time.Time, limit, offset int) ([]*models.Drive, error) {
+	cond, args := filter.where([]interface{}{carID, limit, offset})
+	beforeCond, args := beforeStartTime(before, args)
+	cond += beforeCond
 	query := `
 		SELECT id, car_id, start_time, end_time, start_position_id, end_position_id, start_geofence_id, end_geofence_id,
 			distance_km, duration_min, start_battery_level, end_battery_level, start_range_km, end_range_km,
 			start_odometer_km, end_odometer_km, speed_max, power_max, power_min, inside_temp_avg, outside_temp_avg,
-			energy_used_kwh, energy_regen_kwh,
+			energy_used_kwh, energy_regen_kwh, energy_soc_kwh, energy_climate_kwh,
+			accel_max_g, decel_max_g, harsh_accel_count, harsh_brake_count,
 			start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude
-		FROM drives WHERE car_id = $1 ORDER BY start_time DESC LIMIT $2 OFFSET $3
+		FROM drives WHERE car_id = $1` + cond + ` ORDER BY start_time DESC LIMIT $2 OFFSET $3
 	`
-	rows, err := r.db.Pool.Query(ctx, query, carID, limit, offset)
+	rows, err := r.db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list drives: %w", err)
 	}
@@ -189,6 +251,12 @@ func (r *DriveRepository) ListByCarID(ctx context.Context, carID int64, limit, o
 			&drive.OutsideTempAvg,
 			&drive.EnergyUsedKwh,
 			&drive.EnergyRegenKwh,
+			&drive.EnergySocKwh,
+			&drive.EnergyClimateKwh,
+			&drive.AccelMaxG,
+			&drive.DecelMaxG,
+			&drive.HarshAccelCount,
+			&drive.HarshBrakeCount,
 			&drive.StartAddress,
 			&drive.EndAddress,
 			&drive.StartLatitude,
@@ -199,16 +267,18 @@ func (r *DriveRepository) ListByCarID(ctx context.Context, carID int64, limit, o
 		if err != nil {
 			return nil, fmt.Errorf("scan drive: %w", err)
 		}
+		drive.IsActive = drive.EndTime == nil
 		drives = append(drives, drive)
 	}
 
 	return drives, nil
 }
 
-// CountByCarID 统计车辆行程数
-func (r *DriveRepository) CountByCarID(ctx context.Context, carID int64) (int64, error) {
+// CountByCarID 统计车辆符合筛选条件的行程数
+func (r *DriveRepository) CountByCarID(ctx context.Context, carID int64, filter DriveFilter) (int64, error) {
+	cond, args := filter.where([]interface{}{carID})
 	var count int64
-	err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM drives WHERE car_id = $1`, carID).Scan(&count)
+	err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM drives WHERE car_id = $1`+cond, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("count drives: %w", err)
 	}
@@ -216,12 +286,14 @@ func (r *DriveRepository) CountByCarID(ctx context.Context, carID int64) (int64,
 }
 
 // GetActiveDrive 获取进行中的行程
+// 没有进行中的行程时返回 nil
 func (r *DriveRepository) GetActiveDrive(ctx context.Context, carID int64) (*models.Drive, error) {
 	query := `
 		SELECT id, car_id, start_time, end_time, start_position_id, end_position_id, start_geofence_id, end_geofence_id,
 			distance_km, duration_min, start_battery_level, end_battery_level, start_range_km, end_range_km,
 			start_odometer_km, end_odometer_km, speed_max, power_max, power_min, inside_temp_avg, outside_temp_avg,
-			energy_used_kwh, energy_regen_kwh,
+			energy_used_kwh, energy_regen_kwh, energy_soc_kwh, energy_climate_kwh,
+			accel_max_g, decel_max_g, harsh_accel_count, harsh_brake_count,
 			start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude
 		FROM drives WHERE car_id = $1 AND end_time IS NULL ORDER BY start_time DESC LIMIT 1
 	`
@@ -250,6 +322,12 @@ func (r *DriveRepository) GetActiveDrive(ctx context.Context, carID int64) (*mod
 		&drive.OutsideTempAvg,
 		&drive.EnergyUsedKwh,
 		&drive.EnergyRegenKwh,
+		&drive.EnergySocKwh,
+		&drive.EnergyClimateKwh,
+		&drive.AccelMaxG,
+		&drive.DecelMaxG,
+		&drive.HarshAccelCount,
+		&drive.HarshBrakeCount,
 		&drive.StartAddress,
 		&drive.EndAddress,
 		&drive.StartLatitude,
@@ -257,21 +335,107 @@ func (r *DriveRepository) GetActiveDrive(ctx context.Context, carID int64) (*mod
 		&drive.EndLatitude,
 		&drive.EndLongitude,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, err // 可能是没有进行中的行程
+		return nil, fmt.Errorf("get active drive: %w", err)
 	}
+	drive.IsActive = true
 	return drive, nil
 }
 
-// GetStats 获取行程统计
-func (r *DriveRepository) GetStats(ctx context.Context, carID int64, since time.Time) (totalDistance float64, totalDuration float64, count int64, err error) {
+// GetStats 获取 [start, end) 内开始的已结束行程统计
+func (r *DriveRepository) GetStats(ctx context.Context, carID int64, start, end time.Time) (totalDistance float64, totalDuration float64, count int64, err error) {
 	query := `
 		SELECT COALESCE(SUM(distance_km), 0), COALESCE(SUM(duration_min), 0), COUNT(*)
-		FROM drives WHERE car_id = $1 AND start_time >= $2 AND end_time IS NOT NULL
+		FROM drives WHERE car_id = $1 AND start_time >= $2 AND start_time < $3 AND end_time IS NOT NULL
 	`
-	err = r.db.Pool.QueryRow(ctx, query, carID, since).Scan(&totalDistance, &totalDuration, &count)
+	err = r.db.Pool.QueryRow(ctx, query, carID, start, end).Scan(&totalDistance, &totalDuration, &count)
 	if err != nil {
 		err = fmt.Errorf("get drive stats: %w", err)
 	}
 	return
 }
+
+// GetLongestInRange 获取 [start, end) 内开始的里程最长的已结束行程，没有行程时返回 nil
+func (r *DriveRepository) GetLongestInRange(ctx context.Context, carID int64, start, end time.Time) (*models.Drive, error) {
+	var id int64
+	err := r.db.Pool.QueryRow(ctx, `
+		SELECT id FROM drives
+		WHERE car_id = $1 AND start_time >= $2 AND start_time < $3 AND end_time IS NOT NULL AND distance_km IS NOT NULL
+		ORDER BY distance_km DESC, start_time
+		LIMIT 1
+	`, carID, start, end).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get longest drive: %w", err)
+	}
+	return r.GetByID(ctx, id)
+}
+
+// MissingAddress 有坐标但缺少地址的记录 (用于逆地理编码补全)
+type MissingAddress struct {
+	ID        int64
+	Field     string // 行程为 start / end，充电和停车记录为空
+	Latitude  float64
+	Longitude float64
+}
+
+// ListMissingAddresses 获取 ID 大于 afterID、缺少起止地址的已结束行程
+// 按 ID 升序返回，调用方以最后一条的 ID 作为下一页的 afterID
+func (r *DriveRepository) ListMissingAddresses(ctx context.Context, afterID int64, limit int) ([]*MissingAddress, error) {
+	query := `
+		SELECT id, start_latitude, start_longitude, start_address IS NULL,
+			end_latitude, end_longitude, end_address IS NULL
+		FROM drives
+		WHERE id > $1 AND end_time IS NOT NULL AND (
+			(start_address IS NULL AND start_latitude IS NOT NULL AND start_longitude IS NOT NULL)
+			OR (end_address IS NULL AND end_latitude IS NOT NULL AND end_longitude IS NOT NULL)
+		)
+		ORDER BY id
+		LIMIT $2
+	`
+	rows, err := r.db.Pool.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list drives missing address: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*MissingAddress
+	for rows.Next() {
+		var id int64
+		var startLat, startLng, endLat, endLng *float64
+		var startMissing, endMissing bool
+		if err := rows.Scan(&id, &startLat, &startLng, &startMissing, &endLat, &endLng, &endMissing); err != nil {
+			return nil, fmt.Errorf("scan drive missing address: %w", err)
+		}
+		if startMissing && startLat != nil && startLng != nil {
+			items = append(items, &MissingAddress{ID: id, Field: "start", Latitude: *startLat, Longitude: *startLng})
+		}
+		if endMissing && endLat != nil && endLng != nil {
+			items = append(items, &MissingAddress{ID: id, Field: "end", Latitude: *endLat, Longitude: *endLng})
+		}
+	}
+
+	return items, nil
+}
+
+// UpdateAddress 补全行程的起始 (field=start) 或结束 (field=end) 地址，已有地址不会被覆盖
+func (r *DriveRepository) UpdateAddress(ctx context.Context, id int64, field string, address *models.Address) error {
+	var query string
+	switch field {
+	case "start":
+		query = `UPDATE drives SET start_address = COALESCE(start_address, $1) WHERE id = $2`
+	case "end":
+		query = `UPDATE drives SET end_address = COALESCE(end_address, $1) WHERE id = $2`
+	default:
+		return fmt.Errorf("unknown drive address field: %s", field)
+	}
+	if _, err := r.db.Pool.Exec(ctx, query, address, id); err != nil {
+		return fmt.Errorf("update drive address: %w", err)
+	}
+	return nil
+}
@@ -3,28 +3,72 @@ package repository
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
-	// For pq.Array
 	"github.com/langchou/tesgazer/internal/models"
 )
 
-// GetDrivePathsInRange 获取指定时间范围内的行程轨迹（精简版）
-func (r *DriveRepository) GetDrivePathsInRange(ctx context.Context, carID int64, start, end time.Time) ([]*models.DrivePath, error) {
+// FootprintOptions 足迹查询选项
+type FootprintOptions struct {
+	BBox          *models.BoundingBox // 不为空时只返回轨迹经过该范围的行程
+	MinDistanceKm float64             // 只返回行驶距离不小于该值的行程 (排除短途)
+	Ascending     bool                // 按开始时间升序 (用于动画回放)，默认倒序
+	Limit         int                 // 最多返回的行程数，0 表示不限制
+	Offset        int
+	Downsample    int // 每条轨迹每 N 个点保留一个 (保留终点)，不超过 1 时返回全部点
+}
+
+// GetDrivePathsInRange 获取指定时间范围内的行程轨迹（精简版），同时返回符合条件的行程总数
+func (r *DriveRepository) GetDrivePathsInRange(ctx context.Context, carID int64, start, end time.Time, opts FootprintOptions) ([]*models.DrivePath, int64, error) {
 	// 1. 获取范围内的行程基本信息
+	where := `car_id = $1 AND start_time >= $2 AND start_time <= $3`
+	args := []interface{}{carID, start, end}
+
+	if opts.BBox != nil {
+		// PostGIS 可用时走 GiST 索引，否则回退到经纬度范围比较
+		inBox := `p.latitude BETWEEN $5 AND $7 AND p.longitude BETWEEN $4 AND $6`
+		if r.db.PostGIS {
+			inBox = `ST_Intersects(ST_MakePoint(p.longitude, p.latitude)::geography, ST_MakeEnvelope($4, $5, $6, $7, 4326)::geography)`
+		}
+		where += ` AND EXISTS (SELECT 1 FROM positions p WHERE p.drive_id = d.id AND ` + inBox + `)`
+		args = append(args, opts.BBox.MinLng, opts.BBox.MinLat, opts.BBox.MaxLng, opts.BBox.MaxLat)
+	}
+	if opts.MinDistanceKm > 0 {
+		args = append(args, opts.MinDistanceKm)
+		where += ` AND distance_km >= $` + strconv.Itoa(len(args))
+	}
+
+	var total int64
+	if err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM drives d WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("count drives in range: %w", err)
+	}
+
+	order := "DESC"
+	if opts.Ascending {
+		order = "ASC"
+	}
 	drivesQuery := `
-		SELECT id, start_time, duration_min, distance_km 
-		FROM drives 
-		WHERE car_id = $1 AND start_time >= $2 AND start_time <= $3 
-		ORDER BY start_time DESC
-	`
-	rows, err := r.db.Pool.Query(ctx, drivesQuery, carID, start, end)
+		SELECT id, start_time, duration_min, distance_km
+		FROM drives d
+		WHERE ` + where + `
+		ORDER BY start_time ` + order + `, id ` + order
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		drivesQuery += ` LIMIT $` + strconv.Itoa(len(args))
+	}
+	if opts.Offset > 0 {
+		args = append(args, opts.Offset)
+		drivesQuery += ` OFFSET $` + strconv.Itoa(len(args))
+	}
+
+	rows, err := r.db.Pool.Query(ctx, drivesQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("list drives in range: %w", err)
+		return nil, 0, fmt.Errorf("list drives in range: %w", err)
 	}
 	defer rows.Close()
 
-	var drives []*models.DrivePath
+	drives := []*models.DrivePath{}
 	var driveIDs []int64
 	driveMap := make(map[int64]*models.DrivePath)
 
@@ -33,45 +77,40 @@ func (r *DriveRepository) GetDrivePathsInRange(ctx context.Context, carID int64,
 			Path: [][2]float64{},
 		}
 		if err := rows.Scan(&d.ID, &d.StartTime, &d.DurationMin, &d.DistanceKm); err != nil {
-			return nil, fmt.Errorf("scan drive: %w", err)
+			return nil, 0, fmt.Errorf("scan drive: %w", err)
 		}
 		drives = append(drives, d)
 		driveIDs = append(driveIDs, d.ID)
 		driveMap[d.ID] = d
 	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("list drives in range: %w", err)
+	}
 
 	if len(driveIDs) == 0 {
-		return drives, nil
+		return drives, total, nil
 	}
 
-	// 2. 批量获取位置点 (Downsampling: id % 10 for 1/10th data)
-	// 注意：pq.Array 需要 lib/pq，但如果项目使用 pgx，可能需要转换。
-	// 假设项目原本使用 database/sql + lib/pq 或者是 pgx pool。
-	// 在 repository/drive.go 中看到 r.db.Pool.Query，这通常是 pgx/v4 binding。
-	// pgx 支持 ANY($1) 语法。
-
+	// 2. 批量获取位置点，按抽样间隔保留每条轨迹的第 1、N+1、2N+1... 个点和终点
+	step := opts.Downsample
+	if step < 1 {
+		step = 1
+	}
 	posQuery := `
-		SELECT drive_id, latitude, longitude 
-		FROM positions 
-		WHERE drive_id = ANY($1) 
-		AND (id % 10 = 0 OR speed < 5) -- 简单采样：保留1/10的点，或者低速点(转弯/停车可能需要)
-		ORDER BY drive_id, id
-	`
-	// Wait, speed < 5 might indicate stop, but we want path shape.
-	// id % 10 is safest simple heuristic.
-	// Let's stick to id % 10.
-
-	posQuery = `
-		SELECT drive_id, latitude, longitude 
-		FROM positions 
-		WHERE drive_id = ANY($1) 
-
+		SELECT drive_id, latitude, longitude FROM (
+			SELECT drive_id, latitude, longitude, id,
+				ROW_NUMBER() OVER (PARTITION BY drive_id ORDER BY id) AS rn,
+				COUNT(*) OVER (PARTITION BY drive_id) AS cnt
+			FROM positions
+			WHERE drive_id = ANY($1)
+		) p
+		WHERE (rn - 1) % $2 = 0 OR rn = cnt
 		ORDER BY drive_id, id
 	`
 
-	pRows, err := r.db.Pool.Query(ctx, posQuery, driveIDs) // pgx expects slice directly for ANY
+	pRows, err := r.db.Pool.Query(ctx, posQuery, driveIDs, step) // pgx expects slice directly for ANY
 	if err != nil {
-		return nil, fmt.Errorf("list combined positions: %w", err)
+		return nil, 0, fmt.Errorf("list combined positions: %w", err)
 	}
 	defer pRows.Close()
 
@@ -86,5 +125,5 @@ func (r *DriveRepository) GetDrivePathsInRange(ctx context.Context, carID int64,
 		}
 	}
 
-	return drives, nil
+	return drives, total, nil
 }
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// GetMatchedPath 获取行程的纠偏轨迹，未纠偏时返回 nil
+func (r *DriveRepository) GetMatchedPath(ctx context.Context, driveID int64) (*models.MatchedPath, error) {
+	mp := &models.MatchedPath{DriveID: driveID, Matched: true}
+	err := r.db.Pool.QueryRow(ctx, `
+		SELECT provider, matched_at, path FROM drive_matched_paths WHERE drive_id = $1
+	`, driveID).Scan(&mp.Provider, &mp.MatchedAt, &mp.Path)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get matched path: %w", err)
+	}
+	return mp, nil
+}
+
+// SaveMatchedPath 保存行程的纠偏轨迹，已存在时覆盖
+func (r *DriveRepository) SaveMatchedPath(ctx context.Context, driveID int64, provider string, path [][2]float64) error {
+	_, err := r.db.Pool.Exec(ctx, `
+		INSERT INTO drive_matched_paths (drive_id, provider, path, matched_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (drive_id) DO UPDATE SET
+			provider = EXCLUDED.provider,
+			path = EXCLUDED.path,
+			matched_at = EXCLUDED.matched_at
+	`, driveID, provider, path)
+	if err != nil {
+		return fmt.Errorf("save matched path: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// GetPolylines 批量获取行程的缩略轨迹 (drive_id -> polyline)，尚未生成的行程不在结果中
+func (r *DriveRepository) GetPolylines(ctx context.Context, driveIDs []int64) (map[int64]string, error) {
+	polylines := make(map[int64]string, len(driveIDs))
+	if len(driveIDs) == 0 {
+		return polylines, nil
+	}
+
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT id, polyline FROM drives WHERE id = ANY($1) AND polyline IS NOT NULL
+	`, driveIDs)
+	if err != nil {
+		return nil, fmt.Errorf("get drive polylines: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var polyline string
+		if err := rows.Scan(&id, &polyline); err != nil {
+			return nil, fmt.Errorf("scan drive polyline: %w", err)
+		}
+		polylines[id] = polyline
+	}
+	return polylines, rows.Err()
+}
+
+// UpdatePolyline 保存行程的缩略轨迹 (没有位置点的行程保存为空字符串，避免重复生成)
+func (r *DriveRepository) UpdatePolyline(ctx context.Context, driveID int64, polyline string) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE drives SET polyline = $2 WHERE id = $1`, driveID, polyline)
+	if err != nil {
+		return fmt.Errorf("update drive polyline: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// Recompute 按位置点重新计算已结束行程的统计数据
+// 起止里程表缺失时取行程首尾有效位置点的里程表，随后重新计算时长、距离、速度、功率、温度和能耗。
+// 返回 false 表示行程不存在或尚未结束
+func (r *DriveRepository) Recompute(ctx context.Context, driveID int64, batteryCapacityKwh float64) (bool, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("begin recompute drive: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// 锁定行程，避免与拆分、地址补全互相覆盖
+	var ended bool
+	err = tx.QueryRow(ctx, `SELECT end_time IS NOT NULL FROM drives WHERE id = $1 FOR UPDATE`, driveID).Scan(&ended)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("lock drive: %w", err)
+	}
+	if !ended {
+		return false, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE drives SET
+			start_odometer_km = COALESCE(NULLIF(start_odometer_km, 0), (
+				SELECT odometer FROM positions
+				WHERE drive_id = $1 AND odometer > 0 ORDER BY recorded_at ASC LIMIT 1
+			), start_odometer_km),
+			end_odometer_km = COALESCE(NULLIF(end_odometer_km, 0), (
+				SELECT odometer FROM positions
+				WHERE drive_id = $1 AND odometer > 0 ORDER BY recorded_at DESC LIMIT 1
+			))
+		WHERE id = $1
+	`, driveID)
+	if err != nil {
+		return false, fmt.Errorf("fill drive odometer: %w", err)
+	}
+
+	if err := updateDriveStats(ctx, tx, driveID, batteryCapacityKwh); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("commit recompute drive: %w", err)
+	}
+	return true, nil
+}
+
+// ReplaceAddress 覆盖行程的起始 (field=start) 或结束 (field=end) 地址
+func (r *DriveRepository) ReplaceAddress(ctx context.Context, id int64, field string, address *models.Address) error {
+	var query string
+	switch field {
+	case "start":
+		query = `UPDATE drives SET start_address = $1 WHERE id = $2`
+	case "end":
+		query = `UPDATE drives SET end_address = $1 WHERE id = $2`
+	default:
+		return fmt.Errorf("unknown drive address field: %s", field)
+	}
+	if _, err := r.db.Pool.Exec(ctx, query, address, id); err != nil {
+		return fmt.Errorf("replace drive address: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Split 在拆分点将已结束的行程拆分为两段
+// 拆分点及之前的位置点保留在原行程，之后的归入新行程；原行程在拆分点结束，新行程从下一个位置点开始并沿用原行程的终点。
+// 拆分处的地址和地理围栏留空，两段的时长、距离和统计数据按各自的位置点重新计算，原有的纠偏轨迹被删除。
+// positionID 为 0 时取 at 及之前的最后一个位置点作为拆分点。
+// 返回新行程 ID；行程未结束、拆分点不在行程内或拆分后某一段没有位置点时返回 0
+func (r *DriveRepository) Split(ctx context.Context, driveID, positionID int64, at time.Time, batteryCapacityKwh float64) (int64, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin split drive: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// 锁定行程，避免与地址补全、并发拆分互相覆盖
+	var ended bool
+	err = tx.QueryRow(ctx, `SELECT end_time IS NOT NULL FROM drives WHERE id = $1 FOR UPDATE`, driveID).Scan(&ended)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("lock drive: %w", err)
+	}
+	if !ended {
+		return 0, nil
+	}
+
+	// 拆分点 (前半段的最后一个位置点)
+	var splitID int64
+	var splitAt time.Time
+	if positionID > 0 {
+		err = tx.QueryRow(ctx, `
+			SELECT id, recorded_at FROM positions WHERE id = $1 AND drive_id = $2
+		`, positionID, driveID).Scan(&splitID, &splitAt)
+	} else {
+		err = tx.QueryRow(ctx, `
+			SELECT id, recorded_at FROM positions WHERE drive_id = $1 AND recorded_at <= $2
+			ORDER BY recorded_at DESC, id DESC LIMIT 1
+		`, driveID, at).Scan(&splitID, &splitAt)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get split position: %w", err)
+	}
+
+	// 后半段的第一个位置点作为新行程的起点
+	var newID int64
+	err = tx.QueryRow(ctx, `
+		INSERT INTO drives (car_id, trip_id, start_time, start_position_id, start_battery_level, start_range_km, start_odometer_km,
+			start_latitude, start_longitude,
+			end_time, end_position_id, end_geofence_id, end_battery_level, end_range_km, end_odometer_km,
+			end_latitude, end_longitude, end_address)
+		SELECT d.car_id, d.trip_id, p.recorded_at, p.id, p.battery_level, p.range_km, p.odometer,
+			p.latitude, p.longitude,
+			d.end_time, d.end_position_id, d.end_geofence_id, d.end_battery_level, d.end_range_km, d.end_odometer_km,
+			d.end_latitude, d.end_longitude, d.end_address
+		FROM drives d
+		JOIN LATERAL (
+			SELECT * FROM positions
+			WHERE drive_id = d.id AND (recorded_at, id) > ($2, $3)
+			ORDER BY recorded_at, id LIMIT 1
+		) p ON true
+		WHERE d.id = $1
+		RETURNING id
+	`, driveID, splitAt, splitID).Scan(&newID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// 拆分点之后没有位置点
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("insert split drive: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE positions SET drive_id = $1 WHERE drive_id = $2 AND (recorded_at, id) > ($3, $4)
+	`, newID, driveID, splitAt, splitID)
+	if err != nil {
+		return 0, fmt.Errorf("move positions to split drive: %w", err)
+	}
+
+	// 原行程的纠偏轨迹已失效，两段在下次请求时重新纠偏
+	if _, err := tx.Exec(ctx, `DELETE FROM drive_matched_paths WHERE drive_id = $1`, driveID); err != nil {
+		return 0, fmt.Errorf("delete matched path: %w", err)
+	}
+
+	// 原行程在拆分点结束
+	_, err = tx.Exec(ctx, `
+		UPDATE drives SET
+			end_time = p.recorded_at,
+			end_position_id = p.id,
+			end_geofence_id = NULL,
+			end_battery_level = NULLIF(p.battery_level, 0),
+			end_range_km = p.range_km,
+			end_odometer_km = NULLIF(p.odometer, 0),
+			end_latitude = p.latitude,
+			end_longitude = p.longitude,
+			end_address = NULL
+		FROM positions p
+		WHERE drives.id = $1 AND p.id = $2
+	`, driveID, splitID)
+	if err != nil {
+		return 0, fmt.Errorf("end drive at split point: %w", err)
+	}
+
+	for _, id := range []int64{driveID, newID} {
+		if err := updateDriveStats(ctx, tx, id, batteryCapacityKwh); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit split drive: %w", err)
+	}
+	return newID, nil
+}
+
+// updateDriveStats 按行程的起止时间、里程表和位置点重新计算时长、距离和统计数据
+// 距离只按里程表计算，读数异常时由 service 层改用轨迹距离
+func updateDriveStats(ctx context.Context, tx pgx.Tx, driveID int64, batteryCapacityKwh float64) error {
+	stats, err := queryDriveStats(ctx, tx, driveID, batteryCapacityKwh)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE drives SET
+			duration_min = EXTRACT(EPOCH FROM (end_time - start_time)) / 60,
+			distance_km = CASE WHEN start_odometer_km > 0 AND end_odometer_km > start_odometer_km
+				THEN end_odometer_km - start_odometer_km ELSE 0 END,
+			speed_max = $2,
+			power_max = $3,
+			power_min = $4,
+			inside_temp_avg = $5,
+			outside_temp_avg = $6,
+			energy_used_kwh = $7,
+			energy_regen_kwh = $8,
+			energy_soc_kwh = $9,
+			energy_climate_kwh = $10
+		WHERE id = $1
+	`, driveID, stats.SpeedMax, stats.PowerMax, stats.PowerMin, stats.InsideTempAvg, stats.OutsideTempAvg,
+		stats.EnergyUsedKwh, stats.EnergyRegenKwh, stats.EnergySocKwh, stats.EnergyClimateKwh)
+	if err != nil {
+		return fmt.Errorf("update drive stats: %w", err)
+	}
+	return nil
+}
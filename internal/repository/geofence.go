@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// GeofenceRepository 地理围栏仓库
+type GeofenceRepository struct {
+	db *DB
+}
+
+// NewGeofenceRepository 创建地理围栏仓库
+func NewGeofenceRepository(db *DB) *GeofenceRepository {
+	return &GeofenceRepository{db: db}
+}
+
+// GetByID 通过 ID 获取地理围栏，不存在时返回 nil
+func (r *GeofenceRepository) GetByID(ctx context.Context, id int64) (*models.Geofence, error) {
+	g := &models.Geofence{}
+	err := r.db.Pool.QueryRow(ctx, `
+		SELECT id, name, latitude, longitude, radius, privacy FROM geofences WHERE id = $1
+	`, id).Scan(&g.ID, &g.Name, &g.Latitude, &g.Longitude, &g.Radius, &g.Privacy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get geofence: %w", err)
+	}
+	return g, nil
+}
+
+// Create 创建地理围栏
+func (r *GeofenceRepository) Create(ctx context.Context, g *models.Geofence) error {
+	err := r.db.Pool.QueryRow(ctx, `
+		INSERT INTO geofences (name, latitude, longitude, radius, privacy) VALUES ($1, $2, $3, $4, $5) RETURNING id
+	`, g.Name, g.Latitude, g.Longitude, g.Radius, g.Privacy).Scan(&g.ID)
+	if err != nil {
+		return fmt.Errorf("create geofence: %w", err)
+	}
+	return nil
+}
+
+// SetPrivacy 设置地理围栏是否为隐私区域，围栏不存在时返回 false
+func (r *GeofenceRepository) SetPrivacy(ctx context.Context, id int64, privacy bool) (bool, error) {
+	tag, err := r.db.Pool.Exec(ctx, `UPDATE geofences SET privacy = $2 WHERE id = $1`, id, privacy)
+	if err != nil {
+		return false, fmt.Errorf("set geofence privacy: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListPrivacyZones 获取标记为隐私区域的地理围栏
+func (r *GeofenceRepository) ListPrivacyZones(ctx context.Context) ([]*models.Geofence, error) {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT id, name, latitude, longitude, radius, privacy FROM geofences WHERE privacy ORDER BY id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("list privacy zones: %w", err)
+	}
+	defer rows.Close()
+
+	var zones []*models.Geofence
+	for rows.Next() {
+		g := &models.Geofence{}
+		if err := rows.Scan(&g.ID, &g.Name, &g.Latitude, &g.Longitude, &g.Radius, &g.Privacy); err != nil {
+			return nil, fmt.Errorf("scan privacy zone: %w", err)
+		}
+		zones = append(zones, g)
+	}
+	return zones, rows.Err()
+}
+
+// haversineSQL 坐标列 (lat, lng) 到 $2/$3 (围栏中心) 的 Haversine 距离 (米)
+func haversineSQL(lat, lng string) string {
+	return `2 * 6371000 * asin(sqrt(
+		power(sin(radians(` + lat + ` - $2) / 2), 2) +
+		cos(radians($2)) * cos(radians(` + lat + `)) * power(sin(radians(` + lng + ` - $3) / 2), 2)
+	))`
+}
+
+// AssignExisting 将位于围栏内、尚未关联地理围栏的停车、充电和行程起终点关联到该围栏
+func (r *GeofenceRepository) AssignExisting(ctx context.Context, g *models.Geofence) (*models.GeofenceAssignResult, error) {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin assign geofence: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	args := []interface{}{g.ID, g.Latitude, g.Longitude, g.Radius}
+	result := &models.GeofenceAssignResult{}
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE parkings SET geofence_id = $1
+		WHERE geofence_id IS NULL AND `+haversineSQL("latitude", "longitude")+` <= $4
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("assign geofence to parkings: %w", err)
+	}
+	result.Parkings = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		UPDATE charging_processes cp SET geofence_id = $1
+		FROM positions p
+		WHERE p.id = cp.position_id AND cp.geofence_id IS NULL
+			AND `+haversineSQL("p.latitude", "p.longitude")+` <= $4
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("assign geofence to charging processes: %w", err)
+	}
+	result.Charges = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		UPDATE drives SET start_geofence_id = $1
+		WHERE start_geofence_id IS NULL AND start_latitude IS NOT NULL
+			AND `+haversineSQL("start_latitude", "start_longitude")+` <= $4
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("assign geofence to drive starts: %w", err)
+	}
+	result.Drives = tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `
+		UPDATE drives SET end_geofence_id = $1
+		WHERE end_geofence_id IS NULL AND end_latitude IS NOT NULL
+			AND `+haversineSQL("end_latitude", "end_longitude")+` <= $4
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("assign geofence to drive ends: %w", err)
+	}
+	result.Drives += tag.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("commit assign geofence: %w", err)
+	}
+	return result, nil
+}
+
+// FindContaining 查找包含指定坐标的地理围栏，多个命中时返回距离中心最近的一个
+// 未命中返回 nil
+func (r *GeofenceRepository) FindContaining(ctx context.Context, lat, lng float64) (*models.Geofence, error) {
+	var query string
+	if r.db.PostGIS {
+		query = `
+			SELECT id, name, latitude, longitude, radius, privacy
+			FROM geofences
+			WHERE ST_DWithin(ST_MakePoint(longitude, latitude)::geography, ST_MakePoint($2, $1)::geography, radius)
+			ORDER BY ST_Distance(ST_MakePoint(longitude, latitude)::geography, ST_MakePoint($2, $1)::geography)
+			LIMIT 1
+		`
+	} else {
+		// Haversine 距离 (米)
+		query = `
+			SELECT id, name, latitude, longitude, radius, privacy
+			FROM (
+				SELECT id, name, latitude, longitude, radius, privacy,
+					2 * 6371000 * asin(sqrt(
+						power(sin(radians(latitude - $1) / 2), 2) +
+						cos(radians($1)) * cos(radians(latitude)) * power(sin(radians(longitude - $2) / 2), 2)
+					)) AS distance
+				FROM geofences
+			) g
+			WHERE distance <= radius
+			ORDER BY distance
+			LIMIT 1
+		`
+	}
+
+	g := &models.Geofence{}
+	err := r.db.Pool.QueryRow(ctx, query, lat, lng).Scan(&g.ID, &g.Name, &g.Latitude, &g.Longitude, &g.Radius, &g.Privacy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find geofence: %w", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// MaintenanceRepository 保养提醒仓库
+type MaintenanceRepository struct {
+	db *DB
+}
+
+// NewMaintenanceRepository 创建保养提醒仓库
+func NewMaintenanceRepository(db *DB) *MaintenanceRepository {
+	return &MaintenanceRepository{db: db}
+}
+
+// maintenanceColumns 保养提醒查询的列，与 scanMaintenanceReminder 的扫描顺序一致
+const maintenanceColumns = `id, car_id, type, note, interval_km, interval_days, last_done_km, last_done_at,
+			notified_status, created_at, updated_at`
+
+// scanMaintenanceReminder 扫描一行保养提醒
+func scanMaintenanceReminder(row pgx.Row) (*models.MaintenanceReminder, error) {
+	m := &models.MaintenanceReminder{}
+	err := row.Scan(
+		&m.ID,
+		&m.CarID,
+		&m.Type,
+		&m.Note,
+		&m.IntervalKm,
+		&m.IntervalDays,
+		&m.LastDoneKm,
+		&m.LastDoneAt,
+		&m.NotifiedStatus,
+		&m.CreatedAt,
+		&m.UpdatedAt,
+	)
+	return m, err
+}
+
+// Create 创建保养提醒
+func (r *MaintenanceRepository) Create(ctx context.Context, m *models.MaintenanceReminder) error {
+	err := r.db.Pool.QueryRow(ctx, `
+		INSERT INTO maintenance_reminders (car_id, type, note, interval_km, interval_days, last_done_km, last_done_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at
+	`, m.CarID, m.Type, m.Note, m.IntervalKm, m.IntervalDays, m.LastDoneKm, m.LastDoneAt).Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("create maintenance reminder: %w", err)
+	}
+	return nil
+}
+
+// GetByID 获取保养提醒，不存在时返回 nil
+func (r *MaintenanceRepository) GetByID(ctx context.Context, id int64) (*models.MaintenanceReminder, error) {
+	m, err := scanMaintenanceReminder(r.db.Pool.QueryRow(ctx, `
+		SELECT `+maintenanceColumns+` FROM maintenance_reminders WHERE id = $1
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get maintenance reminder: %w", err)
+	}
+	return m, nil
+}
+
+// ListByCarID 获取车辆的保养提醒
+func (r *MaintenanceRepository) ListByCarID(ctx context.Context, carID int64) ([]*models.MaintenanceReminder, error) {
+	return r.list(ctx, `WHERE car_id = $1 ORDER BY id`, carID)
+}
+
+// ListAll 获取所有车辆的保养提醒 (定期检查使用)
+func (r *MaintenanceRepository) ListAll(ctx context.Context) ([]*models.MaintenanceReminder, error) {
+	return r.list(ctx, `ORDER BY car_id, id`)
+}
+
+// list 按条件查询保养提醒
+func (r *MaintenanceRepository) list(ctx context.Context, cond string, args ...interface{}) ([]*models.MaintenanceReminder, error) {
+	rows, err := r.db.Pool.Query(ctx, `SELECT `+maintenanceColumns+` FROM maintenance_reminders `+cond, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list maintenance reminders: %w", err)
+	}
+	defer rows.Close()
+
+	var reminders []*models.MaintenanceReminder
+	for rows.Next() {
+		m, err := scanMaintenanceReminder(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan maintenance reminder: %w", err)
+		}
+		reminders = append(reminders, m)
+	}
+	return reminders, rows.Err()
+}
+
+// Update 更新保养提醒的项目、间隔和上次保养记录，同时清空已告警状态，不存在时返回 false
+func (r *MaintenanceRepository) Update(ctx context.Context, m *models.MaintenanceReminder) (bool, error) {
+	err := r.db.Pool.QueryRow(ctx, `
+		UPDATE maintenance_reminders
+		SET type = $2, note = $3, interval_km = $4, interval_days = $5, last_done_km = $6, last_done_at = $7,
+			notified_status = '', updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`, m.ID, m.Type, m.Note, m.IntervalKm, m.IntervalDays, m.LastDoneKm, m.LastDoneAt).Scan(&m.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("update maintenance reminder: %w", err)
+	}
+	m.NotifiedStatus = ""
+	return true, nil
+}
+
+// SetNotifiedStatus 记录已推送过告警的状态
+func (r *MaintenanceRepository) SetNotifiedStatus(ctx context.Context, id int64, status models.MaintenanceStatus) error {
+	_, err := r.db.Pool.Exec(ctx, `UPDATE maintenance_reminders SET notified_status = $2 WHERE id = $1`, id, status)
+	if err != nil {
+		return fmt.Errorf("set maintenance notified status: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除保养提醒，不存在时返回 false
+func (r *MaintenanceRepository) Delete(ctx context.Context, id int64) (bool, error) {
+	tag, err := r.db.Pool.Exec(ctx, `DELETE FROM maintenance_reminders WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete maintenance reminder: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"fmt"
+	"time"
+)
+
+// beforeStartTime 游标分页条件
+// before 不为 nil 时追加参数并返回 " AND start_time < $n"，配合 (car_id, start_time DESC) 索引代替大 OFFSET
+func beforeStartTime(before *time.Time, args []interface{}) (string, []interface{}) {
+	if before == nil {
+		return "", args
+	}
+	args = append(args, *before)
+	return fmt.Sprintf(" AND start_time < $%d", len(args)), args
+}
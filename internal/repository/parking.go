@@ -2,9 +2,12 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/langchou/tesgazer/internal/models"
 )
 
@@ -28,9 +31,9 @@ func (r *ParkingRepository) Create(ctx context.Context, parking *models.Parking)
 			start_locked, start_sentry_mode, start_doors_open, start_windows_open,
 			start_frunk_open, start_trunk_open, start_is_climate_on, start_is_user_present,
 			start_tpms_pressure_fl, start_tpms_pressure_fr, start_tpms_pressure_rl, start_tpms_pressure_rr,
-			car_version, address
+			car_version, address, start_closures
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23, $24, $25, $26)
 		RETURNING id
 	`
 	err := r.db.Pool.QueryRow(ctx, query,
@@ -59,6 +62,7 @@ func (r *ParkingRepository) Create(ctx context.Context, parking *models.Parking)
 		parking.StartTpmsPressureRR,
 		parking.CarVersion,
 		parking.Address,
+		parking.StartClosures,
 	).Scan(&parking.ID)
 
 	if err != nil {
@@ -94,8 +98,13 @@ func (r *ParkingRepository) Complete(ctx context.Context, parking *models.Parkin
 			end_tpms_pressure_fl = $21,
 			end_tpms_pressure_fr = $22,
 			end_tpms_pressure_rl = $23,
-			end_tpms_pressure_rr = $24
-		WHERE id = $25
+			end_tpms_pressure_rr = $24,
+			preconditioning_used_min = $25,
+			car_version = COALESCE(NULLIF($26, ''), car_version),
+			end_closures = COALESCE($28, end_closures),
+			seat_heater_used_min = $29,
+			defroster_used_min = $30
+		WHERE id = $27
 	`
 	_, err := r.db.Pool.Exec(ctx, query,
 		parking.EndTime,
@@ -122,7 +131,12 @@ func (r *ParkingRepository) Complete(ctx context.Context, parking *models.Parkin
 		parking.EndTpmsPressureFR,
 		parking.EndTpmsPressureRL,
 		parking.EndTpmsPressureRR,
+		parking.PreconditioningUsedMin,
+		parking.CarVersion,
 		parking.ID,
+		parking.EndClosures,
+		parking.SeatHeaterUsedMin,
+		parking.DefrosterUsedMin,
 	)
 	if err != nil {
 		return fmt.Errorf("complete parking: %w", err)
@@ -147,7 +161,11 @@ func (r *ParkingRepository) UpdateSnapshot(ctx context.Context, parking *models.
 			end_trunk_open = $12,
 			end_is_climate_on = $13,
 			climate_used_min = $14,
-			sentry_mode_used_min = $15
+			sentry_mode_used_min = $15,
+			preconditioning_used_min = $16,
+			end_closures = COALESCE($17, end_closures),
+			seat_heater_used_min = $18,
+			defroster_used_min = $19
 		WHERE id = $1 AND end_time IS NULL
 	`
 	_, err := r.db.Pool.Exec(ctx, query,
@@ -166,6 +184,10 @@ func (r *ParkingRepository) UpdateSnapshot(ctx context.Context, parking *models.
 		parking.EndIsClimateOn,
 		parking.ClimateUsedMin,
 		parking.SentryModeUsedMin,
+		parking.PreconditioningUsedMin,
+		parking.EndClosures,
+		parking.SeatHeaterUsedMin,
+		parking.DefrosterUsedMin,
 	)
 	if err != nil {
 		return fmt.Errorf("update parking snapshot: %w", err)
@@ -182,14 +204,15 @@ func (r *ParkingRepository) GetByID(ctx context.Context, id int64) (*models.Park
 			start_odometer, end_odometer, energy_used_kwh,
 			start_inside_temp, end_inside_temp, start_outside_temp, end_outside_temp,
 			inside_temp_avg, outside_temp_avg,
-			climate_used_min, sentry_mode_used_min,
+			climate_used_min, sentry_mode_used_min, preconditioning_used_min,
+			seat_heater_used_min, defroster_used_min,
 			start_locked, start_sentry_mode, start_doors_open, start_windows_open,
 			start_frunk_open, start_trunk_open, start_is_climate_on, start_is_user_present,
 			end_locked, end_sentry_mode, end_doors_open, end_windows_open,
 			end_frunk_open, end_trunk_open, end_is_climate_on, end_is_user_present,
 			start_tpms_pressure_fl, start_tpms_pressure_fr, start_tpms_pressure_rl, start_tpms_pressure_rr,
 			end_tpms_pressure_fl, end_tpms_pressure_fr, end_tpms_pressure_rl, end_tpms_pressure_rr,
-			car_version, address
+			car_version, address, start_closures, end_closures
 		FROM parkings WHERE id = $1
 	`
 	parking := &models.Parking{}
@@ -218,6 +241,9 @@ func (r *ParkingRepository) GetByID(ctx context.Context, id int64) (*models.Park
 		&parking.OutsideTempAvg,
 		&parking.ClimateUsedMin,
 		&parking.SentryModeUsedMin,
+		&parking.PreconditioningUsedMin,
+		&parking.SeatHeaterUsedMin,
+		&parking.DefrosterUsedMin,
 		&parking.StartLocked,
 		&parking.StartSentryMode,
 		&parking.StartDoorsOpen,
@@ -244,6 +270,8 @@ func (r *ParkingRepository) GetByID(ctx context.Context, id int64) (*models.Park
 		&parking.EndTpmsPressureRR,
 		&parking.CarVersion,
 		&parking.Address,
+		&parking.StartClosures,
+		&parking.EndClosures,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get parking by id: %w", err)
@@ -251,8 +279,9 @@ func (r *ParkingRepository) GetByID(ctx context.Context, id int64) (*models.Park
 	return parking, nil
 }
 
-// ListByCarID 获取车辆的停车列表
-func (r *ParkingRepository) ListByCarID(ctx context.Context, carID int64, limit, offset int) ([]*models.Parking, error) {
+// ListByCarID 获取车辆的停车列表，before 不为 nil 时只返回开始时间早于 before 的停车 (游标分页)
+func (r *ParkingRepository) ListByCarID(ctx context.Context, carID int64, before *time.Time, limit, offset int) ([]*models.Parking, error) {
+	cond, args := beforeStartTime(before, []interface{}{carID, limit, offset})
 	query := `
 		SELECT id, car_id, position_id, geofence_id, start_time, end_time, duration_min,
 			latitude, longitude,
@@ -260,17 +289,18 @@ func (r *ParkingRepository) ListByCarID(ctx context.Context, carID int64, limit,
 			start_odometer, end_odometer, energy_used_kwh,
 			start_inside_temp, end_inside_temp, start_outside_temp, end_outside_temp,
 			inside_temp_avg, outside_temp_avg,
-			climate_used_min, sentry_mode_used_min,
+			climate_used_min, sentry_mode_used_min, preconditioning_used_min,
+			seat_heater_used_min, defroster_used_min,
 			start_locked, start_sentry_mode, start_doors_open, start_windows_open,
 			start_frunk_open, start_trunk_open, start_is_climate_on, start_is_user_present,
 			end_locked, end_sentry_mode, end_doors_open, end_windows_open,
 			end_frunk_open, end_trunk_open, end_is_climate_on, end_is_user_present,
 			start_tpms_pressure_fl, start_tpms_pressure_fr, start_tpms_pressure_rl, start_tpms_pressure_rr,
 			end_tpms_pressure_fl, end_tpms_pressure_fr, end_tpms_pressure_rl, end_tpms_pressure_rr,
-			car_version, address
-		FROM parkings WHERE car_id = $1 ORDER BY start_time DESC LIMIT $2 OFFSET $3
+			car_version, address, start_closures, end_closures
+		FROM parkings WHERE car_id = $1` + cond + ` ORDER BY start_time DESC LIMIT $2 OFFSET $3
 	`
-	rows, err := r.db.Pool.Query(ctx, query, carID, limit, offset)
+	rows, err := r.db.Pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("list parkings: %w", err)
 	}
@@ -304,6 +334,9 @@ func (r *ParkingRepository) ListByCarID(ctx context.Context, carID int64, limit,
 			&parking.OutsideTempAvg,
 			&parking.ClimateUsedMin,
 			&parking.SentryModeUsedMin,
+			&parking.PreconditioningUsedMin,
+			&parking.SeatHeaterUsedMin,
+			&parking.DefrosterUsedMin,
 			&parking.StartLocked,
 			&parking.StartSentryMode,
 			&parking.StartDoorsOpen,
@@ -330,6 +363,8 @@ func (r *ParkingRepository) ListByCarID(ctx context.Context, carID int64, limit,
 			&parking.EndTpmsPressureRR,
 			&parking.CarVersion,
 			&parking.Address,
+			&parking.StartClosures,
+			&parking.EndClosures,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan parking: %w", err)
@@ -351,6 +386,7 @@ func (r *ParkingRepository) CountByCarID(ctx context.Context, carID int64) (int6
 }
 
 // GetActiveParking 获取进行中的停车记录
+// 没有进行中的停车时返回 nil
 func (r *ParkingRepository) GetActiveParking(ctx context.Context, carID int64) (*models.Parking, error) {
 	query := `
 		SELECT id, car_id, position_id, geofence_id, start_time, end_time, duration_min,
@@ -359,14 +395,15 @@ func (r *ParkingRepository) GetActiveParking(ctx context.Context, carID int64) (
 			start_odometer, end_odometer, energy_used_kwh,
 			start_inside_temp, end_inside_temp, start_outside_temp, end_outside_temp,
 			inside_temp_avg, outside_temp_avg,
-			climate_used_min, sentry_mode_used_min,
+			climate_used_min, sentry_mode_used_min, preconditioning_used_min,
+			seat_heater_used_min, defroster_used_min,
 			start_locked, start_sentry_mode, start_doors_open, start_windows_open,
 			start_frunk_open, start_trunk_open, start_is_climate_on, start_is_user_present,
 			end_locked, end_sentry_mode, end_doors_open, end_windows_open,
 			end_frunk_open, end_trunk_open, end_is_climate_on, end_is_user_present,
 			start_tpms_pressure_fl, start_tpms_pressure_fr, start_tpms_pressure_rl, start_tpms_pressure_rr,
 			end_tpms_pressure_fl, end_tpms_pressure_fr, end_tpms_pressure_rl, end_tpms_pressure_rr,
-			car_version, address
+			car_version, address, start_closures, end_closures
 		FROM parkings WHERE car_id = $1 AND end_time IS NULL ORDER BY start_time DESC LIMIT 1
 	`
 	parking := &models.Parking{}
@@ -395,6 +432,9 @@ func (r *ParkingRepository) GetActiveParking(ctx context.Context, carID int64) (
 		&parking.OutsideTempAvg,
 		&parking.ClimateUsedMin,
 		&parking.SentryModeUsedMin,
+		&parking.PreconditioningUsedMin,
+		&parking.SeatHeaterUsedMin,
+		&parking.DefrosterUsedMin,
 		&parking.StartLocked,
 		&parking.StartSentryMode,
 		&parking.StartDoorsOpen,
@@ -421,9 +461,14 @@ func (r *ParkingRepository) GetActiveParking(ctx context.Context, carID int64) (
 		&parking.EndTpmsPressureRR,
 		&parking.CarVersion,
 		&parking.Address,
+		&parking.StartClosures,
+		&parking.EndClosures,
 	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
 	if err != nil {
-		return nil, err // 可能是没有进行中的停车
+		return nil, fmt.Errorf("get active parking: %w", err)
 	}
 	return parking, nil
 }
@@ -439,13 +484,13 @@ func (r *ParkingRepository) ForceCloseOpenParkings(ctx context.Context, carID in
 	return err
 }
 
-// GetStats 获取停车统计
-func (r *ParkingRepository) GetStats(ctx context.Context, carID int64, since time.Time) (totalDuration float64, totalEnergyUsed float64, count int64, err error) {
+// GetStats 获取 [start, end) 内开始的已结束停车统计
+func (r *ParkingRepository) GetStats(ctx context.Context, carID int64, start, end time.Time) (totalDuration float64, totalEnergyUsed float64, count int64, err error) {
 	query := `
 		SELECT COALESCE(SUM(duration_min), 0), COALESCE(SUM(energy_used_kwh), 0), COUNT(*)
-		FROM parkings WHERE car_id = $1 AND start_time >= $2 AND end_time IS NOT NULL
+		FROM parkings WHERE car_id = $1 AND start_time >= $2 AND start_time < $3 AND end_time IS NOT NULL
 	`
-	err = r.db.Pool.QueryRow(ctx, query, carID, since).Scan(&totalDuration, &totalEnergyUsed, &count)
+	err = r.db.Pool.QueryRow(ctx, query, carID, start, end).Scan(&totalDuration, &totalEnergyUsed, &count)
 	if err != nil {
 		err = fmt.Errorf("get parking stats: %w", err)
 	}
@@ -512,3 +557,64 @@ func (r *ParkingRepository) DeleteEventsByParkingID(ctx context.Context, parking
 	}
 	return nil
 }
+
+// ListMissingAddresses 获取 ID 大于 afterID、缺少地址的停车记录
+func (r *ParkingRepository) ListMissingAddresses(ctx context.Context, afterID int64, limit int) ([]*MissingAddress, error) {
+	query := `
+		SELECT id, latitude, longitude
+		FROM parkings
+		WHERE id > $1 AND address IS NULL
+		ORDER BY id
+		LIMIT $2
+	`
+	rows, err := r.db.Pool.Query(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list parkings missing address: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*MissingAddress
+	for rows.Next() {
+		item := &MissingAddress{}
+		if err := rows.Scan(&item.ID, &item.Latitude, &item.Longitude); err != nil {
+			return nil, fmt.Errorf("scan parking missing address: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// UpdateAddress 补全停车记录地址，已有地址不会被覆盖
+func (r *ParkingRepository) UpdateAddress(ctx context.Context, id int64, address *models.Address) error {
+	query := `UPDATE parkings SET address = COALESCE(address, $1) WHERE id = $2`
+	if _, err := r.db.Pool.Exec(ctx, query, address, id); err != nil {
+		return fmt.Errorf("update parking address: %w", err)
+	}
+	return nil
+}
+
+// ListStaysByCarID 获取车辆 [since, until) 内开始的已结束停车的位置和停留时长 (用于常去地点聚类)，为 nil 时不限制
+func (r *ParkingRepository) ListStaysByCarID(ctx context.Context, carID int64, since, until *time.Time) ([]*models.ParkingStay, error) {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT latitude, longitude, start_time, COALESCE(duration_min, 0), address
+		FROM parkings
+		WHERE car_id = $1 AND end_time IS NOT NULL
+			AND ($2::timestamptz IS NULL OR start_time >= $2) AND ($3::timestamptz IS NULL OR start_time < $3)
+		ORDER BY start_time
+	`, carID, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("list parking stays: %w", err)
+	}
+	defer rows.Close()
+
+	var stays []*models.ParkingStay
+	for rows.Next() {
+		s := &models.ParkingStay{}
+		if err := rows.Scan(&s.Latitude, &s.Longitude, &s.StartTime, &s.DurationMin, &s.Address); err != nil {
+			return nil, fmt.Errorf("scan parking stay: %w", err)
+		}
+		stays = append(stays, s)
+	}
+	return stays, rows.Err()
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// plugSessionColumns 插枪会话查询的列，与 scanPlugSession 的扫描顺序一致
+const plugSessionColumns = `id, car_id, geofence_id, latitude, longitude, start_time, end_time,
+			duration_min, charging_min, start_battery_level, end_battery_level, scheduled_mode`
+
+// scanPlugSession 扫描一行插枪会话
+func scanPlugSession(row pgx.Row) (*models.PlugSession, error) {
+	ps := &models.PlugSession{}
+	err := row.Scan(
+		&ps.ID,
+		&ps.CarID,
+		&ps.GeofenceID,
+		&ps.Latitude,
+		&ps.Longitude,
+		&ps.StartTime,
+		&ps.EndTime,
+		&ps.DurationMin,
+		&ps.ChargingMin,
+		&ps.StartBatteryLevel,
+		&ps.EndBatteryLevel,
+		&ps.ScheduledMode,
+	)
+	return ps, err
+}
+
+// CreatePlugSession 创建插枪会话
+func (r *ChargeRepository) CreatePlugSession(ctx context.Context, ps *models.PlugSession) error {
+	err := r.db.Pool.QueryRow(ctx, `
+		INSERT INTO plug_sessions (car_id, geofence_id, latitude, longitude, start_time, start_battery_level, scheduled_mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, ps.CarID, ps.GeofenceID, ps.Latitude, ps.Longitude, ps.StartTime, ps.StartBatteryLevel, ps.ScheduledMode).Scan(&ps.ID)
+	if err != nil {
+		return fmt.Errorf("create plug session: %w", err)
+	}
+	return nil
+}
+
+// CompletePlugSession 结束插枪会话
+func (r *ChargeRepository) CompletePlugSession(ctx context.Context, ps *models.PlugSession) error {
+	_, err := r.db.Pool.Exec(ctx, `
+		UPDATE plug_sessions SET end_time = $2, duration_min = $3, charging_min = $4, end_battery_level = $5
+		WHERE id = $1
+	`, ps.ID, ps.EndTime, ps.DurationMin, ps.ChargingMin, ps.EndBatteryLevel)
+	if err != nil {
+		return fmt.Errorf("complete plug session: %w", err)
+	}
+	return nil
+}
+
+// GetActivePlugSession 获取进行中的插枪会话，没有时返回 nil
+func (r *ChargeRepository) GetActivePlugSession(ctx context.Context, carID int64) (*models.PlugSession, error) {
+	ps, err := scanPlugSession(r.db.Pool.QueryRow(ctx, `
+		SELECT `+plugSessionColumns+`
+		FROM plug_sessions WHERE car_id = $1 AND end_time IS NULL ORDER BY start_time DESC LIMIT 1
+	`, carID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get active plug session: %w", err)
+	}
+	return ps, nil
+}
+
+// GetPlugSessionByID 获取插枪会话
+func (r *ChargeRepository) GetPlugSessionByID(ctx context.Context, id int64) (*models.PlugSession, error) {
+	ps, err := scanPlugSession(r.db.Pool.QueryRow(ctx, `
+		SELECT `+plugSessionColumns+` FROM plug_sessions WHERE id = $1
+	`, id))
+	if err != nil {
+		return nil, fmt.Errorf("get plug session by id: %w", err)
+	}
+	return ps, nil
+}
+
+// ListPlugSessionsByCarID 获取车辆插枪会话列表，before 不为 nil 时只返回开始时间早于 before 的会话 (游标分页)
+func (r *ChargeRepository) ListPlugSessionsByCarID(ctx context.Context, carID int64, before *time.Time, limit, offset int) ([]*models.PlugSession, error) {
+	cond, args := beforeStartTime(before, []interface{}{carID, limit, offset})
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT `+plugSessionColumns+`
+		FROM plug_sessions WHERE car_id = $1`+cond+` ORDER BY start_time DESC LIMIT $2 OFFSET $3
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list plug sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*models.PlugSession
+	for rows.Next() {
+		ps, err := scanPlugSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan plug session: %w", err)
+		}
+		sessions = append(sessions, ps)
+	}
+	return sessions, rows.Err()
+}
+
+// CountPlugSessionsByCarID 统计车辆插枪会话数
+func (r *ChargeRepository) CountPlugSessionsByCarID(ctx context.Context, carID int64) (int64, error) {
+	var count int64
+	err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM plug_sessions WHERE car_id = $1`, carID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count plug sessions: %w", err)
+	}
+	return count, nil
+}
+
+// CreatePlugEvent 创建插枪会话事件
+func (r *ChargeRepository) CreatePlugEvent(ctx context.Context, event *models.PlugEvent) error {
+	err := r.db.Pool.QueryRow(ctx, `
+		INSERT INTO plug_session_events (plug_session_id, event_type, event_time, charging_state, battery_level)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, event.PlugSessionID, event.EventType, event.EventTime, event.ChargingState, event.BatteryLevel).Scan(&event.ID)
+	if err != nil {
+		return fmt.Errorf("create plug event: %w", err)
+	}
+	return nil
+}
+
+// ListPlugEvents 获取插枪会话的事件 (按时间升序)
+func (r *ChargeRepository) ListPlugEvents(ctx context.Context, sessionID int64) ([]*models.PlugEvent, error) {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT id, plug_session_id, event_type, event_time, charging_state, battery_level
+		FROM plug_session_events WHERE plug_session_id = $1
+		ORDER BY event_time ASC, id ASC
+	`, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("list plug events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*models.PlugEvent{}
+	for rows.Next() {
+		event := &models.PlugEvent{}
+		if err := rows.Scan(
+			&event.ID,
+			&event.PlugSessionID,
+			&event.EventType,
+			&event.EventTime,
+			&event.ChargingState,
+			&event.BatteryLevel,
+		); err != nil {
+			return nil, fmt.Errorf("scan plug event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// ListProcessesInRange 获取车辆在 [start, end) 内开始的充电记录 (按开始时间升序)，end 为 nil 时不限结束时间
+func (r *ChargeRepository) ListProcessesInRange(ctx context.Context, carID int64, start time.Time, end *time.Time) ([]*models.ChargingProcess, error) {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT `+chargingProcessColumns+`
+		FROM charging_processes
+		WHERE car_id = $1 AND start_time >= $2 AND ($3::timestamptz IS NULL OR start_time < $3)
+		ORDER BY start_time ASC
+	`, carID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("list charging processes in range: %w", err)
+	}
+	return scanChargingProcesses(rows)
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
 
 	"github.com/langchou/tesgazer/internal/models"
 )
@@ -20,8 +23,8 @@ func NewPositionRepository(db *DB) *PositionRepository {
 // Create 创建位置记录
 func (r *PositionRepository) Create(ctx context.Context, pos *models.Position) error {
 	query := `
-		INSERT INTO positions (car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km, inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, recorded_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		INSERT INTO positions (car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km, inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, closures, recorded_at, interpolated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
 		RETURNING id
 	`
 	err := r.db.Pool.QueryRow(ctx, query,
@@ -42,7 +45,9 @@ func (r *PositionRepository) Create(ctx context.Context, pos *models.Position) e
 		pos.TpmsPressureFR,
 		pos.TpmsPressureRL,
 		pos.TpmsPressureRR,
+		pos.Closures,
 		pos.RecordedAt,
+		pos.Interpolated,
 	).Scan(&pos.ID)
 
 	if err != nil {
@@ -51,10 +56,38 @@ func (r *PositionRepository) Create(ctx context.Context, pos *models.Position) e
 	return nil
 }
 
+// positionCopyColumns CreateBatch 写入的列，顺序与 Create 一致
+var positionCopyColumns = []string{
+	"car_id", "drive_id", "latitude", "longitude", "heading", "speed", "power", "odometer", "battery_level", "range_km",
+	"inside_temp", "outside_temp", "elevation", "tpms_pressure_fl", "tpms_pressure_fr", "tpms_pressure_rl", "tpms_pressure_rr", "closures", "recorded_at",
+	"interpolated",
+}
+
+// CreateBatch 使用 COPY 批量写入位置记录 (不回填 ID)，返回写入行数
+func (r *PositionRepository) CreateBatch(ctx context.Context, positions []*models.Position) (int64, error) {
+	if len(positions) == 0 {
+		return 0, nil
+	}
+	n, err := r.db.Pool.CopyFrom(ctx, pgx.Identifier{"positions"}, positionCopyColumns,
+		pgx.CopyFromSlice(len(positions), func(i int) ([]any, error) {
+			pos := positions[i]
+			return []any{
+				pos.CarID, pos.DriveID, pos.Latitude, pos.Longitude, pos.Heading, pos.Speed, pos.Power, pos.Odometer,
+				pos.BatteryLevel, pos.RangeKm, pos.InsideTemp, pos.OutsideTemp, pos.Elevation,
+				pos.TpmsPressureFL, pos.TpmsPressureFR, pos.TpmsPressureRL, pos.TpmsPressureRR, pos.Closures, pos.RecordedAt,
+				pos.Interpolated,
+			}, nil
+		}))
+	if err != nil {
+		return n, fmt.Errorf("copy positions: %w", err)
+	}
+	return n, nil
+}
+
 // GetLatestByCarID 获取车辆最新位置
 func (r *PositionRepository) GetLatestByCarID(ctx context.Context, carID int64) (*models.Position, error) {
 	query := `
-		SELECT id, car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km, inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, recorded_at
+		SELECT id, car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km, inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, closures, recorded_at
 		FROM positions WHERE car_id = $1 ORDER BY recorded_at DESC LIMIT 1
 	`
 	pos := &models.Position{}
@@ -77,6 +110,7 @@ func (r *PositionRepository) GetLatestByCarID(ctx context.Context, carID int64)
 		&pos.TpmsPressureFR,
 		&pos.TpmsPressureRL,
 		&pos.TpmsPressureRR,
+		&pos.Closures,
 		&pos.RecordedAt,
 	)
 	if err != nil {
@@ -88,7 +122,8 @@ func (r *PositionRepository) GetLatestByCarID(ctx context.Context, carID int64)
 // ListByDriveID 获取行程的所有位置
 func (r *PositionRepository) ListByDriveID(ctx context.Context, driveID int64) ([]*models.Position, error) {
 	query := `
-		SELECT id, car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km, inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, recorded_at
+		SELECT id, car_id, drive_id, latitude, longitude, heading, speed, power, odometer, battery_level, range_km, inside_temp, outside_temp, elevation, tpms_pressure_fl, tpms_pressure_fr, tpms_pressure_rl, tpms_pressure_rr, closures, recorded_at,
+			interpolated
 		FROM positions WHERE drive_id = $1 ORDER BY recorded_at
 	`
 	rows, err := r.db.Pool.Query(ctx, query, driveID)
@@ -119,7 +154,9 @@ func (r *PositionRepository) ListByDriveID(ctx context.Context, driveID int64) (
 			&pos.TpmsPressureFR,
 			&pos.TpmsPressureRL,
 			&pos.TpmsPressureRR,
+			&pos.Closures,
 			&pos.RecordedAt,
+			&pos.Interpolated,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("scan position: %w", err)
@@ -149,10 +186,37 @@ type DriveStats struct {
 	OutsideTempAvg *float64 // 平均车外温度
 	EnergyUsedKwh  *float64 // 总耗电量 (kWh)
 	EnergyRegenKwh *float64 // 总回收电量 (kWh)
+	// 能耗拆分
+	EnergySocKwh     *float64 // 按电量变化估算的净耗电量 (kWh)
+	EnergyClimateKwh *float64 // 空调等附件耗电估算 (kWh) = 电量净耗电 - 功率积分净耗电
+}
+
+// ApplyTo 将统计数据写入行程
+func (s *DriveStats) ApplyTo(drive *models.Drive) {
+	drive.SpeedMax = s.SpeedMax
+	drive.PowerMax = s.PowerMax
+	drive.PowerMin = s.PowerMin
+	drive.InsideTempAvg = s.InsideTempAvg
+	drive.OutsideTempAvg = s.OutsideTempAvg
+	drive.EnergyUsedKwh = s.EnergyUsedKwh
+	drive.EnergyRegenKwh = s.EnergyRegenKwh
+	drive.EnergySocKwh = s.EnergySocKwh
+	drive.EnergyClimateKwh = s.EnergyClimateKwh
+}
+
+// rowQuerier 连接池和事务共有的单行查询方法
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 // GetDriveStats 获取行程统计数据
-func (r *PositionRepository) GetDriveStats(ctx context.Context, driveID int64) (*DriveStats, error) {
+// batteryCapacityKwh 为可用电池容量，用于将电量百分比变化换算为 kWh
+func (r *PositionRepository) GetDriveStats(ctx context.Context, driveID int64, batteryCapacityKwh float64) (*DriveStats, error) {
+	return queryDriveStats(ctx, r.db.Pool, driveID, batteryCapacityKwh)
+}
+
+// queryDriveStats 根据行程的位置点计算统计数据 (可在事务中执行)
+func queryDriveStats(ctx context.Context, q rowQuerier, driveID int64, batteryCapacityKwh float64) (*DriveStats, error) {
 	query := `
 		SELECT
 			MAX(speed) as speed_max,
@@ -164,7 +228,7 @@ func (r *PositionRepository) GetDriveStats(ctx context.Context, driveID int64) (
 		WHERE drive_id = $1
 	`
 	stats := &DriveStats{}
-	err := r.db.Pool.QueryRow(ctx, query, driveID).Scan(
+	err := q.QueryRow(ctx, query, driveID).Scan(
 		&stats.SpeedMax,
 		&stats.PowerMax,
 		&stats.PowerMin,
@@ -195,8 +259,8 @@ func (r *PositionRepository) GetDriveStats(ctx context.Context, driveID int64) (
 		WHERE interval_seconds IS NOT NULL AND interval_seconds < 60
 	`
 	var energyUsed, energyRegen float64
-	err = r.db.Pool.QueryRow(ctx, energyQuery, driveID).Scan(&energyUsed, &energyRegen)
-	if err == nil {
+	energyErr := q.QueryRow(ctx, energyQuery, driveID).Scan(&energyUsed, &energyRegen)
+	if energyErr == nil {
 		if energyUsed > 0 {
 			stats.EnergyUsedKwh = &energyUsed
 		}
@@ -205,5 +269,86 @@ func (r *PositionRepository) GetDriveStats(ctx context.Context, driveID int64) (
 		}
 	}
 
+	// 按首尾位置点的电量变化估算净耗电量
+	// 功率积分只覆盖驱动功率，差值可以反映空调、加热等附件耗电
+	socQuery := `
+		SELECT
+			(SELECT battery_level FROM positions
+			 WHERE drive_id = $1 AND battery_level > 0 ORDER BY recorded_at ASC LIMIT 1),
+			(SELECT battery_level FROM positions
+			 WHERE drive_id = $1 AND battery_level > 0 ORDER BY recorded_at DESC LIMIT 1)
+	`
+	var startLevel, endLevel *int
+	err = q.QueryRow(ctx, socQuery, driveID).Scan(&startLevel, &endLevel)
+	if err == nil && startLevel != nil && endLevel != nil && *startLevel > *endLevel && batteryCapacityKwh > 0 {
+		energySoc := float64(*startLevel-*endLevel) / 100.0 * batteryCapacityKwh
+		stats.EnergySocKwh = &energySoc
+
+		// 电量百分比为整数，短途行程误差较大，差值为负时不记录
+		if energyErr == nil {
+			energyClimate := energySoc - (energyUsed - energyRegen)
+			if energyClimate > 0 {
+				stats.EnergyClimateKwh = &energyClimate
+			}
+		}
+	}
+
 	return stats, nil
 }
+
+// positionReferenced 被行程、充电、停车记录引用的位置点不能删除
+const positionReferenced = `
+	EXISTS (SELECT 1 FROM drives d WHERE d.start_position_id = p.id OR d.end_position_id = p.id)
+	OR EXISTS (SELECT 1 FROM charging_processes cp WHERE cp.position_id = p.id)
+	OR EXISTS (SELECT 1 FROM parkings pk WHERE pk.position_id = p.id)
+`
+
+// DeleteOrphanedBefore 删除指定时间之前不属于任何行程的位置记录
+// 分批删除，避免长事务锁表，返回删除的总行数
+func (r *PositionRepository) DeleteOrphanedBefore(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM positions WHERE id IN (
+			SELECT p.id FROM positions p
+			WHERE p.drive_id IS NULL AND p.recorded_at < $1 AND NOT (` + positionReferenced + `)
+			LIMIT $2
+		)
+	`
+	return r.deleteInBatches(ctx, query, before, batchSize)
+}
+
+// DownsampleDrivesBefore 将指定时间之前的行程轨迹降采样为每分钟保留 1 个点
+// 每分钟保留最早的点，重复执行不会继续删除
+func (r *PositionRepository) DownsampleDrivesBefore(ctx context.Context, before time.Time, batchSize int) (int64, error) {
+	query := `
+		DELETE FROM positions WHERE id IN (
+			SELECT id FROM (
+				SELECT p.id, ROW_NUMBER() OVER (
+					PARTITION BY p.drive_id, date_trunc('minute', p.recorded_at) ORDER BY p.recorded_at, p.id
+				) AS rn
+				FROM positions p
+				WHERE p.drive_id IS NOT NULL AND p.recorded_at < $1 AND NOT (` + positionReferenced + `)
+			) ranked
+			WHERE rn > 1
+			LIMIT $2
+		)
+	`
+	return r.deleteInBatches(ctx, query, before, batchSize)
+}
+
+// deleteInBatches 循环执行删除直到没有可删除的记录
+func (r *PositionRepository) deleteInBatches(ctx context.Context, query string, before time.Time, batchSize int) (int64, error) {
+	var total int64
+	for {
+		tag, err := r.db.Pool.Exec(ctx, query, before, batchSize)
+		if err != nil {
+			return total, fmt.Errorf("delete positions: %w", err)
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
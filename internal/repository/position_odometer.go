@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// OdometerBucket 一个周期内的里程表读数范围，周期内没有读数时 MinKm/MaxKm 为 nil
+type OdometerBucket struct {
+	Period time.Time
+	MinKm  *float64
+	MaxKm  *float64
+}
+
+// odometerReadingsSQL 里程表读数来源：位置点和行程起止里程 (位置点被保留策略清理后仍可从行程获取)
+// $1 car_id，$2/$3 时间范围
+const odometerReadingsSQL = `
+	SELECT recorded_at AS at, odometer AS km FROM positions
+	WHERE car_id = $1 AND recorded_at >= $2 AND recorded_at < $3 AND odometer > 0
+	UNION ALL
+	SELECT start_time, start_odometer_km FROM drives
+	WHERE car_id = $1 AND start_time >= $2 AND start_time < $3 AND start_odometer_km > 0
+	UNION ALL
+	SELECT end_time, end_odometer_km FROM drives
+	WHERE car_id = $1 AND end_time >= $2 AND end_time < $3 AND end_odometer_km > 0`
+
+// OdometerBuckets 按周期 (day/week/month，按会话时区对齐) 汇总 [from, to) 内的里程表读数
+// 返回第一个周期开始前的最后一次读数 (没有时为 nil) 和每个周期的读数范围 (包含没有读数的周期)
+func (r *PositionRepository) OdometerBuckets(ctx context.Context, carID int64, from, to time.Time, granularity string) (*float64, []*OdometerBucket, error) {
+	var start time.Time
+	if err := r.db.Pool.QueryRow(ctx, `SELECT date_trunc($1, $2::timestamptz)`, granularity, from).Scan(&start); err != nil {
+		return nil, nil, fmt.Errorf("truncate odometer period: %w", err)
+	}
+
+	var baseline *float64
+	err := r.db.Pool.QueryRow(ctx, `
+		SELECT km FROM (
+			(SELECT recorded_at AS at, odometer AS km FROM positions
+			 WHERE car_id = $1 AND recorded_at < $2 AND odometer > 0
+			 ORDER BY recorded_at DESC LIMIT 1)
+			UNION ALL
+			(SELECT end_time, end_odometer_km FROM drives
+			 WHERE car_id = $1 AND end_time < $2 AND end_odometer_km > 0
+			 ORDER BY end_time DESC LIMIT 1)
+		) r
+		ORDER BY at DESC LIMIT 1
+	`, carID, start).Scan(&baseline)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil, fmt.Errorf("get odometer baseline: %w", err)
+	}
+
+	rows, err := r.db.Pool.Query(ctx, `
+		WITH readings AS (`+odometerReadingsSQL+`
+		), buckets AS (
+			SELECT date_trunc($4, at) AS period, MIN(km) AS min_km, MAX(km) AS max_km
+			FROM readings GROUP BY 1
+		)
+		SELECT s.period, b.min_km, b.max_km
+		FROM generate_series($2::timestamptz, $3::timestamptz - interval '1 microsecond', ('1 ' || $4)::interval) AS s(period)
+		LEFT JOIN buckets b ON b.period = s.period
+		ORDER BY s.period
+	`, carID, start, to, granularity)
+	if err != nil {
+		return nil, nil, fmt.Errorf("query odometer buckets: %w", err)
+	}
+	defer rows.Close()
+
+	var buckets []*OdometerBucket
+	for rows.Next() {
+		b := &OdometerBucket{}
+		if err := rows.Scan(&b.Period, &b.MinKm, &b.MaxKm); err != nil {
+			return nil, nil, fmt.Errorf("scan odometer bucket: %w", err)
+		}
+		buckets = append(buckets, b)
+	}
+	return baseline, buckets, rows.Err()
+}
+
+// LatestOdometer 获取车辆最新的里程表读数 (km)，没有读数时返回 nil
+// 优先取最新位置点，位置点被清理后从最近结束的行程获取
+func (r *PositionRepository) LatestOdometer(ctx context.Context, carID int64) (*float64, error) {
+	var km *float64
+	err := r.db.Pool.QueryRow(ctx, `
+		SELECT km FROM (
+			(SELECT recorded_at AS at, odometer AS km FROM positions
+			 WHERE car_id = $1 AND odometer > 0
+			 ORDER BY recorded_at DESC LIMIT 1)
+			UNION ALL
+			(SELECT end_time, end_odometer_km FROM drives
+			 WHERE car_id = $1 AND end_time IS NOT NULL AND end_odometer_km > 0
+			 ORDER BY end_time DESC LIMIT 1)
+		) r
+		ORDER BY at DESC LIMIT 1
+	`, carID).Scan(&km)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get latest odometer: %w", err)
+	}
+	return km, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// Query 按时间范围和经纬度范围查询车辆的位置点 (用于热力图)
+// bbox 为空时不限范围；step 为抽样间隔，小于 1 时按 limit 自动计算，保证返回的点数不超过 limit
+func (r *PositionRepository) Query(ctx context.Context, carID int64, from, to time.Time, bbox *models.BoundingBox, step, limit int) (*models.PositionCloud, error) {
+	where := `car_id = $1 AND recorded_at >= $2 AND recorded_at < $3`
+	args := []interface{}{carID, from, to}
+	if bbox != nil {
+		// PostGIS 可用时走 GiST 索引，否则回退到经纬度范围比较
+		if r.db.PostGIS {
+			where += ` AND ST_Intersects(ST_MakePoint(longitude, latitude)::geography, ST_MakeEnvelope($4, $5, $6, $7, 4326)::geography)`
+		} else {
+			where += ` AND latitude BETWEEN $5 AND $7 AND longitude BETWEEN $4 AND $6`
+		}
+		args = append(args, bbox.MinLng, bbox.MinLat, bbox.MaxLng, bbox.MaxLat)
+	}
+
+	cloud := &models.PositionCloud{Points: [][2]float64{}}
+	if err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM positions WHERE `+where, args...).Scan(&cloud.Total); err != nil {
+		return nil, fmt.Errorf("count positions: %w", err)
+	}
+	if cloud.Total == 0 {
+		cloud.Step = 1
+		return cloud, nil
+	}
+
+	if step < 1 {
+		step = int((cloud.Total + int64(limit) - 1) / int64(limit))
+		if step < 1 {
+			step = 1
+		}
+	}
+	cloud.Step = step
+
+	stepArg := len(args) + 1
+	query := `
+		SELECT latitude, longitude FROM (
+			SELECT latitude, longitude, recorded_at, ROW_NUMBER() OVER (ORDER BY recorded_at) AS rn
+			FROM positions WHERE ` + where + `
+		) p
+		WHERE (rn - 1) % $` + strconv.Itoa(stepArg) + ` = 0
+		ORDER BY recorded_at
+		LIMIT $` + strconv.Itoa(stepArg+1)
+	args = append(args, step, limit)
+
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query positions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var lat, lng float64
+		if err := rows.Scan(&lat, &lng); err != nil {
+			return nil, fmt.Errorf("scan position: %w", err)
+		}
+		cloud.Points = append(cloud.Points, [2]float64{lat, lng})
+	}
+	return cloud, rows.Err()
+}
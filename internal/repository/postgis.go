@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// EnablePostGIS 启用 PostGIS 支持
+// 创建扩展并执行空间迁移，失败时保持 PostGIS = false，查询回退到经纬度计算
+func (db *DB) EnablePostGIS(ctx context.Context) error {
+	if _, err := db.Pool.Exec(ctx, `CREATE EXTENSION IF NOT EXISTS postgis`); err != nil {
+		return fmt.Errorf("create postgis extension: %w", err)
+	}
+	if _, err := db.Pool.Exec(ctx, migrationAddGeographyToPositions); err != nil {
+		return fmt.Errorf("execute postgis migration: %w", err)
+	}
+
+	db.PostGIS = true
+	return nil
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// SettingsRepository 车辆设置仓库 (key/value)
+type SettingsRepository struct {
+	db *DB
+}
+
+// NewSettingsRepository 创建设置仓库
+func NewSettingsRepository(db *DB) *SettingsRepository {
+	return &SettingsRepository{db: db}
+}
+
+// Get 获取单个设置
+func (r *SettingsRepository) Get(ctx context.Context, carID int64, key string) (*models.Settings, error) {
+	query := `SELECT id, car_id, key, value FROM settings WHERE car_id = $1 AND key = $2`
+	s := &models.Settings{}
+	err := r.db.Pool.QueryRow(ctx, query, carID, key).Scan(&s.ID, &s.CarID, &s.Key, &s.Value)
+	if err != nil {
+		return nil, fmt.Errorf("get setting: %w", err)
+	}
+	return s, nil
+}
+
+// Set 设置值（存在则覆盖）
+func (r *SettingsRepository) Set(ctx context.Context, carID int64, key, value string) error {
+	query := `
+		INSERT INTO settings (car_id, key, value)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (car_id, key) DO UPDATE SET value = EXCLUDED.value
+	`
+	if _, err := r.db.Pool.Exec(ctx, query, carID, key, value); err != nil {
+		return fmt.Errorf("set setting: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除设置（恢复使用全局配置）
+func (r *SettingsRepository) Delete(ctx context.Context, carID int64, key string) error {
+	if _, err := r.db.Pool.Exec(ctx, `DELETE FROM settings WHERE car_id = $1 AND key = $2`, carID, key); err != nil {
+		return fmt.Errorf("delete setting: %w", err)
+	}
+	return nil
+}
+
+// ListByCarID 获取车辆的所有设置
+func (r *SettingsRepository) ListByCarID(ctx context.Context, carID int64) ([]*models.Settings, error) {
+	return r.list(ctx, `SELECT id, car_id, key, value FROM settings WHERE car_id = $1 ORDER BY key`, carID)
+}
+
+// ListAll 获取所有车辆的设置
+func (r *SettingsRepository) ListAll(ctx context.Context) ([]*models.Settings, error) {
+	return r.list(ctx, `SELECT id, car_id, key, value FROM settings ORDER BY car_id, key`)
+}
+
+func (r *SettingsRepository) list(ctx context.Context, query string, args ...interface{}) ([]*models.Settings, error) {
+	rows, err := r.db.Pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list settings: %w", err)
+	}
+	defer rows.Close()
+
+	var settings []*models.Settings
+	for rows.Next() {
+		s := &models.Settings{}
+		if err := rows.Scan(&s.ID, &s.CarID, &s.Key, &s.Value); err != nil {
+			return nil, fmt.Errorf("scan setting: %w", err)
+		}
+		settings = append(settings, s)
+	}
+
+	return settings, nil
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// StateRepository 车辆状态记录仓库
+type StateRepository struct {
+	db *DB
+}
+
+// NewStateRepository 创建状态记录仓库
+func NewStateRepository(db *DB) *StateRepository {
+	return &StateRepository{db: db}
+}
+
+// Start 结束车辆当前未结束的状态并开始新的状态，当前状态与新状态相同时不做修改
+func (r *StateRepository) Start(ctx context.Context, carID int64, state string, at time.Time) error {
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin start state: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var current string
+	err = tx.QueryRow(ctx, `
+		SELECT state FROM states WHERE car_id = $1 AND end_time IS NULL
+		ORDER BY start_time DESC LIMIT 1
+	`, carID).Scan(&current)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("get current state: %w", err)
+	}
+	if current == state {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE states SET end_time = GREATEST($2, start_time)
+		WHERE car_id = $1 AND end_time IS NULL
+	`, carID, at)
+	if err != nil {
+		return fmt.Errorf("end state: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO states (car_id, state, start_time) VALUES ($1, $2, $3)`, carID, state, at)
+	if err != nil {
+		return fmt.Errorf("create state: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit start state: %w", err)
+	}
+	return nil
+}
+
+// ListRecent 获取车辆最近的状态记录 (按开始时间倒序)，未结束的状态时长计算到当前时间
+func (r *StateRepository) ListRecent(ctx context.Context, carID int64, limit int) ([]*models.State, error) {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT id, car_id, state, start_time, end_time,
+			ROUND((EXTRACT(EPOCH FROM (COALESCE(end_time, NOW()) - start_time)) / 60)::numeric, 1)::float8
+		FROM states
+		WHERE car_id = $1
+		ORDER BY start_time DESC, id DESC
+		LIMIT $2
+	`, carID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list states: %w", err)
+	}
+	defer rows.Close()
+
+	states := []*models.State{}
+	for rows.Next() {
+		s := &models.State{}
+		if err := rows.Scan(&s.ID, &s.CarID, &s.State, &s.StartTime, &s.EndTime, &s.DurationMin); err != nil {
+			return nil, fmt.Errorf("scan state: %w", err)
+		}
+		states = append(states, s)
+	}
+	return states, rows.Err()
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"database/sql/driver"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// registerLocation 让连接读取的 timestamptz 使用指定时区 (pgx 默认使用服务器本地时区)
+func registerLocation(conn *pgx.Conn, loc *time.Location) {
+	conn.TypeMap().RegisterType(&pgtype.Type{
+		Name:  "timestamptz",
+		OID:   pgtype.TimestamptzOID,
+		Codec: &locationTimestamptzCodec{loc: loc},
+	})
+}
+
+// locationTimestamptzCodec 将读取的时间转换到 loc 时区，写入仍使用 pgx 默认编码
+type locationTimestamptzCodec struct {
+	pgtype.TimestamptzCodec
+	loc *time.Location
+}
+
+func (c *locationTimestamptzCodec) PlanScan(m *pgtype.Map, oid uint32, format int16, target any) pgtype.ScanPlan {
+	plan := c.TimestamptzCodec.PlanScan(m, oid, format, target)
+	if plan == nil {
+		return nil
+	}
+	return &locationScanPlan{next: plan, loc: c.loc}
+}
+
+func (c *locationTimestamptzCodec) DecodeDatabaseSQLValue(m *pgtype.Map, oid uint32, format int16, src []byte) (driver.Value, error) {
+	v, err := c.TimestamptzCodec.DecodeDatabaseSQLValue(m, oid, format, src)
+	if t, ok := v.(time.Time); ok {
+		return t.In(c.loc), err
+	}
+	return v, err
+}
+
+func (c *locationTimestamptzCodec) DecodeValue(m *pgtype.Map, oid uint32, format int16, src []byte) (any, error) {
+	v, err := c.TimestamptzCodec.DecodeValue(m, oid, format, src)
+	if t, ok := v.(time.Time); ok {
+		return t.In(c.loc), err
+	}
+	return v, err
+}
+
+// locationScanPlan 扫描后将时间转换到 loc 时区
+// target 为 pgx 包装后的扫描目标 (*time.Time 会被包装为同时实现 Scanner 和 Valuer 的类型)
+type locationScanPlan struct {
+	next pgtype.ScanPlan
+	loc  *time.Location
+}
+
+func (p *locationScanPlan) Scan(src []byte, target any) error {
+	if err := p.next.Scan(src, target); err != nil || src == nil {
+		return err
+	}
+
+	scanner, ok := target.(pgtype.TimestamptzScanner)
+	if !ok {
+		return nil
+	}
+	valuer, ok := target.(pgtype.TimestamptzValuer)
+	if !ok {
+		return nil
+	}
+	v, err := valuer.TimestamptzValue()
+	if err != nil || !v.Valid || v.InfinityModifier != pgtype.Finite {
+		return err
+	}
+	v.Time = v.Time.In(p.loc)
+	return scanner.ScanTimestamptz(v)
+}
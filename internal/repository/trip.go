@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// TripRepository 旅程数据仓库
+type TripRepository struct {
+	db *DB
+}
+
+// NewTripRepository 创建旅程仓库
+func NewTripRepository(db *DB) *TripRepository {
+	return &TripRepository{db: db}
+}
+
+// LinkDrive 将刚结束的行程与上一段行程归入同一旅程
+// 两段行程之间的停留不超过 maxStop 且期间有已完成的充电时才串联；
+// 上一段行程尚未属于旅程时新建旅程。返回旅程 ID，未串联时返回 0
+func (r *TripRepository) LinkDrive(ctx context.Context, drive *models.Drive, maxStop time.Duration) (int64, error) {
+	if drive.EndTime == nil {
+		return 0, nil
+	}
+
+	tx, err := r.db.Pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin link trip: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// 上一段已结束的行程
+	var prevID int64
+	var prevStart, prevEnd time.Time
+	var prevTripID *int64
+	err = tx.QueryRow(ctx, `
+		SELECT id, start_time, end_time, trip_id FROM drives
+		WHERE car_id = $1 AND id <> $2 AND end_time IS NOT NULL AND end_time <= $3
+		ORDER BY end_time DESC LIMIT 1
+	`, drive.CarID, drive.ID, drive.StartTime).Scan(&prevID, &prevStart, &prevEnd, &prevTripID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get previous drive: %w", err)
+	}
+	if drive.StartTime.Sub(prevEnd) > maxStop {
+		return 0, nil
+	}
+
+	// 停留期间需要有充电，普通的目的地停车不串联
+	var charged bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM charging_processes
+			WHERE car_id = $1 AND end_time IS NOT NULL AND start_time >= $2 AND end_time <= $3
+		)
+	`, drive.CarID, prevEnd, drive.StartTime).Scan(&charged)
+	if err != nil {
+		return 0, fmt.Errorf("check charge between drives: %w", err)
+	}
+	if !charged {
+		return 0, nil
+	}
+
+	var tripID int64
+	if prevTripID != nil {
+		tripID = *prevTripID
+		_, err = tx.Exec(ctx, `UPDATE trips SET end_time = $1 WHERE id = $2`, drive.EndTime, tripID)
+		if err != nil {
+			return 0, fmt.Errorf("extend trip: %w", err)
+		}
+	} else {
+		err = tx.QueryRow(ctx, `
+			INSERT INTO trips (car_id, start_time, end_time) VALUES ($1, $2, $3) RETURNING id
+		`, drive.CarID, prevStart, drive.EndTime).Scan(&tripID)
+		if err != nil {
+			return 0, fmt.Errorf("insert trip: %w", err)
+		}
+		if _, err := tx.Exec(ctx, `UPDATE drives SET trip_id = $1 WHERE id = $2`, tripID, prevID); err != nil {
+			return 0, fmt.Errorf("link previous drive: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE drives SET trip_id = $1 WHERE id = $2`, tripID, drive.ID); err != nil {
+		return 0, fmt.Errorf("link drive: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("commit link trip: %w", err)
+	}
+	return tripID, nil
+}
+
+// ListByCarID 获取车辆的旅程列表 (含各段行程和中途充电)，before 不为 nil 时只返回开始时间早于 before 的旅程 (游标分页)
+func (r *TripRepository) ListByCarID(ctx context.Context, carID int64, before *time.Time, limit, offset int) ([]*models.Trip, error) {
+	cond, args := beforeStartTime(before, []interface{}{carID, limit, offset})
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT id, car_id, start_time, end_time FROM trips
+		WHERE car_id = $1`+cond+` ORDER BY start_time DESC LIMIT $2 OFFSET $3
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list trips: %w", err)
+	}
+	defer rows.Close()
+
+	var trips []*models.Trip
+	byID := make(map[int64]*models.Trip)
+	var ids []int64
+	for rows.Next() {
+		t := &models.Trip{Legs: []*models.TripLeg{}, Charges: []*models.TripStop{}}
+		if err := rows.Scan(&t.ID, &t.CarID, &t.StartTime, &t.EndTime); err != nil {
+			return nil, fmt.Errorf("scan trip: %w", err)
+		}
+		trips = append(trips, t)
+		byID[t.ID] = t
+		ids = append(ids, t.ID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list trips: %w", err)
+	}
+	if len(trips) == 0 {
+		return trips, nil
+	}
+
+	if err := r.loadLegs(ctx, ids, byID); err != nil {
+		return nil, err
+	}
+	if err := r.loadCharges(ctx, ids, byID); err != nil {
+		return nil, err
+	}
+
+	for _, t := range trips {
+		t.Summarize()
+	}
+	return trips, nil
+}
+
+// CountByCarID 统计车辆旅程数
+func (r *TripRepository) CountByCarID(ctx context.Context, carID int64) (int64, error) {
+	var count int64
+	err := r.db.Pool.QueryRow(ctx, `SELECT COUNT(*) FROM trips WHERE car_id = $1`, carID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("count trips: %w", err)
+	}
+	return count, nil
+}
+
+// loadLegs 加载旅程的各段行程
+func (r *TripRepository) loadLegs(ctx context.Context, ids []int64, byID map[int64]*models.Trip) error {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT trip_id, id, start_time, end_time, distance_km, duration_min, energy_used_kwh, start_address, end_address,
+			start_latitude, start_longitude, end_latitude, end_longitude
+		FROM drives WHERE trip_id = ANY($1) AND end_time IS NOT NULL
+		ORDER BY start_time
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("list trip legs: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tripID int64
+		leg := &models.TripLeg{}
+		err := rows.Scan(&tripID, &leg.DriveID, &leg.StartTime, &leg.EndTime, &leg.DistanceKm, &leg.DurationMin,
+			&leg.EnergyUsedKwh, &leg.StartAddress, &leg.EndAddress,
+			&leg.StartLatitude, &leg.StartLongitude, &leg.EndLatitude, &leg.EndLongitude)
+		if err != nil {
+			return fmt.Errorf("scan trip leg: %w", err)
+		}
+		if t := byID[tripID]; t != nil {
+			t.Legs = append(t.Legs, leg)
+		}
+	}
+	return rows.Err()
+}
+
+// loadCharges 加载旅程期间 (第一段行程开始到最后一段结束) 的充电
+func (r *TripRepository) loadCharges(ctx context.Context, ids []int64, byID map[int64]*models.Trip) error {
+	rows, err := r.db.Pool.Query(ctx, `
+		SELECT t.id, cp.id, cp.start_time, cp.end_time, cp.charge_energy_added, cp.cost, cp.address, cp.geofence_id
+		FROM trips t
+		JOIN charging_processes cp ON cp.car_id = t.car_id
+			AND cp.end_time IS NOT NULL AND cp.start_time >= t.start_time AND cp.end_time <= t.end_time
+		WHERE t.id = ANY($1)
+		ORDER BY cp.start_time
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("list trip charges: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tripID int64
+		stop := &models.TripStop{}
+		err := rows.Scan(&tripID, &stop.ChargingProcessID, &stop.StartTime, &stop.EndTime, &stop.ChargeEnergyAdded,
+			&stop.Cost, &stop.Address, &stop.GeofenceID)
+		if err != nil {
+			return fmt.Errorf("scan trip charge: %w", err)
+		}
+		if t := byID[tripID]; t != nil {
+			t.Charges = append(t.Charges, stop)
+		}
+	}
+	return rows.Err()
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/langchou/tesgazer/internal/api/geocoder"
+	"github.com/langchou/tesgazer/internal/api/mapmatch"
 	"github.com/langchou/tesgazer/internal/api/tesla"
 	"github.com/langchou/tesgazer/internal/config"
 	"github.com/langchou/tesgazer/internal/models"
@@ -21,16 +23,24 @@ import (
 type VehicleService struct {
 	cfg          *config.Config
 	logger       *zap.Logger
-	teslaClient  *tesla.Client
 	geocoder     *geocoder.Client // 逆地理编码客户端（支持高德/Nominatim）
+	mapMatcher   *mapmatch.Client // 轨迹纠偏客户端（支持 OSRM/Valhalla）
 	carRepo      *repository.CarRepository
+	carSync      carSyncStore // 同步车辆列表时写入车辆 (默认为 carRepo)
 	posRepo      *repository.PositionRepository
 	driveRepo    *repository.DriveRepository
 	chargeRepo   *repository.ChargeRepository
 	parkingRepo  *repository.ParkingRepository
+	geofenceRepo *repository.GeofenceRepository
+	settingsRepo *repository.SettingsRepository
+	accountRepo  *repository.AccountRepository
+	tripRepo     *repository.TripRepository
+	stateRepo    *repository.StateRepository
 	stateManager *state.Manager
 	wsHub        *ws.Hub // WebSocket Hub
 
+	maintenanceRepo *repository.MaintenanceRepository // 保养提醒
+
 	mu          sync.RWMutex
 	stopCh      chan struct{}
 	wg          sync.WaitGroup
@@ -41,18 +51,109 @@ type VehicleService struct {
 	pollIntervals map[int64]time.Duration // 每辆车当前的轮询间隔
 	lastPollTimes map[int64]time.Time     // 每辆车上次轮询时间
 	lastUsedTimes map[int64]time.Time     // 每辆车最后活跃时间 (用于自动休眠)
+	unlockedSince map[int64]time.Time     // 未锁车成为唯一休眠阻止原因的开始时间
+
+	// 挂入 P 挡并停稳的开始时间 (行程结束去抖)
+	driveEndPending map[int64]time.Time
+
+	// 最近一次充电结束的时间和位置 (充电结束后的误报行程保护)
+	chargeEnds map[int64]*positionMark
+
+	// 上一次广播的状态（用于判断是否需要立即推送）
+	lastBroadcast map[int64]broadcastKey
+
+	// 最近一次写入的位置 (用于控制在线未驾驶时的位置记录频率)
+	lastPositions map[int64]*positionMark
+
+	// 已认证的 Tesla 账号 (label -> 账号)，每个账号独立刷新 Token
+	accounts map[string]*teslaAccount
+
+	// Token 刷新后的持久化 (tokenSaveMu 保护 tokenSaver，并串行化写入)
+	tokenSaveMu sync.Mutex
+	tokenSaver  func(map[string]*tesla.Token) error
+
+	// 车辆设置覆盖 (car_id -> key -> value)
+	carSettings map[int64]map[string]string
+
+	// 位置数据清理任务互斥
+	pruneMu sync.Mutex
+
+	// Streaming 位置写入缓冲 (posBufMu 保护 posBuffer，posFlushMu 保证同一时间只有一次批量写入)
+	posBufMu   sync.Mutex
+	posFlushMu sync.Mutex
+	posBuffer  []*models.Position
+
+	// 写入失败的 Streaming 位置 (posRetryMu 保护)，下次写入成功后补写
+	posRetryMu      sync.Mutex
+	posRetry        []*models.Position
+	posRetryDropped int64 // 队列已满被丢弃的位置数
+
+	// 进行中的 Streaming 位置写入 (停止服务时等待其完成后再写入缓冲区剩余位置)
+	streamWrites sync.WaitGroup
+
+	// 地址补全任务 (backfillMu 保护以下字段)
+	backfillMu     sync.Mutex
+	backfillCancel context.CancelFunc // 非 nil 表示任务正在执行
+	backfillStatus *GeocodeBackfillStatus
+
+	// 并发轮询控制
+	pollSem      chan struct{}  // 限制同时进行的轮询数量
+	pollInFlight map[int64]bool // 正在轮询中的车辆，防止同一辆车并发轮询
+
+	// 轮询状态 (用于 poll-status 接口和限流冷却)
+	pollFailures   map[int64]int             // 连续失败次数
+	pollLastErrors map[int64]string          // 最近一次失败原因
+	rateLimits     map[int64]*rateLimitEvent // 最近一次限流记录
+
+	// 连续返回不可用 (408) 的次数，达到 ASLEEP_CONFIRM_COUNT 才判定为休眠
+	unavailableCounts map[int64]int
+
+	// 进行中的插枪会话 (值为 nil 表示已从数据库确认没有进行中的会话)
+	plugSessions map[int64]*activePlug
+
+	// 进行中行程的实时统计 (每个位置点更新，行程结束时与数据库统计核对)
+	liveDrives map[int64]*liveDriveAgg
+
+	// 隐私区域缓存 (标记为 privacy 的地理围栏)，privacyZonesLoaded 为 false 时使用前从数据库加载；
+	// privacyZonesValid 表示曾经加载成功，重新加载失败时继续使用上次的列表
+	privacyZones       []*models.Geofence
+	privacyZonesLoaded bool
+	privacyZonesValid  bool
 
 	// 停车期间的累计数据 (per vehicle)
-	parkingClimateUsage map[int64]time.Duration // 空调使用时长累计
-	parkingSentryUsage  map[int64]time.Duration // 哨兵模式使用时长累计
-	parkingLastCheck    map[int64]time.Time     // 上次检查时间
-	parkingTempSamples  map[int64][]tempSample  // 温度采样
+	parkingClimateUsage map[int64]time.Duration     // 空调使用时长累计（不含预热）
+	parkingPrecondUsage map[int64]time.Duration     // 预热/预冷时长累计
+	parkingSentryUsage  map[int64]time.Duration     // 哨兵模式使用时长累计
+	parkingSeatHeater   map[int64]time.Duration     // 座椅加热使用时长累计
+	parkingDefroster    map[int64]time.Duration     // 除雾使用时长累计
+	parkingLastCheck    map[int64]time.Time         // 上次检查时间
+	parkingTempSamples  map[int64][]tempSample      // 温度采样
 	parkingPrevStates   map[int64]*parkingPrevState // 上一次状态（用于事件检测）
+	parkingTamperAlerts map[int64]time.Time         // 最近一次疑似入侵告警时间（用于冷却）
+
+	// 各轮胎连续低于胎压阈值的轮询次数 (car_id -> 轮胎位置 -> 次数)
+	tpmsLowPolls map[int64]map[string]int
+
+	// 充电中上一次轮询的功率和电量 (用于自适应充电轮询间隔)
+	chargePollSamples map[int64]chargePollSample
+
+	// 每辆车最近一次获取的 vehicle_data (用于调试接口)
+	lastVehicleData map[int64]*RawVehicleData
 
 	// Tesla Streaming API 客户端 (双链路架构)
 	streamingClients map[int64]*tesla.StreamingClient // 每辆车的 Streaming 客户端
+	streamingOwners  map[int64]*tesla.Client          // Streaming 客户端所属账号的 API 客户端 (用于同步刷新后的 Token)
 	streamingCtx     context.Context                  // Streaming 上下文
 	streamingCancel  context.CancelFunc               // 取消函数
+
+	// Tesla vehicle_id -> car_id (Streaming 数据按 vehicle_id 推送，同步车辆时维护，避免每条数据查库)
+	carIDsByVehicleID map[int64]int64
+}
+
+// broadcastKey 决定是否立即推送的关键状态
+type broadcastKey struct {
+	state         string
+	chargingState string
 }
 
 // tempSample 温度采样
@@ -69,47 +170,101 @@ type parkingPrevState struct {
 	FrunkOpen     bool
 	Locked        bool
 	SentryMode    bool
+	ValetMode     bool
 	IsClimateOn   bool
 	IsUserPresent bool
+	CarVersion    string
 }
 
 // NewVehicleService 创建车辆服务
 func NewVehicleService(
 	cfg *config.Config,
 	logger *zap.Logger,
-	teslaClient *tesla.Client,
 	carRepo *repository.CarRepository,
 	posRepo *repository.PositionRepository,
 	driveRepo *repository.DriveRepository,
 	chargeRepo *repository.ChargeRepository,
 	parkingRepo *repository.ParkingRepository,
+	geofenceRepo *repository.GeofenceRepository,
+	settingsRepo *repository.SettingsRepository,
+	accountRepo *repository.AccountRepository,
+	tripRepo *repository.TripRepository,
+	stateRepo *repository.StateRepository,
+	maintenanceRepo *repository.MaintenanceRepository,
 	wsHub *ws.Hub,
 ) *VehicleService {
 	// 创建逆地理编码客户端（支持高德/Nominatim）
-	geo := geocoder.NewClient(cfg.AmapAPIKey, logger)
-	logger.Info("Geocoder initialized", zap.String("provider", geo.GetProvider()))
+	geo := geocoder.NewClient(cfg.AmapAPIKey, cfg.GeocodeCachePrecision, cfg.GeocodeCacheSize, logger)
+	geo.SetLanguage(cfg.GeocodeLanguage)
+	geo.SetAddressFormat(cfg.GeocodeAddressFormat)
+	geo.SetAmapGCJ02(cfg.AmapGCJ02)
+	logger.Info("Geocoder initialized",
+		zap.String("provider", geo.GetProvider()),
+		zap.String("language", cfg.GeocodeLanguage),
+		zap.String("address_format", cfg.GeocodeAddressFormat))
+
+	matcher := mapmatch.NewClient(cfg.MapMatchURL, logger)
+	if matcher.IsConfigured() {
+		logger.Info("Map matching enabled", zap.String("provider", matcher.GetProvider()))
+	}
+
+	concurrency := cfg.PollConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
 
 	svc := &VehicleService{
 		cfg:                 cfg,
 		logger:              logger,
-		teslaClient:         teslaClient,
+		accounts:            make(map[string]*teslaAccount),
 		geocoder:            geo,
+		mapMatcher:          matcher,
 		carRepo:             carRepo,
+		carSync:             carRepo,
 		posRepo:             posRepo,
 		driveRepo:           driveRepo,
 		chargeRepo:          chargeRepo,
 		parkingRepo:         parkingRepo,
+		geofenceRepo:        geofenceRepo,
+		settingsRepo:        settingsRepo,
+		accountRepo:         accountRepo,
+		tripRepo:            tripRepo,
+		stateRepo:           stateRepo,
+		maintenanceRepo:     maintenanceRepo,
 		wsHub:               wsHub,
 		stopCh:              make(chan struct{}),
 		pollIntervals:       make(map[int64]time.Duration),
 		lastPollTimes:       make(map[int64]time.Time),
 		lastUsedTimes:       make(map[int64]time.Time),
+		unlockedSince:       make(map[int64]time.Time),
+		driveEndPending:     make(map[int64]time.Time),
+		chargeEnds:          make(map[int64]*positionMark),
+		lastBroadcast:       make(map[int64]broadcastKey),
+		lastPositions:       make(map[int64]*positionMark),
+		carSettings:         make(map[int64]map[string]string),
+		pollSem:             make(chan struct{}, concurrency),
+		pollInFlight:        make(map[int64]bool),
+		pollFailures:        make(map[int64]int),
+		pollLastErrors:      make(map[int64]string),
+		rateLimits:          make(map[int64]*rateLimitEvent),
+		unavailableCounts:   make(map[int64]int),
+		plugSessions:        make(map[int64]*activePlug),
+		liveDrives:          make(map[int64]*liveDriveAgg),
 		parkingClimateUsage: make(map[int64]time.Duration),
+		parkingPrecondUsage: make(map[int64]time.Duration),
 		parkingSentryUsage:  make(map[int64]time.Duration),
+		parkingSeatHeater:   make(map[int64]time.Duration),
+		parkingDefroster:    make(map[int64]time.Duration),
 		parkingLastCheck:    make(map[int64]time.Time),
 		parkingTempSamples:  make(map[int64][]tempSample),
 		parkingPrevStates:   make(map[int64]*parkingPrevState),
+		parkingTamperAlerts: make(map[int64]time.Time),
+		tpmsLowPolls:        make(map[int64]map[string]int),
+		chargePollSamples:   make(map[int64]chargePollSample),
+		lastVehicleData:     make(map[int64]*RawVehicleData),
 		streamingClients:    make(map[int64]*tesla.StreamingClient),
+		streamingOwners:     make(map[int64]*tesla.Client),
+		carIDsByVehicleID:   make(map[int64]int64),
 	}
 
 	// 创建状态管理器
@@ -120,6 +275,11 @@ func NewVehicleService(
 
 // Start 启动服务
 func (s *VehicleService) Start(ctx context.Context) error {
+	return s.start(ctx, nil)
+}
+
+// start 启动服务，synced 为调用方刚同步过的账号，启动时不再重复同步
+func (s *VehicleService) start(ctx context.Context, synced *teslaAccount) error {
 	s.mu.Lock()
 	if s.running {
 		s.mu.Unlock()
@@ -134,19 +294,48 @@ func (s *VehicleService) Start(ctx context.Context) error {
 	s.logger.Info("Starting vehicle service")
 
 	// 同步车辆列表
-	if err := s.syncVehicles(ctx); err != nil {
+	if _, err := s.syncVehicles(ctx, synced); err != nil {
 		s.mu.Lock()
 		s.running = false
 		s.mu.Unlock()
 		return fmt.Errorf("sync vehicles: %w", err)
 	}
 
+	// 加载车辆设置覆盖
+	if err := s.loadCarSettings(ctx); err != nil {
+		s.logger.Warn("Failed to load car settings, using global config", zap.Error(err))
+	}
+
 	// 启动轮询
 	s.wg.Add(1)
 	go s.pollLoop(ctx)
 
+	// 启动 Token 定期刷新任务
+	if s.cfg.TokenRefreshMargin > 0 {
+		s.wg.Add(1)
+		go s.tokenRefreshLoop(ctx)
+	}
+
+	// 启动位置数据清理任务
+	if s.cfg.PositionRetentionDays > 0 {
+		s.wg.Add(1)
+		go s.retentionLoop(ctx)
+	}
+
+	// 启动保养提醒检查任务
+	if s.cfg.MaintenanceCheckPeriod > 0 {
+		s.wg.Add(1)
+		go s.maintenanceLoop(ctx)
+	}
+
+	// 启动 Streaming 位置批量写入任务
+	if s.positionBatchingEnabled() {
+		s.wg.Add(1)
+		go s.positionFlushLoop(ctx)
+	}
+
 	// 启动 Streaming API（双链路架构）
-	if s.cfg.UseStreamingAPI {
+	if s.streamingWebSocketEnabled() {
 		s.startAllStreaming(ctx)
 	}
 
@@ -162,14 +351,21 @@ func (s *VehicleService) Stop() {
 		return
 	}
 	s.running = false
+	stopCh := s.stopCh
 	s.mu.Unlock()
 
 	s.logger.Info("Stopping vehicle service")
 
-	// 停止 Streaming
+	// 停止 Streaming，并等待进行中的位置写入进入缓冲区或落库
 	s.stopAllStreaming()
+	s.waitStreamWrites(streamWriteDrainTimeout)
 
-	close(s.stopCh)
+	// 关闭 stop channel (与 StreamingClient.Close 相同，已关闭时跳过，避免重复关闭 panic)
+	select {
+	case <-stopCh:
+	default:
+		close(stopCh)
+	}
 	s.wg.Wait()
 	s.logger.Info("Vehicle service stopped")
 }
@@ -184,32 +380,62 @@ func (s *VehicleService) Subscribe() <-chan *state.VehicleState {
 	return ch
 }
 
-// syncVehicles 同步车辆列表
-func (s *VehicleService) syncVehicles(ctx context.Context) error {
-	vehicles, err := s.teslaClient.ListVehicles(ctx)
-	if err != nil {
-		return fmt.Errorf("list vehicles from tesla: %w", err)
+// syncVehicles 同步所有账号的车辆列表，返回同步到的车辆
+// 单个账号同步失败不影响其他账号，全部失败时返回错误；skip 为已同步过的账号，视为同步成功
+func (s *VehicleService) syncVehicles(ctx context.Context, skip *teslaAccount) ([]*models.Car, error) {
+	s.mu.RLock()
+	accounts := make([]*teslaAccount, 0, len(s.accounts))
+	for _, acct := range s.accounts {
+		accounts = append(accounts, acct)
 	}
+	s.mu.RUnlock()
 
-	for _, v := range vehicles {
-		car := &models.Car{
-			TeslaID:        v.ID,
-			TeslaVehicleID: v.VehicleID,
-			VIN:            v.VIN,
-			Name:           v.DisplayName,
-		}
+	if len(accounts) == 0 {
+		return nil, ErrNotAuthenticated
+	}
 
-		if err := s.carRepo.Upsert(ctx, car); err != nil {
-			s.logger.Error("Failed to upsert car", zap.Error(err), zap.Int64("tesla_id", v.ID))
+	var lastErr error
+	cars := []*models.Car{}
+	synced := 0
+	for _, acct := range accounts {
+		if acct == skip {
+			synced++
+			continue
+		}
+		acctCars, err := s.syncAccount(ctx, acct)
+		if err != nil {
+			s.logger.Error("Failed to sync account vehicles", zap.String("account", acct.label), zap.Error(err))
+			lastErr = err
 			continue
 		}
+		cars = append(cars, acctCars...)
+		synced++
+	}
 
-		// 初始化状态机
-		s.stateManager.GetOrCreate(car.ID, v.State)
-		s.logger.Info("Synced vehicle", zap.String("name", car.Name), zap.String("vin", car.VIN), zap.String("state", v.State))
+	if synced == 0 {
+		return nil, lastErr
 	}
+	return cars, nil
+}
 
-	return nil
+// SyncVehicles 重新同步所有账号的车辆列表 (如账号下新增了车辆)，返回同步到的车辆
+// 新车辆会创建状态机并由下一轮轮询开始记录，服务运行中时为其启动 Streaming；
+// 已有车辆的状态机和 Streaming 连接保持不变，可在轮询运行时调用
+func (s *VehicleService) SyncVehicles(ctx context.Context) ([]*models.Car, error) {
+	cars, err := s.syncVehicles(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+	if running {
+		s.startMissingStreaming(cars)
+	}
+
+	s.logger.Info("Vehicles synced", zap.Int("count", len(cars)))
+	return cars, nil
 }
 
 // pollLoop 轮询循环 - 实现指数退避策略
@@ -237,61 +463,143 @@ func (s *VehicleService) pollLoop(ctx context.Context) {
 }
 
 // pollAllVehiclesWithBackoff 根据每辆车的状态使用不同的轮询间隔
+// 每辆车在独立的 goroutine 中轮询，由工作池限制并发数，
+// 单辆车的慢请求不会拖慢其他车辆的轮询
 func (s *VehicleService) pollAllVehiclesWithBackoff(ctx context.Context) {
-	cars, err := s.carRepo.List(ctx)
+	dbCtx, cancel := s.dbContext(ctx)
+	cars, err := s.carRepo.List(dbCtx)
+	cancel()
 	if err != nil {
 		s.logger.Error("Failed to list cars", zap.Error(err))
 		return
 	}
 
-	now := time.Now()
-
 	for _, car := range cars {
 		// 检查该车辆是否应该被轮询
 		if !s.shouldPollVehicle(car.ID) {
 			continue
 		}
 
-		// 获取当前状态，决定使用轻量轮询还是完整轮询
-		machine, ok := s.stateManager.Get(car.ID)
-		var currentState string
-		if ok {
-			currentState = machine.CurrentState()
+		// 上一次轮询尚未结束，跳过本轮
+		if !s.acquirePollSlot(car.ID) {
+			continue
 		}
 
-		s.logger.Debug("Polling vehicle with backoff",
-			zap.Int64("car_id", car.ID),
-			zap.String("name", car.Name),
-			zap.String("state", currentState),
-			zap.Duration("interval", s.getPollInterval(car.ID)))
-
-		var pollErr error
-		// 根据状态选择轮询方式
-		// suspended/asleep/offline 状态使用轻量轮询（只查状态，不唤醒）
-		if currentState == state.StateSuspended || currentState == state.StateAsleep || currentState == state.StateOffline {
-			pollErr = s.pollVehicleLightweight(ctx, car)
-		} else {
-			pollErr = s.pollVehicle(ctx, car)
-		}
+		s.wg.Add(1)
+		go func(car *models.Car) {
+			defer s.wg.Done()
+			defer s.releasePollSlot(car.ID)
+
+			if !s.acquireWorker(ctx) {
+				return
+			}
+			defer s.releaseWorker()
+
+			s.pollVehicleWithBackoff(ctx, car)
+		}(car)
+	}
+}
+
+// pollVehicleWithBackoff 轮询单个车辆并更新退避状态
+func (s *VehicleService) pollVehicleWithBackoff(ctx context.Context, car *models.Car) {
+	now := time.Now()
+
+	// 每次轮询使用独立的超时，防止挂起的请求长期占用工作池
+	pollCtx, cancel := context.WithTimeout(ctx, s.cfg.PollRequestTimeout)
+	defer cancel()
 
-		if pollErr != nil {
+	// 获取当前状态，决定使用轻量轮询还是完整轮询
+	machine, ok := s.stateManager.Get(car.ID)
+	var currentState string
+	if ok {
+		currentState = machine.CurrentState()
+	}
+
+	s.logger.Debug("Polling vehicle with backoff",
+		zap.Int64("car_id", car.ID),
+		zap.String("name", car.Name),
+		zap.String("state", currentState),
+		zap.Duration("interval", s.getPollInterval(car.ID)))
+
+	var pollErr error
+	// 根据状态选择轮询方式
+	// suspended/asleep/offline 状态使用轻量轮询（只查状态，不唤醒）
+	if currentState == state.StateSuspended || currentState == state.StateAsleep || currentState == state.StateOffline {
+		pollErr = s.pollVehicleLightweight(pollCtx, car)
+	} else {
+		pollErr = s.pollVehicle(pollCtx, car)
+	}
+
+	s.recordPollResult(car.ID, pollErr)
+
+	if pollErr != nil {
+		// 熔断期间请求未发送，不重复记录错误
+		if errors.Is(pollErr, tesla.ErrCircuitOpen) {
+			s.logger.Debug("Skipped polling vehicle, Tesla API circuit open", zap.Int64("car_id", car.ID))
+		} else {
 			s.logger.Error("Failed to poll vehicle", zap.Error(pollErr), zap.Int64("car_id", car.ID))
-			// 轮询失败时也应用退避策略
-			s.applyBackoff(car.ID)
 		}
+		// 轮询失败时也应用退避策略
+		s.applyBackoff(car.ID)
+	}
+
+	// 更新下次轮询时间
+	s.updateNextPollTime(car.ID, now)
+}
+
+// acquirePollSlot 标记车辆为轮询中，如果已在轮询中返回 false
+func (s *VehicleService) acquirePollSlot(carID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pollInFlight[carID] {
+		return false
+	}
+	s.pollInFlight[carID] = true
+	return true
+}
+
+// releasePollSlot 清除车辆的轮询中标记
+func (s *VehicleService) releasePollSlot(carID int64) {
+	s.mu.Lock()
+	delete(s.pollInFlight, carID)
+	s.mu.Unlock()
+}
+
+// acquireWorker 获取工作池名额，服务停止时返回 false
+func (s *VehicleService) acquireWorker(ctx context.Context) bool {
+	s.mu.RLock()
+	stopCh := s.stopCh
+	s.mu.RUnlock()
 
-		// 更新下次轮询时间
-		s.updateNextPollTime(car.ID, now)
+	select {
+	case s.pollSem <- struct{}{}:
+		return true
+	case <-stopCh:
+		return false
+	case <-ctx.Done():
+		return false
 	}
 }
 
+// releaseWorker 归还工作池名额
+func (s *VehicleService) releaseWorker() {
+	<-s.pollSem
+}
+
 // shouldPollVehicle 检查是否应该轮询该车辆
 func (s *VehicleService) shouldPollVehicle(carID int64) bool {
 	s.mu.RLock()
 	interval, intervalExists := s.pollIntervals[carID]
 	lastPoll, lastPollExists := s.lastPollTimes[carID]
+	_, coolingDown := s.rateLimitCooldown(carID)
 	s.mu.RUnlock()
 
+	// 限流冷却期间不轮询
+	if coolingDown {
+		return false
+	}
+
 	if !intervalExists || !lastPollExists {
 		// 首次轮询
 		return true
@@ -331,23 +639,23 @@ func (s *VehicleService) updateNextPollTime(carID int64, now time.Time) {
 			zap.Duration("interval", newInterval))
 
 	case state.StateCharging:
-		// 充电中：中频轮询
-		newInterval = s.cfg.PollIntervalCharging
-		s.logger.Debug("Vehicle charging, using charging interval",
+		// 充电中：按功率和电量的变化速度调整轮询间隔
+		newInterval = s.chargingPollInterval(carID, machine.GetState(), now)
+		s.logger.Debug("Vehicle charging, using adaptive charging interval",
 			zap.Int64("car_id", carID),
 			zap.Duration("interval", newInterval))
 
 	case state.StateSuspended:
 		// 暂停日志状态：使用较长的轮询间隔，让车辆有机会休眠（默认 21 分钟）
-		newInterval = s.cfg.SuspendPollInterval
+		newInterval = s.suspendPollInterval(carID)
 		s.logger.Debug("Vehicle suspended, using suspend poll interval",
 			zap.Int64("car_id", carID),
 			zap.Duration("interval", newInterval))
 
 	case state.StateAsleep, state.StateOffline:
-		// 睡眠/离线：使用指数退避
-		newInterval = s.calculateBackoffInterval(carID)
-		s.logger.Debug("Vehicle asleep/offline, using backoff interval",
+		// 睡眠/离线：从 POLL_INTERVAL_ASLEEP 指数退避到 POLL_INTERVAL_ASLEEP_MAX，从暂停状态进入时直接使用上限
+		newInterval = s.sleepPollInterval(carID)
+		s.logger.Debug("Vehicle asleep/offline, using sleep poll interval",
 			zap.Int64("car_id", carID),
 			zap.Duration("interval", newInterval))
 
@@ -362,26 +670,50 @@ func (s *VehicleService) updateNextPollTime(carID int64, now time.Time) {
 	s.mu.Lock()
 	s.pollIntervals[carID] = newInterval
 	s.lastPollTimes[carID] = now
+	if currentState != state.StateCharging {
+		delete(s.chargePollSamples, carID)
+	}
 	s.mu.Unlock()
 }
 
-// calculateBackoffInterval 计算退避间隔（不修改状态）
-func (s *VehicleService) calculateBackoffInterval(carID int64) time.Duration {
+// sleepPollInterval 计算睡眠/离线状态的轮询间隔（不修改状态）
+// 完整周期：在线 (POLL_INTERVAL_ONLINE) → 暂停 (SUSPEND_POLL_INTERVAL，给车辆休眠的机会) →
+// 睡眠/离线 (以上一次的间隔为基础按 POLL_BACKOFF_FACTOR 递增，限制在 POLL_INTERVAL_ASLEEP 到 POLL_INTERVAL_ASLEEP_MAX 之间；
+// 从暂停进入时上一次间隔较长，直接使用上限，不从头退避) → 唤醒 (重置退避，恢复 POLL_INTERVAL_ONLINE)
+func (s *VehicleService) sleepPollInterval(carID int64) time.Duration {
 	s.mu.RLock()
-	currentInterval, exists := s.pollIntervals[carID]
+	currentInterval := s.pollIntervals[carID]
 	s.mu.RUnlock()
 
-	if !exists || currentInterval < s.cfg.PollBackoffInitial {
-		return s.cfg.PollBackoffInitial
+	floor := s.cfg.PollIntervalAsleep
+	if floor <= 0 {
+		floor = s.cfg.PollBackoffInitial
+	}
+	ceiling := s.cfg.PollIntervalAsleepMax
+	if ceiling <= 0 {
+		ceiling = s.cfg.PollBackoffMax
 	}
+	return sleepBackoff(currentInterval, floor, ceiling, s.cfg.PollBackoffFactor)
+}
 
-	// 指数退避: interval * factor, 但不超过最大值
-	newInterval := time.Duration(float64(currentInterval) * s.cfg.PollBackoffFactor)
-	if newInterval > s.cfg.PollBackoffMax {
-		newInterval = s.cfg.PollBackoffMax
+// sleepBackoff 以 current 为基础按 factor 递增轮询间隔，结果限制在 [floor, ceiling] 内
+// current 为 0 (没有上一次间隔) 时返回 floor；ceiling 小于 floor 时以 floor 为准
+func sleepBackoff(current, floor, ceiling time.Duration, factor float64) time.Duration {
+	if ceiling < floor {
+		ceiling = floor
+	}
+	if current <= 0 {
+		return floor
 	}
 
-	return newInterval
+	next := time.Duration(float64(current) * factor)
+	if next < floor {
+		next = floor
+	}
+	if next > ceiling {
+		next = ceiling
+	}
+	return next
 }
 
 // applyBackoff 应用指数退避策略
@@ -423,9 +755,11 @@ func (s *VehicleService) resetBackoff(carID int64) {
 		zap.Duration("interval", s.cfg.PollBackoffInitial))
 }
 
-// pollAllVehicles 轮询所有车辆
+// pollAllVehicles 轮询所有车辆（并发执行，等待全部完成）
 func (s *VehicleService) pollAllVehicles(ctx context.Context) {
-	cars, err := s.carRepo.List(ctx)
+	dbCtx, cancel := s.dbContext(ctx)
+	cars, err := s.carRepo.List(dbCtx)
+	cancel()
 	if err != nil {
 		s.logger.Error("Failed to list cars", zap.Error(err))
 		return
@@ -433,41 +767,90 @@ func (s *VehicleService) pollAllVehicles(ctx context.Context) {
 
 	s.logger.Info("Polling all vehicles", zap.Int("count", len(cars)))
 
+	var wg sync.WaitGroup
 	for _, car := range cars {
-		if err := s.pollVehicle(ctx, car); err != nil {
-			s.logger.Error("Failed to poll vehicle", zap.Error(err), zap.Int64("car_id", car.ID))
-		} else {
-			s.logger.Info("Successfully polled vehicle", zap.Int64("car_id", car.ID), zap.String("name", car.Name))
+		if !s.acquirePollSlot(car.ID) {
+			continue
 		}
+
+		wg.Add(1)
+		go func(car *models.Car) {
+			defer wg.Done()
+			defer s.releasePollSlot(car.ID)
+
+			if !s.acquireWorker(ctx) {
+				return
+			}
+			defer s.releaseWorker()
+
+			pollCtx, cancel := context.WithTimeout(ctx, s.cfg.PollRequestTimeout)
+			defer cancel()
+
+			err := s.pollVehicle(pollCtx, car)
+			s.recordPollResult(car.ID, err)
+			if err != nil {
+				s.logger.Error("Failed to poll vehicle", zap.Error(err), zap.Int64("car_id", car.ID))
+			} else {
+				s.logger.Info("Successfully polled vehicle", zap.Int64("car_id", car.ID), zap.String("name", car.Name))
+			}
+		}(car)
+	}
+	wg.Wait()
+}
+
+// dbContext 为单次数据库操作创建独立的超时 context
+// 轮询的整体超时包含 Tesla API 和逆地理编码请求，挂起的查询不应占满整个轮询时间
+func (s *VehicleService) dbContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.cfg.DBQueryTimeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, s.cfg.DBQueryTimeout)
 }
 
 // pollVehicle 轮询单个车辆
 func (s *VehicleService) pollVehicle(ctx context.Context, car *models.Car) error {
 	machine := s.stateManager.GetOrCreate(car.ID, "")
 
+	client, err := s.clientForCar(car)
+	if err != nil {
+		return err
+	}
+
 	// 获取车辆数据
-	data, err := s.teslaClient.GetVehicleData(ctx, car.TeslaID)
+	data, err := client.GetVehicleData(ctx, car.TeslaID)
 	if err != nil {
 		if err == tesla.ErrVehicleUnavailable {
-			// 车辆不可用（可能在睡眠）
+			// 车辆不可用（可能在睡眠），单次 408 可能是瞬时错误，连续多次才判定为休眠
+			if !s.confirmUnavailable(car.ID) {
+				s.logger.Debug("Vehicle unavailable, waiting for confirmation before marking asleep",
+					zap.Int64("car_id", car.ID))
+				return nil
+			}
 			s.transitionToSleepOrOffline(machine, "asleep")
 			return nil
 		}
 		return err
 	}
+	s.resetUnavailable(car.ID)
+	s.storeVehicleData(car.ID, data)
 
 	// 根据 API 返回的 state 字段更新状态机
 	s.handleVehicleStateFromAPI(machine, data.State)
 
-	// 更新车辆配置信息（如 model、exterior_color 等）
-	if data.VehicleConfig != nil {
-		s.updateCarConfig(ctx, car, data.VehicleConfig)
-	}
+	// 更新车辆配置信息（如 model、exterior_color、单位偏好等）
+	s.updateCarConfig(ctx, car, data)
 
 	// 更新状态机数据
 	s.updateMachineFromData(machine, data)
 
+	// 暂停日志期间离开停车所在的地理围栏时恢复日志
+	s.resumeOnGeofenceExit(ctx, car.ID, machine, data.DriveState)
+
+	// 胎压检测
+	if data.VehicleState != nil {
+		s.checkTirePressure(ctx, car.ID, data.VehicleState)
+	}
+
 	// 处理状态变化（驾驶、充电等）
 	// 注意：必须在记录位置之前处理状态变化，这样才能正确关联 drive_id
 	s.handleStateTransitions(ctx, car, machine, data)
@@ -482,25 +865,14 @@ func (s *VehicleService) pollVehicle(ctx context.Context, car *models.Car) error
 		s.updateActiveChargingSnapshot(ctx, car, data)
 	}
 
-	// 记录位置（仅在线时）
-	if data.State == "online" && data.DriveState != nil {
-		pos := s.createPosition(car.ID, data)
-
-		// 如果正在驾驶，关联到当前活动的行程
-		if machine.CurrentState() == state.StateDriving {
-			activeDrive, err := s.driveRepo.GetActiveDrive(ctx, car.ID)
-			if err == nil && activeDrive != nil {
-				pos.DriveID = &activeDrive.ID
-			}
-		}
-
-		if err := s.posRepo.Create(ctx, pos); err != nil {
-			s.logger.Error("Failed to create position", zap.Error(err))
-		}
+	// 记录位置（仅在线时，未驾驶时按 ONLINE_POSITION_INTERVAL 降低频率，跳过重复位置）
+	if data.State == "online" && data.DriveState != nil &&
+		s.shouldRecordPosition(car.ID, machine.CurrentState(), data, time.Now()) {
+		s.recordPolledPosition(ctx, car.ID, machine, data)
 	}
 
 	// 获取最新状态
-	currentState := machine.GetState()
+	currentState := withComputedFields(machine.GetState(), time.Now())
 
 	// 通知内部订阅者
 	s.notifySubscribers(currentState)
@@ -522,8 +894,13 @@ func (s *VehicleService) pollVehicleLightweight(ctx context.Context, car *models
 	machine := s.stateManager.GetOrCreate(car.ID, "")
 	currentState := machine.CurrentState()
 
+	client, err := s.clientForCar(car)
+	if err != nil {
+		return err
+	}
+
 	// 使用 GetVehicle API（不会唤醒车辆）
-	vehicle, err := s.teslaClient.GetVehicle(ctx, car.TeslaID)
+	vehicle, err := client.GetVehicle(ctx, car.TeslaID)
 	if err != nil {
 		s.logger.Debug("Lightweight poll failed",
 			zap.Int64("car_id", car.ID),
@@ -575,7 +952,7 @@ func (s *VehicleService) pollVehicleLightweight(ctx context.Context, car *models
 	}
 
 	// 广播状态更新
-	s.broadcastState(machine.GetState())
+	s.broadcastState(withComputedFields(machine.GetState(), time.Now()))
 
 	return nil
 }
@@ -643,6 +1020,22 @@ func (s *VehicleService) transitionToSleepOrOffline(machine *state.Machine, targ
 		zap.String("target", targetState))
 }
 
+// confirmUnavailable 记录一次不可用 (408) 响应，连续次数达到 ASLEEP_CONFIRM_COUNT 时返回 true
+func (s *VehicleService) confirmUnavailable(carID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unavailableCounts[carID]++
+	return s.unavailableCounts[carID] >= s.cfg.AsleepConfirmCount
+}
+
+// resetUnavailable 成功获取车辆数据后清零连续不可用次数
+func (s *VehicleService) resetUnavailable(carID int64) {
+	s.mu.Lock()
+	delete(s.unavailableCounts, carID)
+	s.mu.Unlock()
+}
+
 // updateMachineFromData 从 API 数据更新状态机
 func (s *VehicleService) updateMachineFromData(machine *state.Machine, data *tesla.VehicleData) {
 	machine.UpdateState(func(vs *state.VehicleState) {
@@ -659,6 +1052,7 @@ func (s *VehicleService) updateMachineFromData(machine *state.Machine, data *tes
 			vs.ChargerCurrent = data.ChargeState.ChargerActualCurrent
 			vs.UsableBatteryLevel = data.ChargeState.UsableBatteryLevel
 			vs.IdealRangeKm = tesla.MilesToKm(data.ChargeState.IdealBatteryRange)
+			vs.ChargeEnergyAdded = data.ChargeState.ChargeEnergyAdded
 		}
 		if data.DriveState != nil {
 			vs.Latitude = data.DriveState.Latitude
@@ -675,10 +1069,12 @@ func (s *VehicleService) updateMachineFromData(machine *state.Machine, data *tes
 			vs.OutsideTemp = &outTemp
 			// 新增空调状态
 			vs.IsClimateOn = data.ClimateState.IsClimateOn
+			vs.IsPreconditioning = data.ClimateState.IsPreconditioning
 		}
 		if data.VehicleState != nil {
 			vs.Locked = data.VehicleState.Locked
 			vs.SentryMode = data.VehicleState.SentryMode
+			vs.ValetMode = data.VehicleState.ValetMode
 			// TPMS 胎压数据
 			vs.TpmsPressureFL = data.VehicleState.TpmsPressureFL
 			vs.TpmsPressureFR = data.VehicleState.TpmsPressureFR
@@ -717,7 +1113,7 @@ func (s *VehicleService) handleStateTransitions(ctx context.Context, car *models
 	// 检测驾驶状态
 	isDriving := data.DriveState != nil && data.DriveState.ShiftState != nil && *data.DriveState.ShiftState != "P"
 	if isDriving && currentState != state.StateDriving {
-		if machine.CanTransition(state.EventStartDriving) {
+		if machine.CanTransition(state.EventStartDriving) && !s.suppressDriveAfterCharge(car.ID, data, time.Now()) {
 			// 结束停车记录（如果有）
 			s.endParking(ctx, car, data)
 			machine.Trigger(state.EventStartDriving)
@@ -726,12 +1122,21 @@ func (s *VehicleService) handleStateTransitions(ctx context.Context, car *models
 			s.markVehicleActive(car.ID)
 		}
 	} else if !isDriving && currentState == state.StateDriving {
-		machine.Trigger(state.EventStopDriving)
-		s.endDrive(ctx, car, data)
-		// 开始停车记录
-		s.startParking(ctx, car, data)
+		// 停稳持续 DRIVE_END_DEBOUNCE 后才结束行程，期间仍按驾驶状态记录位置
+		if parkedAt, ok := s.driveEndConfirmed(car.ID, data, time.Now()); ok {
+			machine.Trigger(state.EventStopDriving)
+			s.endDrive(ctx, car, data, parkedAt)
+			// 开始停车记录
+			s.startParking(ctx, car, data)
+		}
+	} else if isDriving {
+		// 去抖期间重新挂入行驶挡 (如倒车入库)，继续当前行程
+		s.clearDriveEndPending(car.ID)
 	}
 
+	// 插枪会话 (先于充电记录，保证会话开始时间不晚于其中的充电)
+	s.trackPlugSession(ctx, car, data)
+
 	// 检测充电状态
 	isCharging := data.ChargeState != nil && data.ChargeState.ChargingState == "Charging"
 	if isCharging && currentState != state.StateCharging {
@@ -755,6 +1160,26 @@ func (s *VehicleService) handleStateTransitions(ctx context.Context, car *models
 // onStateChange 状态变化回调
 func (s *VehicleService) onStateChange(carID int64, from, to string) {
 	s.logger.Info("Vehicle state changed", zap.Int64("car_id", carID), zap.String("from", from), zap.String("to", to))
+	// 无论以何种方式离开驾驶状态 (正常结束、离线、休眠等)，都不再保留上一次行程的停稳时间
+	if from == state.StateDriving {
+		s.clearDriveEndPending(carID)
+	}
+	s.recordState(carID, to)
+}
+
+// recordState 保存状态变化到 states 表 (状态历史)
+func (s *VehicleService) recordState(carID int64, st string) {
+	dbCtx, cancel := s.dbContext(context.Background())
+	err := s.stateRepo.Start(dbCtx, carID, st, time.Now())
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to record state", zap.Int64("car_id", carID), zap.String("state", st), zap.Error(err))
+	}
+}
+
+// GetStateHistory 获取车辆最近的状态变化记录
+func (s *VehicleService) GetStateHistory(ctx context.Context, carID int64, limit int) ([]*models.State, error) {
+	return s.stateRepo.ListRecent(ctx, carID, limit)
 }
 
 // notifySubscribers 通知订阅者（内部 channel 订阅者）
@@ -772,12 +1197,45 @@ func (s *VehicleService) notifySubscribers(vs *state.VehicleState) {
 }
 
 // broadcastState 广播状态到 WebSocket
+// 状态切换（开始驾驶、充电完成等）立即推送，其余更新由 Hub 合并后批量发送
 func (s *VehicleService) broadcastState(vs *state.VehicleState) {
 	if s.wsHub == nil {
 		return
 	}
-	s.wsHub.BroadcastStateUpdate(vs)
-	s.logger.Debug("Broadcasted state update via WebSocket", zap.Int64("car_id", vs.CarID))
+
+	// 复制后脱敏，不影响内部订阅者收到的状态
+	redacted := *vs
+	vs = &redacted
+	s.RedactCoordinates(context.Background(), vs)
+
+	key := broadcastKey{state: vs.CurrentState, chargingState: vs.ChargingState}
+	s.mu.Lock()
+	prev, exists := s.lastBroadcast[vs.CarID]
+	s.lastBroadcast[vs.CarID] = key
+	s.mu.Unlock()
+
+	if !exists || prev != key {
+		s.wsHub.BroadcastStateUpdateNow(vs.CarID, vs)
+		s.logger.Debug("Broadcasted state change via WebSocket",
+			zap.Int64("car_id", vs.CarID),
+			zap.String("state", vs.CurrentState))
+		return
+	}
+
+	s.wsHub.QueueStateUpdate(vs.CarID, vs)
+}
+
+// queueStreamState 推送 Streaming 位置点带来的实时状态 (位置、行程实时统计)
+// 状态切换仍由轮询路径的 broadcastState 立即推送；这里只进入 Hub 的合并队列，按 WS_FLUSH_INTERVAL 合并发送
+func (s *VehicleService) queueStreamState(vs *state.VehicleState) {
+	if s.wsHub == nil {
+		return
+	}
+
+	redacted := *vs
+	vs = &redacted
+	s.RedactCoordinates(context.Background(), vs)
+	s.wsHub.QueueStateUpdate(vs.CarID, vs)
 }
 
 // GetCars 获取车辆列表（用于 WebSocket 初始数据）
@@ -785,32 +1243,64 @@ func (s *VehicleService) GetCars(ctx context.Context) ([]*models.Car, error) {
 	return s.carRepo.List(ctx)
 }
 
-// updateCarConfig 更新车辆配置信息
-func (s *VehicleService) updateCarConfig(ctx context.Context, car *models.Car, config *tesla.VehicleConfig) {
+// updateCarConfig 按 vehicle_config 和 gui_settings 更新车辆配置信息
+func (s *VehicleService) updateCarConfig(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
 	needUpdate := false
 
-	if config.CarType != "" && car.Model != config.CarType {
-		car.Model = config.CarType
-		needUpdate = true
-	}
-	if config.ExteriorColor != "" && car.ExteriorColor != config.ExteriorColor {
-		car.ExteriorColor = config.ExteriorColor
-		needUpdate = true
-	}
-	if config.TrimBadging != "" && car.TrimBadging != config.TrimBadging {
-		car.TrimBadging = config.TrimBadging
-		needUpdate = true
+	if config := data.VehicleConfig; config != nil {
+		if config.CarType != "" && car.Model != config.CarType {
+			car.Model = config.CarType
+			needUpdate = true
+		}
+		if config.ExteriorColor != "" && car.ExteriorColor != config.ExteriorColor {
+			car.ExteriorColor = config.ExteriorColor
+			needUpdate = true
+		}
+		if config.TrimBadging != "" && car.TrimBadging != config.TrimBadging {
+			car.TrimBadging = config.TrimBadging
+			needUpdate = true
+		}
+		if config.WheelType != "" && car.WheelType != config.WheelType {
+			car.WheelType = config.WheelType
+			needUpdate = true
+		}
 	}
-	if config.WheelType != "" && car.WheelType != config.WheelType {
-		car.WheelType = config.WheelType
-		needUpdate = true
+
+	// 车机的单位偏好
+	if gui := data.GuiSettings; gui != nil {
+		if unit := gui.DistanceUnit(); unit != "" && car.DistanceUnit != unit {
+			car.DistanceUnit = unit
+			needUpdate = true
+		}
+		if unit := gui.TemperatureUnit(); unit != "" && car.TemperatureUnit != unit {
+			car.TemperatureUnit = unit
+			needUpdate = true
+		}
 	}
 
 	if needUpdate {
-		if err := s.carRepo.Update(ctx, car); err != nil {
+		dbCtx, cancel := s.dbContext(ctx)
+		defer cancel()
+		if err := s.carRepo.Update(dbCtx, car); err != nil {
 			s.logger.Error("Failed to update car config", zap.Error(err), zap.Int64("car_id", car.ID))
 		} else {
 			s.logger.Info("Updated car config", zap.Int64("car_id", car.ID), zap.String("model", car.Model))
 		}
 	}
 }
+
+// matchGeofence 查找坐标所在的地理围栏 ID，未命中返回 nil
+func (s *VehicleService) matchGeofence(ctx context.Context, lat, lng float64) *int64 {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	g, err := s.geofenceRepo.FindContaining(dbCtx, lat, lng)
+	if err != nil {
+		s.logger.Warn("Failed to match geofence", zap.Error(err))
+		return nil
+	}
+	if g == nil {
+		return nil
+	}
+	return &g.ID
+}
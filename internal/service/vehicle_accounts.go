@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// DefaultAccount 未指定账号时使用的标识，升级前的单账号 Token 也归属此账号
+const DefaultAccount = "default"
+
+// ErrNoAccount 车辆没有可用的 Tesla 账号
+var ErrNoAccount = errors.New("no tesla account for car")
+
+// ErrNotAuthenticated 尚未添加任何 Tesla 账号
+var ErrNotAuthenticated = errors.New("not authenticated")
+
+// ErrTokenExpired 提交的访问令牌已过期，且没有可用于刷新的 Refresh Token
+var ErrTokenExpired = errors.New("access token expired")
+
+// carSyncStore 同步车辆列表时使用的车辆存储，由 repository.CarRepository 实现
+type carSyncStore interface {
+	RebindByVIN(ctx context.Context, car *models.Car) (int64, int64, error)
+	Upsert(ctx context.Context, car *models.Car) error
+}
+
+// teslaAccount 已认证的 Tesla 账号，每个账号使用独立的 Token 和刷新流程
+type teslaAccount struct {
+	id     int64 // accounts 表 ID，同步车辆时确定
+	label  string
+	client *tesla.Client
+
+	// 刷新状态 (s.mu 保护)
+	lastRefresh    time.Time
+	refreshErr     string
+	reauthRequired bool // Refresh Token 已失效，需要重新提交 Token
+}
+
+// SetAccountToken 设置账号的 Token，账号不存在时创建对应的 API 客户端
+// 已有账号更新 Token 或自动刷新 Token 后，新 Token 会同步给该账号的 Streaming 客户端
+func (s *VehicleService) SetAccountToken(label string, token *tesla.Token) {
+	s.mu.Lock()
+	if acct, ok := s.accounts[label]; ok {
+		// 提交了新 Token，清除之前的刷新失败状态
+		acct.refreshErr = ""
+		acct.reauthRequired = false
+		s.mu.Unlock()
+		acct.client.SetToken(token)
+		s.pushStreamingToken(acct.client, token.AccessToken)
+		return
+	}
+	defer s.mu.Unlock()
+
+	client := s.newTeslaClient()
+	client.SetToken(token)
+	acct := &teslaAccount{label: label, client: client}
+	client.OnTokenRefreshed(func(t *tesla.Token) {
+		s.pushStreamingToken(client, t.AccessToken)
+		s.markTokenRefreshed(acct)
+		s.saveAccountTokens()
+	})
+	s.accounts[label] = acct
+}
+
+// newTeslaClient 按配置创建 Tesla API 客户端
+func (s *VehicleService) newTeslaClient() *tesla.Client {
+	client := tesla.NewClient(
+		s.cfg.TeslaAuthHost,
+		s.cfg.TeslaAPIHost,
+		s.cfg.TeslaClientID,
+		s.cfg.TeslaRedirectURI,
+	)
+	client.SetUserAgent(s.cfg.TeslaUserAgent, s.cfg.TeslaXUserAgent)
+	client.SetCircuitBreaker(s.cfg.TeslaBreakerThreshold, s.cfg.TeslaBreakerCooldown)
+	return client
+}
+
+// VerifyToken 用临时客户端校验提交的 Token，不影响已有账号
+// 访问令牌已过期时用 Refresh Token 刷新，返回校验通过的 Token (可能已刷新)
+func (s *VehicleService) VerifyToken(ctx context.Context, token *tesla.Token) (*tesla.Token, error) {
+	if token.IsExpired() && token.RefreshToken == "" {
+		return nil, ErrTokenExpired
+	}
+
+	client := s.newTeslaClient()
+	client.SetToken(token)
+	if err := client.VerifyToken(ctx); err != nil {
+		return nil, err
+	}
+	return client.GetToken(), nil
+}
+
+// HasAccounts 是否已有认证的账号
+func (s *VehicleService) HasAccounts() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.accounts) > 0
+}
+
+// AccountTokens 获取所有账号当前的 Token (用于持久化)
+func (s *VehicleService) AccountTokens() map[string]*tesla.Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := make(map[string]*tesla.Token, len(s.accounts))
+	for label, acct := range s.accounts {
+		if token := acct.client.GetToken(); token != nil {
+			tokens[label] = token
+		}
+	}
+	return tokens
+}
+
+// AddAccount 添加或更新账号 Token 并同步该账号下的车辆
+// 提供了 Refresh Token 时先尝试刷新；服务未运行时启动服务，已运行时为新车辆启动 Streaming
+func (s *VehicleService) AddAccount(ctx context.Context, label string, token *tesla.Token) error {
+	s.SetAccountToken(label, token)
+
+	s.mu.RLock()
+	acct := s.accounts[label]
+	running := s.running
+	s.mu.RUnlock()
+
+	// 先保存账号记录，后续同步和 Token 持久化都依赖账号 ID
+	if _, err := s.ensureAccountID(ctx, acct); err != nil {
+		return fmt.Errorf("save account: %w", err)
+	}
+
+	if token.RefreshToken != "" {
+		s.logger.Info("Attempting to refresh access token using refresh token...", zap.String("account", label))
+		if err := acct.client.RefreshToken(ctx); err != nil {
+			s.logger.Warn("Failed to refresh token, will try with provided access token",
+				zap.String("account", label),
+				zap.Error(err))
+		} else {
+			s.logger.Info("Successfully refreshed access token", zap.String("account", label))
+		}
+	}
+
+	// 先单独同步该账号，确保新 Token 无效时返回错误
+	cars, err := s.syncAccount(ctx, acct)
+	if err != nil {
+		return fmt.Errorf("sync vehicles: %w", err)
+	}
+
+	if !running {
+		return s.start(ctx, acct)
+	}
+
+	// 服务已在运行，为新同步的车辆启动 Streaming
+	s.startMissingStreaming(cars)
+	return nil
+}
+
+// ensureAccountID 获取或创建账号记录，并记录账号 ID
+func (s *VehicleService) ensureAccountID(ctx context.Context, acct *teslaAccount) (int64, error) {
+	s.mu.RLock()
+	id := acct.id
+	s.mu.RUnlock()
+	if id != 0 {
+		return id, nil
+	}
+
+	account, err := s.accountRepo.GetOrCreate(ctx, acct.label)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	acct.id = account.ID
+	s.mu.Unlock()
+	return account.ID, nil
+}
+
+// syncAccount 同步单个账号下的车辆列表，并将车辆关联到该账号
+func (s *VehicleService) syncAccount(ctx context.Context, acct *teslaAccount) ([]*models.Car, error) {
+	accountID, err := s.ensureAccountID(ctx, acct)
+	if err != nil {
+		return nil, err
+	}
+
+	vehicles, err := acct.client.ListVehicles(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list vehicles from tesla: %w", err)
+	}
+
+	var cars []*models.Car
+	for _, v := range vehicles {
+		car := &models.Car{
+			TeslaID:        v.ID,
+			TeslaVehicleID: v.VehicleID,
+			VIN:            v.VIN,
+			Name:           v.DisplayName,
+			AccountID:      &accountID,
+		}
+
+		// 同一 VIN 换了 tesla_id (账号迁移、车辆过户)：沿用原有车辆记录
+		prevTeslaID, prevVehicleID, err := s.carSync.RebindByVIN(ctx, car)
+		if err != nil {
+			s.logger.Error("Failed to rebind car with new Tesla ID",
+				zap.Error(err),
+				zap.String("vin", v.VIN),
+				zap.Int64("tesla_id", v.ID))
+			continue
+		}
+		if prevTeslaID != 0 {
+			s.logger.Warn("Car re-registered with a new Tesla ID, keeping existing history",
+				zap.String("account", acct.label),
+				zap.String("vin", v.VIN),
+				zap.Int64("old_tesla_id", prevTeslaID),
+				zap.Int64("tesla_id", v.ID))
+			if prevVehicleID != v.VehicleID {
+				s.stopStreaming(prevVehicleID)
+				s.mu.Lock()
+				delete(s.carIDsByVehicleID, prevVehicleID)
+				s.mu.Unlock()
+			}
+		}
+
+		if err := s.carSync.Upsert(ctx, car); err != nil {
+			s.logger.Error("Failed to upsert car", zap.Error(err), zap.Int64("tesla_id", v.ID))
+			continue
+		}
+
+		s.mu.Lock()
+		s.carIDsByVehicleID[car.TeslaVehicleID] = car.ID
+		s.mu.Unlock()
+
+		// 初始化状态机，首次创建时记录初始状态 (与上次记录的状态相同时不重复记录)
+		if _, ok := s.stateManager.Get(car.ID); !ok {
+			machine := s.stateManager.GetOrCreate(car.ID, v.State)
+			s.recordState(car.ID, machine.CurrentState())
+		}
+		s.logger.Info("Synced vehicle",
+			zap.String("account", acct.label),
+			zap.String("name", car.Name),
+			zap.String("vin", car.VIN),
+			zap.String("state", v.State))
+		cars = append(cars, car)
+	}
+
+	return cars, nil
+}
+
+// clientForCar 获取车辆所属账号的 API 客户端
+// 未关联账号的车辆 (如导入的历史数据) 使用默认账号
+func (s *VehicleService) clientForCar(car *models.Car) (*tesla.Client, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if car.AccountID != nil {
+		for _, acct := range s.accounts {
+			if acct.id == *car.AccountID {
+				return acct.client, nil
+			}
+		}
+	} else if acct, ok := s.accounts[DefaultAccount]; ok {
+		return acct.client, nil
+	}
+	return nil, fmt.Errorf("%w: car_id=%d", ErrNoAccount, car.ID)
+}
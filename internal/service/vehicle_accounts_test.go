@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/config"
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// memCarStore 内存中的车辆存储，按 CarRepository 的 SQL 语义实现 RebindByVIN 和 Upsert
+type memCarStore struct {
+	cars   []*models.Car
+	nextID int64
+}
+
+func (m *memCarStore) RebindByVIN(_ context.Context, car *models.Car) (int64, int64, error) {
+	for _, c := range m.cars {
+		if c.VIN == car.VIN && c.TeslaID != car.TeslaID {
+			prevTeslaID, prevVehicleID := c.TeslaID, c.TeslaVehicleID
+			c.TeslaID, c.TeslaVehicleID = car.TeslaID, car.TeslaVehicleID
+			return prevTeslaID, prevVehicleID, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+func (m *memCarStore) Upsert(_ context.Context, car *models.Car) error {
+	for _, c := range m.cars {
+		if c.TeslaID == car.TeslaID {
+			c.Name = car.Name
+			if car.AccountID != nil {
+				c.AccountID = car.AccountID
+			}
+			car.ID = c.ID
+			return nil
+		}
+	}
+	for _, c := range m.cars {
+		if c.VIN == car.VIN {
+			return fmt.Errorf("duplicate key value violates unique constraint \"cars_vin_key\"")
+		}
+	}
+	m.nextID++
+	stored := *car
+	stored.ID = m.nextID
+	m.cars = append(m.cars, &stored)
+	car.ID = stored.ID
+	return nil
+}
+
+// productsServer 按 Bearer Token 返回对应账号车辆列表的 Tesla API
+func productsServer(t *testing.T, vehicles map[string]tesla.Vehicle) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v, ok := vehicles[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok || r.URL.Path != "/api/1/products" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"response": []tesla.Vehicle{v}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestSyncAccountRebindsVINReportedByAnotherAccount(t *testing.T) {
+	const vin = "5YJ3E7EB0KF000001"
+	server := productsServer(t, map[string]tesla.Vehicle{
+		"token-a": {ID: 11, VehicleID: 111, VIN: vin, DisplayName: "Model 3", State: "asleep"},
+		"token-b": {ID: 22, VehicleID: 222, VIN: vin, DisplayName: "Model 3", State: "asleep"},
+	})
+
+	store := &memCarStore{}
+	s := NewVehicleService(&config.Config{TeslaAPIHost: server.URL}, zap.NewNop(),
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	s.carSync = store
+	// 账号已保存，车辆状态机已存在 (不写数据库)
+	for i, label := range []string{"a", "b"} {
+		s.SetAccountToken(label, &tesla.Token{AccessToken: "token-" + label, ExpiresIn: 3600, CreatedAt: time.Now()})
+		s.accounts[label].id = int64(i + 1)
+	}
+	s.stateManager.GetOrCreate(1, "asleep")
+
+	ctx := context.Background()
+	carsA, err := s.syncAccount(ctx, s.accounts["a"])
+	if err != nil || len(carsA) != 1 {
+		t.Fatalf("sync account a: cars=%d err=%v", len(carsA), err)
+	}
+	carsB, err := s.syncAccount(ctx, s.accounts["b"])
+	if err != nil || len(carsB) != 1 {
+		t.Fatalf("sync account b: cars=%d err=%v, want the VIN collision handled", len(carsB), err)
+	}
+
+	if len(store.cars) != 1 {
+		t.Fatalf("stored cars = %d, want the existing car reused", len(store.cars))
+	}
+	car := store.cars[0]
+	if carsB[0].ID != carsA[0].ID {
+		t.Errorf("car id = %d, want existing car %d", carsB[0].ID, carsA[0].ID)
+	}
+	if car.TeslaID != 22 || car.TeslaVehicleID != 222 {
+		t.Errorf("tesla ids = %d/%d, want 22/222", car.TeslaID, car.TeslaVehicleID)
+	}
+	if car.AccountID == nil || *car.AccountID != 2 {
+		t.Errorf("account_id = %v, want account b (2)", car.AccountID)
+	}
+
+	if _, ok := s.carIDsByVehicleID[111]; ok {
+		t.Error("old vehicle id still mapped to the car")
+	}
+	if id := s.carIDsByVehicleID[222]; id != car.ID {
+		t.Errorf("vehicle 222 maps to car %d, want %d", id, car.ID)
+	}
+}
+
+// newTestVehicleService 创建使用 db 和指定 Tesla API 地址的服务
+func newTestVehicleService(db *repository.DB, apiHost string) *VehicleService {
+	cfg := &config.Config{TeslaAPIHost: apiHost}
+	return NewVehicleService(cfg, zap.NewNop(),
+		repository.NewCarRepository(db),
+		repository.NewPositionRepository(db),
+		repository.NewDriveRepository(db),
+		repository.NewChargeRepository(db),
+		repository.NewParkingRepository(db),
+		repository.NewGeofenceRepository(db),
+		repository.NewSettingsRepository(db),
+		repository.NewAccountRepository(db),
+		repository.NewTripRepository(db),
+		repository.NewStateRepository(db),
+		repository.NewMaintenanceRepository(db),
+		nil,
+	)
+}
+
+func TestSyncAccountVINCollisionAcrossAccounts(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+
+	suffix := time.Now().UnixNano()
+	vin := fmt.Sprintf("TEST%013d", suffix%1e13)
+	labelA, labelB := fmt.Sprintf("test-a-%d", suffix), fmt.Sprintf("test-b-%d", suffix)
+	teslaIDA, teslaIDB := suffix%1e9+1, suffix%1e9+2
+
+	// 两个账号下报告同一 VIN，Tesla ID 不同 (车辆过户到另一个账号)
+	vehicles := map[string]tesla.Vehicle{
+		"token-a": {ID: teslaIDA, VehicleID: teslaIDA + 100, VIN: vin, DisplayName: "Test", State: "asleep"},
+		"token-b": {ID: teslaIDB, VehicleID: teslaIDB + 100, VIN: vin, DisplayName: "Test", State: "asleep"},
+	}
+	server := productsServer(t, vehicles)
+
+	t.Cleanup(func() {
+		db.Pool.Exec(ctx, `DELETE FROM states WHERE car_id IN (SELECT id FROM cars WHERE vin = $1)`, vin)
+		db.Pool.Exec(ctx, `DELETE FROM cars WHERE vin = $1`, vin)
+		db.Pool.Exec(ctx, `DELETE FROM accounts WHERE label IN ($1, $2)`, labelA, labelB)
+	})
+
+	s := newTestVehicleService(db, server.URL)
+	for label, token := range map[string]string{labelA: "token-a", labelB: "token-b"} {
+		s.SetAccountToken(label, &tesla.Token{AccessToken: token, ExpiresIn: 3600, CreatedAt: time.Now()})
+	}
+
+	carsA, err := s.syncAccount(ctx, s.accounts[labelA])
+	if err != nil || len(carsA) != 1 {
+		t.Fatalf("sync account A: cars=%d err=%v", len(carsA), err)
+	}
+	carsB, err := s.syncAccount(ctx, s.accounts[labelB])
+	if err != nil || len(carsB) != 1 {
+		t.Fatalf("sync account B: cars=%d err=%v, want the VIN collision handled", len(carsB), err)
+	}
+
+	car, err := s.carRepo.GetByVIN(ctx, vin)
+	if err != nil || car == nil {
+		t.Fatalf("get car by VIN: car=%v err=%v", car, err)
+	}
+	if car.ID != carsA[0].ID {
+		t.Errorf("car id = %d, want existing car %d to be kept", car.ID, carsA[0].ID)
+	}
+	if car.TeslaID != teslaIDB {
+		t.Errorf("tesla_id = %d, want %d", car.TeslaID, teslaIDB)
+	}
+	if car.AccountID == nil || *car.AccountID != s.accounts[labelB].id {
+		t.Errorf("account_id = %v, want account B (%d)", car.AccountID, s.accounts[labelB].id)
+	}
+}
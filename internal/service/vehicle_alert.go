@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// alertWebhookTimeout 告警 Webhook 请求超时
+const alertWebhookTimeout = 10 * time.Second
+
+var alertHTTPClient = &http.Client{Timeout: alertWebhookTimeout}
+
+// sendAlert 推送车辆告警
+// 通过 WebSocket 广播 (保存到事件缓冲区供重连补发)，配置了 ALERT_WEBHOOK_URL 时同时以
+// 与 WebSocket 消息相同的格式 ({"type": ..., "data": ...}) POST 到该地址
+func (s *VehicleService) sendAlert(carID int64, msgType string, payload map[string]interface{}) {
+	if s.wsHub != nil {
+		s.wsHub.BroadcastEvent(carID, msgType, payload)
+	}
+
+	if s.cfg.AlertWebhookURL == "" {
+		return
+	}
+	go func() {
+		if err := s.postAlertWebhook(msgType, payload); err != nil {
+			s.logger.Warn("Failed to send alert webhook",
+				zap.Int64("car_id", carID),
+				zap.String("type", msgType),
+				zap.Error(err))
+		}
+	}()
+}
+
+// postAlertWebhook 发送告警 Webhook 请求
+func (s *VehicleService) postAlertWebhook(msgType string, payload map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"type": msgType, "data": payload})
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertWebhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.AlertWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := alertHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
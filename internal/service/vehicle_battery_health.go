@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// 电池健康度估算参数
+const (
+	healthMinSocDelta      = 30    // 参与估算的充电至少充入的电量 (%)，变化越大取整误差越小
+	healthMinEndLevel      = 80    // 参与估算的充电结束电量下限 (%)，只使用接近充满的充电
+	healthMinCapacityKwh   = 20.0  // 估算容量下限 (kWh)，超出合理范围的视为充电量上报异常
+	healthMaxCapacityKwh   = 150.0 // 估算容量上限 (kWh)
+	healthRecentSamples    = 10    // 当前容量取最近多少次充电估算值的中位数
+	healthBaselineSamples  = 5     // 未设置电池容量时，取最早多少次充电的估算值作为基准
+	healthHighConfidence   = 10    // 最近样本数达到该值时为 high
+	healthMediumConfidence = 5     // 最近样本数达到该值时为 medium
+)
+
+// GetBatteryHealth 按充电记录估算电池可用容量及其按月趋势
+// 每次接近充满的充电按 充电量 / 可用电量变化 估算容量，当前容量取最近几次的中位数；
+// 基准容量优先使用车辆设置的 battery_capacity_kwh，未设置时使用最早几次充电的估算值
+func (s *VehicleService) GetBatteryHealth(ctx context.Context, carID int64) (*models.BatteryHealth, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	samples, err := s.chargeRepo.ListCapacitySamples(dbCtx, carID, healthMinSocDelta, healthMinEndLevel)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	type estimate struct {
+		sample   *models.CapacitySample
+		capacity float64
+	}
+	var estimates []estimate
+	for _, sample := range samples {
+		capacity := sample.EnergyAddedKwh / float64(sample.EndLevel-sample.StartLevel) * 100
+		if capacity < healthMinCapacityKwh || capacity > healthMaxCapacityKwh {
+			continue
+		}
+		estimates = append(estimates, estimate{sample: sample, capacity: capacity})
+	}
+
+	health := &models.BatteryHealth{
+		Confidence:  "none",
+		SampleCount: len(estimates),
+		Monthly:     []*models.BatteryHealthMonth{},
+	}
+	if len(estimates) == 0 {
+		return health, nil
+	}
+
+	// 按月汇总 (样本按开始时间升序)
+	var monthCaps []float64
+	var monthRanges []float64
+	var month *models.BatteryHealthMonth
+	flush := func() {
+		if month == nil {
+			return
+		}
+		month.CapacityKwh = roundTo(median(monthCaps), 1)
+		if len(monthRanges) > 0 {
+			avg := roundTo(mean(monthRanges), 1)
+			month.FullRangeKm = &avg
+		}
+		health.Monthly = append(health.Monthly, month)
+	}
+	for _, e := range estimates {
+		key := e.sample.StartTime.In(s.cfg.Location).Format("2006-01")
+		if month == nil || month.Month != key {
+			flush()
+			month = &models.BatteryHealthMonth{Month: key}
+			monthCaps, monthRanges = nil, nil
+		}
+		month.Sessions++
+		monthCaps = append(monthCaps, e.capacity)
+		if e.sample.FullRangeKm != nil {
+			monthRanges = append(monthRanges, *e.sample.FullRangeKm)
+		}
+	}
+	flush()
+
+	// 当前容量: 最近几次的中位数
+	recent := estimates
+	if len(recent) > healthRecentSamples {
+		recent = recent[len(recent)-healthRecentSamples:]
+	}
+	var recentCaps, recentRanges []float64
+	for _, e := range recent {
+		recentCaps = append(recentCaps, e.capacity)
+		if e.sample.FullRangeKm != nil {
+			recentRanges = append(recentRanges, *e.sample.FullRangeKm)
+		}
+	}
+	current := roundTo(median(recentCaps), 1)
+	health.EstimatedCapacityKwh = &current
+	health.RecentSampleCount = len(recent)
+	if len(recentRanges) > 0 {
+		fullRange := roundTo(median(recentRanges), 1)
+		health.FullRangeKm = &fullRange
+	}
+
+	switch {
+	case len(recent) >= healthHighConfidence:
+		health.Confidence = "high"
+	case len(recent) >= healthMediumConfidence:
+		health.Confidence = "medium"
+	default:
+		health.Confidence = "low"
+	}
+
+	// 基准容量
+	var original float64
+	if _, ok := s.carSetting(carID, SettingBatteryCapacityKwh); ok {
+		original = s.batteryCapacityKwh(carID)
+		health.BaselineSource = "setting"
+	} else {
+		earliest := estimates
+		if len(earliest) > healthBaselineSamples {
+			earliest = earliest[:healthBaselineSamples]
+		}
+		var caps []float64
+		for _, e := range earliest {
+			caps = append(caps, e.capacity)
+		}
+		original = roundTo(median(caps), 1)
+		health.BaselineSource = "earliest"
+	}
+	if original > 0 {
+		health.OriginalCapacityKwh = &original
+		pct := roundTo(current/original*100, 1)
+		health.HealthPct = &pct
+	}
+
+	return health, nil
+}
+
+// median 中位数 (不修改原切片)，空切片返回 0
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// mean 平均值，空切片返回 0
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// roundTo 四舍五入到指定小数位
+func roundTo(v float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(v*p) / p
+}
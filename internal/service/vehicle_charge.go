@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"math"
 	"time"
 
 	"go.uber.org/zap"
@@ -20,10 +21,30 @@ func (s *VehicleService) startCharging(ctx context.Context, car *models.Car, dat
 	if data.ChargeState != nil {
 		cp.StartBatteryLevel = data.ChargeState.BatteryLevel
 		cp.StartRangeKm = tesla.MilesToKm(data.ChargeState.EstBatteryRange)
+
+		limit := data.ChargeState.ChargeLimitSoc
+		cp.ChargeLimitSoc = &limit
+		if mode := data.ChargeState.ScheduledChargingMode; mode != "" {
+			cp.ScheduledMode = &mode
+		}
+
+		// 上报值可能仍是上一次充电的累计值，只作为增量计算的基准
+		if reported := data.ChargeState.ChargeEnergyAdded; reported >= 0 {
+			cp.EnergyReported = &reported
+		}
+	}
+
+	// 代客模式下不记录或匿名化位置时，地址和地理围栏同样不记录
+	precise := false
+	if data.DriveState != nil {
+		_, _, _, precise = s.valetCoordinates(car.ID, data.DriveState.Latitude, data.DriveState.Longitude)
+	}
+	if precise {
+		cp.GeofenceID = s.matchGeofence(ctx, data.DriveState.Latitude, data.DriveState.Longitude)
 	}
 
 	// 解析地址
-	if data.DriveState != nil && s.geocoder.IsConfigured() {
+	if precise && s.geocoder.IsConfigured() {
 		addr, err := s.geocoder.ReverseGeocode(ctx, data.DriveState.Latitude, data.DriveState.Longitude)
 		if err == nil {
 			cp.Address = addr
@@ -32,7 +53,9 @@ func (s *VehicleService) startCharging(ctx context.Context, car *models.Car, dat
 		}
 	}
 
-	if err := s.chargeRepo.CreateProcess(ctx, cp); err != nil {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.chargeRepo.CreateProcess(dbCtx, cp); err != nil {
 		s.logger.Error("Failed to create charging process", zap.Error(err))
 	} else {
 		s.logger.Info("Started charging", zap.Int64("charging_process_id", cp.ID))
@@ -41,46 +64,84 @@ func (s *VehicleService) startCharging(ctx context.Context, car *models.Car, dat
 
 // endCharging 结束充电
 func (s *VehicleService) endCharging(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
-	cp, err := s.chargeRepo.GetActiveProcess(ctx, car.ID)
+	dbCtx, cancel := s.dbContext(ctx)
+	cp, err := s.chargeRepo.GetActiveProcess(dbCtx, car.ID)
+	cancel()
 	if err != nil {
-		s.logger.Error("Failed to get active charging process", zap.Error(err))
+		s.logger.Error("Failed to get active charging process", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	if cp == nil {
+		s.logger.Debug("No active charging process to end", zap.Int64("car_id", car.ID))
 		return
 	}
 
 	now := time.Now()
 	cp.EndTime = &now
-	cp.DurationMin = now.Sub(cp.StartTime).Minutes()
+	s.markChargeEnd(car.ID, data, now)
 
 	if data.ChargeState != nil {
 		level := data.ChargeState.BatteryLevel
 		cp.EndBatteryLevel = &level
 		rangeKm := tesla.MilesToKm(data.ChargeState.EstBatteryRange)
 		cp.EndRangeKm = &rangeKm
-		cp.ChargeEnergyAdded = data.ChargeState.ChargeEnergyAdded
+		s.applyChargeEnergy(cp, data.ChargeState.ChargeEnergyAdded, now)
+
+		// 结束时的充电状态: Complete 为充到上限自然结束，Stopped (手动/预约停止)、Disconnected (充电中拔枪) 等为中断
+		if endState := data.ChargeState.ChargingState; endState != "" {
+			cp.EndChargingState = &endState
+		}
 	}
+	if data.ClimateState != nil {
+		s.accumulateOutsideTemp(cp, data.ClimateState.OutsideTemp, now)
+		s.accumulateBatteryHeater(cp, data.ClimateState.BatteryHeater, now)
+	}
+	cp.DurationMin = now.Sub(cp.StartTime).Minutes()
+	s.checkChargeEnergyDiscrepancy(cp)
 
-	if err := s.chargeRepo.CompleteProcess(ctx, cp); err != nil {
+	// 按车辆设置的电价计算费用
+	if cost, ok := s.chargeCost(car.ID, cp.ChargeEnergyAdded); ok {
+		cp.Cost = &cost
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.chargeRepo.CompleteProcess(dbCtx, cp); err != nil {
 		s.logger.Error("Failed to complete charging process", zap.Error(err))
 	} else {
-		s.logger.Info("Completed charging", zap.Int64("charging_process_id", cp.ID), zap.Float64("energy_added", cp.ChargeEnergyAdded))
+		s.logger.Info("Completed charging",
+			zap.Int64("charging_process_id", cp.ID),
+			zap.Float64("energy_added", cp.ChargeEnergyAdded),
+			zap.Stringp("end_charging_state", cp.EndChargingState))
 	}
 }
 
 // updateActiveChargingSnapshot 更新活跃充电过程的快照信息
 func (s *VehicleService) updateActiveChargingSnapshot(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
 	// 1. 获取活跃的充电过程
-	cp, err := s.chargeRepo.GetActiveProcess(ctx, car.ID)
+	dbCtx, cancel := s.dbContext(ctx)
+	cp, err := s.chargeRepo.GetActiveProcess(dbCtx, car.ID)
+	cancel()
 	if err != nil {
+		s.logger.Warn("Failed to get active charging process", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	if cp == nil {
 		return // 没有活跃充电过程
 	}
 
 	// 2. 更新快照字段
+	now := time.Now()
 	if data.ChargeState != nil {
 		level := data.ChargeState.BatteryLevel
 		cp.EndBatteryLevel = &level
 		rangeKm := tesla.MilesToKm(data.ChargeState.EstBatteryRange)
 		cp.EndRangeKm = &rangeKm
-		cp.ChargeEnergyAdded = data.ChargeState.ChargeEnergyAdded
+		s.applyChargeEnergy(cp, data.ChargeState.ChargeEnergyAdded, now)
+
+		// 充电中调整了充电上限
+		limit := data.ChargeState.ChargeLimitSoc
+		cp.ChargeLimitSoc = &limit
 
 		// 更新最大功率
 		currentPower := int(data.ChargeState.ChargerPower)
@@ -89,18 +150,234 @@ func (s *VehicleService) updateActiveChargingSnapshot(ctx context.Context, car *
 		}
 	}
 
+	// 累计车外温度采样和电池加热时长 (须在更新时长之前)
+	if data.ClimateState != nil {
+		s.accumulateOutsideTemp(cp, data.ClimateState.OutsideTemp, now)
+		s.accumulateBatteryHeater(cp, data.ClimateState.BatteryHeater, now)
+	}
+
 	// 更新时长
-	now := time.Now()
 	cp.DurationMin = now.Sub(cp.StartTime).Minutes()
 
-	// 更新外部温度 (暂用当前温度代替平均温度用于显示)
+	// 3. 保存到数据库
+	dbCtx, cancel = s.dbContext(ctx)
+	err = s.chargeRepo.UpdateSnapshot(dbCtx, cp)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to update active charging snapshot", zap.Error(err))
+	}
+
+	// 4. 记录充电采样（用于充电曲线）
+	if data.ChargeState != nil {
+		s.recordChargeSample(ctx, cp.ID, data)
+	}
+}
+
+// maxChargePowerKw 充电量增量校验使用的功率上限 (kW)，高于任何充电桩的实际功率
+const maxChargePowerKw = 350.0
+
+// minSocDeltaForEnergyCheck 电量变化 (%) 低于该值时不比较上报值与估算值 (电量为整数，误差过大)
+const minSocDeltaForEnergyCheck = 5
+
+// applyChargeEnergy 校验 Tesla 上报的充电量，按增量累计到充电过程
+// 上报值下降视为计数重置 (充电中断后从 0 重新计数)，从新值继续累计；
+// 自上次更新以来的增量超过 maxChargePowerKw 所能充入的电量视为跳变，丢弃该增量。
+// 须在更新 DurationMin 之前调用 (用于计算距上次更新的时间)
+func (s *VehicleService) applyChargeEnergy(cp *models.ChargingProcess, reported float64, now time.Time) {
+	// 升级前创建的充电过程没有原始值，charge_energy_added 即为上次上报值
+	prev := cp.ChargeEnergyAdded
+	if cp.EnergyReported != nil {
+		prev = *cp.EnergyReported
+	}
+
+	if reported < 0 {
+		s.logger.Warn("Ignoring negative charge energy added",
+			zap.Int64("charging_process_id", cp.ID),
+			zap.Float64("reported_kwh", reported))
+		return
+	}
+
+	delta := reported - prev
+	if reported < prev {
+		delta = reported
+	}
+
+	lastUpdate := cp.StartTime.Add(time.Duration(cp.DurationMin * float64(time.Minute)))
+	maxDelta := maxChargePowerKw*now.Sub(lastUpdate).Hours() + 0.1
+	if delta > maxDelta {
+		s.logger.Warn("Discarding implausible charge energy jump",
+			zap.Int64("charging_process_id", cp.ID),
+			zap.Float64("previous_kwh", prev),
+			zap.Float64("reported_kwh", reported),
+			zap.Float64("max_delta_kwh", maxDelta))
+		delta = 0
+	}
+
+	cp.ChargeEnergyAdded = math.Round((cp.ChargeEnergyAdded+delta)*100) / 100
+	cp.EnergyReported = &reported
+
+	if cp.EndBatteryLevel != nil && *cp.EndBatteryLevel > cp.StartBatteryLevel {
+		socDelta := float64(*cp.EndBatteryLevel - cp.StartBatteryLevel)
+		estimated := math.Round(socDelta/100*s.batteryCapacityKwh(cp.CarID)*100) / 100
+		cp.EnergyEstimated = &estimated
+	}
+}
+
+// accumulateOutsideTemp 将一次车外温度采样按距上次更新的时间加权累计到平均值
+// 轮询间隔不固定 (充电中、休眠前后、服务重启)，按次数平均会偏向采样密集的时段；
+// 单次采样的权重不超过 CHARGE_TEMP_MAX_GAP，避免长时间断开后的一次采样主导平均值。
+// 采样数为 0 (升级前开始的充电) 时从当前采样重新计算。须在更新 DurationMin 之前调用
+func (s *VehicleService) accumulateOutsideTemp(cp *models.ChargingProcess, temp float64, now time.Time) {
+	lastUpdate := cp.StartTime.Add(time.Duration(cp.DurationMin * float64(time.Minute)))
+	gap := now.Sub(lastUpdate)
+	if gap < 0 {
+		gap = 0
+	}
+	if maxGap := s.cfg.ChargeTempMaxGap; maxGap > 0 && gap > maxGap {
+		gap = maxGap
+	}
+	weight := gap.Minutes()
+
+	if cp.OutsideTempCount == 0 || cp.OutsideTempAvg == nil {
+		cp.OutsideTempAvg = &temp
+		cp.OutsideTempCount = 1
+		cp.OutsideTempWeight = weight
+		return
+	}
+
+	prev := *cp.OutsideTempAvg
+	var avg float64
+	if total := cp.OutsideTempWeight + weight; total > 0 {
+		avg = (prev*cp.OutsideTempWeight + temp*weight) / total
+	} else {
+		// 同一时刻的多次采样，退化为按次数平均
+		avg = (prev*float64(cp.OutsideTempCount) + temp) / float64(cp.OutsideTempCount+1)
+	}
+	avg = math.Round(avg*100) / 100
+	cp.OutsideTempAvg = &avg
+	cp.OutsideTempCount++
+	cp.OutsideTempWeight += weight
+}
+
+// accumulateBatteryHeater 电池加热开启时将距上次更新的时间累计到电池加热时长
+// 低温充电时部分电量用于加热电池，充电效率偏低时可据此判断原因。
+// 与车外温度相同，单次累计不超过 CHARGE_TEMP_MAX_GAP。须在更新 DurationMin 之前调用
+func (s *VehicleService) accumulateBatteryHeater(cp *models.ChargingProcess, active bool, now time.Time) {
+	if !active {
+		return
+	}
+	lastUpdate := cp.StartTime.Add(time.Duration(cp.DurationMin * float64(time.Minute)))
+	gap := now.Sub(lastUpdate)
+	if gap <= 0 {
+		return
+	}
+	if maxGap := s.cfg.ChargeTempMaxGap; maxGap > 0 && gap > maxGap {
+		gap = maxGap
+	}
+	cp.BatteryHeaterMin = roundTo(cp.BatteryHeaterMin+gap.Minutes(), 2)
+}
+
+// checkChargeEnergyDiscrepancy 充电结束时比较校验后的充电量与按电量变化估算的值
+// 差异超过 CHARGE_ENERGY_MAX_DIFF_PCT 时记录警告 (通常是电池容量设置不准确或上报数据异常)
+func (s *VehicleService) checkChargeEnergyDiscrepancy(cp *models.ChargingProcess) {
+	if cp.EnergyEstimated == nil || *cp.EnergyEstimated <= 0 || s.cfg.ChargeEnergyMaxDiffPct <= 0 {
+		return
+	}
+	if cp.EndBatteryLevel == nil || *cp.EndBatteryLevel-cp.StartBatteryLevel < minSocDeltaForEnergyCheck {
+		return
+	}
+
+	estimated := *cp.EnergyEstimated
+	diffPct := math.Abs(cp.ChargeEnergyAdded-estimated) / estimated * 100
+	if diffPct > float64(s.cfg.ChargeEnergyMaxDiffPct) {
+		s.logger.Warn("Charge energy added disagrees with SoC estimate",
+			zap.Int64("charging_process_id", cp.ID),
+			zap.Float64("energy_added_kwh", cp.ChargeEnergyAdded),
+			zap.Float64("estimated_kwh", estimated),
+			zap.Float64("diff_pct", math.Round(diffPct)))
+	}
+}
+
+// recordChargeSample 记录一条充电详情采样
+func (s *VehicleService) recordChargeSample(ctx context.Context, processID int64, data *tesla.VehicleData) {
+	charge := &models.Charge{
+		ChargingProcessID:  processID,
+		BatteryLevel:       data.ChargeState.BatteryLevel,
+		UsableBatteryLevel: data.ChargeState.UsableBatteryLevel,
+		RangeKm:            tesla.MilesToKm(data.ChargeState.EstBatteryRange),
+		ChargerPower:       data.ChargeState.ChargerPower,
+		ChargerVoltage:     data.ChargeState.ChargerVoltage,
+		ChargerCurrent:     data.ChargeState.ChargerActualCurrent,
+		ChargerPhases:      data.ChargeState.ChargerPhases,
+		ACPowerKw:          data.ChargeState.ACPowerKw(),
+		ChargeEnergyAdded:  data.ChargeState.ChargeEnergyAdded,
+		RecordedAt:         time.Now(),
+	}
 	if data.ClimateState != nil {
 		out := data.ClimateState.OutsideTemp
-		cp.OutsideTempAvg = &out
+		charge.OutsideTemp = &out
+		heater := data.ClimateState.BatteryHeater
+		charge.BatteryHeater = &heater
 	}
 
-	// 3. 保存到数据库
-	if err := s.chargeRepo.UpdateSnapshot(ctx, cp); err != nil {
-		s.logger.Warn("Failed to update active charging snapshot", zap.Error(err))
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.chargeRepo.CreateCharge(dbCtx, charge); err != nil {
+		s.logger.Warn("Failed to record charge sample", zap.Error(err), zap.Int64("charging_process_id", processID))
 	}
 }
+
+// markChargeEnd 记录充电结束的时间和位置，用于 suppressDriveAfterCharge
+func (s *VehicleService) markChargeEnd(carID int64, data *tesla.VehicleData, now time.Time) {
+	if s.cfg.ChargeEndGuard <= 0 || data.DriveState == nil {
+		return
+	}
+	s.mu.Lock()
+	s.chargeEnds[carID] = &positionMark{at: now, latitude: data.DriveState.Latitude, longitude: data.DriveState.Longitude}
+	s.mu.Unlock()
+}
+
+// suppressDriveAfterCharge 判断是否忽略充电结束后的行程开始
+// 快充结束后车辆短暂离线，重新连接时挡位可能短暂报告为非 P，导致在充电桩处记录一次不存在的行程。
+// 充电结束 CHARGE_END_GUARD 内、离充电位置不超过 CHARGE_END_GUARD_DISTANCE_M 时返回 true；
+// 超过保护期或检测到实际移动后清除记录，之后正常开始行程
+func (s *VehicleService) suppressDriveAfterCharge(carID int64, data *tesla.VehicleData, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end, ok := s.chargeEnds[carID]
+	if !ok {
+		return false
+	}
+	if now.Sub(end.at) >= s.cfg.ChargeEndGuard || data.DriveState == nil {
+		delete(s.chargeEnds, carID)
+		return false
+	}
+
+	moved := distanceMeters(end.latitude, end.longitude, data.DriveState.Latitude, data.DriveState.Longitude)
+	if moved > float64(s.cfg.ChargeEndGuardDistanceM) {
+		delete(s.chargeEnds, carID)
+		return false
+	}
+
+	shiftState := ""
+	if data.DriveState.ShiftState != nil {
+		shiftState = *data.DriveState.ShiftState
+	}
+	s.logger.Info("Ignoring drive start shortly after charging at the same location",
+		zap.Int64("car_id", carID),
+		zap.String("shift_state", shiftState),
+		zap.Duration("since_charge_end", now.Sub(end.at)),
+		zap.Float64("moved_m", math.Round(moved)))
+	return true
+}
+
+// Location 统计分桶和日期边界使用的时区 (TIMEZONE)
+func (s *VehicleService) Location() *time.Location {
+	return s.cfg.Location
+}
+
+// DCPowerThresholdKw 直流快充的峰值功率阈值 (kW)
+func (s *VehicleService) DCPowerThresholdKw() int {
+	return s.cfg.DCPowerThresholdKw
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+var (
+	// ErrChargeInProgress 充电尚未结束
+	ErrChargeInProgress = errors.New("charge still in progress")
+	// ErrChargeNotFound 充电记录不存在 (查询之后被删除或合并)
+	ErrChargeNotFound = errors.New("charge not found")
+	// ErrChargesNotAdjacent 两条充电记录不属于同一车辆，或中间还有其他充电记录
+	ErrChargesNotAdjacent = errors.New("charges not adjacent")
+)
+
+// DeleteCharge 删除已结束的充电记录 (误判的短暂充电等) 及其充电详情
+func (s *VehicleService) DeleteCharge(ctx context.Context, cp *models.ChargingProcess) error {
+	if cp.EndTime == nil {
+		return ErrChargeInProgress
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	deleted, err := s.chargeRepo.DeleteProcess(dbCtx, cp.ID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrChargeNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		// 查询之后重新开始充电
+		return ErrChargeInProgress
+	}
+
+	s.logger.Info("Deleted charge",
+		zap.Int64("car_id", cp.CarID),
+		zap.Int64("charging_process_id", cp.ID))
+	return nil
+}
+
+// MergeCharges 合并同一车辆相邻的两条已结束充电记录 (充电中断后重新开始被记录为两次充电时)
+// 保留开始较早的记录，返回合并后的记录
+func (s *VehicleService) MergeCharges(ctx context.Context, cp, other *models.ChargingProcess) (*models.ChargingProcess, error) {
+	if cp.EndTime == nil || other.EndTime == nil {
+		return nil, ErrChargeInProgress
+	}
+	if cp.ID == other.ID || cp.CarID != other.CarID {
+		return nil, ErrChargesNotAdjacent
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	mergedID, err := s.chargeRepo.MergeProcesses(dbCtx, cp.ID, other.ID)
+	cancel()
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrChargeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if mergedID == 0 {
+		return nil, ErrChargesNotAdjacent
+	}
+
+	s.logger.Info("Merged charges",
+		zap.Int64("car_id", cp.CarID),
+		zap.Int64("charging_process_id", mergedID),
+		zap.Int64s("merged_ids", []int64{cp.ID, other.ID}))
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	return s.chargeRepo.GetProcessByID(dbCtx, mergedID)
+}
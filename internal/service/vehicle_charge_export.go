@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// chargeExportFlushRows 每写出多少行刷新一次输出
+const chargeExportFlushRows = 100
+
+// chargeExportHeader CSV 表头
+var chargeExportHeader = []string{
+	"start_time", "end_time", "duration_min", "geofence", "address",
+	"start_battery_level", "end_battery_level", "energy_added_kwh", "charger_power_max_kw", "cost",
+}
+
+// ExportChargesCSV 以 CSV 格式逐行写出车辆在 [from, to) 内开始的已结束充电记录 (按开始时间升序)，最后一行为合计
+// 时间按 TIMEZONE 时区格式化；没有保存费用的充电按当前设置的电价计算，未设置电价时费用留空。
+// flush 不为 nil 时定期调用 (将已写出的内容发送给客户端)。返回导出的充电记录数
+func (s *VehicleService) ExportChargesCSV(ctx context.Context, w io.Writer, carID int64, from, to *time.Time, flush func()) (int, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(chargeExportHeader); err != nil {
+		return 0, err
+	}
+
+	loc := s.Location()
+	zones := s.privacyRedactorFor(ctx)
+	var count int
+	var totalDuration, totalEnergy, totalCost float64
+	err := s.chargeRepo.ForEachEndedProcess(ctx, carID, from, to, func(cp *models.ChargingProcess, geofenceName *string) error {
+		cost := cp.Cost
+		if cost == nil {
+			if c, ok := s.chargeCost(carID, cp.ChargeEnergyAdded); ok {
+				cost = &c
+			}
+		}
+
+		var geofence, address string
+		if geofenceName != nil {
+			geofence = *geofenceName
+		}
+		zones.charge(cp)
+		if cp.Address != nil {
+			address = cp.Address.FormattedAddress
+		}
+		record := []string{
+			cp.StartTime.In(loc).Format(time.DateTime),
+			cp.EndTime.In(loc).Format(time.DateTime),
+			formatCSVFloat(cp.DurationMin, 1),
+			geofence,
+			address,
+			strconv.Itoa(cp.StartBatteryLevel),
+			formatCSVIntPtr(cp.EndBatteryLevel),
+			formatCSVFloat(cp.ChargeEnergyAdded, 2),
+			formatCSVIntPtr(cp.ChargerPowerMax),
+			"",
+		}
+		if cost != nil {
+			record[9] = formatCSVFloat(*cost, 2)
+			totalCost += *cost
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+
+		count++
+		totalDuration += cp.DurationMin
+		totalEnergy += cp.ChargeEnergyAdded
+		if count%chargeExportFlushRows == 0 {
+			cw.Flush()
+			if flush != nil {
+				flush()
+			}
+		}
+		return cw.Error()
+	})
+	if err != nil {
+		return count, err
+	}
+
+	total := []string{"total", "", formatCSVFloat(totalDuration, 1), "", "", "", "", formatCSVFloat(totalEnergy, 2), "", formatCSVFloat(totalCost, 2)}
+	if err := cw.Write(total); err != nil {
+		return count, err
+	}
+	cw.Flush()
+	if flush != nil {
+		flush()
+	}
+	return count, cw.Error()
+}
+
+// formatCSVFloat 按指定小数位格式化数值
+func formatCSVFloat(v float64, digits int) string {
+	return strconv.FormatFloat(v, 'f', digits, 64)
+}
+
+// formatCSVIntPtr 格式化可空整数，为空时输出空字符串
+func formatCSVIntPtr(v *int) string {
+	if v == nil {
+		return ""
+	}
+	return strconv.Itoa(*v)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+// chargePollSample 充电中一次轮询的功率和电量
+type chargePollSample struct {
+	at           time.Time
+	chargerPower int // kW
+	batteryLevel int // %
+}
+
+// chargePowerDeadbandKw 功率变化不超过该值视为读数抖动 (ChargerPower 为整数 kW)
+const chargePowerDeadbandKw = 1
+
+// chargingPollInterval 计算充电中的轮询间隔
+// 按上一次轮询以来功率和电量的变化速度，估算功率变化 CHARGE_POLL_POWER_STEP_KW 或电量变化 CHARGE_POLL_SOC_STEP
+// 所需的时间作为下次轮询间隔，限制在 POLL_INTERVAL_CHARGING ~ POLL_INTERVAL_CHARGING_MAX 之间：
+// 直流快充初期和接近充满时的功率衰减阶段变化快，按最短间隔采样；功率平稳的中段逐渐放慢 (每次最多翻倍)
+func (s *VehicleService) chargingPollInterval(carID int64, vs *state.VehicleState, now time.Time) time.Duration {
+	minInterval := s.cfg.PollIntervalCharging
+	maxInterval := s.cfg.PollIntervalChargingMax
+
+	s.mu.Lock()
+	prev, hasPrev := s.chargePollSamples[carID]
+	current := s.pollIntervals[carID]
+	s.chargePollSamples[carID] = chargePollSample{at: now, chargerPower: vs.ChargerPower, batteryLevel: vs.BatteryLevel}
+	s.mu.Unlock()
+
+	// 未启用自适应，或刚开始充电
+	if maxInterval <= minInterval || !hasPrev {
+		return minInterval
+	}
+
+	elapsed := now.Sub(prev.at)
+	if elapsed <= 0 {
+		return minInterval
+	}
+
+	target := maxInterval
+	if powerDelta := math.Abs(float64(vs.ChargerPower - prev.chargerPower)); powerDelta > chargePowerDeadbandKw && s.cfg.ChargePollPowerStepKw > 0 {
+		if d := time.Duration(float64(elapsed) * s.cfg.ChargePollPowerStepKw / powerDelta); d < target {
+			target = d
+		}
+	}
+	if socDelta := math.Abs(float64(vs.BatteryLevel - prev.batteryLevel)); socDelta > 0 && s.cfg.ChargePollSocStep > 0 {
+		if d := time.Duration(float64(elapsed) * s.cfg.ChargePollSocStep / socDelta); d < target {
+			target = d
+		}
+	}
+
+	// 放慢时每次最多翻倍，避免一次平稳读数就跳到最长间隔
+	if current >= minInterval && target > current*2 {
+		target = current * 2
+	}
+	if target < minInterval {
+		target = minInterval
+	}
+	if target > maxInterval {
+		target = maxInterval
+	}
+	return target
+}
@@ -0,0 +1,32 @@
+package service
+
+import (
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// closuresFromData 从车辆数据中提取车门、车窗的详细状态
+// 优先使用单独返回的 closures_state，否则使用 vehicle_state 中的同名字段；两者都没有时返回 nil
+func closuresFromData(data *tesla.VehicleData) *models.Closures {
+	state := data.ClosuresState
+	if state == nil && data.VehicleState != nil {
+		state = &data.VehicleState.ClosuresState
+	}
+	if state == nil {
+		return nil
+	}
+
+	return &models.Closures{
+		DriverFrontDoor:      state.DriverDoorOpen != 0,
+		PassengerFrontDoor:   state.PassengerDoorOpen != 0,
+		DriverRearDoor:       state.DriverRearDoorOpen != 0,
+		PassengerRearDoor:    state.PassengerRearDoorOpen != 0,
+		Frunk:                state.FrunkOpen != 0,
+		Trunk:                state.TrunkOpen != 0,
+		DriverFrontWindow:    state.DriverWindowOpen,
+		PassengerFrontWindow: state.PassengerWindowOpen,
+		DriverRearWindow:     state.DriverRearWindowOpen,
+		PassengerRearWindow:  state.PassengerRearWindowOpen,
+		SunRoofPercentOpen:   state.SunRoofPercentOpen,
+	}
+}
@@ -42,12 +42,15 @@ func (s *VehicleService) canFallAsleep(data *tesla.VehicleData) SleepBlockReason
 	}
 
 	// 3. 预热/预冷中
+	// 预约出发的预热会自行结束，可通过配置允许其期间暂停日志
 	if data.ClimateState != nil && data.ClimateState.IsPreconditioning {
-		return SleepBlockPreconditioning
+		if s.cfg.BlockSleepOnPrecond {
+			return SleepBlockPreconditioning
+		}
 	}
 
 	// 4. 空调开启 (非预热模式下的空调使用)
-	if data.ClimateState != nil && data.ClimateState.IsClimateOn {
+	if data.ClimateState != nil && data.ClimateState.IsClimateOn && !data.ClimateState.IsPreconditioning {
 		return SleepBlockClimateOn
 	}
 
@@ -81,17 +84,12 @@ func (s *VehicleService) canFallAsleep(data *tesla.VehicleData) SleepBlockReason
 		}
 	}
 
-	// 9. 车辆未锁定（如果配置要求）
-	if s.cfg.RequireNotUnlocked && data.VehicleState != nil && !data.VehicleState.Locked {
-		return SleepBlockUnlocked
-	}
-
-	// 10. 正在消耗电力 (power > 0 表示在放电)
+	// 9. 正在消耗电力 (power > 0 表示在放电)
 	if data.DriveState != nil && data.DriveState.Power > 0 {
 		return SleepBlockPowerUsage
 	}
 
-	// 11. 正在下载更新
+	// 10. 正在下载更新
 	if data.VehicleState != nil && data.VehicleState.SoftwareUpdate != nil {
 		su := data.VehicleState.SoftwareUpdate
 		if su.Status == "downloading" && su.DownloadPerc < 100 {
@@ -99,6 +97,12 @@ func (s *VehicleService) canFallAsleep(data *tesla.VehicleData) SleepBlockReason
 		}
 	}
 
+	// 11. 车辆未锁定（如果配置要求）
+	// 放在最后检查：返回 unlocked 时表示没有其他阻止原因，用于未锁车宽限判断
+	if s.cfg.RequireNotUnlocked && data.VehicleState != nil && !data.VehicleState.Locked {
+		return SleepBlockUnlocked
+	}
+
 	return SleepBlockNone
 }
 
@@ -124,7 +128,16 @@ func (s *VehicleService) tryToSuspend(carID int64, machine *state.Machine, data
 	}
 
 	idleMinutes := time.Since(lastUsed).Minutes()
-	suspendAfterIdle := float64(s.cfg.SuspendAfterIdleMin)
+	suspendAfterIdle := float64(s.suspendAfterIdleMin(carID))
+
+	// 只有未锁车阻止休眠时先等待宽限时间，车辆通常会自动上锁
+	// 宽限期内不重置空闲计时，上锁后可以立即暂停
+	if s.inUnlockedGrace(carID, blockReason) {
+		s.logger.Debug("Vehicle unlocked, waiting for auto-lock",
+			zap.Int64("car_id", carID),
+			zap.Float64("idle_minutes", idleMinutes))
+		return
+	}
 
 	// 如果有阻止原因
 	if blockReason != SleepBlockNone {
@@ -163,12 +176,32 @@ func (s *VehicleService) tryToSuspend(carID int64, machine *state.Machine, data
 			zap.Float64("idle_minutes", idleMinutes))
 
 		// 设置暂停状态的轮询间隔
+		interval := s.suspendPollInterval(carID)
 		s.mu.Lock()
-		s.pollIntervals[carID] = s.cfg.SuspendPollInterval
+		s.pollIntervals[carID] = interval
 		s.mu.Unlock()
 	}
 }
 
+// inUnlockedGrace 判断车辆是否处于未锁车宽限期内
+// 仅当未锁车是唯一的阻止原因时计时，出现其他阻止原因或已上锁时重新计时
+func (s *VehicleService) inUnlockedGrace(carID int64, blockReason SleepBlockReason) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if blockReason != SleepBlockUnlocked || s.cfg.UnlockedGraceMin <= 0 {
+		delete(s.unlockedSince, carID)
+		return false
+	}
+
+	since, ok := s.unlockedSince[carID]
+	if !ok {
+		since = time.Now()
+		s.unlockedSince[carID] = since
+	}
+	return time.Since(since) < time.Duration(s.cfg.UnlockedGraceMin)*time.Minute
+}
+
 // markVehicleActive 标记车辆为活跃状态
 func (s *VehicleService) markVehicleActive(carID int64) {
 	s.mu.Lock()
@@ -210,8 +243,9 @@ func (s *VehicleService) SuspendLogging(carID int64) error {
 	s.logger.Info("Manually suspended logging", zap.Int64("car_id", carID))
 
 	// 设置暂停状态的轮询间隔
+	interval := s.suspendPollInterval(carID)
 	s.mu.Lock()
-	s.pollIntervals[carID] = s.cfg.SuspendPollInterval
+	s.pollIntervals[carID] = interval
 	s.mu.Unlock()
 
 	return nil
@@ -261,10 +295,15 @@ func (s *VehicleService) GetState(carID int64) (*state.VehicleState, bool) {
 	if !ok {
 		return nil, false
 	}
-	return machine.GetState(), true
+	return withComputedFields(machine.GetState(), time.Now()), true
 }
 
 // GetAllStates 获取所有车辆状态
 func (s *VehicleService) GetAllStates() map[int64]*state.VehicleState {
-	return s.stateManager.GetAllStates()
+	states := s.stateManager.GetAllStates()
+	now := time.Now()
+	for _, vs := range states {
+		withComputedFields(vs, now)
+	}
+	return states
 }
@@ -8,6 +8,7 @@ import (
 
 	"github.com/langchou/tesgazer/internal/api/tesla"
 	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/state"
 )
 
 // createPosition 创建位置记录
@@ -46,14 +47,52 @@ func (s *VehicleService) createPosition(carID int64, data *tesla.VehicleData) *m
 		pos.OutsideTemp = &outTemp
 	}
 
+	// 门窗全部关闭时不记录，避免每个位置点都存一份相同的状态
+	if closures := closuresFromData(data); closures != nil && !closures.AllClosed() {
+		pos.Closures = closures
+	}
+
 	return pos
 }
 
+// recordPolledPosition 保存轮询得到的位置，驾驶中时关联到当前行程
+// 代客模式下按 VALET_MODE_POSITIONS 不记录或匿名化坐标
+func (s *VehicleService) recordPolledPosition(ctx context.Context, carID int64, machine *state.Machine, data *tesla.VehicleData) {
+	pos := s.createPosition(carID, data)
+	lat, lng := pos.Latitude, pos.Longitude
+	if !s.applyValetPolicy(carID, pos) {
+		return
+	}
+
+	// 如果正在驾驶，关联到当前活动的行程
+	if machine.CurrentState() == state.StateDriving {
+		dbCtx, cancel := s.dbContext(ctx)
+		activeDrive, err := s.driveRepo.GetActiveDrive(dbCtx, carID)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to get active drive for position", zap.Int64("car_id", carID), zap.Error(err))
+		} else if activeDrive != nil {
+			pos.DriveID = &activeDrive.ID
+		}
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.posRepo.Create(dbCtx, pos); err != nil {
+		s.logger.Error("Failed to create position", zap.Error(err))
+	} else {
+		// 按实际坐标记录，匿名化不影响下次是否记录的距离判断
+		s.markPositionRecorded(carID, lat, lng, pos)
+	}
+	s.updateLiveDrive(machine, pos)
+}
+
 // startDrive 开始行程
 func (s *VehicleService) startDrive(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
 	drive := &models.Drive{
 		CarID:     car.ID,
 		StartTime: time.Now(),
+		IsActive:  true,
 	}
 
 	if data.ChargeState != nil {
@@ -66,15 +105,19 @@ func (s *VehicleService) startDrive(ctx context.Context, car *models.Car, data *
 		drive.StartOdometerKm = tesla.MilesToKm(data.VehicleState.Odometer)
 	}
 
-	// 记录起始位置坐标
+	// 记录起始位置坐标 (代客模式下按 VALET_MODE_POSITIONS 不记录或匿名化)
 	if data.DriveState != nil {
-		lat := data.DriveState.Latitude
-		lng := data.DriveState.Longitude
-		drive.StartLatitude = &lat
-		drive.StartLongitude = &lng
+		lat, lng, record, precise := s.valetCoordinates(car.ID, data.DriveState.Latitude, data.DriveState.Longitude)
+		if record {
+			drive.StartLatitude = &lat
+			drive.StartLongitude = &lng
+		}
+		if precise {
+			drive.StartGeofenceID = s.matchGeofence(ctx, lat, lng)
+		}
 
 		// 异步进行逆地理编码（不阻塞行程开始）
-		if s.geocoder.IsConfigured() {
+		if precise && s.geocoder.IsConfigured() {
 			go func() {
 				address, err := s.geocoder.ReverseGeocode(context.Background(), lat, lng)
 				if err != nil {
@@ -93,24 +136,81 @@ func (s *VehicleService) startDrive(ctx context.Context, car *models.Car, data *
 		}
 	}
 
-	if err := s.driveRepo.Create(ctx, drive); err != nil {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.Create(dbCtx, drive); err != nil {
 		s.logger.Error("Failed to create drive", zap.Error(err))
 	} else {
 		s.logger.Info("Started drive", zap.Int64("drive_id", drive.ID), zap.Float64("start_odometer_km", drive.StartOdometerKm))
 	}
 }
 
+// driveEndMaxSpeedMph 视为停稳的最大车速 (英里/小时)
+const driveEndMaxSpeedMph = 1
+
+// driveEndConfirmed 判断是否可以结束行程，可以时同时返回首次检测到停稳的时间 (作为行程结束时间)
+// 挂入 P 挡且车速接近 0 并持续 DRIVE_END_DEBOUNCE 后才返回 true，
+// 避免停车时短暂换挡 (如倒车入库) 把一次行程拆成两段
+func (s *VehicleService) driveEndConfirmed(carID int64, data *tesla.VehicleData, now time.Time) (time.Time, bool) {
+	parked := true
+	if ds := data.DriveState; ds != nil {
+		parked = (ds.ShiftState == nil || *ds.ShiftState == "P") &&
+			(ds.Speed == nil || *ds.Speed <= driveEndMaxSpeedMph)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !parked {
+		delete(s.driveEndPending, carID)
+		return time.Time{}, false
+	}
+	if s.cfg.DriveEndDebounce <= 0 {
+		return now, true
+	}
+
+	since, ok := s.driveEndPending[carID]
+	if !ok {
+		s.driveEndPending[carID] = now
+		s.logger.Debug("Vehicle parked, waiting before ending drive",
+			zap.Int64("car_id", carID),
+			zap.Duration("debounce", s.cfg.DriveEndDebounce))
+		return time.Time{}, false
+	}
+	if now.Sub(since) < s.cfg.DriveEndDebounce {
+		return time.Time{}, false
+	}
+	delete(s.driveEndPending, carID)
+	return since, true
+}
+
+// clearDriveEndPending 清除等待结束的行程状态 (行程以其他方式结束或重新开始时调用)
+func (s *VehicleService) clearDriveEndPending(carID int64) {
+	s.mu.Lock()
+	delete(s.driveEndPending, carID)
+	s.mu.Unlock()
+}
+
 // endDrive 结束行程
-func (s *VehicleService) endDrive(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
-	drive, err := s.driveRepo.GetActiveDrive(ctx, car.ID)
+// endTime 为车辆停稳的时间，不计入等待 DRIVE_END_DEBOUNCE 确认的时长
+func (s *VehicleService) endDrive(ctx context.Context, car *models.Car, data *tesla.VehicleData, endTime time.Time) {
+	dbCtx, cancel := s.dbContext(ctx)
+	drive, err := s.driveRepo.GetActiveDrive(dbCtx, car.ID)
+	cancel()
 	if err != nil {
-		s.logger.Error("Failed to get active drive", zap.Error(err))
+		s.logger.Error("Failed to get active drive", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	if drive == nil {
+		s.logger.Debug("No active drive to end", zap.Int64("car_id", car.ID))
 		return
 	}
 
-	now := time.Now()
-	drive.EndTime = &now
-	drive.DurationMin = now.Sub(drive.StartTime).Minutes()
+	if endTime.Before(drive.StartTime) {
+		endTime = drive.StartTime
+	}
+	drive.EndTime = &endTime
+	drive.DurationMin = endTime.Sub(drive.StartTime).Minutes()
 
 	if data.ChargeState != nil {
 		level := data.ChargeState.BatteryLevel
@@ -119,25 +219,25 @@ func (s *VehicleService) endDrive(ctx context.Context, car *models.Car, data *te
 		drive.EndRangeKm = &rangeKm
 	}
 
-	// 记录结束里程表并计算行驶距离
+	// 结束里程表 (在写入缓冲位置后计算行驶距离，读数异常时需要按轨迹计算)
+	var endOdometer float64
 	if data.VehicleState != nil {
-		endOdometer := tesla.MilesToKm(data.VehicleState.Odometer)
-		drive.EndOdometerKm = &endOdometer
-		// 根据里程表计算行驶距离
-		if drive.StartOdometerKm > 0 && endOdometer > drive.StartOdometerKm {
-			drive.DistanceKm = endOdometer - drive.StartOdometerKm
-		}
+		endOdometer = tesla.MilesToKm(data.VehicleState.Odometer)
 	}
 
-	// 记录结束位置坐标并解析地址
+	// 记录结束位置坐标并解析地址 (代客模式下按 VALET_MODE_POSITIONS 不记录或匿名化)
 	if data.DriveState != nil {
-		lat := data.DriveState.Latitude
-		lng := data.DriveState.Longitude
-		drive.EndLatitude = &lat
-		drive.EndLongitude = &lng
+		lat, lng, record, precise := s.valetCoordinates(car.ID, data.DriveState.Latitude, data.DriveState.Longitude)
+		if record {
+			drive.EndLatitude = &lat
+			drive.EndLongitude = &lng
+		}
+		if precise {
+			drive.EndGeofenceID = s.matchGeofence(ctx, lat, lng)
+		}
 
 		// 逆地理编码结束地址
-		if s.geocoder.IsConfigured() {
+		if precise && s.geocoder.IsConfigured() {
 			address, err := s.geocoder.ReverseGeocode(ctx, lat, lng)
 			if err != nil {
 				s.logger.Warn("Failed to geocode end address",
@@ -169,19 +269,29 @@ func (s *VehicleService) endDrive(ctx context.Context, car *models.Car, data *te
 		}
 	}
 
+	// 先写入缓冲区中的 Streaming 位置，保证统计包含全部轨迹点
+	if s.positionBatchingEnabled() {
+		s.flushPositions(ctx)
+	}
+
+	s.applyDriveDistance(ctx, drive, endOdometer)
+
 	// 从位置记录中统计行程数据
-	stats, err := s.posRepo.GetDriveStats(ctx, drive.ID)
-	if err == nil && stats != nil {
-		drive.SpeedMax = stats.SpeedMax
-		drive.PowerMax = stats.PowerMax
-		drive.PowerMin = stats.PowerMin
-		drive.InsideTempAvg = stats.InsideTempAvg
-		drive.OutsideTempAvg = stats.OutsideTempAvg
-		drive.EnergyUsedKwh = stats.EnergyUsedKwh
-		drive.EnergyRegenKwh = stats.EnergyRegenKwh
-	}
-
-	if err := s.driveRepo.Complete(ctx, drive); err != nil {
+	dbCtx, cancel = s.dbContext(ctx)
+	stats, err := s.posRepo.GetDriveStats(dbCtx, drive.ID, s.batteryCapacityKwh(drive.CarID))
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get drive stats", zap.Int64("drive_id", drive.ID), zap.Error(err))
+		stats = nil
+	}
+	// 数据库缺失的统计用内存中的实时统计补全
+	if stats = s.reconcileLiveDrive(car.ID, drive.ID, stats); stats != nil {
+		stats.ApplyTo(drive)
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.Complete(dbCtx, drive); err != nil {
 		s.logger.Error("Failed to complete drive", zap.Error(err))
 	} else {
 		// 构建日志字段
@@ -191,6 +301,7 @@ func (s *VehicleService) endDrive(ctx context.Context, car *models.Car, data *te
 			zap.Float64("distance_km", drive.DistanceKm),
 			zap.Intp("speed_max", drive.SpeedMax),
 			zap.Float64p("energy_regen_kwh", drive.EnergyRegenKwh),
+			zap.Float64p("energy_climate_kwh", drive.EnergyClimateKwh),
 		}
 		if drive.StartAddress != nil {
 			logFields = append(logFields, zap.String("start_address", drive.StartAddress.FormattedAddress))
@@ -199,5 +310,32 @@ func (s *VehicleService) endDrive(ctx context.Context, car *models.Car, data *te
 			logFields = append(logFields, zap.String("end_address", drive.EndAddress.FormattedAddress))
 		}
 		s.logger.Info("Completed drive", logFields...)
+
+		s.analyzeDriveAcceleration(ctx, drive)
+		s.updateDrivePolyline(ctx, drive.ID)
+		s.linkTrip(ctx, drive)
+
+		// 后台纠偏轨迹，失败时查询接口回退到原始轨迹
+		if s.mapMatcher.IsConfigured() {
+			go s.matchDriveRoute(context.Background(), drive.ID)
+		}
+	}
+}
+
+// linkTrip 将刚结束的行程与中途充电前的上一段行程归入同一旅程
+func (s *VehicleService) linkTrip(ctx context.Context, drive *models.Drive) {
+	if s.cfg.TripMaxStop <= 0 {
+		return
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	tripID, err := s.tripRepo.LinkDrive(dbCtx, drive, s.cfg.TripMaxStop)
+	if err != nil {
+		s.logger.Warn("Failed to link drive to trip", zap.Int64("drive_id", drive.ID), zap.Error(err))
+		return
+	}
+	if tripID != 0 {
+		s.logger.Info("Drive linked to trip", zap.Int64("drive_id", drive.ID), zap.Int64("trip_id", tripID))
 	}
 }
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// 加减速分析参数
+const (
+	standardGravity = 9.80665 // m/s²
+	// accelMinIntervalSec 参与计算的最小时间间隔 (秒)，间隔更短的点跳过，与下一个点合并计算，
+	// 避免速度取整误差 (Streaming 速度为整数 mph) 在极短间隔下被放大
+	accelMinIntervalSec = 1.0
+	// accelMaxIntervalSec 参与计算的最大时间间隔 (秒)，数据缺失 (隧道、轮询) 时的平均值不代表瞬时加速度
+	accelMaxIntervalSec = 10.0
+)
+
+// analyzeAcceleration 按相邻位置点的速度变化计算最大加/减速度和急加速、急刹车次数
+// 采样间隔不固定：按实际时间差计算，间隔过短的点合并，间隔过长的点之间不计算；
+// 连续超过阈值的区间计为一次事件。没有可用的速度数据时返回 false
+func analyzeAcceleration(positions []*models.Position, harshAccelG, harshBrakeG float64) (accelMax, decelMax float64, harshAccel, harshBrake int, ok bool) {
+	var prev *models.Position
+	inAccel, inBrake := false, false
+	for _, pos := range positions {
+		if pos.Speed == nil {
+			continue
+		}
+		if prev == nil {
+			prev = pos
+			continue
+		}
+
+		dt := pos.RecordedAt.Sub(prev.RecordedAt).Seconds()
+		if dt < accelMinIntervalSec {
+			continue
+		}
+		if dt > accelMaxIntervalSec {
+			prev = pos
+			inAccel, inBrake = false, false
+			continue
+		}
+
+		// km/h -> m/s，再换算为 g
+		g := float64(*pos.Speed-*prev.Speed) / 3.6 / dt / standardGravity
+		prev = pos
+		ok = true
+
+		if g > accelMax {
+			accelMax = g
+		}
+		if -g > decelMax {
+			decelMax = -g
+		}
+
+		if harshAccelG > 0 && g >= harshAccelG {
+			if !inAccel {
+				harshAccel++
+			}
+			inAccel = true
+		} else {
+			inAccel = false
+		}
+		if harshBrakeG > 0 && -g >= harshBrakeG {
+			if !inBrake {
+				harshBrake++
+			}
+			inBrake = true
+		} else {
+			inBrake = false
+		}
+	}
+	return accelMax, decelMax, harshAccel, harshBrake, ok
+}
+
+// analyzeDriveAcceleration 分析行程的加减速并保存 (行程结束、拆分、重新处理时调用)
+// 没有可用的速度数据时字段保持为空
+func (s *VehicleService) analyzeDriveAcceleration(ctx context.Context, drive *models.Drive) {
+	dbCtx, cancel := s.dbContext(ctx)
+	positions, err := s.posRepo.ListByDriveID(dbCtx, drive.ID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to list positions for acceleration analysis", zap.Int64("drive_id", drive.ID), zap.Error(err))
+		return
+	}
+
+	accelMax, decelMax, harshAccel, harshBrake, ok := analyzeAcceleration(positions, s.cfg.HarshAccelG, s.cfg.HarshBrakeG)
+	if ok {
+		accelMax, decelMax = roundTo(accelMax, 2), roundTo(decelMax, 2)
+		drive.AccelMaxG, drive.DecelMaxG = &accelMax, &decelMax
+		drive.HarshAccelCount, drive.HarshBrakeCount = &harshAccel, &harshBrake
+	} else {
+		drive.AccelMaxG, drive.DecelMaxG = nil, nil
+		drive.HarshAccelCount, drive.HarshBrakeCount = nil, nil
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.UpdateAcceleration(dbCtx, drive); err != nil {
+		s.logger.Warn("Failed to save drive acceleration", zap.Int64("drive_id", drive.ID), zap.Error(err))
+		return
+	}
+
+	if ok {
+		s.logger.Debug("Analyzed drive acceleration",
+			zap.Int64("drive_id", drive.ID),
+			zap.Float64("accel_max_g", accelMax),
+			zap.Float64("decel_max_g", decelMax),
+			zap.Int("harsh_accel", harshAccel),
+			zap.Int("harsh_brake", harshBrake))
+	}
+}
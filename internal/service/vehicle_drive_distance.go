@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// 里程表异常检测参数
+const (
+	odometerMaxSpeedKmh = 300.0 // 行程平均速度上限 (km/h)，超出时视为里程表跳变
+	odometerJumpSlackKm = 5.0   // 允许的额外误差 (km)，避免短行程因取整被误判
+)
+
+// 里程表读数异常原因
+const (
+	odometerMissing    = "missing"    // 起始或结束读数为 0 (API 未返回)
+	odometerDecreasing = "decreasing" // 结束读数小于起始读数 (里程表重置或上报错误)
+	odometerJump       = "jump"       // 里程增量超出行程时长内可能行驶的距离
+)
+
+// checkOdometerDistance 按起止里程表读数计算行驶距离 (km)
+// 读数为 0、递减或增量超出 elapsed 内以 odometerMaxSpeedKmh 可行驶的距离时返回异常原因
+func checkOdometerDistance(startKm, endKm float64, elapsed time.Duration) (float64, string) {
+	if startKm <= 0 || endKm <= 0 {
+		return 0, odometerMissing
+	}
+	if endKm < startKm {
+		return 0, odometerDecreasing
+	}
+	distance := endKm - startKm
+	if distance > elapsed.Hours()*odometerMaxSpeedKmh+odometerJumpSlackKm {
+		return 0, odometerJump
+	}
+	return distance, ""
+}
+
+// pathDistanceKm 按相邻位置点的球面距离累计轨迹长度 (km)
+func pathDistanceKm(positions []*models.Position) float64 {
+	var total float64
+	var prev *models.Position
+	for _, p := range positions {
+		if p.Latitude == 0 && p.Longitude == 0 {
+			continue
+		}
+		if prev != nil {
+			total += distanceMeters(prev.Latitude, prev.Longitude, p.Latitude, p.Longitude)
+		}
+		prev = p
+	}
+	return total / 1000
+}
+
+// applyDriveDistance 按里程表计算行程距离，读数异常时记录告警并改用轨迹的球面距离
+// 异常的结束读数不保存，避免影响里程表统计
+func (s *VehicleService) applyDriveDistance(ctx context.Context, drive *models.Drive, endKm float64) {
+	elapsed := drive.EndTime.Sub(drive.StartTime)
+	distance, reason := checkOdometerDistance(drive.StartOdometerKm, endKm, elapsed)
+	if reason == "" {
+		drive.EndOdometerKm = &endKm
+		drive.DistanceKm = distance
+		return
+	}
+	if reason == odometerMissing && endKm > 0 {
+		drive.EndOdometerKm = &endKm // 只缺起始读数时结束读数仍然可用
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	positions, err := s.posRepo.ListByDriveID(dbCtx, drive.ID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to list positions for drive distance", zap.Int64("drive_id", drive.ID), zap.Error(err))
+		return
+	}
+	drive.DistanceKm = roundTo(pathDistanceKm(positions), 2)
+
+	s.logger.Warn("Implausible odometer reading, using GPS distance",
+		zap.Int64("drive_id", drive.ID),
+		zap.String("reason", reason),
+		zap.Float64("start_odometer_km", drive.StartOdometerKm),
+		zap.Float64("end_odometer_km", endKm),
+		zap.Duration("elapsed", elapsed),
+		zap.Float64("gps_distance_km", drive.DistanceKm))
+}
+
+// recheckDriveDistance 按 applyDriveDistance 的规则重新校验已结束行程的距离并保存
+// 用于拆分和重新处理行程后，修正仓库层只按里程表计算的距离
+func (s *VehicleService) recheckDriveDistance(ctx context.Context, driveID int64) {
+	dbCtx, cancel := s.dbContext(ctx)
+	drive, err := s.driveRepo.GetByID(dbCtx, driveID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get drive for distance check", zap.Int64("drive_id", driveID), zap.Error(err))
+		return
+	}
+	if drive.EndTime == nil {
+		return
+	}
+
+	var endKm float64
+	if drive.EndOdometerKm != nil {
+		endKm = *drive.EndOdometerKm
+	}
+	drive.EndOdometerKm = nil
+	s.applyDriveDistance(ctx, drive, endKm)
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.UpdateDistance(dbCtx, drive); err != nil {
+		s.logger.Warn("Failed to update drive distance", zap.Int64("drive_id", driveID), zap.Error(err))
+	}
+}
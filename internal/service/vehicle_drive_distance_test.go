@@ -0,0 +1,51 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+func TestCheckOdometerDistance(t *testing.T) {
+	tests := []struct {
+		name         string
+		startKm      float64
+		endKm        float64
+		elapsed      time.Duration
+		wantDistance float64
+		wantReason   string
+	}{
+		{name: "normal", startKm: 1000, endKm: 1025.5, elapsed: 30 * time.Minute, wantDistance: 25.5},
+		{name: "no movement", startKm: 1000, endKm: 1000, elapsed: 5 * time.Minute, wantDistance: 0},
+		{name: "short drive within slack", startKm: 1000, endKm: 1004, elapsed: 0, wantDistance: 4},
+		{name: "zero start", startKm: 0, endKm: 1025, elapsed: 30 * time.Minute, wantReason: odometerMissing},
+		{name: "zero end", startKm: 1000, endKm: 0, elapsed: 30 * time.Minute, wantReason: odometerMissing},
+		{name: "negative delta", startKm: 1000, endKm: 990, elapsed: 30 * time.Minute, wantReason: odometerDecreasing},
+		{name: "implausible speed", startKm: 1000, endKm: 5000, elapsed: time.Hour, wantReason: odometerJump},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			distance, reason := checkOdometerDistance(tt.startKm, tt.endKm, tt.elapsed)
+			if reason != tt.wantReason {
+				t.Fatalf("reason = %q, want %q", reason, tt.wantReason)
+			}
+			if distance != tt.wantDistance {
+				t.Fatalf("distance = %v, want %v", distance, tt.wantDistance)
+			}
+		})
+	}
+}
+
+func TestPathDistanceKmSkipsMissingCoordinates(t *testing.T) {
+	positions := []*models.Position{
+		{Latitude: 31.2304, Longitude: 121.4737},
+		{Latitude: 0, Longitude: 0},
+		{Latitude: 31.2394, Longitude: 121.4737},
+	}
+	got := pathDistanceKm(positions)
+	if got < 0.95 || got > 1.05 {
+		t.Fatalf("pathDistanceKm = %v, want about 1 km", got)
+	}
+}
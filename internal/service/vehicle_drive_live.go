@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+// liveDriveAgg 进行中行程的实时累计数据 (s.mu 保护)
+type liveDriveAgg struct {
+	driveID  int64
+	samples  int
+	speedMax *int
+	powerMax *int
+	powerMin *int
+	// 温度累计 (求平均值)
+	insideSum  float64
+	insideN    int
+	outsideSum float64
+	outsideN   int
+	// 最近的位置点，最多 LIVE_DRIVE_BUFFER_SIZE 个
+	recent []state.LiveDriveSample
+}
+
+// add 累计一个位置点
+func (a *liveDriveAgg) add(pos *models.Position, bufferSize int) {
+	a.samples++
+	if pos.Speed != nil && (a.speedMax == nil || *pos.Speed > *a.speedMax) {
+		speed := *pos.Speed
+		a.speedMax = &speed
+	}
+	if a.powerMax == nil || pos.Power > *a.powerMax {
+		power := pos.Power
+		a.powerMax = &power
+	}
+	if a.powerMin == nil || pos.Power < *a.powerMin {
+		power := pos.Power
+		a.powerMin = &power
+	}
+	if pos.InsideTemp != nil {
+		a.insideSum += *pos.InsideTemp
+		a.insideN++
+	}
+	if pos.OutsideTemp != nil {
+		a.outsideSum += *pos.OutsideTemp
+		a.outsideN++
+	}
+
+	if bufferSize <= 0 {
+		return
+	}
+	a.recent = append(a.recent, state.LiveDriveSample{RecordedAt: pos.RecordedAt, Speed: pos.Speed, Power: pos.Power})
+	if len(a.recent) > bufferSize {
+		a.recent = append(a.recent[:0], a.recent[len(a.recent)-bufferSize:]...)
+	}
+}
+
+// snapshot 生成实时统计快照 (复制最近位置点，快照发布后不再被修改)
+func (a *liveDriveAgg) snapshot() *state.LiveDriveStats {
+	stats := &state.LiveDriveStats{
+		DriveID:  a.driveID,
+		Samples:  a.samples,
+		SpeedMax: a.speedMax,
+		PowerMax: a.powerMax,
+		PowerMin: a.powerMin,
+		Recent:   append([]state.LiveDriveSample{}, a.recent...),
+	}
+	if a.insideN > 0 {
+		avg := roundTo(a.insideSum/float64(a.insideN), 1)
+		stats.InsideTempAvg = &avg
+	}
+	if a.outsideN > 0 {
+		avg := roundTo(a.outsideSum/float64(a.outsideN), 1)
+		stats.OutsideTempAvg = &avg
+	}
+	return stats
+}
+
+// updateLiveDrive 用新记录的行程位置更新实时统计，并写入状态机 (随状态推送)
+func (s *VehicleService) updateLiveDrive(machine *state.Machine, pos *models.Position) {
+	if pos.DriveID == nil {
+		return
+	}
+
+	s.mu.Lock()
+	agg := s.liveDrives[pos.CarID]
+	if agg == nil || agg.driveID != *pos.DriveID {
+		agg = &liveDriveAgg{driveID: *pos.DriveID}
+		s.liveDrives[pos.CarID] = agg
+	}
+	agg.add(pos, s.cfg.LiveDriveBufferSize)
+	snapshot := agg.snapshot()
+	s.mu.Unlock()
+
+	machine.UpdateState(func(vs *state.VehicleState) {
+		vs.LiveDrive = snapshot
+	})
+}
+
+// reconcileLiveDrive 行程结束时用实时统计补全数据库统计，并清除实时统计
+// 数据库统计以已写入的位置为准；位置还未写入 (重试队列中) 或统计查询失败时，缺失的字段使用实时统计
+func (s *VehicleService) reconcileLiveDrive(carID, driveID int64, stats *repository.DriveStats) *repository.DriveStats {
+	s.mu.Lock()
+	agg := s.liveDrives[carID]
+	delete(s.liveDrives, carID)
+	s.mu.Unlock()
+
+	if machine, ok := s.stateManager.Get(carID); ok {
+		machine.UpdateState(func(vs *state.VehicleState) {
+			vs.LiveDrive = nil
+		})
+	}
+
+	if agg == nil || agg.driveID != driveID {
+		return stats
+	}
+	live := agg.snapshot()
+	if stats == nil {
+		stats = &repository.DriveStats{}
+	}
+
+	if !equalIntPtr(stats.SpeedMax, live.SpeedMax) || !equalIntPtr(stats.PowerMax, live.PowerMax) || !equalIntPtr(stats.PowerMin, live.PowerMin) {
+		s.logger.Debug("Live drive stats differ from database",
+			zap.Int64("drive_id", driveID),
+			zap.Int("samples", live.Samples),
+			zap.Intp("db_speed_max", stats.SpeedMax),
+			zap.Intp("live_speed_max", live.SpeedMax),
+			zap.Intp("db_power_max", stats.PowerMax),
+			zap.Intp("live_power_max", live.PowerMax),
+			zap.Intp("db_power_min", stats.PowerMin),
+			zap.Intp("live_power_min", live.PowerMin))
+	}
+
+	if stats.SpeedMax == nil {
+		stats.SpeedMax = live.SpeedMax
+	}
+	if stats.PowerMax == nil {
+		stats.PowerMax = live.PowerMax
+	}
+	if stats.PowerMin == nil {
+		stats.PowerMin = live.PowerMin
+	}
+	if stats.InsideTempAvg == nil {
+		stats.InsideTempAvg = live.InsideTempAvg
+	}
+	if stats.OutsideTempAvg == nil {
+		stats.OutsideTempAvg = live.OutsideTempAvg
+	}
+	return stats
+}
+
+// ActiveDrive 进行中的行程、已记录的轨迹和实时统计
+type ActiveDrive struct {
+	Drive     *models.Drive         `json:"drive"`
+	Positions []*models.Position    `json:"positions"`      // 已写入数据库的位置点 (按时间升序)
+	Live      *state.LiveDriveStats `json:"live,omitempty"` // 内存中的实时统计 (服务重启后从下一个位置点重新累计)
+}
+
+// GetActiveDrive 获取车辆进行中的行程，没有进行中的行程时返回 nil
+// 行程的距离和时长按最新位置点计算到当前，最高速度、功率和温度在数据库中还没有统计值时使用实时统计；
+// 行程结束时以数据库统计为准
+func (s *VehicleService) GetActiveDrive(ctx context.Context, carID int64) (*ActiveDrive, error) {
+	drive, err := s.driveRepo.GetActiveDrive(ctx, carID)
+	if err != nil || drive == nil {
+		return nil, err
+	}
+	positions, err := s.posRepo.ListByDriveID(ctx, drive.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	active := &ActiveDrive{Drive: drive, Positions: positions}
+	s.mu.RLock()
+	if agg := s.liveDrives[carID]; agg != nil && agg.driveID == drive.ID {
+		active.Live = agg.snapshot()
+	}
+	s.mu.RUnlock()
+
+	elapsed := time.Since(drive.StartTime)
+	drive.DurationMin = roundTo(elapsed.Minutes(), 1)
+	if len(positions) > 0 {
+		// 里程表读数异常时按轨迹的球面距离计算，与行程结束时一致
+		last := positions[len(positions)-1]
+		if distance, reason := checkOdometerDistance(drive.StartOdometerKm, last.Odometer, elapsed); reason == "" {
+			drive.DistanceKm = roundTo(distance, 2)
+		} else {
+			drive.DistanceKm = roundTo(pathDistanceKm(positions), 2)
+		}
+	}
+
+	if live := active.Live; live != nil {
+		if drive.SpeedMax == nil {
+			drive.SpeedMax = live.SpeedMax
+		}
+		if drive.PowerMax == nil {
+			drive.PowerMax = live.PowerMax
+		}
+		if drive.PowerMin == nil {
+			drive.PowerMin = live.PowerMin
+		}
+		if drive.InsideTempAvg == nil {
+			drive.InsideTempAvg = live.InsideTempAvg
+		}
+		if drive.OutsideTempAvg == nil {
+			drive.OutsideTempAvg = live.OutsideTempAvg
+		}
+	}
+	return active, nil
+}
+
+// equalIntPtr 比较两个可空整数
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
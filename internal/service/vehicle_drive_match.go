@@ -0,0 +1,102 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/mapmatch"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// minMatchPointDistanceM 纠偏时相邻轨迹点的最小间距 (米)，过密的点 (等红灯、Streaming 高频点) 只保留一个
+const minMatchPointDistanceM = 10
+
+// GetMatchedPath 获取行程的纠偏轨迹
+// 已纠偏时直接返回；行程已结束且配置了 MAPMATCH_URL 时立即纠偏并保存；
+// 未配置、行程未结束或纠偏失败时返回原始轨迹 (matched 为 false)
+func (s *VehicleService) GetMatchedPath(ctx context.Context, drive *models.Drive) (*models.MatchedPath, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	matched, err := s.driveRepo.GetMatchedPath(dbCtx, drive.ID)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if matched != nil {
+		return matched, nil
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	positions, err := s.posRepo.ListByDriveID(dbCtx, drive.ID)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	if drive.EndTime != nil && s.mapMatcher.IsConfigured() {
+		if matched := s.matchPositions(ctx, drive.ID, positions); matched != nil {
+			return matched, nil
+		}
+	}
+
+	raw := &models.MatchedPath{DriveID: drive.ID, Path: make([][2]float64, 0, len(positions))}
+	for _, pos := range positions {
+		raw.Path = append(raw.Path, [2]float64{pos.Latitude, pos.Longitude})
+	}
+	return raw, nil
+}
+
+// matchDriveRoute 纠偏刚结束的行程并保存 (行程结束时后台调用)
+func (s *VehicleService) matchDriveRoute(ctx context.Context, driveID int64) {
+	dbCtx, cancel := s.dbContext(ctx)
+	positions, err := s.posRepo.ListByDriveID(dbCtx, driveID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to list positions for map matching", zap.Int64("drive_id", driveID), zap.Error(err))
+		return
+	}
+	s.matchPositions(ctx, driveID, positions)
+}
+
+// matchPositions 调用纠偏服务并保存结果，失败时返回 nil (由调用方回退到原始轨迹)
+func (s *VehicleService) matchPositions(ctx context.Context, driveID int64, positions []*models.Position) *models.MatchedPath {
+	var points []mapmatch.Point
+	for i, pos := range positions {
+		if len(points) > 0 && i < len(positions)-1 {
+			last := points[len(points)-1]
+			if distanceMeters(last.Latitude, last.Longitude, pos.Latitude, pos.Longitude) < minMatchPointDistanceM {
+				continue
+			}
+		}
+		points = append(points, mapmatch.Point{Latitude: pos.Latitude, Longitude: pos.Longitude, Time: pos.RecordedAt})
+	}
+	if len(points) < 2 {
+		return nil
+	}
+
+	path, err := s.mapMatcher.Match(ctx, points)
+	if err != nil || len(path) < 2 {
+		s.logger.Warn("Map matching failed, falling back to raw positions",
+			zap.Int64("drive_id", driveID),
+			zap.Int("points", len(points)),
+			zap.Error(err))
+		return nil
+	}
+
+	provider := s.mapMatcher.GetProvider()
+	dbCtx, cancel := s.dbContext(ctx)
+	err = s.driveRepo.SaveMatchedPath(dbCtx, driveID, provider, path)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to save matched path", zap.Int64("drive_id", driveID), zap.Error(err))
+	}
+
+	s.logger.Debug("Matched drive route",
+		zap.Int64("drive_id", driveID),
+		zap.Int("points", len(points)),
+		zap.Int("matched_points", len(path)))
+
+	now := time.Now()
+	return &models.MatchedPath{DriveID: driveID, Matched: true, Provider: provider, MatchedAt: &now, Path: path}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"math"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// drivePolylineMaxPoints 行程缩略轨迹最多保留的点数 (列表小地图用，不需要完整轨迹)
+const drivePolylineMaxPoints = 200
+
+// DrivePolylines 为行程列表填充缩略轨迹
+// 已结束但还没有缩略轨迹的行程 (升级前的行程) 按位置点生成并保存，之后直接读取
+func (s *VehicleService) DrivePolylines(ctx context.Context, drives []*models.Drive) error {
+	ids := make([]int64, 0, len(drives))
+	for _, d := range drives {
+		ids = append(ids, d.ID)
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	polylines, err := s.driveRepo.GetPolylines(dbCtx, ids)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	for _, d := range drives {
+		polyline, ok := polylines[d.ID]
+		if !ok {
+			if d.EndTime == nil {
+				continue // 进行中的行程在结束时生成
+			}
+			if polyline, ok = s.updateDrivePolyline(ctx, d.ID); !ok {
+				continue
+			}
+		}
+		if polyline != "" {
+			d.Polyline = &polyline
+		}
+	}
+	return nil
+}
+
+// updateDrivePolyline 按行程位置点生成缩略轨迹并保存 (行程结束、拆分、重新处理时调用)
+func (s *VehicleService) updateDrivePolyline(ctx context.Context, driveID int64) (string, bool) {
+	dbCtx, cancel := s.dbContext(ctx)
+	positions, err := s.posRepo.ListByDriveID(dbCtx, driveID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to list positions for drive polyline", zap.Int64("drive_id", driveID), zap.Error(err))
+		return "", false
+	}
+
+	polyline := encodePolyline(downsamplePath(positions, drivePolylineMaxPoints))
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.UpdatePolyline(dbCtx, driveID, polyline); err != nil {
+		s.logger.Warn("Failed to save drive polyline", zap.Int64("drive_id", driveID), zap.Error(err))
+		return "", false
+	}
+	return polyline, true
+}
+
+// downsamplePath 取位置点的 [lat, lng]，去掉无效坐标和相邻重复点后均匀抽样到最多 limit 个点 (保留终点)
+func downsamplePath(positions []*models.Position, limit int) [][2]float64 {
+	var path [][2]float64
+	for _, p := range positions {
+		if p.Latitude == 0 && p.Longitude == 0 {
+			continue
+		}
+		pt := [2]float64{roundTo(p.Latitude, 5), roundTo(p.Longitude, 5)}
+		if len(path) > 0 && path[len(path)-1] == pt {
+			continue
+		}
+		path = append(path, pt)
+	}
+	if len(path) <= limit || limit < 2 {
+		return path
+	}
+
+	step := float64(len(path)-1) / float64(limit-1)
+	sampled := make([][2]float64, 0, limit)
+	for i := 0; i < limit; i++ {
+		sampled = append(sampled, path[int(math.Round(float64(i)*step))])
+	}
+	return sampled
+}
+
+// encodePolyline 按 Google Encoded Polyline 算法 (精度 5) 编码 [lat, lng] 序列
+func encodePolyline(path [][2]float64) string {
+	var b strings.Builder
+	var prevLat, prevLng int64
+	for _, p := range path {
+		lat := int64(math.Round(p[0] * 1e5))
+		lng := int64(math.Round(p[1] * 1e5))
+		writePolylineValue(&b, lat-prevLat)
+		writePolylineValue(&b, lng-prevLng)
+		prevLat, prevLng = lat, lng
+	}
+	return b.String()
+}
+
+// decodePolyline 解码 Google Encoded Polyline (精度 5)，格式错误时返回 false
+func decodePolyline(polyline string) ([][2]float64, bool) {
+	var path [][2]float64
+	var lat, lng int64
+	for i := 0; i < len(polyline); {
+		var deltas [2]int64
+		for k := range deltas {
+			var u uint64
+			var shift uint
+			for {
+				if i >= len(polyline) || shift > 60 {
+					return nil, false
+				}
+				c := uint64(polyline[i]) - 63
+				i++
+				u |= (c & 0x1f) << shift
+				shift += 5
+				if c < 0x20 {
+					break
+				}
+			}
+			v := int64(u >> 1)
+			if u&1 != 0 {
+				v = ^v
+			}
+			deltas[k] = v
+		}
+		lat += deltas[0]
+		lng += deltas[1]
+		path = append(path, [2]float64{float64(lat) / 1e5, float64(lng) / 1e5})
+	}
+	return path, true
+}
+
+// writePolylineValue 写入一个有符号差值 (左移一位，负数取反，每 5 位一组)
+func writePolylineValue(b *strings.Builder, v int64) {
+	u := uint64(v) << 1
+	if v < 0 {
+		u = ^u
+	}
+	for u >= 0x20 {
+		b.WriteByte(byte(0x20|(u&0x1f)) + 63)
+		u >>= 5
+	}
+	b.WriteByte(byte(u) + 63)
+}
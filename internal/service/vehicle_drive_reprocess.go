@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// ReprocessDrive 重新处理单个已结束的行程：按位置点重新计算统计数据、加减速和缩略轨迹，
+// 配置了逆地理编码时重新解析起止地址 (覆盖原地址，解析失败时保留原地址)。返回更新后的行程
+func (s *VehicleService) ReprocessDrive(ctx context.Context, drive *models.Drive) (*models.Drive, error) {
+	if drive.EndTime == nil {
+		return nil, ErrDriveInProgress
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	ok, err := s.driveRepo.Recompute(dbCtx, drive.ID, s.batteryCapacityKwh(drive.CarID))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDriveInProgress
+	}
+	s.recheckDriveDistance(ctx, drive.ID)
+	s.analyzeDriveAcceleration(ctx, drive)
+	s.updateDrivePolyline(ctx, drive.ID)
+
+	if s.geocoder.IsConfigured() {
+		s.regeocodeDrive(ctx, drive.ID, "start", drive.StartLatitude, drive.StartLongitude)
+		s.regeocodeDrive(ctx, drive.ID, "end", drive.EndLatitude, drive.EndLongitude)
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	updated, err := s.driveRepo.GetByID(dbCtx, drive.ID)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Info("Reprocessed drive",
+		zap.Int64("drive_id", drive.ID),
+		zap.Float64("distance_km", updated.DistanceKm),
+		zap.Float64("duration_min", updated.DurationMin))
+	return updated, nil
+}
+
+// regeocodeDrive 重新解析行程的起始或结束地址并覆盖保存
+func (s *VehicleService) regeocodeDrive(ctx context.Context, driveID int64, field string, lat, lng *float64) {
+	if lat == nil || lng == nil {
+		return
+	}
+
+	address, err := s.geocoder.ReverseGeocode(ctx, *lat, *lng)
+	if err != nil {
+		s.logger.Warn("Failed to geocode drive address",
+			zap.Int64("drive_id", driveID),
+			zap.String("field", field),
+			zap.Error(err))
+		return
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.ReplaceAddress(dbCtx, driveID, field, address); err != nil {
+		s.logger.Warn("Failed to update drive address", zap.Int64("drive_id", driveID), zap.Error(err))
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+var (
+	// ErrDriveInProgress 行程尚未结束
+	ErrDriveInProgress = errors.New("drive still in progress")
+	// ErrInvalidSplitPoint 拆分点不在行程内，或拆分后某一段没有位置点
+	ErrInvalidSplitPoint = errors.New("invalid split point")
+)
+
+// SplitDrive 在指定位置点 (positionID 为 0 时按时间 at) 将已结束的行程拆分为两段
+// 返回原行程和新行程的 ID；配置了逆地理编码时补全拆分处的地址
+func (s *VehicleService) SplitDrive(ctx context.Context, drive *models.Drive, positionID int64, at time.Time) ([]int64, error) {
+	if drive.EndTime == nil {
+		return nil, ErrDriveInProgress
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	newID, err := s.driveRepo.Split(dbCtx, drive.ID, positionID, at, s.batteryCapacityKwh(drive.CarID))
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if newID == 0 {
+		return nil, ErrInvalidSplitPoint
+	}
+
+	s.logger.Info("Split drive",
+		zap.Int64("drive_id", drive.ID),
+		zap.Int64("new_drive_id", newID))
+
+	s.geocodeSplitPoint(ctx, drive.ID, "end")
+	s.geocodeSplitPoint(ctx, newID, "start")
+
+	// 两段分别校验距离、重新分析加减速并生成缩略轨迹
+	s.recheckDriveDistance(ctx, drive.ID)
+	s.recheckDriveDistance(ctx, newID)
+	s.analyzeDriveAcceleration(ctx, drive)
+	s.analyzeDriveAcceleration(ctx, &models.Drive{ID: newID})
+	s.updateDrivePolyline(ctx, drive.ID)
+	s.updateDrivePolyline(ctx, newID)
+
+	return []int64{drive.ID, newID}, nil
+}
+
+// geocodeSplitPoint 补全拆分后行程的起始或结束地址，失败时留给地址补全任务处理
+func (s *VehicleService) geocodeSplitPoint(ctx context.Context, driveID int64, field string) {
+	if !s.geocoder.IsConfigured() {
+		return
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	drive, err := s.driveRepo.GetByID(dbCtx, driveID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get split drive", zap.Int64("drive_id", driveID), zap.Error(err))
+		return
+	}
+
+	lat, lng := drive.EndLatitude, drive.EndLongitude
+	if field == "start" {
+		lat, lng = drive.StartLatitude, drive.StartLongitude
+	}
+	if lat == nil || lng == nil {
+		return
+	}
+
+	address, err := s.geocoder.ReverseGeocode(ctx, *lat, *lng)
+	if err != nil {
+		s.logger.Warn("Failed to geocode split point", zap.Int64("drive_id", driveID), zap.Error(err))
+		return
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.driveRepo.UpdateAddress(dbCtx, driveID, field, address); err != nil {
+		s.logger.Warn("Failed to update split drive address", zap.Int64("drive_id", driveID), zap.Error(err))
+	}
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+const (
+	// minFrequentLocationRadiusM 常去地点建议半径的下限 (米)，与地理围栏默认半径一致
+	minFrequentLocationRadiusM = 50
+	// metersPerDegreeLat 每纬度对应的距离 (米)
+	metersPerDegreeLat = 111320.0
+)
+
+// ErrGeofenceAssign 地理围栏已创建，但关联历史记录失败
+var ErrGeofenceAssign = errors.New("assign existing records to geofence")
+
+// FindFrequentLocations 按停车位置聚类得到车辆的常去地点，按停车次数降序
+// 使用 DBSCAN：相距不超过 FREQUENT_LOCATION_RADIUS_M 的停车互为邻居，
+// 邻居数 (含自身) 达到 FREQUENT_LOCATION_MIN_VISITS 的停车及其可达的停车归为同一地点，其余视为偶发停车
+func (s *VehicleService) FindFrequentLocations(ctx context.Context, carID int64) ([]*models.FrequentLocation, error) {
+	var since *time.Time
+	if s.cfg.FreqLocationDays > 0 {
+		t := time.Now().AddDate(0, 0, -s.cfg.FreqLocationDays)
+		since = &t
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	stays, err := s.parkingRepo.ListStaysByCarID(dbCtx, carID, since, nil)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	return s.frequentLocations(ctx, carID, stays, s.cfg.FreqLocationMinVisits), nil
+}
+
+// frequentLocations 聚类停车得到常去地点 (标注所在地理围栏)，按停车次数降序
+func (s *VehicleService) frequentLocations(ctx context.Context, carID int64, stays []*models.ParkingStay, minVisits int) []*models.FrequentLocation {
+	clusters := clusterStays(stays, float64(s.cfg.FreqLocationRadiusM), minVisits)
+	locations := make([]*models.FrequentLocation, 0, len(clusters))
+	for _, members := range clusters {
+		loc := summarizeStays(members)
+
+		// 已被地理围栏覆盖的地点标注围栏，前端可据此隐藏"设为地点"
+		dbCtx, cancel := s.dbContext(ctx)
+		g, err := s.geofenceRepo.FindContaining(dbCtx, loc.Latitude, loc.Longitude)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to match geofence for frequent location", zap.Int64("car_id", carID), zap.Error(err))
+		} else if g != nil {
+			loc.GeofenceID = &g.ID
+			loc.GeofenceName = &g.Name
+		}
+		locations = append(locations, loc)
+	}
+
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].VisitCount != locations[j].VisitCount {
+			return locations[i].VisitCount > locations[j].VisitCount
+		}
+		return locations[i].TotalStayMin > locations[j].TotalStayMin
+	})
+	return locations
+}
+
+// CreateGeofence 创建地理围栏 (如将常去地点设为命名地点)，并关联围栏内已有的停车、充电和行程
+// 关联失败不影响创建，返回的关联数量为 nil
+func (s *VehicleService) CreateGeofence(ctx context.Context, g *models.Geofence) (*models.GeofenceAssignResult, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	err := s.geofenceRepo.Create(dbCtx, g)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if g.Privacy {
+		s.invalidatePrivacyZones()
+	}
+
+	// 关联历史记录可能涉及大量行，不使用轮询路径的数据库超时
+	assigned, err := s.geofenceRepo.AssignExisting(ctx, g)
+	if err != nil {
+		return nil, fmt.Errorf("%w %d: %w", ErrGeofenceAssign, g.ID, err)
+	}
+
+	s.logger.Info("Geofence created",
+		zap.Int64("geofence_id", g.ID),
+		zap.String("name", g.Name),
+		zap.Int("radius", g.Radius),
+		zap.Int64("parkings", assigned.Parkings),
+		zap.Int64("charges", assigned.Charges),
+		zap.Int64("drives", assigned.Drives))
+	return assigned, nil
+}
+
+// clusterStays DBSCAN 聚类，返回各簇的停车 (不含噪声点)
+// 按 eps 大小的经纬度网格查找邻居，避免两两计算距离
+func clusterStays(stays []*models.ParkingStay, epsM float64, minPts int) [][]*models.ParkingStay {
+	if len(stays) == 0 || epsM <= 0 {
+		return nil
+	}
+	if minPts < 1 {
+		minPts = 1
+	}
+
+	// 经度网格按最高纬度计算，保证相邻 3x3 网格覆盖 eps 范围
+	maxAbsLat := 0.0
+	for _, st := range stays {
+		maxAbsLat = math.Max(maxAbsLat, math.Abs(st.Latitude))
+	}
+	cellLat := epsM / metersPerDegreeLat
+	cellLng := epsM / (metersPerDegreeLat * math.Cos(math.Min(maxAbsLat, 85)*math.Pi/180))
+
+	type cell struct{ x, y int }
+	cellOf := func(st *models.ParkingStay) cell {
+		return cell{int(math.Floor(st.Longitude / cellLng)), int(math.Floor(st.Latitude / cellLat))}
+	}
+	grid := make(map[cell][]int)
+	for i, st := range stays {
+		c := cellOf(st)
+		grid[c] = append(grid[c], i)
+	}
+	neighbors := func(i int) []int {
+		c := cellOf(stays[i])
+		var result []int
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for _, j := range grid[cell{c.x + dx, c.y + dy}] {
+					if distanceMeters(stays[i].Latitude, stays[i].Longitude, stays[j].Latitude, stays[j].Longitude) <= epsM {
+						result = append(result, j)
+					}
+				}
+			}
+		}
+		return result
+	}
+
+	const noise = -1
+	labels := make([]int, len(stays)) // 0 未访问，-1 噪声，>0 所属簇
+	cluster := 0
+	for i := range stays {
+		if labels[i] != 0 {
+			continue
+		}
+		seeds := neighbors(i)
+		if len(seeds) < minPts {
+			labels[i] = noise
+			continue
+		}
+		cluster++
+		labels[i] = cluster
+		for k := 0; k < len(seeds); k++ {
+			j := seeds[k]
+			if labels[j] == noise {
+				labels[j] = cluster // 边界点
+			}
+			if labels[j] != 0 {
+				continue
+			}
+			labels[j] = cluster
+			if more := neighbors(j); len(more) >= minPts {
+				seeds = append(seeds, more...)
+			}
+		}
+	}
+
+	clusters := make([][]*models.ParkingStay, cluster)
+	for i, label := range labels {
+		if label > 0 {
+			clusters[label-1] = append(clusters[label-1], stays[i])
+		}
+	}
+	return clusters
+}
+
+// summarizeStays 汇总同一簇的停车：中心、覆盖半径、次数、停留时长和最常见的地址
+func summarizeStays(members []*models.ParkingStay) *models.FrequentLocation {
+	loc := &models.FrequentLocation{VisitCount: len(members)}
+	addressCount := make(map[string]int)
+	bestCount := 0
+	for _, st := range members {
+		loc.Latitude += st.Latitude
+		loc.Longitude += st.Longitude
+		loc.TotalStayMin += st.DurationMin
+		if st.StartTime.After(loc.LastVisit) {
+			loc.LastVisit = st.StartTime
+		}
+		if st.Address != nil && st.Address.FormattedAddress != "" {
+			addressCount[st.Address.FormattedAddress]++
+			if n := addressCount[st.Address.FormattedAddress]; n > bestCount {
+				bestCount = n
+				loc.Address = st.Address
+			}
+		}
+	}
+	n := float64(len(members))
+	loc.Latitude /= n
+	loc.Longitude /= n
+	loc.AvgStayMin = math.Round(loc.TotalStayMin/n*10) / 10
+	loc.TotalStayMin = math.Round(loc.TotalStayMin*10) / 10
+
+	// 半径取到中心最远的停车，向上取整到 10 米
+	radius := 0.0
+	for _, st := range members {
+		radius = math.Max(radius, distanceMeters(loc.Latitude, loc.Longitude, st.Latitude, st.Longitude))
+	}
+	loc.RadiusM = int(math.Ceil(radius/10) * 10)
+	if loc.RadiusM < minFrequentLocationRadiusM {
+		loc.RadiusM = minFrequentLocationRadiusM
+	}
+	return loc
+}
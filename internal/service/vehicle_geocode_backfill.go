@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// 地址补全支持的记录类型
+const (
+	BackfillDrives   = "drives"
+	BackfillCharges  = "charges"
+	BackfillParkings = "parkings"
+)
+
+// backfillPageSize 每次查询的待补全记录数
+const backfillPageSize = 100
+
+// backfillMaxConsecutiveFailures 连续失败多少次后中止 (通常是 Key 无效或配额用尽)
+const backfillMaxConsecutiveFailures = 10
+
+var (
+	// ErrBackfillInProgress 已有地址补全任务在执行
+	ErrBackfillInProgress = errors.New("geocode backfill already in progress")
+	// ErrNoBackfillRunning 没有正在执行的地址补全任务
+	ErrNoBackfillRunning = errors.New("no geocode backfill running")
+	// ErrInvalidBackfillType 不支持的记录类型
+	ErrInvalidBackfillType = errors.New("invalid backfill type")
+)
+
+// GeocodeBackfillProgress 单类记录的补全进度
+type GeocodeBackfillProgress struct {
+	Processed int   `json:"processed"` // 已处理的地址数
+	Updated   int   `json:"updated"`   // 成功补全的地址数
+	Failed    int   `json:"failed"`    // 逆地理编码失败的地址数
+	LastID    int64 `json:"last_id"`   // 已处理到的记录 ID
+	Done      bool  `json:"done"`      // 是否已处理完
+}
+
+// GeocodeBackfillStatus 地址补全任务状态
+type GeocodeBackfillStatus struct {
+	Running    bool                                `json:"running"`
+	Types      []string                            `json:"types"`
+	Progress   map[string]*GeocodeBackfillProgress `json:"progress"`
+	StartedAt  *time.Time                          `json:"started_at,omitempty"`
+	FinishedAt *time.Time                          `json:"finished_at,omitempty"`
+	Error      string                              `json:"error,omitempty"`
+}
+
+// backfillSource 一类记录的查询和更新方法
+type backfillSource struct {
+	list   func(ctx context.Context, afterID int64, limit int) ([]*repository.MissingAddress, error)
+	update func(ctx context.Context, item *repository.MissingAddress, address *models.Address) error
+}
+
+// backfillSources 按类型获取数据源
+func (s *VehicleService) backfillSources() map[string]backfillSource {
+	return map[string]backfillSource{
+		BackfillDrives: {
+			list: s.driveRepo.ListMissingAddresses,
+			update: func(ctx context.Context, item *repository.MissingAddress, address *models.Address) error {
+				return s.driveRepo.UpdateAddress(ctx, item.ID, item.Field, address)
+			},
+		},
+		BackfillCharges: {
+			list: s.chargeRepo.ListMissingAddresses,
+			update: func(ctx context.Context, item *repository.MissingAddress, address *models.Address) error {
+				return s.chargeRepo.UpdateAddress(ctx, item.ID, address)
+			},
+		},
+		BackfillParkings: {
+			list: s.parkingRepo.ListMissingAddresses,
+			update: func(ctx context.Context, item *repository.MissingAddress, address *models.Address) error {
+				return s.parkingRepo.UpdateAddress(ctx, item.ID, address)
+			},
+		},
+	}
+}
+
+// GetGeocodeBackfillStatus 获取最近一次地址补全任务的状态，从未执行时返回 nil
+func (s *VehicleService) GetGeocodeBackfillStatus() *GeocodeBackfillStatus {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+
+	if s.backfillStatus == nil {
+		return nil
+	}
+	status := *s.backfillStatus
+	status.Progress = make(map[string]*GeocodeBackfillProgress, len(s.backfillStatus.Progress))
+	for t, p := range s.backfillStatus.Progress {
+		progress := *p
+		status.Progress[t] = &progress
+	}
+	return &status
+}
+
+// TriggerGeocodeBackfill 在后台为缺少地址的记录执行逆地理编码 (供 API 调用)
+// 每次只查询仍缺少地址的记录，已补全的不会重复请求，中断后重新触发即可继续
+func (s *VehicleService) TriggerGeocodeBackfill(types []string) (*GeocodeBackfillStatus, error) {
+	sources := s.backfillSources()
+	for _, t := range types {
+		if _, ok := sources[t]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidBackfillType, t)
+		}
+	}
+
+	s.backfillMu.Lock()
+	if s.backfillCancel != nil {
+		s.backfillMu.Unlock()
+		return nil, ErrBackfillInProgress
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now()
+	status := &GeocodeBackfillStatus{
+		Running:   true,
+		Types:     types,
+		Progress:  make(map[string]*GeocodeBackfillProgress, len(types)),
+		StartedAt: &now,
+	}
+	for _, t := range types {
+		status.Progress[t] = &GeocodeBackfillProgress{}
+	}
+	s.backfillStatus = status
+	s.backfillCancel = cancel
+	s.backfillMu.Unlock()
+
+	go func() {
+		err := s.runGeocodeBackfill(ctx, types, sources)
+
+		s.backfillMu.Lock()
+		finished := time.Now()
+		s.backfillStatus.Running = false
+		s.backfillStatus.FinishedAt = &finished
+		if err != nil {
+			s.backfillStatus.Error = err.Error()
+		}
+		s.backfillCancel = nil
+		s.backfillMu.Unlock()
+		cancel()
+
+		if err != nil && !errors.Is(err, context.Canceled) {
+			s.logger.Error("Geocode backfill failed", zap.Error(err))
+		}
+	}()
+
+	return s.GetGeocodeBackfillStatus(), nil
+}
+
+// CancelGeocodeBackfill 取消正在执行的地址补全任务
+// 已补全的记录会保留，之后重新触发会从剩余记录继续
+func (s *VehicleService) CancelGeocodeBackfill() error {
+	s.backfillMu.Lock()
+	defer s.backfillMu.Unlock()
+
+	if s.backfillCancel == nil {
+		return ErrNoBackfillRunning
+	}
+	s.backfillCancel()
+	s.logger.Info("Geocode backfill cancelled")
+	return nil
+}
+
+// runGeocodeBackfill 依次补全各类记录的地址
+// 请求间隔由 GEOCODE_BACKFILL_DELAY 控制，避免超出服务商限流
+func (s *VehicleService) runGeocodeBackfill(ctx context.Context, types []string, sources map[string]backfillSource) error {
+	s.logger.Info("Starting geocode backfill",
+		zap.Strings("types", types),
+		zap.String("provider", s.geocoder.GetProvider()))
+
+	s.mu.RLock()
+	stopCh := s.stopCh
+	s.mu.RUnlock()
+
+	delay := s.cfg.GeocodeBackfillDelay
+	if delay <= 0 {
+		delay = time.Millisecond
+	}
+	ticker := time.NewTicker(delay)
+	defer ticker.Stop()
+
+	consecutiveFailures := 0
+	for _, t := range types {
+		source := sources[t]
+		var afterID int64
+
+		for {
+			items, err := source.list(ctx, afterID, backfillPageSize)
+			if err != nil {
+				return err
+			}
+			if len(items) == 0 {
+				break
+			}
+
+			for _, item := range items {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-stopCh:
+					return context.Canceled
+				case <-ticker.C:
+				}
+
+				address, err := s.geocoder.ReverseGeocode(ctx, item.Latitude, item.Longitude)
+				if err == nil {
+					err = source.update(ctx, item, address)
+				}
+
+				s.backfillMu.Lock()
+				progress := s.backfillStatus.Progress[t]
+				progress.Processed++
+				progress.LastID = item.ID
+				if err != nil {
+					progress.Failed++
+				} else {
+					progress.Updated++
+				}
+				s.backfillMu.Unlock()
+
+				if err != nil {
+					if ctx.Err() != nil {
+						return ctx.Err()
+					}
+					consecutiveFailures++
+					s.logger.Warn("Failed to backfill address",
+						zap.String("type", t),
+						zap.Int64("id", item.ID),
+						zap.Error(err))
+					if consecutiveFailures >= backfillMaxConsecutiveFailures {
+						return fmt.Errorf("aborted after %d consecutive failures: %w", consecutiveFailures, err)
+					}
+					continue
+				}
+				consecutiveFailures = 0
+			}
+
+			// 失败的记录仍缺少地址，按 ID 游标翻页避免在同一批上循环
+			afterID = items[len(items)-1].ID
+		}
+
+		s.backfillMu.Lock()
+		progress := s.backfillStatus.Progress[t]
+		progress.Done = true
+		s.logger.Info("Geocode backfill finished",
+			zap.String("type", t),
+			zap.Int("updated", progress.Updated),
+			zap.Int("failed", progress.Failed))
+		s.backfillMu.Unlock()
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+// resumeOnGeofenceExit 暂停日志期间车辆离开停车所在的地理围栏 (如离家) 时恢复日志记录
+// 未启用 Streaming 时只能靠暂停状态下的低频轮询发现车辆被使用，离开围栏说明车辆已经开走，
+// 不必等到轮询恰好遇到挂挡或充电。恢复后按在线间隔轮询
+func (s *VehicleService) resumeOnGeofenceExit(ctx context.Context, carID int64, machine *state.Machine, driveState *tesla.DriveState) {
+	if machine.CurrentState() != state.StateSuspended || driveState == nil ||
+		(driveState.Latitude == 0 && driveState.Longitude == 0) {
+		return
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	parking, err := s.parkingRepo.GetActiveParking(dbCtx, carID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get active parking for geofence exit check", zap.Int64("car_id", carID), zap.Error(err))
+		return
+	}
+	if parking == nil || parking.GeofenceID == nil {
+		return
+	}
+
+	dbCtx, cancel = s.dbContext(ctx)
+	geofence, err := s.geofenceRepo.GetByID(dbCtx, *parking.GeofenceID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get geofence for exit check", zap.Int64("car_id", carID), zap.Error(err))
+		return
+	}
+	if geofence == nil {
+		return
+	}
+
+	distance := distanceMeters(geofence.Latitude, geofence.Longitude, driveState.Latitude, driveState.Longitude)
+	if distance <= float64(geofence.Radius) {
+		return
+	}
+
+	if !machine.CanTransition(state.EventResume) {
+		return
+	}
+	machine.Trigger(state.EventResume)
+	s.markVehicleActive(carID)
+
+	s.mu.Lock()
+	s.pollIntervals[carID] = s.cfg.PollIntervalOnline
+	s.mu.Unlock()
+
+	s.logger.Info("Resumed logging after leaving geofence",
+		zap.Int64("car_id", carID),
+		zap.String("geofence", geofence.Name),
+		zap.Float64("distance_m", distance),
+		zap.Int("radius_m", geofence.Radius))
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/version"
+)
+
+// CarRuntimeInfo 单辆车的运行状态
+type CarRuntimeInfo struct {
+	Name string `json:"name"`
+	VIN  string `json:"vin"`
+	*PollStatus
+}
+
+// AdminInfo 诊断信息 (生效配置和运行状态)
+type AdminInfo struct {
+	Build         version.Info           `json:"build"`
+	StartedAt     time.Time              `json:"started_at"`
+	UptimeSec     float64                `json:"uptime_sec"`
+	Config        map[string]interface{} `json:"config"` // 生效的配置，令牌和 API Key 已隐藏
+	Geocoder      string                 `json:"geocoder"`
+	TelemetryMode string                 `json:"telemetry_mode"`
+	CarCount      int                    `json:"car_count"`
+	Cars          []*CarRuntimeInfo      `json:"cars"`
+}
+
+// processStartedAt 进程启动时间
+var processStartedAt = time.Now()
+
+// AdminToken 管理接口的访问令牌
+func (s *VehicleService) AdminToken() string {
+	return s.cfg.AdminToken
+}
+
+// AllowedOrigins 允许跨域访问的来源 (CORS_ALLOWED_ORIGINS) 以及是否为 debug 模式
+// 未配置来源时 debug 模式允许任意来源
+func (s *VehicleService) AllowedOrigins() ([]string, bool) {
+	return s.cfg.CORSAllowedOrigins, s.cfg.Debug
+}
+
+// GetAdminInfo 获取诊断信息，用于排查部署问题
+func (s *VehicleService) GetAdminInfo(ctx context.Context) (*AdminInfo, error) {
+	cars, err := s.carRepo.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &AdminInfo{
+		Build:         version.Get(),
+		StartedAt:     processStartedAt,
+		UptimeSec:     time.Since(processStartedAt).Seconds(),
+		Config:        s.cfg.Redacted(),
+		Geocoder:      s.geocoder.GetProvider(),
+		TelemetryMode: s.cfg.TelemetryMode,
+		CarCount:      len(cars),
+		Cars:          make([]*CarRuntimeInfo, 0, len(cars)),
+	}
+	for _, car := range cars {
+		info.Cars = append(info.Cars, &CarRuntimeInfo{
+			Name:       car.Name,
+			VIN:        car.VIN,
+			PollStatus: s.pollStatus(car),
+		})
+	}
+	return info, nil
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/pkg/ws"
+)
+
+// maintenanceSeverity 保养提醒状态的严重程度 (用于判断状态是否恶化)
+var maintenanceSeverity = map[models.MaintenanceStatus]int{
+	models.MaintenanceStatusOK:      0,
+	models.MaintenanceStatusDueSoon: 1,
+	models.MaintenanceStatusOverdue: 2,
+}
+
+// ListMaintenanceReminders 获取车辆的保养提醒，按当前里程和时间计算状态
+func (s *VehicleService) ListMaintenanceReminders(ctx context.Context, carID int64) ([]*models.MaintenanceReminder, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	reminders, err := s.maintenanceRepo.ListByCarID(dbCtx, carID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return []*models.MaintenanceReminder{}, nil
+	}
+	odometer, err := s.posRepo.LatestOdometer(dbCtx, carID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for _, m := range reminders {
+		s.evaluateMaintenance(m, odometer, now)
+	}
+	return reminders, nil
+}
+
+// GetMaintenanceReminder 获取保养提醒 (不计算状态)，不存在时返回 nil
+func (s *VehicleService) GetMaintenanceReminder(ctx context.Context, id int64) (*models.MaintenanceReminder, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	return s.maintenanceRepo.GetByID(dbCtx, id)
+}
+
+// CreateMaintenanceReminder 创建保养提醒
+// 未指定上次保养的里程和日期时从当前里程和当前时间开始计算
+func (s *VehicleService) CreateMaintenanceReminder(ctx context.Context, m *models.MaintenanceReminder) error {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	odometer, err := s.posRepo.LatestOdometer(dbCtx, m.CarID)
+	if err != nil {
+		return err
+	}
+	if m.LastDoneKm == nil && odometer != nil {
+		km := roundTo(*odometer, 1)
+		m.LastDoneKm = &km
+	}
+	if m.LastDoneAt == nil {
+		now := time.Now()
+		m.LastDoneAt = &now
+	}
+
+	if err := s.maintenanceRepo.Create(dbCtx, m); err != nil {
+		return err
+	}
+	s.evaluateMaintenance(m, odometer, time.Now())
+
+	s.logger.Info("Maintenance reminder created",
+		zap.Int64("car_id", m.CarID),
+		zap.Int64("reminder_id", m.ID),
+		zap.String("type", m.Type))
+	return nil
+}
+
+// UpdateMaintenanceReminder 保存修改后的保养提醒并重新计算状态，清空已告警状态，不存在时返回 false
+func (s *VehicleService) UpdateMaintenanceReminder(ctx context.Context, m *models.MaintenanceReminder) (bool, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	updated, err := s.maintenanceRepo.Update(dbCtx, m)
+	if err != nil || !updated {
+		return false, err
+	}
+	odometer, err := s.posRepo.LatestOdometer(dbCtx, m.CarID)
+	if err != nil {
+		return false, err
+	}
+	s.evaluateMaintenance(m, odometer, time.Now())
+	return true, nil
+}
+
+// CompleteMaintenanceReminder 标记保养已完成，重新开始计算间隔
+// doneKm/doneAt 为空时使用当前里程和当前时间，返回更新后的提醒 (不存在时为 nil)
+func (s *VehicleService) CompleteMaintenanceReminder(ctx context.Context, id int64, doneKm *float64, doneAt *time.Time) (*models.MaintenanceReminder, error) {
+	m, err := s.GetMaintenanceReminder(ctx, id)
+	if err != nil || m == nil {
+		return nil, err
+	}
+
+	if doneKm == nil {
+		dbCtx, cancel := s.dbContext(ctx)
+		odometer, err := s.posRepo.LatestOdometer(dbCtx, m.CarID)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if odometer != nil {
+			km := roundTo(*odometer, 1)
+			doneKm = &km
+		}
+	}
+	if doneAt == nil {
+		now := time.Now()
+		doneAt = &now
+	}
+	m.LastDoneKm = doneKm
+	m.LastDoneAt = doneAt
+
+	updated, err := s.UpdateMaintenanceReminder(ctx, m)
+	if err != nil || !updated {
+		return nil, err
+	}
+
+	s.logger.Info("Maintenance reminder completed",
+		zap.Int64("car_id", m.CarID),
+		zap.Int64("reminder_id", m.ID),
+		zap.String("type", m.Type))
+	return m, nil
+}
+
+// DeleteMaintenanceReminder 删除保养提醒，不存在时返回 false
+func (s *VehicleService) DeleteMaintenanceReminder(ctx context.Context, id int64) (bool, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	return s.maintenanceRepo.Delete(dbCtx, id)
+}
+
+// evaluateMaintenance 按当前里程和时间计算保养提醒的到期里程、到期时间和状态
+// 里程和天数间隔先到为准；没有里程读数或上次保养里程时只按天数计算
+func (s *VehicleService) evaluateMaintenance(m *models.MaintenanceReminder, odometer *float64, now time.Time) {
+	m.Status = models.MaintenanceStatusOK
+	m.CurrentOdometerKm = odometer
+	m.DueKm, m.RemainingKm, m.DueAt, m.RemainingDays = nil, nil, nil, nil
+
+	worsen := func(status models.MaintenanceStatus) {
+		if maintenanceSeverity[status] > maintenanceSeverity[m.Status] {
+			m.Status = status
+		}
+	}
+
+	if m.IntervalKm != nil && *m.IntervalKm > 0 && m.LastDoneKm != nil {
+		dueKm := roundTo(*m.LastDoneKm+*m.IntervalKm, 1)
+		m.DueKm = &dueKm
+		if odometer != nil {
+			remaining := roundTo(dueKm-*odometer, 1)
+			m.RemainingKm = &remaining
+			switch {
+			case remaining <= 0:
+				worsen(models.MaintenanceStatusOverdue)
+			case remaining <= s.cfg.MaintenanceDueSoonKm:
+				worsen(models.MaintenanceStatusDueSoon)
+			}
+		}
+	}
+
+	if m.IntervalDays != nil && *m.IntervalDays > 0 && m.LastDoneAt != nil {
+		dueAt := m.LastDoneAt.AddDate(0, 0, *m.IntervalDays)
+		m.DueAt = &dueAt
+		remaining := roundTo(dueAt.Sub(now).Hours()/24, 1)
+		m.RemainingDays = &remaining
+		switch {
+		case !now.Before(dueAt):
+			worsen(models.MaintenanceStatusOverdue)
+		case remaining <= float64(s.cfg.MaintenanceDueSoonDays):
+			worsen(models.MaintenanceStatusDueSoon)
+		}
+	}
+}
+
+// maintenanceLoop 定期检查保养提醒 (启动时先检查一次)
+func (s *VehicleService) maintenanceLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	s.checkMaintenanceReminders(ctx)
+
+	ticker := time.NewTicker(s.cfg.MaintenanceCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkMaintenanceReminders(ctx)
+		}
+	}
+}
+
+// checkMaintenanceReminders 检查所有保养提醒，状态恶化 (即将到期、超期) 时推送告警
+// 每个状态只告警一次，标记完成或修改提醒后重新开始
+func (s *VehicleService) checkMaintenanceReminders(ctx context.Context) {
+	dbCtx, cancel := s.dbContext(ctx)
+	reminders, err := s.maintenanceRepo.ListAll(dbCtx)
+	cancel()
+	if err != nil {
+		s.logger.Error("Failed to list maintenance reminders", zap.Error(err))
+		return
+	}
+
+	now := time.Now()
+	odometers := make(map[int64]*float64)
+	for _, m := range reminders {
+		odometer, ok := odometers[m.CarID]
+		if !ok {
+			dbCtx, cancel := s.dbContext(ctx)
+			odometer, err = s.posRepo.LatestOdometer(dbCtx, m.CarID)
+			cancel()
+			if err != nil {
+				s.logger.Warn("Failed to get odometer for maintenance check", zap.Int64("car_id", m.CarID), zap.Error(err))
+				continue
+			}
+			odometers[m.CarID] = odometer
+		}
+
+		s.evaluateMaintenance(m, odometer, now)
+		if maintenanceSeverity[m.Status] <= maintenanceSeverity[m.NotifiedStatus] {
+			continue
+		}
+
+		s.logger.Info("Maintenance reminder due",
+			zap.Int64("car_id", m.CarID),
+			zap.Int64("reminder_id", m.ID),
+			zap.String("type", m.Type),
+			zap.String("status", string(m.Status)))
+
+		s.sendAlert(m.CarID, ws.MsgTypeVehicleAlert, map[string]interface{}{
+			"car_id":     m.CarID,
+			"event_type": "maintenance_" + string(m.Status),
+			"event_time": now,
+			"details": map[string]interface{}{
+				"reminder_id":    m.ID,
+				"type":           m.Type,
+				"note":           m.Note,
+				"due_km":         m.DueKm,
+				"remaining_km":   m.RemainingKm,
+				"due_at":         m.DueAt,
+				"remaining_days": m.RemainingDays,
+			},
+		})
+
+		dbCtx, cancel := s.dbContext(ctx)
+		err := s.maintenanceRepo.SetNotifiedStatus(dbCtx, m.ID, m.Status)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to save maintenance notified status", zap.Int64("reminder_id", m.ID), zap.Error(err))
+		}
+	}
+}
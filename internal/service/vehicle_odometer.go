@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// GetOdometerHistory 按周期 (day/week/month) 汇总里程表读数和每个周期的行驶里程
+// 每个周期取最大读数作为周期结束时的读数，没有读数的周期沿用上一周期的读数 (行驶里程为 0)
+func (s *VehicleService) GetOdometerHistory(ctx context.Context, carID int64, from, to time.Time, granularity string) (*models.OdometerHistory, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	baseline, buckets, err := s.posRepo.OdometerBuckets(dbCtx, carID, from, to, granularity)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	if baseline != nil {
+		rounded := roundTo(*baseline, 1)
+		baseline = &rounded
+	}
+
+	history := &models.OdometerHistory{
+		Granularity:     granularity,
+		From:            from,
+		To:              to,
+		StartOdometerKm: baseline,
+		Points:          make([]*models.OdometerPoint, 0, len(buckets)),
+	}
+
+	last := baseline
+	for _, b := range buckets {
+		point := &models.OdometerPoint{Period: b.Period, OdometerKm: last}
+		if b.MaxKm != nil {
+			// 还没有之前的读数时，第一个周期的里程按周期内读数范围计算
+			prev := *b.MinKm
+			if last != nil {
+				prev = *last
+			}
+			if distance := *b.MaxKm - prev; distance > 0 {
+				point.DistanceKm = roundTo(distance, 1)
+			}
+			odometer := roundTo(*b.MaxKm, 1)
+			point.OdometerKm = &odometer
+			point.HasData = true
+			last = &odometer
+		}
+		history.TotalDistanceKm += point.DistanceKm
+		history.Points = append(history.Points, point)
+	}
+	history.TotalDistanceKm = roundTo(history.TotalDistanceKm, 1)
+
+	return history, nil
+}
@@ -8,12 +8,16 @@ import (
 
 	"github.com/langchou/tesgazer/internal/api/tesla"
 	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/pkg/ws"
 )
 
 // startParking 开始停车记录
 func (s *VehicleService) startParking(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
 	// 强制结束任何尚未结束的停车记录 (避免出现多个 active parking)
-	if err := s.parkingRepo.ForceCloseOpenParkings(ctx, car.ID, time.Now()); err != nil {
+	dbCtx, cancel := s.dbContext(ctx)
+	err := s.parkingRepo.ForceCloseOpenParkings(dbCtx, car.ID, time.Now())
+	cancel()
+	if err != nil {
 		s.logger.Warn("Failed to force close previous parkings", zap.Error(err), zap.Int64("car_id", car.ID))
 	}
 
@@ -22,13 +26,17 @@ func (s *VehicleService) startParking(ctx context.Context, car *models.Car, data
 		StartTime: time.Now(),
 	}
 
-	// 位置
+	// 位置 (代客模式下匿名化，停车记录的坐标不能为空，pause 时同样只保存匿名化的坐标)
 	if data.DriveState != nil {
-		parking.Latitude = data.DriveState.Latitude
-		parking.Longitude = data.DriveState.Longitude
+		lat, lng, _, precise := s.valetCoordinates(car.ID, data.DriveState.Latitude, data.DriveState.Longitude)
+		parking.Latitude = lat
+		parking.Longitude = lng
+		if precise {
+			parking.GeofenceID = s.matchGeofence(ctx, parking.Latitude, parking.Longitude)
+		}
 
 		// 逆地理编码：获取停车位置的地址
-		if s.geocoder.IsConfigured() {
+		if precise && s.geocoder.IsConfigured() {
 			addr, err := s.geocoder.ReverseGeocode(ctx, data.DriveState.Latitude, data.DriveState.Longitude)
 			if err != nil {
 				s.logger.Warn("Failed to reverse geocode parking location", zap.Error(err))
@@ -62,6 +70,7 @@ func (s *VehicleService) startParking(ctx context.Context, car *models.Car, data
 			data.VehicleState.PassengerRearWindowOpen != 0
 		parking.StartFrunkOpen = data.VehicleState.FrunkOpen != 0
 		parking.StartTrunkOpen = data.VehicleState.TrunkOpen != 0
+		parking.StartClosures = closuresFromData(data)
 		// 胎压
 		parking.StartTpmsPressureFL = data.VehicleState.TpmsPressureFL
 		parking.StartTpmsPressureFR = data.VehicleState.TpmsPressureFR
@@ -80,7 +89,10 @@ func (s *VehicleService) startParking(ctx context.Context, car *models.Car, data
 		parking.StartIsClimateOn = data.ClimateState.IsClimateOn
 	}
 
-	if err := s.parkingRepo.Create(ctx, parking); err != nil {
+	dbCtx, cancel = s.dbContext(ctx)
+	err = s.parkingRepo.Create(dbCtx, parking)
+	cancel()
+	if err != nil {
 		s.logger.Error("Failed to create parking", zap.Error(err))
 	} else {
 		s.logger.Info("Started parking", zap.Int64("parking_id", parking.ID))
@@ -89,7 +101,10 @@ func (s *VehicleService) startParking(ctx context.Context, car *models.Car, data
 	// 初始化停车期间的累计数据
 	s.mu.Lock()
 	s.parkingClimateUsage[car.ID] = 0
+	s.parkingPrecondUsage[car.ID] = 0
 	s.parkingSentryUsage[car.ID] = 0
+	s.parkingSeatHeater[car.ID] = 0
+	s.parkingDefroster[car.ID] = 0
 	s.parkingLastCheck[car.ID] = time.Now()
 	s.parkingTempSamples[car.ID] = []tempSample{}
 	// 初始化事件检测的上一次状态
@@ -108,8 +123,14 @@ func (s *VehicleService) startParking(ctx context.Context, car *models.Car, data
 
 // endParking 结束停车记录
 func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
-	parking, err := s.parkingRepo.GetActiveParking(ctx, car.ID)
+	dbCtx, cancel := s.dbContext(ctx)
+	parking, err := s.parkingRepo.GetActiveParking(dbCtx, car.ID)
+	cancel()
 	if err != nil {
+		s.logger.Error("Failed to get active parking", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	if parking == nil {
 		s.logger.Debug("No active parking to end", zap.Int64("car_id", car.ID))
 		return
 	}
@@ -127,10 +148,9 @@ func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *
 
 		// 计算吸血鬼功耗 (vampire drain)
 		// 假设每 % 电量约等于总电池容量的 1%
-		// 对于 Model 3 约 60-82 kWh，这里用一个近似值
 		if parking.EndBatteryLevel != nil && parking.StartBatteryLevel > *parking.EndBatteryLevel {
-			// 简单估算：假设电池容量约 75 kWh
-			batteryCapacityKwh := 75.0
+			// 电池容量可按车设置，默认约 75 kWh
+			batteryCapacityKwh := s.batteryCapacityKwh(car.ID)
 			energyUsed := float64(parking.StartBatteryLevel-*parking.EndBatteryLevel) / 100.0 * batteryCapacityKwh
 			parking.EnergyUsedKwh = &energyUsed
 		}
@@ -162,11 +182,16 @@ func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *
 		parking.EndFrunkOpen = &frunkOpen
 		trunkOpen := data.VehicleState.TrunkOpen != 0
 		parking.EndTrunkOpen = &trunkOpen
+		parking.EndClosures = closuresFromData(data)
 		// 胎压
 		parking.EndTpmsPressureFL = data.VehicleState.TpmsPressureFL
 		parking.EndTpmsPressureFR = data.VehicleState.TpmsPressureFR
 		parking.EndTpmsPressureRL = data.VehicleState.TpmsPressureRL
 		parking.EndTpmsPressureRR = data.VehicleState.TpmsPressureRR
+		// 停车期间可能完成了软件更新，记录结束时的版本
+		if data.VehicleState.CarVersion != "" {
+			parking.CarVersion = data.VehicleState.CarVersion
+		}
 	}
 
 	// 温度
@@ -183,7 +208,10 @@ func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *
 	s.mu.RLock()
 	samples := s.parkingTempSamples[car.ID]
 	climateUsage := s.parkingClimateUsage[car.ID]
+	precondUsage := s.parkingPrecondUsage[car.ID]
 	sentryUsage := s.parkingSentryUsage[car.ID]
+	seatHeaterUsage := s.parkingSeatHeater[car.ID]
+	defrosterUsage := s.parkingDefroster[car.ID]
 	s.mu.RUnlock()
 
 	if len(samples) > 0 {
@@ -209,17 +237,32 @@ func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *
 		}
 	}
 
-	// 空调和哨兵模式使用时长
+	// 空调、预热、座椅加热、除雾和哨兵模式使用时长
 	if climateUsage > 0 {
 		minutes := climateUsage.Minutes()
 		parking.ClimateUsedMin = &minutes
 	}
+	if precondUsage > 0 {
+		minutes := precondUsage.Minutes()
+		parking.PreconditioningUsedMin = &minutes
+	}
 	if sentryUsage > 0 {
 		minutes := sentryUsage.Minutes()
 		parking.SentryModeUsedMin = &minutes
 	}
+	if seatHeaterUsage > 0 {
+		minutes := seatHeaterUsage.Minutes()
+		parking.SeatHeaterUsedMin = &minutes
+	}
+	if defrosterUsage > 0 {
+		minutes := defrosterUsage.Minutes()
+		parking.DefrosterUsedMin = &minutes
+	}
 
-	if err := s.parkingRepo.Complete(ctx, parking); err != nil {
+	dbCtx, cancel = s.dbContext(ctx)
+	err = s.parkingRepo.Complete(dbCtx, parking)
+	cancel()
+	if err != nil {
 		s.logger.Error("Failed to complete parking", zap.Error(err))
 	} else {
 		s.logger.Info("Completed parking",
@@ -231,7 +274,10 @@ func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *
 	// 清理累计数据
 	s.mu.Lock()
 	delete(s.parkingClimateUsage, car.ID)
+	delete(s.parkingPrecondUsage, car.ID)
 	delete(s.parkingSentryUsage, car.ID)
+	delete(s.parkingSeatHeater, car.ID)
+	delete(s.parkingDefroster, car.ID)
 	delete(s.parkingLastCheck, car.ID)
 	delete(s.parkingTempSamples, car.ID)
 	delete(s.parkingPrevStates, car.ID)
@@ -239,11 +285,17 @@ func (s *VehicleService) endParking(ctx context.Context, car *models.Car, data *
 }
 
 // updateParkingStats 更新停车期间的统计数据
-// 每次轮询时调用，累计空调/哨兵模式使用时间，记录温度采样
+// 每次轮询时调用，累计空调/座椅加热/除雾/哨兵模式使用时间，记录温度采样
 func (s *VehicleService) updateParkingStats(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
 	// 检查是否有活动的停车记录
-	parking, err := s.parkingRepo.GetActiveParking(ctx, car.ID)
+	dbCtx, cancel := s.dbContext(ctx)
+	parking, err := s.parkingRepo.GetActiveParking(dbCtx, car.ID)
+	cancel()
 	if err != nil {
+		s.logger.Warn("Failed to get active parking", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	if parking == nil {
 		return // 没有活动的停车记录
 	}
 
@@ -264,9 +316,19 @@ func (s *VehicleService) updateParkingStats(ctx context.Context, car *models.Car
 	interval := now.Sub(lastCheck)
 	s.parkingLastCheck[car.ID] = now
 
-	// 累计空调使用时长
-	if data.ClimateState != nil && data.ClimateState.IsClimateOn {
-		s.parkingClimateUsage[car.ID] += interval
+	// 累计空调使用时长（预热/预冷单独统计）
+	if data.ClimateState != nil {
+		if data.ClimateState.IsPreconditioning {
+			s.parkingPrecondUsage[car.ID] += interval
+		} else if data.ClimateState.IsClimateOn {
+			s.parkingClimateUsage[car.ID] += interval
+		}
+		if seatHeaterOn(data.ClimateState) {
+			s.parkingSeatHeater[car.ID] += interval
+		}
+		if data.ClimateState.IsFrontDefrosterOn || data.ClimateState.IsRearDefrosterOn {
+			s.parkingDefroster[car.ID] += interval
+		}
 	}
 
 	// 累计哨兵模式使用时长
@@ -288,8 +350,14 @@ func (s *VehicleService) updateParkingStats(ctx context.Context, car *models.Car
 // updateActiveParkingSnapshot 更新活跃停车记录的快照信息
 func (s *VehicleService) updateActiveParkingSnapshot(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
 	// 1. 获取活跃的停车记录
-	parking, err := s.parkingRepo.GetActiveParking(ctx, car.ID)
+	dbCtx, cancel := s.dbContext(ctx)
+	parking, err := s.parkingRepo.GetActiveParking(dbCtx, car.ID)
+	cancel()
 	if err != nil {
+		s.logger.Warn("Failed to get active parking", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	if parking == nil {
 		return // 没有活跃停车记录
 	}
 
@@ -331,26 +399,44 @@ func (s *VehicleService) updateActiveParkingSnapshot(ctx context.Context, car *m
 		parking.EndFrunkOpen = &frunkOpen
 		trunkOpen := data.VehicleState.TrunkOpen != 0
 		parking.EndTrunkOpen = &trunkOpen
+		parking.EndClosures = closuresFromData(data)
 	}
 
 	// 3. 更新统计数据 (从内存累加器)
 	s.mu.RLock()
 	climUsage := s.parkingClimateUsage[car.ID]
+	precondUsage := s.parkingPrecondUsage[car.ID]
 	sentryUsage := s.parkingSentryUsage[car.ID]
+	seatHeaterUsage := s.parkingSeatHeater[car.ID]
+	defrosterUsage := s.parkingDefroster[car.ID]
 	s.mu.RUnlock()
 
 	climMin := climUsage.Minutes()
+	precondMin := precondUsage.Minutes()
 	sentryMin := sentryUsage.Minutes()
+	seatHeaterMin := seatHeaterUsage.Minutes()
+	defrosterMin := defrosterUsage.Minutes()
 
 	parking.ClimateUsedMin = &climMin
+	parking.PreconditioningUsedMin = &precondMin
 	parking.SentryModeUsedMin = &sentryMin
+	parking.SeatHeaterUsedMin = &seatHeaterMin
+	parking.DefrosterUsedMin = &defrosterMin
 
 	// 4. 保存到数据库
-	if err := s.parkingRepo.UpdateSnapshot(ctx, parking); err != nil {
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.parkingRepo.UpdateSnapshot(dbCtx, parking); err != nil {
 		s.logger.Warn("Failed to update active parking snapshot", zap.Error(err))
 	}
 }
 
+// seatHeaterOn 任一座椅加热是否开启
+func seatHeaterOn(climate *tesla.ClimateState) bool {
+	return climate.SeatHeaterLeft > 0 || climate.SeatHeaterRight > 0 ||
+		climate.SeatHeaterRearLeft > 0 || climate.SeatHeaterRearRight > 0
+}
+
 // extractParkingState 从 API 数据提取状态（用于事件检测）
 func (s *VehicleService) extractParkingState(data *tesla.VehicleData) *parkingPrevState {
 	state := &parkingPrevState{}
@@ -368,7 +454,9 @@ func (s *VehicleService) extractParkingState(data *tesla.VehicleData) *parkingPr
 		state.FrunkOpen = data.VehicleState.FrunkOpen != 0
 		state.Locked = data.VehicleState.Locked
 		state.SentryMode = data.VehicleState.SentryMode
+		state.ValetMode = data.VehicleState.ValetMode
 		state.IsUserPresent = data.VehicleState.IsUserPresent
+		state.CarVersion = data.VehicleState.CarVersion
 	}
 
 	if data.ClimateState != nil {
@@ -440,6 +528,16 @@ func (s *VehicleService) detectAndRecordEvents(ctx context.Context, carID int64,
 		s.recordParkingEvent(ctx, parkingID, models.EventSentryDisabled, now)
 	}
 
+	// 代客模式
+	if prev.ValetMode != curr.ValetMode {
+		s.logger.Info("Valet mode changed", zap.Int64("car_id", carID), zap.Bool("valet_mode", curr.ValetMode))
+		if curr.ValetMode {
+			s.recordParkingEvent(ctx, parkingID, models.EventValetEnabled, now)
+		} else {
+			s.recordParkingEvent(ctx, parkingID, models.EventValetDisabled, now)
+		}
+	}
+
 	// 空调
 	if !prev.IsClimateOn && curr.IsClimateOn {
 		s.recordParkingEvent(ctx, parkingID, models.EventClimateOn, now)
@@ -454,21 +552,95 @@ func (s *VehicleService) detectAndRecordEvents(ctx context.Context, carID int64,
 		s.recordParkingEvent(ctx, parkingID, models.EventUserLeft, now)
 	}
 
+	// 软件更新：更新期间车辆处于 updating 状态，恢复在线后版本号变化
+	if prev.CarVersion != "" && curr.CarVersion != "" && prev.CarVersion != curr.CarVersion {
+		s.logger.Info("Software updated during parking",
+			zap.Int64("car_id", carID),
+			zap.String("from_version", prev.CarVersion),
+			zap.String("to_version", curr.CarVersion))
+		s.recordParkingEventWithDetails(ctx, parkingID, models.EventSoftwareUpdate, now, map[string]interface{}{
+			"from_version": prev.CarVersion,
+			"to_version":   curr.CarVersion,
+		})
+	} else if curr.CarVersion == "" {
+		// 本次数据缺少版本号时保留上一次的值，避免漏记
+		curr.CarVersion = prev.CarVersion
+	}
+
+	// 疑似入侵
+	s.detectPossibleTamper(ctx, carID, parkingID, prev, curr, now)
+
 	// 更新上一次状态
 	s.mu.Lock()
 	s.parkingPrevStates[carID] = curr
 	s.mu.Unlock()
 }
 
+// detectPossibleTamper 启发式检测疑似入侵
+// API 不提供哨兵报警事件。锁车且哨兵开启时车门/后备箱/前备箱被打开而车辆仍处于锁定状态，
+// 通常不是车主用车 (车主会先解锁)。冷却时间内只记录一次，避免同一事件反复告警
+func (s *VehicleService) detectPossibleTamper(ctx context.Context, carID, parkingID int64, prev, curr *parkingPrevState, now time.Time) {
+	if !prev.Locked || !prev.SentryMode || !curr.Locked {
+		return
+	}
+
+	var openings []string
+	if !prev.DoorsOpen && curr.DoorsOpen {
+		openings = append(openings, "doors")
+	}
+	if !prev.TrunkOpen && curr.TrunkOpen {
+		openings = append(openings, "trunk")
+	}
+	if !prev.FrunkOpen && curr.FrunkOpen {
+		openings = append(openings, "frunk")
+	}
+	if len(openings) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	last, alerted := s.parkingTamperAlerts[carID]
+	if alerted && now.Sub(last) < s.cfg.TamperAlertCooldown {
+		s.mu.Unlock()
+		return
+	}
+	s.parkingTamperAlerts[carID] = now
+	s.mu.Unlock()
+
+	details := map[string]interface{}{"openings": openings}
+	s.recordParkingEventWithDetails(ctx, parkingID, models.EventPossibleTamper, now, details)
+
+	s.logger.Warn("Possible tamper detected while parked",
+		zap.Int64("car_id", carID),
+		zap.Int64("parking_id", parkingID),
+		zap.Strings("openings", openings))
+
+	s.sendAlert(carID, ws.MsgTypeParkingAlert, map[string]interface{}{
+		"car_id":     carID,
+		"parking_id": parkingID,
+		"event_type": models.EventPossibleTamper,
+		"event_time": now,
+		"details":    details,
+	})
+}
+
 // recordParkingEvent 记录停车事件
 func (s *VehicleService) recordParkingEvent(ctx context.Context, parkingID int64, eventType models.ParkingEventType, eventTime time.Time) {
+	s.recordParkingEventWithDetails(ctx, parkingID, eventType, eventTime, nil)
+}
+
+// recordParkingEventWithDetails 记录带详情的停车事件
+func (s *VehicleService) recordParkingEventWithDetails(ctx context.Context, parkingID int64, eventType models.ParkingEventType, eventTime time.Time, details map[string]interface{}) {
 	event := &models.ParkingEvent{
 		ParkingID: parkingID,
 		EventType: eventType,
 		EventTime: eventTime,
+		Details:   details,
 	}
 
-	if err := s.parkingRepo.CreateEvent(ctx, event); err != nil {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.parkingRepo.CreateEvent(dbCtx, event); err != nil {
 		s.logger.Error("Failed to record parking event",
 			zap.Error(err),
 			zap.Int64("parking_id", parkingID),
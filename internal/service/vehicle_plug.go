@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// activePlug 进行中的插枪会话 (缓存在内存中，避免每次轮询查询数据库)
+type activePlug struct {
+	id       int64
+	charging bool // 最近一次记录的事件是否为开始充电
+}
+
+// trackPlugSession 按 charging_state 记录插枪会话：插枪时开始会话，拔枪 (Disconnected) 时结束，
+// 期间充电开始/停止 (含等待预约充电、暂停) 记录为会话事件
+func (s *VehicleService) trackPlugSession(ctx context.Context, car *models.Car, data *tesla.VehicleData) {
+	if data.ChargeState == nil || data.ChargeState.ChargingState == "" {
+		return
+	}
+	chargingState := data.ChargeState.ChargingState
+	pluggedIn := chargingState != "Disconnected"
+	charging := chargingState == "Charging"
+
+	active, ok := s.loadActivePlug(ctx, car.ID)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case active == nil && pluggedIn:
+		s.startPlugSession(ctx, car, data, now, charging)
+	case active != nil && !pluggedIn:
+		if active.charging {
+			s.recordPlugEvent(ctx, active.id, models.PlugEventChargeStopped, data, now)
+		}
+		s.endPlugSession(ctx, car, active.id, data, now)
+	case active != nil && charging != active.charging:
+		eventType := models.PlugEventChargeStopped
+		if charging {
+			eventType = models.PlugEventChargeStarted
+		}
+		s.recordPlugEvent(ctx, active.id, eventType, data, now)
+		s.mu.Lock()
+		active.charging = charging
+		s.mu.Unlock()
+	}
+}
+
+// loadActivePlug 获取车辆进行中的插枪会话 (没有时为 nil)，首次调用时从数据库恢复 (服务重启后继续之前的会话)
+// 查询失败时返回 false
+func (s *VehicleService) loadActivePlug(ctx context.Context, carID int64) (*activePlug, bool) {
+	s.mu.RLock()
+	active, loaded := s.plugSessions[carID]
+	s.mu.RUnlock()
+	if loaded {
+		return active, true
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	ps, err := s.chargeRepo.GetActivePlugSession(dbCtx, carID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get active plug session", zap.Int64("car_id", carID), zap.Error(err))
+		return nil, false
+	}
+
+	if ps != nil {
+		active = &activePlug{id: ps.ID}
+		dbCtx, cancel := s.dbContext(ctx)
+		events, err := s.chargeRepo.ListPlugEvents(dbCtx, ps.ID)
+		cancel()
+		if err != nil {
+			s.logger.Warn("Failed to list plug events", zap.Int64("plug_session_id", ps.ID), zap.Error(err))
+			return nil, false
+		}
+		if len(events) > 0 {
+			active.charging = events[len(events)-1].EventType == models.PlugEventChargeStarted
+		}
+	}
+
+	s.mu.Lock()
+	s.plugSessions[carID] = active
+	s.mu.Unlock()
+	return active, true
+}
+
+// startPlugSession 开始插枪会话并记录插枪事件 (插枪即开始充电时同时记录开始充电)
+func (s *VehicleService) startPlugSession(ctx context.Context, car *models.Car, data *tesla.VehicleData, now time.Time, charging bool) {
+	level := data.ChargeState.BatteryLevel
+	ps := &models.PlugSession{
+		CarID:             car.ID,
+		StartTime:         now,
+		StartBatteryLevel: &level,
+	}
+	if mode := data.ChargeState.ScheduledChargingMode; mode != "" {
+		ps.ScheduledMode = &mode
+	}
+	if data.DriveState != nil {
+		// 代客模式下按 VALET_MODE_POSITIONS 不记录或匿名化坐标
+		lat, lng, record, precise := s.valetCoordinates(car.ID, data.DriveState.Latitude, data.DriveState.Longitude)
+		if record {
+			ps.Latitude, ps.Longitude = &lat, &lng
+		}
+		if precise {
+			ps.GeofenceID = s.matchGeofence(ctx, lat, lng)
+		}
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	err := s.chargeRepo.CreatePlugSession(dbCtx, ps)
+	cancel()
+	if err != nil {
+		s.logger.Error("Failed to create plug session", zap.Int64("car_id", car.ID), zap.Error(err))
+		return
+	}
+	s.logger.Info("Plugged in",
+		zap.Int64("car_id", car.ID),
+		zap.Int64("plug_session_id", ps.ID),
+		zap.String("charging_state", data.ChargeState.ChargingState))
+
+	s.recordPlugEvent(ctx, ps.ID, models.PlugEventPluggedIn, data, now)
+	if charging {
+		s.recordPlugEvent(ctx, ps.ID, models.PlugEventChargeStarted, data, now)
+	}
+
+	s.mu.Lock()
+	s.plugSessions[car.ID] = &activePlug{id: ps.ID, charging: charging}
+	s.mu.Unlock()
+}
+
+// endPlugSession 记录拔枪事件并结束插枪会话，按事件计算插枪时长中实际充电的时间
+func (s *VehicleService) endPlugSession(ctx context.Context, car *models.Car, sessionID int64, data *tesla.VehicleData, now time.Time) {
+	s.recordPlugEvent(ctx, sessionID, models.PlugEventUnplugged, data, now)
+
+	s.mu.Lock()
+	s.plugSessions[car.ID] = nil
+	s.mu.Unlock()
+
+	dbCtx, cancel := s.dbContext(ctx)
+	ps, err := s.chargeRepo.GetPlugSessionByID(dbCtx, sessionID)
+	cancel()
+	if err != nil {
+		s.logger.Error("Failed to get plug session", zap.Int64("plug_session_id", sessionID), zap.Error(err))
+		return
+	}
+	dbCtx, cancel = s.dbContext(ctx)
+	events, err := s.chargeRepo.ListPlugEvents(dbCtx, sessionID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to list plug events", zap.Int64("plug_session_id", sessionID), zap.Error(err))
+	}
+
+	level := data.ChargeState.BatteryLevel
+	ps.EndTime = &now
+	ps.EndBatteryLevel = &level
+	ps.DurationMin = now.Sub(ps.StartTime).Minutes()
+	ps.ChargingMin = plugChargingMinutes(events, now)
+
+	dbCtx, cancel = s.dbContext(ctx)
+	defer cancel()
+	if err := s.chargeRepo.CompletePlugSession(dbCtx, ps); err != nil {
+		s.logger.Error("Failed to complete plug session", zap.Int64("plug_session_id", sessionID), zap.Error(err))
+		return
+	}
+	s.logger.Info("Unplugged",
+		zap.Int64("car_id", car.ID),
+		zap.Int64("plug_session_id", sessionID),
+		zap.Float64("duration_min", ps.DurationMin),
+		zap.Float64("charging_min", ps.ChargingMin))
+}
+
+// recordPlugEvent 记录插枪会话事件
+func (s *VehicleService) recordPlugEvent(ctx context.Context, sessionID int64, eventType models.PlugEventType, data *tesla.VehicleData, now time.Time) {
+	level := data.ChargeState.BatteryLevel
+	event := &models.PlugEvent{
+		PlugSessionID: sessionID,
+		EventType:     eventType,
+		EventTime:     now,
+		ChargingState: data.ChargeState.ChargingState,
+		BatteryLevel:  &level,
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+	if err := s.chargeRepo.CreatePlugEvent(dbCtx, event); err != nil {
+		s.logger.Warn("Failed to record plug event",
+			zap.Int64("plug_session_id", sessionID),
+			zap.String("event_type", string(eventType)),
+			zap.Error(err))
+		return
+	}
+	s.logger.Debug("Recorded plug event",
+		zap.Int64("plug_session_id", sessionID),
+		zap.String("event_type", string(eventType)),
+		zap.String("charging_state", event.ChargingState))
+}
+
+// plugChargingMinutes 累计开始充电到停止充电 (或 end) 之间的时长 (分钟)
+func plugChargingMinutes(events []*models.PlugEvent, end time.Time) float64 {
+	var total time.Duration
+	var started *time.Time
+	for _, e := range events {
+		switch e.EventType {
+		case models.PlugEventChargeStarted:
+			if started == nil {
+				t := e.EventTime
+				started = &t
+			}
+		case models.PlugEventChargeStopped, models.PlugEventUnplugged:
+			if started != nil {
+				total += e.EventTime.Sub(*started)
+				started = nil
+			}
+		}
+	}
+	if started != nil {
+		total += end.Sub(*started)
+	}
+	return total.Minutes()
+}
+
+// LoadPlugSessionDetails 填充插枪会话的事件和期间开始的充电记录
+func (s *VehicleService) LoadPlugSessionDetails(ctx context.Context, ps *models.PlugSession) error {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	var err error
+	if ps.Events, err = s.chargeRepo.ListPlugEvents(dbCtx, ps.ID); err != nil {
+		return err
+	}
+	ps.Charges, err = s.chargeRepo.ListProcessesInRange(dbCtx, ps.CarID, ps.StartTime, ps.EndTime)
+	return err
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// rateLimitEvent 车辆最近一次被 Tesla API 限流的记录
+type rateLimitEvent struct {
+	At         time.Time
+	RetryAfter time.Duration
+	Until      time.Time // 冷却结束时间，之前不再轮询
+	Limit      string
+	Remaining  string
+	Reset      string
+}
+
+// RateLimitStatus 限流状态
+type RateLimitStatus struct {
+	At            time.Time `json:"at"`                  // 收到 429 的时间
+	RetryAfterSec float64   `json:"retry_after_sec"`     // Tesla 建议的等待时间
+	Until         time.Time `json:"until"`               // 冷却结束时间
+	Active        bool      `json:"active"`              // 是否仍在冷却中
+	Limit         string    `json:"limit,omitempty"`     // RateLimit-Limit
+	Remaining     string    `json:"remaining,omitempty"` // RateLimit-Remaining
+	Reset         string    `json:"reset,omitempty"`     // RateLimit-Reset
+}
+
+// StreamingStatus Streaming 连接状态
+type StreamingStatus struct {
+	Enabled        bool   `json:"enabled"`
+	Mode           string `json:"mode"` // 推送数据来源: streaming 或 fleet_telemetry
+	Connected      bool   `json:"connected"`
+	VehicleOffline bool   `json:"vehicle_offline"` // 车辆离线，已停止重连
+
+	Degraded          bool       `json:"degraded"`                 // 连续重连失败次数达到上限，仅靠轮询跟踪
+	DegradedSince     *time.Time `json:"degraded_since,omitempty"` // 降级开始时间
+	ReconnectAttempts int        `json:"reconnect_attempts"`       // 连续失败的连接次数
+}
+
+// PollStatus 车辆轮询状态（用于排查车辆为什么没有更新）
+type PollStatus struct {
+	CarID               int64            `json:"car_id"`
+	State               string           `json:"state"`
+	PollIntervalSec     float64          `json:"poll_interval_sec"`      // 当前轮询间隔
+	LastPollAt          *time.Time       `json:"last_poll_at,omitempty"` // 上次轮询时间
+	NextPollAt          *time.Time       `json:"next_poll_at,omitempty"` // 预计下次轮询时间
+	InFlight            bool             `json:"in_flight"`              // 是否正在轮询
+	ConsecutiveFailures int              `json:"consecutive_failures"`   // 连续失败次数 (退避级别)
+	LastError           string           `json:"last_error,omitempty"`   // 最近一次失败原因
+	RateLimit           *RateLimitStatus `json:"rate_limit,omitempty"`   // 最近一次限流记录
+	Streaming           StreamingStatus  `json:"streaming"`
+
+	Breaker *tesla.BreakerStatus `json:"breaker,omitempty"` // 车辆所属账号的 Tesla API 熔断器状态
+}
+
+// recordPollResult 记录轮询结果，用于退避级别和限流冷却
+func (s *VehicleService) recordPollResult(carID int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err == nil {
+		delete(s.pollFailures, carID)
+		delete(s.pollLastErrors, carID)
+		return
+	}
+
+	s.pollFailures[carID]++
+	s.pollLastErrors[carID] = err.Error()
+
+	var rlErr *tesla.RateLimitError
+	if !errors.As(err, &rlErr) {
+		return
+	}
+
+	// 没有 Retry-After 时按最大退避间隔冷却
+	cooldown := rlErr.RetryAfter
+	if cooldown <= 0 {
+		cooldown = s.cfg.PollBackoffMax
+	}
+	now := time.Now()
+	s.rateLimits[carID] = &rateLimitEvent{
+		At:         now,
+		RetryAfter: rlErr.RetryAfter,
+		Until:      now.Add(cooldown),
+		Limit:      rlErr.Limit,
+		Remaining:  rlErr.Remaining,
+		Reset:      rlErr.Reset,
+	}
+
+	s.logger.Warn("Tesla API rate limited",
+		zap.Int64("car_id", carID),
+		zap.Duration("retry_after", rlErr.RetryAfter),
+		zap.Duration("cooldown", cooldown),
+		zap.String("limit", rlErr.Limit),
+		zap.String("remaining", rlErr.Remaining))
+}
+
+// rateLimitCooldown 返回车辆限流冷却的结束时间（调用方需持有 s.mu 读锁）
+func (s *VehicleService) rateLimitCooldown(carID int64) (time.Time, bool) {
+	rl, ok := s.rateLimits[carID]
+	if !ok || time.Now().After(rl.Until) {
+		return time.Time{}, false
+	}
+	return rl.Until, true
+}
+
+// GetPollStatus 获取车辆的轮询状态
+func (s *VehicleService) GetPollStatus(ctx context.Context, carID int64) (*PollStatus, error) {
+	car, err := s.carRepo.GetByID(ctx, carID)
+	if err != nil {
+		return nil, err
+	}
+	return s.pollStatus(car), nil
+}
+
+// pollStatus 汇总车辆的轮询状态 (内存数据，不访问数据库)
+func (s *VehicleService) pollStatus(car *models.Car) *PollStatus {
+	carID := car.ID
+	status := &PollStatus{CarID: carID}
+	if machine, ok := s.stateManager.Get(carID); ok {
+		status.State = machine.CurrentState()
+	}
+
+	s.mu.RLock()
+	interval, hasInterval := s.pollIntervals[carID]
+	if !hasInterval {
+		interval = s.cfg.PollIntervalOnline
+	}
+	status.PollIntervalSec = interval.Seconds()
+
+	if lastPoll, ok := s.lastPollTimes[carID]; ok {
+		last := lastPoll
+		next := lastPoll.Add(interval)
+		if until, active := s.rateLimitCooldown(carID); active && until.After(next) {
+			next = until
+		}
+		status.LastPollAt = &last
+		status.NextPollAt = &next
+	}
+
+	status.InFlight = s.pollInFlight[carID]
+	status.ConsecutiveFailures = s.pollFailures[carID]
+	status.LastError = s.pollLastErrors[carID]
+
+	if rl, ok := s.rateLimits[carID]; ok {
+		status.RateLimit = &RateLimitStatus{
+			At:            rl.At,
+			RetryAfterSec: rl.RetryAfter.Seconds(),
+			Until:         rl.Until,
+			Active:        time.Now().Before(rl.Until),
+			Limit:         rl.Limit,
+			Remaining:     rl.Remaining,
+			Reset:         rl.Reset,
+		}
+	}
+
+	client := s.streamingClients[car.TeslaVehicleID]
+	s.mu.RUnlock()
+
+	if apiClient, err := s.clientForCar(car); err == nil {
+		breaker := apiClient.BreakerStatus()
+		status.Breaker = &breaker
+	}
+
+	status.Streaming.Enabled = s.streamingWebSocketEnabled() || s.TelemetryEnabled()
+	status.Streaming.Mode = s.cfg.TelemetryMode
+	if client != nil {
+		status.Streaming.Connected = client.IsConnected()
+		status.Streaming.VehicleOffline = client.IsVehicleOffline()
+		status.Streaming.ReconnectAttempts = client.ReconnectAttempts()
+		if failed, since := client.IsFailed(); failed {
+			status.Streaming.Degraded = true
+			status.Streaming.DegradedSince = &since
+		}
+	}
+
+	return status
+}
+
+// StreamingDegradedVehicles 返回 Streaming 已降级为仅轮询的车辆 (Tesla vehicle_id)
+func (s *VehicleService) StreamingDegradedVehicles() []int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	vehicles := make([]int64, 0)
+	for vehicleID, client := range s.streamingClients {
+		if failed, _ := client.IsFailed(); failed {
+			vehicles = append(vehicles, vehicleID)
+		}
+	}
+	return vehicles
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+// earthRadiusM 地球平均半径 (米)
+const earthRadiusM = 6371000.0
+
+// positionMark 最近一次写入的位置
+type positionMark struct {
+	at           time.Time
+	latitude     float64
+	longitude    float64
+	batteryLevel int     // 写入时的电量 (%)
+	odometer     float64 // 写入时的里程 (km)
+}
+
+// odometerEpsilonKm 里程读数视为未变化的误差 (km)
+const odometerEpsilonKm = 0.01
+
+// shouldRecordPosition 判断本次轮询是否需要写入位置记录
+// 驾驶中每次轮询都记录；在线但未驾驶时，距上次记录超过 ONLINE_POSITION_INTERVAL
+// 或移动超过 ONLINE_POSITION_DISTANCE_M 才记录，避免停车在线期间按轮询频率重复写入。
+// 位置 (POSITION_DEDUP_DISTANCE_M 内)、电量和里程都与上次记录相同时，POSITION_DEDUP_INTERVAL 内不再记录
+func (s *VehicleService) shouldRecordPosition(carID int64, currentState string, data *tesla.VehicleData, now time.Time) bool {
+	if currentState == state.StateDriving {
+		return true
+	}
+
+	s.mu.RLock()
+	last, ok := s.lastPositions[carID]
+	s.mu.RUnlock()
+	if !ok {
+		return true
+	}
+
+	lat, lng := data.DriveState.Latitude, data.DriveState.Longitude
+	elapsed := now.Sub(last.at)
+	if s.cfg.PositionDedupInterval > 0 && elapsed < s.cfg.PositionDedupInterval && s.isDuplicatePosition(last, data) {
+		return false
+	}
+
+	if s.cfg.OnlinePositionInterval <= 0 || elapsed >= s.cfg.OnlinePositionInterval {
+		return true
+	}
+	return s.cfg.OnlinePositionDistanceM > 0 &&
+		distanceMeters(last.latitude, last.longitude, lat, lng) >= float64(s.cfg.OnlinePositionDistanceM)
+}
+
+// isDuplicatePosition 本次轮询的位置、电量和里程是否与上次记录相同 (缺少电量或里程数据时不视为重复)
+func (s *VehicleService) isDuplicatePosition(last *positionMark, data *tesla.VehicleData) bool {
+	if data.ChargeState == nil || data.VehicleState == nil {
+		return false
+	}
+	if data.ChargeState.BatteryLevel != last.batteryLevel ||
+		math.Abs(tesla.MilesToKm(data.VehicleState.Odometer)-last.odometer) > odometerEpsilonKm {
+		return false
+	}
+	return distanceMeters(last.latitude, last.longitude, data.DriveState.Latitude, data.DriveState.Longitude) <=
+		float64(s.cfg.PositionDedupDistanceM)
+}
+
+// markPositionRecorded 记录最近一次写入的位置 (lat/lng 为实际坐标，匿名化不影响下次的判断)
+func (s *VehicleService) markPositionRecorded(carID int64, lat, lng float64, pos *models.Position) {
+	s.mu.Lock()
+	s.lastPositions[carID] = &positionMark{
+		at:           pos.RecordedAt,
+		latitude:     lat,
+		longitude:    lng,
+		batteryLevel: pos.BatteryLevel,
+		odometer:     pos.Odometer,
+	}
+	s.mu.Unlock()
+}
+
+// distanceMeters 计算两个经纬度之间的球面距离 (米，Haversine 公式)
+func distanceMeters(lat1, lng1, lat2, lng2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLng := (lng2 - lng1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusM * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// positionBatchingEnabled 是否批量写入 Streaming 位置 (批量大小不超过 1 或未设置写入间隔时逐条写入)
+func (s *VehicleService) positionBatchingEnabled() bool {
+	return s.cfg.PositionBatchSize > 1 && s.cfg.PositionFlushInterval > 0
+}
+
+// enqueuePosition 写入一条 Streaming 位置
+// 启用批量写入时先放入缓冲区，达到批量大小后立即写入，其余由定时任务写入
+func (s *VehicleService) enqueuePosition(pos *models.Position) {
+	if !s.positionBatchingEnabled() {
+		ctx, cancel := s.dbContext(context.Background())
+		err := s.posRepo.Create(ctx, pos)
+		cancel()
+		if err != nil {
+			s.logger.Error("Failed to persist streaming position",
+				zap.Error(err),
+				zap.Int64("car_id", pos.CarID))
+			// 数据本身有误的位置重试也不会成功，不放入重试队列
+			if !repository.IsDataError(err) {
+				s.queuePositionRetry([]*models.Position{pos}, false)
+			}
+			return
+		}
+		s.flushPositionRetry(context.Background())
+		return
+	}
+
+	s.posBufMu.Lock()
+	s.posBuffer = append(s.posBuffer, pos)
+	full := len(s.posBuffer) >= s.cfg.PositionBatchSize
+	s.posBufMu.Unlock()
+
+	if full {
+		s.flushPositions(context.Background())
+	}
+}
+
+// flushPositions 将缓冲区中的位置批量写入数据库，并补写此前写入失败的位置
+// 写入期间持有 posFlushMu，返回时此前入队的位置均已落库 (或已进入重试队列)
+func (s *VehicleService) flushPositions(ctx context.Context) {
+	s.posFlushMu.Lock()
+	defer s.posFlushMu.Unlock()
+
+	s.posBufMu.Lock()
+	batch := s.posBuffer
+	s.posBuffer = nil
+	s.posBufMu.Unlock()
+
+	if len(batch) == 0 {
+		s.flushPositionRetry(ctx)
+		return
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	_, err := s.posRepo.CreateBatch(dbCtx, batch)
+	cancel()
+	if err != nil {
+		// 整批 COPY 中任意一行出错都会使整批失败，逐条写入以免其他车辆的正常位置一并丢失
+		s.logger.Error("Failed to persist streaming positions, falling back to per-row inserts",
+			zap.Int("count", len(batch)),
+			zap.Error(err))
+		if failed := s.insertPositionsIndividually(ctx, batch); len(failed) > 0 {
+			s.queuePositionRetry(failed, false)
+			return
+		}
+	} else {
+		s.logger.Debug("Flushed streaming positions", zap.Int("count", len(batch)))
+	}
+	s.flushPositionRetry(ctx)
+}
+
+// insertPositionsIndividually 逐条写入位置，数据本身有误的位置记录日志后丢弃
+// 遇到暂时性错误 (如数据库不可用) 时停止写入，返回该位置及其后未写入的位置
+func (s *VehicleService) insertPositionsIndividually(ctx context.Context, positions []*models.Position) []*models.Position {
+	for i, pos := range positions {
+		dbCtx, cancel := s.dbContext(ctx)
+		err := s.posRepo.Create(dbCtx, pos)
+		cancel()
+		if err == nil {
+			continue
+		}
+		if repository.IsDataError(err) {
+			s.logger.Warn("Dropped invalid streaming position",
+				zap.Int64("car_id", pos.CarID),
+				zap.Time("recorded_at", pos.RecordedAt),
+				zap.Error(err))
+			continue
+		}
+		return positions[i:]
+	}
+	return nil
+}
+
+// positionFlushLoop 定期写入缓冲区中的位置，服务停止时写入剩余位置
+func (s *VehicleService) positionFlushLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.PositionFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			s.flushPositions(context.Background())
+			return
+		case <-ctx.Done():
+			s.flushPositions(context.Background())
+			return
+		case <-ticker.C:
+			s.flushPositions(ctx)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+// PositionRetryStatus 写入失败等待补写的 Streaming 位置 (用于 /health)
+type PositionRetryStatus struct {
+	Queued  int   `json:"queued"`  // 队列中等待补写的位置数
+	Dropped int64 `json:"dropped"` // 队列已满被丢弃的位置数 (服务启动以来)
+}
+
+// queuePositionRetry 将写入失败的位置放入重试队列，超出 POSITION_RETRY_QUEUE_SIZE 时丢弃最早的位置
+// positions 应早于队列中已有的位置 (补写失败放回时) 或晚于它们 (新写入失败时)，由 front 区分
+func (s *VehicleService) queuePositionRetry(positions []*models.Position, front bool) {
+	limit := s.cfg.PositionRetryQueueSize
+	if limit <= 0 || len(positions) == 0 {
+		return
+	}
+
+	s.posRetryMu.Lock()
+	if front {
+		s.posRetry = append(append([]*models.Position(nil), positions...), s.posRetry...)
+	} else {
+		s.posRetry = append(s.posRetry, positions...)
+	}
+	dropped := 0
+	if len(s.posRetry) > limit {
+		dropped = len(s.posRetry) - limit
+		s.posRetry = append([]*models.Position(nil), s.posRetry[dropped:]...)
+		s.posRetryDropped += int64(dropped)
+	}
+	queued := len(s.posRetry)
+	s.posRetryMu.Unlock()
+
+	// 补写失败放回队列时已由调用方记录错误，只在有位置被丢弃时告警
+	if front && dropped == 0 {
+		return
+	}
+	s.logger.Warn("Queued streaming positions for retry",
+		zap.Int("count", len(positions)),
+		zap.Int("queued", queued),
+		zap.Int("dropped", dropped))
+}
+
+// flushPositionRetry 补写重试队列中的位置 (在一次位置写入成功后调用)，暂时性错误时放回队列，数据有误的位置直接丢弃
+func (s *VehicleService) flushPositionRetry(ctx context.Context) {
+	s.posRetryMu.Lock()
+	batch := s.posRetry
+	s.posRetry = nil
+	s.posRetryMu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	_, err := s.posRepo.CreateBatch(dbCtx, batch)
+	cancel()
+	if err != nil {
+		if !repository.IsDataError(err) {
+			s.logger.Error("Failed to retry streaming positions", zap.Int("count", len(batch)), zap.Error(err))
+			s.queuePositionRetry(batch, true)
+			return
+		}
+		// 队列中有数据本身有误的位置，整批重试永远不会成功，逐条写入并丢弃有误的位置
+		s.logger.Error("Failed to retry streaming positions, falling back to per-row inserts",
+			zap.Int("count", len(batch)), zap.Error(err))
+		if failed := s.insertPositionsIndividually(ctx, batch); len(failed) > 0 {
+			s.queuePositionRetry(failed, true)
+			return
+		}
+	}
+	s.logger.Info("Retried streaming positions", zap.Int("count", len(batch)))
+}
+
+// PositionRetryStatus 获取位置重试队列状态
+func (s *VehicleService) PositionRetryStatus() PositionRetryStatus {
+	s.posRetryMu.Lock()
+	defer s.posRetryMu.Unlock()
+	return PositionRetryStatus{Queued: len(s.posRetry), Dropped: s.posRetryDropped}
+}
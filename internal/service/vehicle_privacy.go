@@ -0,0 +1,292 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+// privacyZoneList 获取隐私区域，首次使用或缓存失效后从数据库加载并缓存
+// 加载失败时沿用上次加载成功的列表；从未加载成功时返回 false，调用方应隐藏全部坐标，下次使用时重试
+func (s *VehicleService) privacyZoneList(ctx context.Context) ([]*models.Geofence, bool) {
+	s.mu.RLock()
+	zones, loaded, valid := s.privacyZones, s.privacyZonesLoaded, s.privacyZonesValid
+	s.mu.RUnlock()
+	if loaded {
+		return zones, true
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	fresh, err := s.geofenceRepo.ListPrivacyZones(dbCtx)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to load privacy zones", zap.Bool("using_cached", valid), zap.Error(err))
+		return zones, valid
+	}
+
+	s.mu.Lock()
+	s.privacyZones = fresh
+	s.privacyZonesLoaded = true
+	s.privacyZonesValid = true
+	s.mu.Unlock()
+	return fresh, true
+}
+
+// privacyRedactorFor 按当前隐私区域创建脱敏器，隐私区域不可用时隐藏全部坐标和地址
+func (s *VehicleService) privacyRedactorFor(ctx context.Context) privacyRedactor {
+	zones, ok := s.privacyZoneList(ctx)
+	return privacyRedactor{zones: zones, withhold: !ok}
+}
+
+// invalidatePrivacyZones 地理围栏变化后标记隐私区域缓存失效 (保留旧列表，重新加载失败时使用)
+func (s *VehicleService) invalidatePrivacyZones() {
+	s.mu.Lock()
+	s.privacyZonesLoaded = false
+	s.mu.Unlock()
+}
+
+// SetGeofencePrivacy 设置地理围栏是否为隐私区域，返回更新后的围栏 (不存在时为 nil)
+func (s *VehicleService) SetGeofencePrivacy(ctx context.Context, id int64, privacy bool) (*models.Geofence, error) {
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	updated, err := s.geofenceRepo.SetPrivacy(dbCtx, id, privacy)
+	if err != nil || !updated {
+		return nil, err
+	}
+	s.invalidatePrivacyZones()
+
+	s.logger.Info("Geofence privacy updated", zap.Int64("geofence_id", id), zap.Bool("privacy", privacy))
+	return s.geofenceRepo.GetByID(dbCtx, id)
+}
+
+// withheldZone 隐私区域不可用时所有位置视为位于该区域：坐标替换为 (0, 0)，地址清空
+var withheldZone = &models.Geofence{}
+
+// privacyRedactor 将隐私区域内的坐标替换为区域中心，地址替换为区域名称
+// withhold 为 true 时 (隐私区域从未加载成功) 不知道哪些位置需要保护，隐藏全部坐标和地址
+type privacyRedactor struct {
+	zones    []*models.Geofence
+	withhold bool
+}
+
+// active 是否需要脱敏
+func (r privacyRedactor) active() bool {
+	return r.withhold || len(r.zones) > 0
+}
+
+// zoneAt 返回坐标所在的隐私区域，不在任何隐私区域内时返回 nil
+func (r privacyRedactor) zoneAt(lat, lng float64) *models.Geofence {
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+	if r.withhold {
+		return withheldZone
+	}
+	for _, g := range r.zones {
+		if distanceMeters(lat, lng, g.Latitude, g.Longitude) <= float64(g.Radius) {
+			return g
+		}
+	}
+	return nil
+}
+
+// byID 返回 ID 对应的隐私区域，不是隐私区域时返回 nil
+func (r privacyRedactor) byID(id *int64) *models.Geofence {
+	if r.withhold {
+		return withheldZone
+	}
+	if id == nil {
+		return nil
+	}
+	for _, g := range r.zones {
+		if g.ID == *id {
+			return g
+		}
+	}
+	return nil
+}
+
+// point 返回脱敏后的坐标，不在任何隐私区域内时原样返回
+func (zones privacyRedactor) point(lat, lng float64) (float64, float64) {
+	if g := zones.zoneAt(lat, lng); g != nil {
+		return g.Latitude, g.Longitude
+	}
+	return lat, lng
+}
+
+// pointPtr 脱敏可空坐标
+func (zones privacyRedactor) pointPtr(lat, lng *float64) {
+	if lat == nil || lng == nil {
+		return
+	}
+	*lat, *lng = zones.point(*lat, *lng)
+}
+
+// address 位于隐私区域 g 内的地址替换为只包含区域名称的地址 (门牌号等会暴露精确位置)
+func (zones privacyRedactor) address(g *models.Geofence, addr **models.Address) {
+	if g == nil || *addr == nil {
+		return
+	}
+	if g == withheldZone {
+		*addr = nil
+		return
+	}
+	*addr = &models.Address{FormattedAddress: g.Name}
+}
+
+// addressAt 按坐标脱敏地址，坐标为空时原样保留
+func (zones privacyRedactor) addressAt(lat, lng *float64, addr **models.Address) {
+	if lat == nil || lng == nil {
+		return
+	}
+	zones.address(zones.zoneAt(*lat, *lng), addr)
+}
+
+// path 脱敏 [lat, lng] 序列
+func (zones privacyRedactor) path(path [][2]float64) {
+	for i := range path {
+		path[i][0], path[i][1] = zones.point(path[i][0], path[i][1])
+	}
+}
+
+// drive 脱敏行程起止坐标、地址和缩略轨迹
+func (zones privacyRedactor) drive(d *models.Drive) {
+	zones.addressAt(d.StartLatitude, d.StartLongitude, &d.StartAddress)
+	zones.addressAt(d.EndLatitude, d.EndLongitude, &d.EndAddress)
+	zones.pointPtr(d.StartLatitude, d.StartLongitude)
+	zones.pointPtr(d.EndLatitude, d.EndLongitude)
+	if d.Polyline != nil {
+		if path, ok := decodePolyline(*d.Polyline); ok {
+			zones.path(path)
+			polyline := encodePolyline(path)
+			d.Polyline = &polyline
+		}
+	}
+}
+
+// parking 脱敏停车坐标和地址
+func (zones privacyRedactor) parking(p *models.Parking) {
+	g := zones.zoneAt(p.Latitude, p.Longitude)
+	if g == nil {
+		return
+	}
+	p.Latitude, p.Longitude = g.Latitude, g.Longitude
+	zones.address(g, &p.Address)
+}
+
+// location 脱敏常去地点坐标和地址
+func (zones privacyRedactor) location(loc *models.FrequentLocation) {
+	g := zones.zoneAt(loc.Latitude, loc.Longitude)
+	if g == nil {
+		return
+	}
+	loc.Latitude, loc.Longitude = g.Latitude, g.Longitude
+	zones.address(g, &loc.Address)
+}
+
+// charge 脱敏充电地址 (充电记录没有坐标，按关联的地理围栏判断)
+func (zones privacyRedactor) charge(cp *models.ChargingProcess) {
+	zones.address(zones.byID(cp.GeofenceID), &cp.Address)
+}
+
+// trip 脱敏旅程各段行程和中途充电的地址
+func (zones privacyRedactor) trip(t *models.Trip) {
+	for _, leg := range t.Legs {
+		zones.addressAt(leg.StartLatitude, leg.StartLongitude, &leg.StartAddress)
+		zones.addressAt(leg.EndLatitude, leg.EndLongitude, &leg.EndAddress)
+	}
+	for _, stop := range t.Charges {
+		zones.address(zones.byID(stop.GeofenceID), &stop.Address)
+	}
+	if len(t.Legs) > 0 {
+		t.StartAddress = t.Legs[0].StartAddress
+		t.EndAddress = t.Legs[len(t.Legs)-1].EndAddress
+	}
+}
+
+// RedactCoordinates 将输出数据中位于隐私区域内的坐标替换为区域中心、地址替换为区域名称 (就地修改，数据库中的数据不受影响)
+// 隐私区域从未加载成功时无法判断哪些位置需要保护，隐藏全部坐标和地址
+// 支持状态、位置点、轨迹、行程、旅程、停车、充电、插枪会话、常去地点和月度报告，其他类型原样保留并记录警告
+func (s *VehicleService) RedactCoordinates(ctx context.Context, v interface{}) {
+	zones := s.privacyRedactorFor(ctx)
+	if !zones.active() {
+		return
+	}
+
+	switch v := v.(type) {
+	case *state.VehicleState:
+		v.Latitude, v.Longitude = zones.point(v.Latitude, v.Longitude)
+	case map[int64]*state.VehicleState:
+		for _, vs := range v {
+			vs.Latitude, vs.Longitude = zones.point(vs.Latitude, vs.Longitude)
+		}
+	case []*models.Position:
+		for _, p := range v {
+			p.Latitude, p.Longitude = zones.point(p.Latitude, p.Longitude)
+		}
+	case [][2]float64:
+		zones.path(v)
+	case []models.ReplayPoint:
+		for i := range v {
+			v[i].Latitude, v[i].Longitude = zones.point(v[i].Latitude, v[i].Longitude)
+		}
+	case *models.MatchedPath:
+		zones.path(v.Path)
+	case []*models.DrivePath:
+		for _, p := range v {
+			zones.path(p.Path)
+		}
+	case *models.PositionCloud:
+		zones.path(v.Points)
+	case *models.Drive:
+		zones.drive(v)
+	case []*models.Drive:
+		for _, d := range v {
+			zones.drive(d)
+		}
+	case *models.Parking:
+		zones.parking(v)
+	case []*models.Parking:
+		for _, p := range v {
+			zones.parking(p)
+		}
+	case *models.ChargingProcess:
+		zones.charge(v)
+	case []*models.ChargingProcess:
+		for _, cp := range v {
+			zones.charge(cp)
+		}
+	case *models.GeofenceChargeStats:
+		for _, cp := range v.Charges {
+			zones.charge(cp)
+		}
+	case []*models.Trip:
+		for _, t := range v {
+			zones.trip(t)
+		}
+	case *models.PlugSession:
+		zones.pointPtr(v.Latitude, v.Longitude)
+	case []*models.PlugSession:
+		for _, ps := range v {
+			zones.pointPtr(ps.Latitude, ps.Longitude)
+		}
+	case []*models.FrequentLocation:
+		for _, loc := range v {
+			zones.location(loc)
+		}
+	case *models.MonthlyReport:
+		if v.LongestDrive != nil {
+			zones.drive(v.LongestDrive)
+		}
+		if loc := v.MostVisitedLocation; loc != nil {
+			zones.location(loc)
+		}
+	default:
+		s.logger.Warn("Unhandled type in RedactCoordinates, output not redacted", zap.String("type", fmt.Sprintf("%T", v)))
+	}
+}
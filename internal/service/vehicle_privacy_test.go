@@ -0,0 +1,48 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+func TestPrivacyRedactorZone(t *testing.T) {
+	home := &models.Geofence{ID: 1, Name: "Home", Latitude: 30.0, Longitude: 120.0, Radius: 100}
+	r := privacyRedactor{zones: []*models.Geofence{home}}
+
+	if lat, lng := r.point(30.0003, 120.0003); lat != home.Latitude || lng != home.Longitude {
+		t.Fatalf("point inside zone = (%v, %v), want zone center", lat, lng)
+	}
+	if lat, lng := r.point(31, 121); lat != 31 || lng != 121 {
+		t.Fatalf("point outside zone = (%v, %v), want unchanged", lat, lng)
+	}
+
+	addr := &models.Address{FormattedAddress: "1 Main Street"}
+	r.address(r.byID(&home.ID), &addr)
+	if addr == nil || addr.FormattedAddress != "Home" {
+		t.Fatalf("address = %+v, want zone name", addr)
+	}
+}
+
+func TestPrivacyRedactorWithholdsWhenZonesUnavailable(t *testing.T) {
+	r := privacyRedactor{withhold: true}
+	if !r.active() {
+		t.Fatal("withholding redactor should be active")
+	}
+
+	if lat, lng := r.point(31, 121); lat != 0 || lng != 0 {
+		t.Fatalf("point = (%v, %v), want withheld (0, 0)", lat, lng)
+	}
+
+	cp := &models.ChargingProcess{Address: &models.Address{FormattedAddress: "1 Main Street"}}
+	r.charge(cp)
+	if cp.Address != nil {
+		t.Fatalf("charge address = %+v, want withheld", cp.Address)
+	}
+
+	p := &models.Parking{Latitude: 31, Longitude: 121, Address: &models.Address{FormattedAddress: "1 Main Street"}}
+	r.parking(p)
+	if p.Latitude != 0 || p.Longitude != 0 || p.Address != nil {
+		t.Fatalf("parking = (%v, %v, %+v), want withheld", p.Latitude, p.Longitude, p.Address)
+	}
+}
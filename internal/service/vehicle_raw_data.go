@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+const (
+	// rawWakeTimeout 调试接口唤醒车辆后等待车辆上线的最长时间，需小于默认的 HTTP_WRITE_TIMEOUT
+	rawWakeTimeout = 20 * time.Second
+	// rawWakeRetryInterval 唤醒后重试获取 vehicle_data 的间隔
+	rawWakeRetryInterval = 3 * time.Second
+)
+
+// RawVehicleData Tesla 返回的 vehicle_data (调试用)
+type RawVehicleData struct {
+	FetchedAt time.Time          `json:"fetched_at"`
+	Cached    bool               `json:"cached"` // true 为最近一次轮询的缓存，false 为本次请求实时获取
+	Data      *tesla.VehicleData `json:"data"`
+	Raw       json.RawMessage    `json:"raw,omitempty"` // Tesla 返回的原始 JSON，仅在请求 raw=true 时返回
+}
+
+// storeVehicleData 缓存车辆最近一次获取的 vehicle_data
+func (s *VehicleService) storeVehicleData(carID int64, data *tesla.VehicleData) {
+	s.mu.Lock()
+	s.lastVehicleData[carID] = &RawVehicleData{FetchedAt: time.Now(), Cached: true, Data: data, Raw: data.Raw}
+	s.mu.Unlock()
+}
+
+// CachedVehicleData 获取车辆最近一次轮询得到的 vehicle_data，没有时返回 nil
+func (s *VehicleService) CachedVehicleData(carID int64) *RawVehicleData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastVehicleData[carID]
+}
+
+// FetchVehicleData 实时获取车辆的 vehicle_data (会唤醒车辆)
+// 车辆休眠时先唤醒，并在 rawWakeTimeout 内重试直到车辆上线；成功后立即触发一次轮询
+func (s *VehicleService) FetchVehicleData(ctx context.Context, car *models.Car) (*RawVehicleData, error) {
+	client, err := s.clientForCar(car)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := client.GetVehicleData(ctx, car.TeslaID)
+	if errors.Is(err, tesla.ErrVehicleUnavailable) {
+		s.logger.Info("Waking vehicle for raw data fetch", zap.Int64("car_id", car.ID))
+		if err := client.WakeUp(ctx, car.TeslaID); err != nil {
+			return nil, err
+		}
+
+		wakeCtx, cancel := context.WithTimeout(ctx, rawWakeTimeout)
+		defer cancel()
+		ticker := time.NewTicker(rawWakeRetryInterval)
+		defer ticker.Stop()
+		for errors.Is(err, tesla.ErrVehicleUnavailable) {
+			select {
+			case <-wakeCtx.Done():
+				return nil, err
+			case <-ticker.C:
+			}
+			data, err = client.GetVehicleData(wakeCtx, car.TeslaID)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.storeVehicleData(car.ID, data)
+	s.triggerImmediatePoll(car.ID)
+
+	return &RawVehicleData{FetchedAt: time.Now(), Data: data, Raw: data.Raw}, nil
+}
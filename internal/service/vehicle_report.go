@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// MonthlyReport 汇总车辆一个自然月 (按 TIMEZONE 时区) 的行程、充电和停车统计，
+// 以及里程最长的行程和停车次数最多的地点。month 为该月内的任意时间
+func (s *VehicleService) MonthlyReport(ctx context.Context, carID int64, month time.Time) (*models.MonthlyReport, error) {
+	month = month.In(s.Location())
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, month.Location())
+	end := start.AddDate(0, 1, 0)
+	report := &models.MonthlyReport{
+		CarID: carID,
+		Month: start.Format("2006-01"),
+		Start: start,
+		End:   end,
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	defer cancel()
+
+	var err error
+	totals := &report.FleetTotals
+	if totals.DistanceKm, totals.DurationMin, totals.DriveCount, err = s.driveRepo.GetStats(dbCtx, carID, start, end); err != nil {
+		return nil, err
+	}
+	if totals.EnergyChargedKwh, totals.ChargeCost, totals.ChargeCount, err = s.chargeRepo.GetStats(dbCtx, carID, start, end); err != nil {
+		return nil, err
+	}
+	if report.ParkingDurationMin, report.ParkingEnergyUsedKwh, _, err = s.parkingRepo.GetStats(dbCtx, carID, start, end); err != nil {
+		return nil, err
+	}
+	if report.LongestDrive, err = s.driveRepo.GetLongestInRange(dbCtx, carID, start, end); err != nil {
+		return nil, err
+	}
+
+	// 一个月内的停车次数较少，不要求达到 FREQUENT_LOCATION_MIN_VISITS，取停车次数最多的地点
+	stays, err := s.parkingRepo.ListStaysByCarID(dbCtx, carID, &start, &end)
+	if err != nil {
+		return nil, err
+	}
+	if locations := s.frequentLocations(ctx, carID, stays, 1); len(locations) > 0 {
+		report.MostVisitedLocation = locations[0]
+	}
+
+	totals.DistanceKm = roundTo(totals.DistanceKm, 1)
+	totals.DurationMin = roundTo(totals.DurationMin, 1)
+	totals.EnergyChargedKwh = roundTo(totals.EnergyChargedKwh, 2)
+	totals.ChargeCost = roundTo(totals.ChargeCost, 2)
+	report.ParkingDurationMin = roundTo(report.ParkingDurationMin, 1)
+	report.ParkingEnergyUsedKwh = roundTo(report.ParkingEnergyUsedKwh, 2)
+	return report, nil
+}
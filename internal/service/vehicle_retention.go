@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pruneBatchSize 每批删除的位置记录数
+const pruneBatchSize = 10000
+
+// ErrPruneInProgress 已有清理任务在执行
+var ErrPruneInProgress = errors.New("position pruning already in progress")
+
+// PruneResult 位置数据清理结果
+type PruneResult struct {
+	RetentionDays int       `json:"retention_days"`
+	Before        time.Time `json:"before"`      // 清理该时间之前的数据
+	Orphaned      int64     `json:"orphaned"`    // 删除的非行程位置点
+	Downsampled   int64     `json:"downsampled"` // 行程轨迹降采样删除的点
+	DurationMs    int64     `json:"duration_ms"` // 耗时
+}
+
+// PositionRetentionDays 配置的位置记录保留天数 (0 表示不清理)
+func (s *VehicleService) PositionRetentionDays() int {
+	return s.cfg.PositionRetentionDays
+}
+
+// retentionLoop 定期清理过期的位置记录
+func (s *VehicleService) retentionLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.cfg.PositionPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.pruneMu.TryLock() {
+				s.logger.Info("Position pruning already in progress, skipping")
+				continue
+			}
+			if _, err := s.prunePositions(ctx, s.cfg.PositionRetentionDays); err != nil {
+				s.logger.Error("Position retention job failed", zap.Error(err))
+			}
+			s.pruneMu.Unlock()
+		}
+	}
+}
+
+// TriggerPrunePositions 在后台执行一次位置数据清理 (供 API 调用)
+// 清理可能耗时较长，结果输出到日志
+func (s *VehicleService) TriggerPrunePositions(retentionDays int) error {
+	if retentionDays <= 0 {
+		return fmt.Errorf("retention days must be positive")
+	}
+
+	// 同一时间只允许一个清理任务
+	if !s.pruneMu.TryLock() {
+		return ErrPruneInProgress
+	}
+
+	// 服务运行中时纳入 wg，停止服务时取消清理并等待其退出
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.RLock()
+	running := s.running
+	stopCh := s.stopCh
+	if running {
+		s.wg.Add(1)
+	}
+	s.mu.RUnlock()
+
+	go func() {
+		if running {
+			defer s.wg.Done()
+		}
+		defer s.pruneMu.Unlock()
+		defer cancel()
+
+		if running {
+			go func() {
+				select {
+				case <-stopCh:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+		}
+
+		if _, err := s.prunePositions(ctx, retentionDays); err != nil {
+			if errors.Is(err, context.Canceled) {
+				s.logger.Info("Manual position pruning cancelled on shutdown")
+				return
+			}
+			s.logger.Error("Manual position pruning failed", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+// prunePositions 清理 retentionDays 天之前的位置记录（调用方需持有 pruneMu）
+// 删除不属于任何行程的位置点（停车/在线期间的采样），
+// 并按配置将旧行程轨迹降采样为每分钟 1 个点
+func (s *VehicleService) prunePositions(ctx context.Context, retentionDays int) (*PruneResult, error) {
+	start := time.Now()
+	result := &PruneResult{
+		RetentionDays: retentionDays,
+		Before:        start.AddDate(0, 0, -retentionDays),
+	}
+
+	s.logger.Info("Pruning old positions",
+		zap.Int("retention_days", retentionDays),
+		zap.Time("before", result.Before))
+
+	orphaned, err := s.posRepo.DeleteOrphanedBefore(ctx, result.Before, pruneBatchSize)
+	result.Orphaned = orphaned
+	if err != nil {
+		return result, fmt.Errorf("delete orphaned positions: %w", err)
+	}
+
+	if s.cfg.DownsampleOldDrives {
+		downsampled, err := s.posRepo.DownsampleDrivesBefore(ctx, result.Before, pruneBatchSize)
+		result.Downsampled = downsampled
+		if err != nil {
+			return result, fmt.Errorf("downsample drive positions: %w", err)
+		}
+	}
+
+	result.DurationMs = time.Since(start).Milliseconds()
+	s.logger.Info("Pruned old positions",
+		zap.Int64("orphaned", result.Orphaned),
+		zap.Int64("downsampled", result.Downsampled),
+		zap.Int64("duration_ms", result.DurationMs))
+
+	return result, nil
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 支持按车覆盖的设置项
+const (
+	SettingSuspendAfterIdleMin = "suspend_after_idle_min" // 空闲多少分钟后暂停轮询
+	SettingSuspendPollInterval = "suspend_poll_interval"  // 暂停状态下的轮询间隔 (如 21m)
+	SettingChargeCostPerKwh    = "charge_cost_per_kwh"    // 每 kWh 充电费用，用于计算充电记录的 cost
+	SettingBatteryCapacityKwh  = "battery_capacity_kwh"   // 可用电池容量，用于将电量变化换算为 kWh
+	SettingValetModePositions  = "valet_mode_positions"   // 代客模式下的位置记录方式: record、pause 或 anonymize
+)
+
+// defaultBatteryCapacityKwh 未设置电池容量时使用的近似值 (Model 3/Y 约 60-82 kWh)
+const defaultBatteryCapacityKwh = 75.0
+
+// ErrInvalidSetting 设置项不存在或值不合法
+var ErrInvalidSetting = errors.New("invalid setting")
+
+// settingValidators 设置项校验
+var settingValidators = map[string]func(string) error{
+	SettingSuspendAfterIdleMin: func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("must be a positive integer")
+		}
+		return nil
+	},
+	SettingSuspendPollInterval: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("must be a duration of at least 1m")
+		}
+		return nil
+	},
+	SettingChargeCostPerKwh: func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			return fmt.Errorf("must be a non-negative number")
+		}
+		return nil
+	},
+	SettingBatteryCapacityKwh: func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return fmt.Errorf("must be a positive number")
+		}
+		return nil
+	},
+	SettingValetModePositions: func(v string) error {
+		if !validValetPositions[v] {
+			return fmt.Errorf("must be one of record, pause, anonymize")
+		}
+		return nil
+	},
+}
+
+// loadCarSettings 从数据库加载所有车辆的设置覆盖
+func (s *VehicleService) loadCarSettings(ctx context.Context) error {
+	settings, err := s.settingsRepo.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	carSettings := make(map[int64]map[string]string)
+	for _, st := range settings {
+		if carSettings[st.CarID] == nil {
+			carSettings[st.CarID] = make(map[string]string)
+		}
+		carSettings[st.CarID][st.Key] = st.Value
+	}
+
+	s.mu.Lock()
+	s.carSettings = carSettings
+	s.mu.Unlock()
+
+	s.logger.Info("Loaded car settings", zap.Int("count", len(settings)))
+	return nil
+}
+
+// GetCarSettings 获取车辆的设置覆盖
+func (s *VehicleService) GetCarSettings(carID int64) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make(map[string]string, len(s.carSettings[carID]))
+	for k, v := range s.carSettings[carID] {
+		result[k] = v
+	}
+	return result
+}
+
+// UpdateCarSettings 更新车辆的设置覆盖，value 为 nil 表示删除该覆盖
+// 修改立即生效，无需重启
+func (s *VehicleService) UpdateCarSettings(ctx context.Context, carID int64, updates map[string]*string) error {
+	// 先整体校验，避免部分写入
+	for key, value := range updates {
+		validate, ok := settingValidators[key]
+		if !ok {
+			return fmt.Errorf("%w: unknown key %s", ErrInvalidSetting, key)
+		}
+		if value != nil {
+			if err := validate(*value); err != nil {
+				return fmt.Errorf("%w: %s %v", ErrInvalidSetting, key, err)
+			}
+		}
+	}
+
+	for key, value := range updates {
+		var err error
+		if value == nil {
+			err = s.settingsRepo.Delete(ctx, carID, key)
+		} else {
+			err = s.settingsRepo.Set(ctx, carID, key, *value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	if s.carSettings[carID] == nil {
+		s.carSettings[carID] = make(map[string]string)
+	}
+	for key, value := range updates {
+		if value == nil {
+			delete(s.carSettings[carID], key)
+		} else {
+			s.carSettings[carID][key] = *value
+		}
+	}
+	s.mu.Unlock()
+
+	s.logger.Info("Car settings updated", zap.Int64("car_id", carID), zap.Int("changes", len(updates)))
+	return nil
+}
+
+// carSetting 获取车辆的单个设置覆盖
+func (s *VehicleService) carSetting(carID int64, key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.carSettings[carID][key]
+	return v, ok
+}
+
+// suspendAfterIdleMin 车辆空闲多少分钟后暂停轮询（优先使用车辆设置）
+func (s *VehicleService) suspendAfterIdleMin(carID int64) int {
+	if v, ok := s.carSetting(carID, SettingSuspendAfterIdleMin); ok {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return s.cfg.SuspendAfterIdleMin
+}
+
+// suspendPollInterval 暂停状态下的轮询间隔（优先使用车辆设置）
+func (s *VehicleService) suspendPollInterval(carID int64) time.Duration {
+	if v, ok := s.carSetting(carID, SettingSuspendPollInterval); ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return s.cfg.SuspendPollInterval
+}
+
+// chargeCostPerKwh 每 kWh 充电费用，未设置时返回 false
+func (s *VehicleService) chargeCostPerKwh(carID int64) (float64, bool) {
+	v, ok := s.carSetting(carID, SettingChargeCostPerKwh)
+	if !ok {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// chargeCost 按车辆设置的电价计算充电费用 (保留两位小数)，未设置电价时返回 false
+func (s *VehicleService) chargeCost(carID int64, energyKwh float64) (float64, bool) {
+	price, ok := s.chargeCostPerKwh(carID)
+	if !ok {
+		return 0, false
+	}
+	return math.Round(energyKwh*price*100) / 100, true
+}
+
+// batteryCapacityKwh 车辆可用电池容量（优先使用车辆设置）
+func (s *VehicleService) batteryCapacityKwh(carID int64) float64 {
+	if v, ok := s.carSetting(carID, SettingBatteryCapacityKwh); ok {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return defaultBatteryCapacityKwh
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"math"
+	"time"
+
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+// withComputedFields 计算状态的派生数据 (充满剩余时间、本次充电电量、瞬时能耗) 并填入 Computed
+// vs 应为状态机返回的副本，原始字段保持不变
+func withComputedFields(vs *state.VehicleState, now time.Time) *state.VehicleState {
+	computed := &state.ComputedState{}
+
+	if vs.CurrentState == state.StateCharging || vs.ChargingState == "Charging" {
+		if vs.TimeToFullCharge > 0 {
+			minutes := int(math.Round(vs.TimeToFullCharge * 60))
+			eta := now.Add(time.Duration(minutes) * time.Minute)
+			computed.ChargeMinutesRemaining = &minutes
+			computed.ChargeETA = &eta
+		}
+		energy := roundTo(vs.ChargeEnergyAdded, 2)
+		computed.ChargeEnergyAddedKwh = &energy
+	}
+
+	// 功率 (kW) / 速度 (km/h) = kWh/km，能量回收 (功率为负) 时不计算
+	if vs.CurrentState == state.StateDriving && vs.Speed != nil && *vs.Speed > 0 && vs.Power > 0 {
+		efficiency := roundTo(float64(vs.Power)*1000/float64(*vs.Speed), 1)
+		computed.EfficiencyWhKm = &efficiency
+	}
+
+	vs.Computed = computed
+	return vs
+}
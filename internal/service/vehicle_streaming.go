@@ -7,21 +7,29 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/config"
 	"github.com/langchou/tesgazer/internal/models"
 	"github.com/langchou/tesgazer/internal/state"
 )
 
+// streamWriteDrainTimeout 停止服务时等待进行中的 Streaming 位置写入的最长时间
+const streamWriteDrainTimeout = 10 * time.Second
+
 // startAllStreaming 为所有车辆启动 Streaming 连接
 func (s *VehicleService) startAllStreaming(ctx context.Context) {
 	// 创建 Streaming 专用的 context
 	s.streamingCtx, s.streamingCancel = context.WithCancel(ctx)
 
-	cars, err := s.carRepo.List(ctx)
+	dbCtx, cancel := s.dbContext(ctx)
+	cars, err := s.carRepo.List(dbCtx)
+	cancel()
 	if err != nil {
 		s.logger.Error("Failed to list cars for streaming", zap.Error(err))
 		return
 	}
 
+	s.setCarIDsByVehicleID(cars)
+
 	for _, car := range cars {
 		s.startStreaming(car)
 	}
@@ -30,6 +38,21 @@ func (s *VehicleService) startAllStreaming(ctx context.Context) {
 		zap.Int("count", len(cars)))
 }
 
+// startMissingStreaming 为尚未建立 Streaming 连接的车辆启动连接 (服务运行中同步到新车辆时调用)
+func (s *VehicleService) startMissingStreaming(cars []*models.Car) {
+	if !s.streamingWebSocketEnabled() || s.streamingCtx == nil {
+		return
+	}
+	for _, car := range cars {
+		s.mu.RLock()
+		_, streaming := s.streamingClients[car.TeslaVehicleID]
+		s.mu.RUnlock()
+		if !streaming {
+			s.startStreaming(car)
+		}
+	}
+}
+
 // stopAllStreaming 停止所有 Streaming 连接
 func (s *VehicleService) stopAllStreaming() {
 	if s.streamingCancel != nil {
@@ -42,14 +65,36 @@ func (s *VehicleService) stopAllStreaming() {
 		s.logger.Debug("Stopped streaming client", zap.Int64("vehicle_id", vehicleID))
 	}
 	s.streamingClients = make(map[int64]*tesla.StreamingClient)
+	s.streamingOwners = make(map[int64]*tesla.Client)
 	s.mu.Unlock()
 
 	s.logger.Info("Stopped all streaming connections")
 }
 
+// stopStreaming 停止单个车辆的 Streaming 连接 (车辆的 vehicle_id 变更后调用)
+func (s *VehicleService) stopStreaming(vehicleID int64) {
+	s.mu.Lock()
+	client, ok := s.streamingClients[vehicleID]
+	delete(s.streamingClients, vehicleID)
+	delete(s.streamingOwners, vehicleID)
+	s.mu.Unlock()
+
+	if ok {
+		client.Stop()
+		s.logger.Info("Stopped streaming for vehicle", zap.Int64("vehicle_id", vehicleID))
+	}
+}
+
 // startStreaming 为单个车辆启动 Streaming 连接
 func (s *VehicleService) startStreaming(car *models.Car) {
-	token := s.teslaClient.GetToken()
+	apiClient, err := s.clientForCar(car)
+	if err != nil {
+		s.logger.Warn("No account available for streaming",
+			zap.Int64("car_id", car.ID),
+			zap.Error(err))
+		return
+	}
+	token := apiClient.GetToken()
 	if token == nil {
 		s.logger.Warn("No token available for streaming",
 			zap.Int64("car_id", car.ID))
@@ -62,6 +107,11 @@ func (s *VehicleService) startStreaming(car *models.Car) {
 	if s.cfg.StreamingHost != "" {
 		client.SetHost(s.cfg.StreamingHost)
 	}
+	client.SetReconnectLimit(s.cfg.StreamingMaxAttempts, s.cfg.StreamingRetryCooldown)
+	client.SetUserAgent(s.cfg.TeslaUserAgent, s.cfg.TeslaXUserAgent)
+	if err := client.SetFields(s.cfg.StreamingFields); err != nil {
+		s.logger.Warn("Invalid STREAMING_FIELDS, using default fields", zap.Error(err))
+	}
 
 	// 设置回调
 	client.SetCallbacks(tesla.StreamingCallbacks{
@@ -69,11 +119,17 @@ func (s *VehicleService) startStreaming(car *models.Car) {
 		OnConnect:        s.handleStreamConnect,
 		OnDisconnect:     s.handleStreamDisconnect,
 		OnVehicleOffline: s.handleStreamVehicleOffline,
+		OnFailed:         s.handleStreamFailed,
 	})
 
-	// 保存客户端引用
+	// 保存客户端引用；并发启动 (如同步车辆时车辆恰好上线) 时保留已有的客户端
 	s.mu.Lock()
+	if _, exists := s.streamingClients[car.TeslaVehicleID]; exists {
+		s.mu.Unlock()
+		return
+	}
 	s.streamingClients[car.TeslaVehicleID] = client
+	s.streamingOwners[car.TeslaVehicleID] = apiClient
 	s.mu.Unlock()
 
 	// 启动自动重连
@@ -84,6 +140,28 @@ func (s *VehicleService) startStreaming(car *models.Car) {
 		zap.Int64("vehicle_id", car.TeslaVehicleID))
 }
 
+// pushStreamingToken 将账号刷新后的 Token 同步给该账号下所有车辆的 Streaming 客户端
+// 已连接的客户端会在当前连接上重新订阅，断线重连时也会使用新 Token
+func (s *VehicleService) pushStreamingToken(owner *tesla.Client, accessToken string) {
+	s.mu.RLock()
+	var clients []*tesla.StreamingClient
+	for vehicleID, client := range s.streamingClients {
+		if s.streamingOwners[vehicleID] == owner {
+			clients = append(clients, client)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, client := range clients {
+		client.SetAccessToken(accessToken)
+	}
+
+	if len(clients) > 0 {
+		s.logger.Info("Pushed refreshed token to streaming clients",
+			zap.Int("count", len(clients)))
+	}
+}
+
 // handleStreamData 处理 Streaming 数据
 // 关键：实现 < 1 秒的唤醒检测
 func (s *VehicleService) handleStreamData(vehicleID int64, data *tesla.StreamData) {
@@ -177,47 +255,52 @@ func (s *VehicleService) handleStreamData(vehicleID int64, data *tesla.StreamDat
 
 	// 更新部分状态数据（不触发完整轮询）
 	machine.UpdateState(func(vs *state.VehicleState) {
-		if data.SOC > 0 {
-			vs.BatteryLevel = data.SOC
-		}
-		if data.EstLat != 0 && data.EstLng != 0 {
-			vs.Latitude = data.EstLat
-			vs.Longitude = data.EstLng
-		}
-		if data.Speed > 0 {
-			speed := tesla.MphToKmh(data.Speed) // mph -> km/h
-			vs.Speed = &speed
-		}
-		vs.Power = data.Power
-		if data.Heading > 0 {
-			vs.Heading = data.Heading
-		}
+		applyStreamData(vs, data)
 	})
 
 	// 核心修改：如果处于驾驶状态，将 Streaming 数据直接入库，实现高频轨迹记录
-	if currentState == state.StateDriving && data.EstLat != 0 && data.EstLng != 0 {
+	if currentState == state.StateDriving {
+		lat, lng, interpolated, ok := s.streamLocation(carID, machine, data)
+		if !ok {
+			return
+		}
+
+		// 服务停止后不再写入；Add 在持有读锁时调用，保证不会与 Stop 中的 Wait 并发
+		s.mu.RLock()
+		if !s.running {
+			s.mu.RUnlock()
+			return
+		}
+		s.streamWrites.Add(1)
+		s.mu.RUnlock()
+
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
+			defer s.streamWrites.Done()
 
 			// 获取当前关联的行程
+			ctx, cancel := s.dbContext(context.Background())
 			activeDrive, err := s.driveRepo.GetActiveDrive(ctx, carID)
-			if err != nil || activeDrive == nil {
-				// 可能行程刚开始还没入库，或者查询失败
+			cancel()
+			if err != nil {
+				s.logger.Warn("Failed to get active drive for streaming position", zap.Int64("car_id", carID), zap.Error(err))
+				return
+			}
+			if activeDrive == nil {
+				// 行程刚开始还没入库
 				return
 			}
 
 			// 构造位置数据
-			speedKmh := tesla.MphToKmh(data.Speed) // mph -> km/h
 			pos := &models.Position{
-				CarID:      carID,
-				DriveID:    &activeDrive.ID,
-				Latitude:   data.EstLat,
-				Longitude:  data.EstLng,
-				Heading:    data.Heading,
-				Speed:      &speedKmh,
-				Power:      data.Power,
-				RecordedAt: time.Now(),
+				CarID:        carID,
+				DriveID:      &activeDrive.ID,
+				Latitude:     lat,
+				Longitude:    lng,
+				Heading:      data.Heading,
+				Speed:        tesla.MphToKmhPtr(data.Speed), // mph -> km/h，无数据时为空
+				Power:        data.Power,
+				RecordedAt:   time.Now(),
+				Interpolated: interpolated,
 			}
 
 			// 填充其他可用数据
@@ -258,16 +341,63 @@ func (s *VehicleService) handleStreamData(vehicleID int64, data *tesla.StreamDat
 				pos.OutsideTemp = cachedState.OutsideTemp
 			}
 
-			// 写入数据库
-			if err := s.posRepo.Create(ctx, pos); err != nil {
-				s.logger.Error("Failed to persist streaming position",
-					zap.Error(err),
-					zap.Int64("car_id", carID))
+			// 代客模式下按 VALET_MODE_POSITIONS 不记录或匿名化坐标
+			if !s.applyValetPolicy(carID, pos) {
+				return
 			}
+
+			// 写入数据库 (启用批量写入时先进入缓冲区)
+			s.enqueuePosition(pos)
+
+			// 更新并推送行程实时统计
+			s.updateLiveDrive(machine, pos)
+			s.queueStreamState(withComputedFields(machine.GetState(), time.Now()))
 		}()
 	}
 }
 
+// streamLocation 驾驶中推送位置使用的坐标，返回 false 表示不记录该位置
+// 推送数据缺少坐标 (字段为空或上报 0,0) 时按 STREAM_MISSING_LOCATION 处理：cached 沿用状态缓存中最近的坐标并标记为插值，
+// poll 立即轮询获取新的 GPS 定位，drop 丢弃该位置；缓存中也没有坐标时同样触发轮询
+func (s *VehicleService) streamLocation(carID int64, machine *state.Machine, data *tesla.StreamData) (lat, lng float64, interpolated, ok bool) {
+	if data.HasLocation() {
+		return data.EstLat, data.EstLng, false, true
+	}
+
+	switch s.cfg.StreamMissingLocation {
+	case config.MissingLocationDrop:
+		return 0, 0, false, false
+	case config.MissingLocationPoll:
+	default:
+		cached := machine.GetState()
+		if cached.Latitude != 0 || cached.Longitude != 0 {
+			s.logger.Debug("Streaming: Missing location, using last known coordinates",
+				zap.Int64("car_id", carID))
+			return cached.Latitude, cached.Longitude, true, true
+		}
+	}
+
+	s.logger.Debug("Streaming: Missing location, polling for a fresh GPS fix",
+		zap.Int64("car_id", carID))
+	s.triggerImmediatePoll(carID)
+	return 0, 0, false, false
+}
+
+// waitStreamWrites 等待进行中的 Streaming 位置写入完成，超时后放弃等待
+func (s *VehicleService) waitStreamWrites(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		s.streamWrites.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		s.logger.Warn("Timed out waiting for streaming position writes", zap.Duration("timeout", timeout))
+	}
+}
+
 // handleStreamConnect Streaming 连接成功回调
 func (s *VehicleService) handleStreamConnect(vehicleID int64) {
 	s.logger.Info("Streaming connected",
@@ -292,14 +422,29 @@ func (s *VehicleService) handleStreamVehicleOffline(vehicleID int64) {
 		zap.Int64("vehicle_id", vehicleID))
 }
 
-// restartStreamingIfNeeded 如果 Streaming 因车辆离线而停止，则重新启动
+// handleStreamFailed Streaming 连续重连失败回调，车辆降级为仅靠轮询跟踪
+func (s *VehicleService) handleStreamFailed(vehicleID int64, attempts int) {
+	retry := "on next wake-up"
+	if s.cfg.StreamingRetryCooldown > 0 {
+		retry = "in " + s.cfg.StreamingRetryCooldown.String()
+	}
+	s.logger.Warn("Streaming degraded: reconnect attempts exhausted, vehicle is tracked by polling only",
+		zap.Int64("vehicle_id", vehicleID),
+		zap.Int("attempts", attempts),
+		zap.String("streaming_host", s.cfg.StreamingHost),
+		zap.String("retry", retry))
+}
+
+// restartStreamingIfNeeded 如果 Streaming 因车辆离线 (或降级后不自动重试) 而停止，则重新启动
 func (s *VehicleService) restartStreamingIfNeeded(carID int64) {
-	if !s.cfg.UseStreamingAPI {
+	if !s.streamingWebSocketEnabled() {
 		return
 	}
 
 	// 根据 carID 找到对应的 vehicleID
-	car, err := s.carRepo.GetByID(context.Background(), carID)
+	ctx, cancel := s.dbContext(context.Background())
+	car, err := s.carRepo.GetByID(ctx, carID)
+	cancel()
 	if err != nil {
 		return
 	}
@@ -314,26 +459,55 @@ func (s *VehicleService) restartStreamingIfNeeded(carID int64) {
 		return
 	}
 
-	// 如果客户端存在且车辆之前离线，重新启动
-	if client.IsVehicleOffline() {
+	// 如果客户端存在且车辆之前离线，或降级后重连循环已退出，重新启动
+	failed, _ := client.IsFailed()
+	if client.IsVehicleOffline() || (failed && !client.IsRunning()) {
 		client.ResetAndRestart(s.streamingCtx)
 	}
 }
 
 // findCarIDByVehicleID 根据 Tesla vehicle_id 查找内部 car_id
+// 优先使用内存映射，未命中时从数据库重建映射 (车辆表被外部修改的情况)
 func (s *VehicleService) findCarIDByVehicleID(vehicleID int64) int64 {
-	ctx := context.Background()
-	cars, err := s.carRepo.List(ctx)
-	if err != nil {
+	s.mu.RLock()
+	carID, ok := s.carIDsByVehicleID[vehicleID]
+	s.mu.RUnlock()
+	if ok {
+		return carID
+	}
+
+	if err := s.reloadCarIDsByVehicleID(context.Background()); err != nil {
+		s.logger.Warn("Failed to reload vehicle ID mapping", zap.Error(err))
 		return 0
 	}
 
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.carIDsByVehicleID[vehicleID]
+}
+
+// reloadCarIDsByVehicleID 从数据库重建 vehicle_id -> car_id 映射 (已删除的车辆随之移除)
+func (s *VehicleService) reloadCarIDsByVehicleID(ctx context.Context) error {
+	dbCtx, cancel := s.dbContext(ctx)
+	cars, err := s.carRepo.List(dbCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	s.setCarIDsByVehicleID(cars)
+	return nil
+}
+
+// setCarIDsByVehicleID 按车辆列表替换 vehicle_id -> car_id 映射
+func (s *VehicleService) setCarIDsByVehicleID(cars []*models.Car) {
+	carIDs := make(map[int64]int64, len(cars))
 	for _, car := range cars {
-		if car.TeslaVehicleID == vehicleID {
-			return car.ID
-		}
+		carIDs[car.TeslaVehicleID] = car.ID
 	}
-	return 0
+	s.mu.Lock()
+	s.carIDsByVehicleID = carIDs
+	s.mu.Unlock()
 }
 
 // triggerImmediatePoll 触发立即轮询
@@ -348,3 +522,23 @@ func (s *VehicleService) triggerImmediatePoll(carID int64) {
 	s.logger.Debug("Triggered immediate poll",
 		zap.Int64("car_id", carID))
 }
+
+// applyStreamData 用 Streaming 推送更新状态中的电量、坐标、速度、功率和航向
+func applyStreamData(vs *state.VehicleState, data *tesla.StreamData) {
+	if data.SOC > 0 {
+		vs.BatteryLevel = data.SOC
+	}
+	if data.HasLocation() {
+		vs.Latitude = data.EstLat
+		vs.Longitude = data.EstLng
+	}
+	// 速度为 0 同样是有效数据 (驾驶中停车等红灯)，需要覆盖之前的速度；
+	// 字段为空时没有数据，保留原值
+	if data.Speed != nil {
+		vs.Speed = tesla.MphToKmhPtr(data.Speed) // mph -> km/h
+	}
+	vs.Power = data.Power
+	if data.Heading > 0 {
+		vs.Heading = data.Heading
+	}
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/state"
+)
+
+func intPtr(v int) *int { return &v }
+
+func TestApplyStreamDataSpeedTransitions(t *testing.T) {
+	steps := []struct {
+		name  string
+		speed *int // 推送的速度 (mph)
+		want  *int // 状态中的速度 (km/h)
+	}{
+		{name: "start", speed: intPtr(30), want: intPtr(tesla.MphToKmh(30))},
+		{name: "stop", speed: intPtr(0), want: intPtr(0)},
+		{name: "missing keeps zero", speed: nil, want: intPtr(0)},
+		{name: "resume", speed: intPtr(20), want: intPtr(tesla.MphToKmh(20))},
+		{name: "missing keeps speed", speed: nil, want: intPtr(tesla.MphToKmh(20))},
+		{name: "stop again", speed: intPtr(0), want: intPtr(0)},
+	}
+
+	vs := &state.VehicleState{}
+	for _, step := range steps {
+		applyStreamData(vs, &tesla.StreamData{Speed: step.speed})
+		switch {
+		case vs.Speed == nil && step.want != nil:
+			t.Fatalf("%s: speed = nil, want %d", step.name, *step.want)
+		case vs.Speed != nil && step.want == nil:
+			t.Fatalf("%s: speed = %d, want nil", step.name, *vs.Speed)
+		case vs.Speed != nil && *vs.Speed != *step.want:
+			t.Fatalf("%s: speed = %d, want %d", step.name, *vs.Speed, *step.want)
+		}
+	}
+}
+
+func TestApplyStreamDataNoSpeedBeforeFirstSample(t *testing.T) {
+	vs := &state.VehicleState{}
+	applyStreamData(vs, &tesla.StreamData{})
+	if vs.Speed != nil {
+		t.Fatalf("speed = %d, want nil when no speed was reported", *vs.Speed)
+	}
+}
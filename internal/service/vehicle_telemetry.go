@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/config"
+)
+
+var (
+	// ErrTelemetryDisabled 未启用 Fleet Telemetry 模式
+	ErrTelemetryDisabled = errors.New("fleet telemetry disabled")
+	// ErrUnknownVehicle 推送数据中的车辆不存在
+	ErrUnknownVehicle = errors.New("unknown vehicle")
+)
+
+// streamingWebSocketEnabled 是否使用旧版 Streaming WebSocket 接收推送数据
+func (s *VehicleService) streamingWebSocketEnabled() bool {
+	return s.cfg.UseStreamingAPI && s.cfg.TelemetryMode != config.TelemetryModeFleet
+}
+
+// TelemetryEnabled 是否接收 Fleet Telemetry 推送
+func (s *VehicleService) TelemetryEnabled() bool {
+	return s.cfg.TelemetryMode == config.TelemetryModeFleet
+}
+
+// TelemetryToken Fleet Telemetry 接收接口的访问令牌
+func (s *VehicleService) TelemetryToken() string {
+	return s.cfg.TelemetryToken
+}
+
+// HandleTelemetry 处理 Fleet Telemetry 推送的数据
+// 按 VIN 找到车辆后转换为 Streaming 数据格式，与 Streaming WebSocket 走相同的处理流程
+// (唤醒检测、驾驶/充电检测、高频轨迹记录)
+func (s *VehicleService) HandleTelemetry(ctx context.Context, payload *tesla.TelemetryPayload) error {
+	if !s.TelemetryEnabled() {
+		return ErrTelemetryDisabled
+	}
+
+	dbCtx, cancel := s.dbContext(ctx)
+	car, err := s.carRepo.GetByVIN(dbCtx, payload.VIN)
+	cancel()
+	if err != nil {
+		return err
+	}
+	if car == nil {
+		return ErrUnknownVehicle
+	}
+
+	s.logger.Debug("Received fleet telemetry",
+		zap.Int64("car_id", car.ID),
+		zap.Int("fields", len(payload.Values)))
+
+	s.handleStreamData(car.TeslaVehicleID, payload.StreamData())
+	return nil
+}
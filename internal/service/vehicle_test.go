@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/langchou/tesgazer/internal/config"
+	"github.com/langchou/tesgazer/internal/repository"
+)
+
+func TestSleepBackoffProgression(t *testing.T) {
+	const (
+		floor   = 30 * time.Second
+		ceiling = 5 * time.Minute
+		factor  = 2.0
+	)
+
+	tests := []struct {
+		name    string
+		current time.Duration
+		want    []time.Duration // 连续轮询得到的间隔
+	}{
+		{
+			name:    "no previous interval starts at floor",
+			current: 0,
+			want:    []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute},
+		},
+		{
+			name:    "from online interval backs off from floor",
+			current: 15 * time.Second,
+			want:    []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute},
+		},
+		{
+			name:    "from suspended carries over to ceiling",
+			current: 21 * time.Minute,
+			want:    []time.Duration{5 * time.Minute, 5 * time.Minute},
+		},
+		{
+			name:    "mid-way continues backoff",
+			current: 90 * time.Second,
+			want:    []time.Duration{3 * time.Minute, 5 * time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := tt.current
+			for i, want := range tt.want {
+				got := sleepBackoff(current, floor, ceiling, factor)
+				if got != want {
+					t.Fatalf("poll %d: sleepBackoff(%v) = %v, want %v", i, current, got, want)
+				}
+				current = got
+			}
+		})
+	}
+}
+
+func TestSleepBackoffCeilingBelowFloor(t *testing.T) {
+	if got := sleepBackoff(time.Minute, 30*time.Second, 10*time.Second, 2); got != 30*time.Second {
+		t.Fatalf("sleepBackoff = %v, want floor 30s", got)
+	}
+}
+
+// blockingDB 返回连接到一个接受连接但从不响应的服务端的数据库，模拟挂起的数据库
+func blockingDB(t *testing.T) *repository.DB {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+
+	poolCfg, err := pgxpool.ParseConfig(fmt.Sprintf("postgres://test@%s/test?sslmode=disable", ln.Addr()))
+	if err != nil {
+		t.Fatalf("parse config: %v", err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
+	if err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	return &repository.DB{Pool: pool}
+}
+
+func TestPollAbandonsHungQueryAtDBQueryTimeout(t *testing.T) {
+	db := blockingDB(t)
+	core, logs := observer.New(zap.ErrorLevel)
+	cfg := &config.Config{DBQueryTimeout: 50 * time.Millisecond}
+	s := NewVehicleService(cfg, zap.New(core), repository.NewCarRepository(db),
+		nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		s.pollAllVehiclesWithBackoff(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("poll still blocked on the database after 5s")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("poll returned after %v, want it to give up at DB_QUERY_TIMEOUT", elapsed)
+	}
+
+	entries := logs.FilterMessage("Failed to list cars").All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d \"Failed to list cars\" logs, want 1", len(entries))
+	}
+	err, _ := entries[0].ContextMap()["error"].(string)
+	if !strings.Contains(err, context.DeadlineExceeded.Error()) {
+		t.Fatalf("error = %q, want a deadline error", err)
+	}
+}
+
+// testDB 连接 TEST_DATABASE_URL 指定的数据库并执行迁移，未设置时跳过测试
+func testDB(t *testing.T) *repository.DB {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	db, err := repository.New(context.Background(), url, repository.PoolConfig{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(db.Close)
+	if err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	return db
+}
+
+func TestDBContextCancelsSlowDatabaseQuery(t *testing.T) {
+	db := testDB(t)
+
+	s := &VehicleService{cfg: &config.Config{DBQueryTimeout: 50 * time.Millisecond}}
+	ctx, cancel := s.dbContext(context.Background())
+	defer cancel()
+
+	start := time.Now()
+	_, err := db.Pool.Exec(ctx, "SELECT pg_sleep(5)")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want wrapped context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("query returned after %v, want it cancelled at the timeout", elapsed)
+	}
+}
+
+// newRunningTestService 创建标记为运行中的服务，并启动一个在 stopCh 关闭后退出的后台任务
+func newRunningTestService(t *testing.T) (*VehicleService, <-chan struct{}) {
+	t.Helper()
+	s := NewVehicleService(&config.Config{}, zap.NewNop(), nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	s.running = true
+
+	exited := make(chan struct{})
+	stopCh := s.stopCh
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		<-stopCh
+		close(exited)
+	}()
+	return s, exited
+}
+
+func TestStopTwiceIsSafe(t *testing.T) {
+	s, exited := newRunningTestService(t)
+
+	s.Stop()
+	s.Stop()
+
+	select {
+	case <-exited:
+	default:
+		t.Fatal("background task still running after Stop")
+	}
+}
+
+func TestConcurrentStopIsSafe(t *testing.T) {
+	s, exited := newRunningTestService(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Stop()
+		}()
+	}
+	wg.Wait()
+
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("background task still running after Stop")
+	}
+}
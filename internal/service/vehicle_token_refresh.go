@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+)
+
+// tokenRefreshCheckInterval 检查账号 Token 是否需要刷新的间隔
+const tokenRefreshCheckInterval = 5 * time.Minute
+
+// AccountAuthStatus 账号的认证状态
+type AccountAuthStatus struct {
+	Account        string     `json:"account"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`   // 访问令牌过期时间
+	LastRefresh    *time.Time `json:"last_refresh,omitempty"` // 最近一次成功刷新的时间 (本次启动后)
+	ReauthRequired bool       `json:"reauth_required"`        // Refresh Token 已失效，需要重新提交 Token
+	Error          string     `json:"error,omitempty"`        // 最近一次刷新失败的原因
+}
+
+// SetTokenSaver 设置 Token 刷新后的持久化函数
+func (s *VehicleService) SetTokenSaver(fn func(map[string]*tesla.Token) error) {
+	s.tokenSaveMu.Lock()
+	s.tokenSaver = fn
+	s.tokenSaveMu.Unlock()
+}
+
+// saveAccountTokens 持久化所有账号当前的 Token
+func (s *VehicleService) saveAccountTokens() {
+	s.tokenSaveMu.Lock()
+	defer s.tokenSaveMu.Unlock()
+
+	if s.tokenSaver == nil {
+		return
+	}
+	if err := s.tokenSaver(s.AccountTokens()); err != nil {
+		s.logger.Error("Failed to save refreshed tokens", zap.Error(err))
+	}
+}
+
+// markTokenRefreshed 记录账号刷新成功 (主动刷新和请求时按需刷新都会调用)
+func (s *VehicleService) markTokenRefreshed(acct *teslaAccount) {
+	s.mu.Lock()
+	acct.lastRefresh = time.Now()
+	acct.refreshErr = ""
+	acct.reauthRequired = false
+	s.mu.Unlock()
+}
+
+// AccountAuthStatuses 获取所有账号的认证状态，按账号标识排序
+func (s *VehicleService) AccountAuthStatuses() []AccountAuthStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make([]AccountAuthStatus, 0, len(s.accounts))
+	for label, acct := range s.accounts {
+		status := AccountAuthStatus{
+			Account:        label,
+			ReauthRequired: acct.reauthRequired,
+			Error:          acct.refreshErr,
+		}
+		if token := acct.client.GetToken(); token != nil {
+			expiresAt := token.ExpiresAt()
+			status.ExpiresAt = &expiresAt
+		}
+		if !acct.lastRefresh.IsZero() {
+			lastRefresh := acct.lastRefresh
+			status.LastRefresh = &lastRefresh
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Account < statuses[j].Account })
+	return statuses
+}
+
+// tokenRefreshLoop 定期在访问令牌过期前主动刷新，避免长时间休眠、请求很少时 Refresh Token 也随之失效
+func (s *VehicleService) tokenRefreshLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(tokenRefreshCheckInterval)
+	defer ticker.Stop()
+
+	s.refreshExpiringTokens(ctx)
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshExpiringTokens(ctx)
+		}
+	}
+}
+
+// refreshExpiringTokens 刷新距过期不足 TOKEN_REFRESH_MARGIN 的账号 Token
+// 认证服务拒绝 Refresh Token 时标记为需要重新认证，不再重试，直到提交新的 Token
+func (s *VehicleService) refreshExpiringTokens(ctx context.Context) {
+	s.mu.RLock()
+	accounts := make([]*teslaAccount, 0, len(s.accounts))
+	for _, acct := range s.accounts {
+		if !acct.reauthRequired {
+			accounts = append(accounts, acct)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, acct := range accounts {
+		token := acct.client.GetToken()
+		if token == nil || token.RefreshToken == "" {
+			continue
+		}
+
+		// 提前量不超过令牌有效期的一半，避免配置过大时每次检查都刷新
+		margin := s.cfg.TokenRefreshMargin
+		if lifetime := time.Duration(token.ExpiresIn) * time.Second; margin > lifetime/2 {
+			margin = lifetime / 2
+		}
+		if time.Until(token.ExpiresAt()) > margin {
+			continue
+		}
+
+		refreshCtx, cancel := context.WithTimeout(ctx, s.cfg.PollRequestTimeout)
+		err := acct.client.RefreshToken(refreshCtx)
+		cancel()
+		if err == nil {
+			s.logger.Info("Proactively refreshed access token",
+				zap.String("account", acct.label),
+				zap.Time("expires_at", acct.client.GetToken().ExpiresAt()))
+			continue
+		}
+
+		rejected := errors.Is(err, tesla.ErrRefreshRejected)
+		s.mu.Lock()
+		acct.refreshErr = err.Error()
+		acct.reauthRequired = rejected
+		s.mu.Unlock()
+
+		if rejected {
+			s.logger.Error("Refresh token rejected, re-authentication required",
+				zap.String("account", acct.label),
+				zap.Error(err))
+		} else {
+			s.logger.Warn("Failed to refresh access token, will retry",
+				zap.String("account", acct.label),
+				zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/langchou/tesgazer/internal/api/tesla"
+	"github.com/langchou/tesgazer/internal/models"
+	"github.com/langchou/tesgazer/pkg/ws"
+)
+
+// lowTire 低于阈值的轮胎
+type lowTire struct {
+	wheel     string  // fl/fr/rl/rr
+	pressure  float64 // 胎压 (bar)
+	threshold float64 // 阈值 (bar)
+}
+
+// checkTirePressure 检测胎压过低
+// 同一轮胎连续 TPMS_ALERT_POLLS 次轮询低于阈值时告警一次，胎压恢复后重新计数；
+// 没有胎压数据 (车辆未上报或传感器未唤醒) 时保持计数不变
+func (s *VehicleService) checkTirePressure(ctx context.Context, carID int64, vehicleState *tesla.VehicleState) {
+	wheels := []struct {
+		name      string
+		pressure  *float64
+		threshold float64
+	}{
+		{"fl", vehicleState.TpmsPressureFL, s.cfg.TPMSMinFrontBar},
+		{"fr", vehicleState.TpmsPressureFR, s.cfg.TPMSMinFrontBar},
+		{"rl", vehicleState.TpmsPressureRL, s.cfg.TPMSMinRearBar},
+		{"rr", vehicleState.TpmsPressureRR, s.cfg.TPMSMinRearBar},
+	}
+	polls := s.cfg.TPMSAlertPolls
+	if polls < 1 {
+		polls = 1
+	}
+
+	var low []lowTire
+	s.mu.Lock()
+	counts := s.tpmsLowPolls[carID]
+	if counts == nil {
+		counts = make(map[string]int)
+		s.tpmsLowPolls[carID] = counts
+	}
+	for _, w := range wheels {
+		if w.threshold <= 0 || w.pressure == nil || *w.pressure <= 0 {
+			continue
+		}
+		if *w.pressure >= w.threshold {
+			delete(counts, w.name)
+			continue
+		}
+		counts[w.name]++
+		if counts[w.name] == polls {
+			low = append(low, lowTire{wheel: w.name, pressure: *w.pressure, threshold: w.threshold})
+		}
+	}
+	s.mu.Unlock()
+
+	if len(low) == 0 {
+		return
+	}
+
+	// 停车期间同时记录为停车事件
+	var parkingID *int64
+	dbCtx, cancel := s.dbContext(ctx)
+	parking, err := s.parkingRepo.GetActiveParking(dbCtx, carID)
+	cancel()
+	if err != nil {
+		s.logger.Warn("Failed to get active parking for tire pressure event", zap.Int64("car_id", carID), zap.Error(err))
+	} else if parking != nil {
+		parkingID = &parking.ID
+	}
+
+	now := time.Now()
+	for _, t := range low {
+		details := map[string]interface{}{
+			"wheel":         t.wheel,
+			"pressure_bar":  t.pressure,
+			"threshold_bar": t.threshold,
+		}
+
+		s.logger.Warn("Low tire pressure detected",
+			zap.Int64("car_id", carID),
+			zap.String("wheel", t.wheel),
+			zap.Float64("pressure_bar", t.pressure),
+			zap.Float64("threshold_bar", t.threshold))
+
+		if parkingID != nil {
+			s.recordParkingEventWithDetails(ctx, *parkingID, models.EventLowTirePressure, now, details)
+		}
+
+		s.sendAlert(carID, ws.MsgTypeVehicleAlert, map[string]interface{}{
+			"car_id":     carID,
+			"parking_id": parkingID,
+			"event_type": models.EventLowTirePressure,
+			"event_time": now,
+			"details":    details,
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"github.com/langchou/tesgazer/internal/config"
+	"github.com/langchou/tesgazer/internal/models"
+)
+
+// valetAnonymizeDigits 代客模式下匿名化位置保留的小数位 (约 1 km)
+const valetAnonymizeDigits = 2
+
+// validValetPositions 合法的代客模式位置记录方式
+var validValetPositions = map[string]bool{
+	config.ValetPositionsRecord:    true,
+	config.ValetPositionsPause:     true,
+	config.ValetPositionsAnonymize: true,
+}
+
+// valetPositionPolicy 车辆当前的位置记录方式
+// 未开启代客模式时为 record；开启时优先使用车辆设置，其次使用 VALET_MODE_POSITIONS (值不合法时照常记录)
+func (s *VehicleService) valetPositionPolicy(carID int64) string {
+	machine, ok := s.stateManager.Get(carID)
+	if !ok || !machine.GetState().ValetMode {
+		return config.ValetPositionsRecord
+	}
+
+	policy := s.cfg.ValetModePositions
+	if v, ok := s.carSetting(carID, SettingValetModePositions); ok {
+		policy = v
+	}
+	if !validValetPositions[policy] {
+		return config.ValetPositionsRecord
+	}
+	return policy
+}
+
+// applyValetPolicy 按代客模式的位置记录方式处理位置，返回 false 表示不记录该位置
+func (s *VehicleService) applyValetPolicy(carID int64, pos *models.Position) bool {
+	switch s.valetPositionPolicy(carID) {
+	case config.ValetPositionsPause:
+		return false
+	case config.ValetPositionsAnonymize:
+		pos.Latitude = roundTo(pos.Latitude, valetAnonymizeDigits)
+		pos.Longitude = roundTo(pos.Longitude, valetAnonymizeDigits)
+	}
+	return true
+}
+
+// valetCoordinates 按代客模式的位置记录方式处理行程、停车和充电记录的坐标
+// record 为 false 表示不记录坐标 (pause)，此时返回的坐标已匿名化，仅供必须有坐标的记录使用；
+// precise 为 false 时坐标已匿名化，不应再解析地址或匹配地理围栏，否则同样会泄露精确位置
+func (s *VehicleService) valetCoordinates(carID int64, lat, lng float64) (outLat, outLng float64, record, precise bool) {
+	switch s.valetPositionPolicy(carID) {
+	case config.ValetPositionsPause:
+		return roundTo(lat, valetAnonymizeDigits), roundTo(lng, valetAnonymizeDigits), false, false
+	case config.ValetPositionsAnonymize:
+		return roundTo(lat, valetAnonymizeDigits), roundTo(lng, valetAnonymizeDigits), true, false
+	}
+	return lat, lng, true, true
+}
@@ -51,6 +51,7 @@ type VehicleState struct {
 	OutsideTemp   *float64  `json:"outside_temp"`
 	Locked        bool      `json:"locked"`
 	SentryMode    bool      `json:"sentry_mode"`
+	ValetMode     bool      `json:"valet_mode"` // 代客模式
 	PluggedIn     bool      `json:"plugged_in"`
 	ChargingState string    `json:"charging_state"`
 	ChargerPower  int       `json:"charger_power"`
@@ -76,9 +77,41 @@ type VehicleState struct {
 	ChargerCurrent     int     `json:"charger_current"`        // 充电电流
 	UsableBatteryLevel int     `json:"usable_battery_level"`   // 可用电量
 	IdealRangeKm       float64 `json:"ideal_range_km"`         // 理想续航 (km)
+	ChargeEnergyAdded  float64 `json:"charge_energy_added"`    // 本次充电已充入电量 (kWh)
 	// 休眠相关
 	CanSleep         bool   `json:"can_sleep"`          // 是否满足休眠条件
 	SleepBlockReason string `json:"sleep_block_reason"` // 如果不能休眠，原因
+	// 进行中行程的实时统计，驾驶中才有 (每个位置点更新，替换为新的快照而不修改原值)
+	LiveDrive *LiveDriveStats `json:"live_drive,omitempty"`
+	// 服务端计算的派生数据，只出现在返回给 API/WebSocket 的副本中
+	Computed *ComputedState `json:"computed,omitempty"`
+}
+
+// LiveDriveStats 进行中行程的实时统计 (按内存中的位置点累计，行程结束时以数据库统计为准)
+type LiveDriveStats struct {
+	DriveID        int64             `json:"drive_id"`
+	Samples        int               `json:"samples"`                    // 已累计的位置点数
+	SpeedMax       *int              `json:"speed_max,omitempty"`        // 最高速度 (km/h)
+	PowerMax       *int              `json:"power_max,omitempty"`        // 最大功率 (kW)
+	PowerMin       *int              `json:"power_min,omitempty"`        // 最小功率 (kW，负值=回收)
+	InsideTempAvg  *float64          `json:"inside_temp_avg,omitempty"`  // 平均车内温度
+	OutsideTempAvg *float64          `json:"outside_temp_avg,omitempty"` // 平均车外温度
+	Recent         []LiveDriveSample `json:"recent"`                     // 最近的位置点 (最多 LIVE_DRIVE_BUFFER_SIZE 个，按时间升序)
+}
+
+// LiveDriveSample 实时统计中保留的位置点
+type LiveDriveSample struct {
+	RecordedAt time.Time `json:"recorded_at"`
+	Speed      *int      `json:"speed"` // km/h
+	Power      int       `json:"power"` // kW
+}
+
+// ComputedState 由原始字段计算的派生数据，不适用的字段为空
+type ComputedState struct {
+	ChargeMinutesRemaining *int       `json:"charge_minutes_remaining,omitempty"` // 充满剩余时间 (分钟)，充电中
+	ChargeETA              *time.Time `json:"charge_eta,omitempty"`               // 预计充满时间，充电中
+	ChargeEnergyAddedKwh   *float64   `json:"charge_energy_added_kwh,omitempty"`  // 本次充电已充入电量 (kWh)，充电中
+	EfficiencyWhKm         *float64   `json:"efficiency_wh_km,omitempty"`         // 瞬时能耗 (Wh/km)，驾驶中耗电时
 }
 
 // Machine 车辆状态机
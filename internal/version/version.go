@@ -0,0 +1,62 @@
+// Package version 构建版本信息
+// 版本号、提交哈希和构建时间在编译时通过 -ldflags 注入，例如:
+//
+//	go build -ldflags "-X github.com/langchou/tesgazer/internal/version.Version=v1.2.0 \
+//	  -X github.com/langchou/tesgazer/internal/version.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/langchou/tesgazer/internal/version.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+//
+// 未注入时从 Go 工具链嵌入的模块和 VCS 信息中读取
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// 编译时通过 -ldflags "-X" 注入
+var (
+	Version   = "" // 版本号，如 v1.2.0
+	Commit    = "" // git 提交哈希
+	BuildTime = "" // 构建时间 (RFC3339)
+)
+
+// Info 构建信息
+type Info struct {
+	Version     string `json:"version"`                // 版本号，未注入时为模块版本 (本地构建为 (devel))
+	Commit      string `json:"commit,omitempty"`       // git 提交哈希
+	BuildTime   string `json:"build_time,omitempty"`   // 构建时间
+	GoVersion   string `json:"go_version"`             // 编译使用的 Go 版本
+	VCSRevision string `json:"vcs_revision,omitempty"` // Go 工具链嵌入的提交哈希 (从 git 仓库构建时存在)
+	VCSTime     string `json:"vcs_time,omitempty"`     // 提交时间 (从 git 仓库构建时存在)
+	VCSModified bool   `json:"vcs_modified,omitempty"` // 构建时工作区是否有未提交的修改
+}
+
+// Get 获取构建信息，ldflags 注入的值优先
+func Get() Info {
+	info := Info{Version: "unknown", GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Version = bi.Main.Version
+		for _, setting := range bi.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.VCSRevision = setting.Value
+				info.Commit = setting.Value
+			case "vcs.time":
+				info.VCSTime = setting.Value
+			case "vcs.modified":
+				info.VCSModified = setting.Value == "true"
+			}
+		}
+	}
+
+	if Version != "" {
+		info.Version = Version
+	}
+	if Commit != "" {
+		info.Commit = Commit
+	}
+	if BuildTime != "" {
+		info.BuildTime = BuildTime
+	}
+	return info
+}
@@ -2,7 +2,9 @@ package ws
 
 import (
 	"encoding/json"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -10,15 +12,45 @@ import (
 
 // MessageType WebSocket 消息类型
 const (
-	MsgTypeInit        = "init"         // 初始化数据（车辆列表+状态）
-	MsgTypeStateUpdate = "state_update" // 状态更新
-	MsgTypeError       = "error"        // 错误消息
+	MsgTypeInit         = "init"          // 初始化数据（车辆列表+状态）
+	MsgTypeStateUpdate  = "state_update"  // 状态更新
+	MsgTypeError        = "error"         // 错误消息
+	MsgTypeParkingAlert = "parking_alert" // 停车告警（疑似入侵等）
+	MsgTypeVehicleAlert = "vehicle_alert" // 车辆告警（胎压过低等，不限于停车期间）
+)
+
+const (
+	// replayBufferSize 每辆车保留的最近事件数 (用于断线重连后补发)
+	replayBufferSize = 32
+	// defaultSendBufferSize 每个客户端发送队列的默认长度，队列满时视为慢消费者断开
+	defaultSendBufferSize = 256
+	// maxReadMessageSize 客户端消息的最大字节数，超出时断开连接 (服务端不处理客户端消息)
+	maxReadMessageSize = 4096
 )
 
 // Message WebSocket 消息结构
+// Seq 为单调递增的序号，init 消息携带当前最新序号；Replay 表示重连后补发的历史事件
 type Message struct {
-	Type string      `json:"type"`
-	Data interface{} `json:"data"`
+	Type   string      `json:"type"`
+	Seq    uint64      `json:"seq,omitempty"`
+	Replay bool        `json:"replay,omitempty"`
+	Data   interface{} `json:"data"`
+}
+
+// outbound 待发送的消息，由 Run 协程统一分配序号
+type outbound struct {
+	raw   []byte // 原始消息，不分配序号
+	typ   string
+	data  interface{}
+	carID int64
+	event bool // 是否保存到该车辆的事件缓冲区
+}
+
+// replayEntry 事件缓冲区中的一条消息
+type replayEntry struct {
+	seq  uint64
+	typ  string
+	data json.RawMessage // 发送时的数据快照
 }
 
 // InitData 初始化数据
@@ -28,43 +60,88 @@ type InitData struct {
 }
 
 // Client WebSocket 客户端
+// 订阅者 (NewSubscriber 创建) 没有 WebSocket 连接，由调用方从 Messages 读取消息 (如 SSE)
 type Client struct {
-	hub  *Hub
-	conn *websocket.Conn
-	send chan []byte
+	hub     *Hub
+	conn    *websocket.Conn
+	send    chan []byte
+	lastSeq uint64 // 重连前收到的最后序号，0 表示新连接
+	carID   int64  // 只接收该车辆的消息，0 表示全部车辆
 }
 
 // Hub WebSocket 连接管理中心
 type Hub struct {
 	logger     *zap.Logger
 	clients    map[*Client]bool
-	broadcast  chan []byte
+	broadcast  chan outbound
 	register   chan *Client
 	unregister chan *Client
 	mu         sync.RWMutex
 
 	// 初始数据提供者回调
 	getInitData func() *InitData
+	// 单车辆当前状态提供者回调 (订阅单个车辆的客户端连接时发送)
+	getCarState func(carID int64) interface{}
+
+	// 状态更新合并：每辆车只保留最新一条，按 flushInterval 批量发送
+	flushInterval time.Duration
+	pending       map[int64]interface{}
+	pendingMu     sync.Mutex
+
+	// 每个客户端发送队列的长度
+	sendBufferSize int
+
+	// 消息序号和每辆车最近的事件 (仅在 Run 协程中访问)
+	seq    uint64
+	events map[int64][]replayEntry
 }
 
 // NewHub 创建 Hub
 func NewHub(logger *zap.Logger) *Hub {
 	return &Hub{
-		logger:     logger,
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+		logger:         logger,
+		clients:        make(map[*Client]bool),
+		broadcast:      make(chan outbound, 256),
+		register:       make(chan *Client),
+		unregister:     make(chan *Client),
+		pending:        make(map[int64]interface{}),
+		events:         make(map[int64][]replayEntry),
+		sendBufferSize: defaultSendBufferSize,
 	}
 }
 
+// SetFlushInterval 设置状态更新的合并发送间隔（需在 Run 之前调用，<= 0 表示不合并）
+func (h *Hub) SetFlushInterval(interval time.Duration) {
+	h.flushInterval = interval
+}
+
+// SetSendBufferSize 设置每个客户端发送队列的长度（对之后连接的客户端生效，<= 0 使用默认值）
+func (h *Hub) SetSendBufferSize(size int) {
+	if size <= 0 {
+		size = defaultSendBufferSize
+	}
+	h.sendBufferSize = size
+}
+
 // SetInitDataProvider 设置初始数据提供者
 func (h *Hub) SetInitDataProvider(provider func() *InitData) {
 	h.getInitData = provider
 }
 
+// SetCarStateProvider 设置单车辆当前状态提供者，返回 nil 表示没有状态
+func (h *Hub) SetCarStateProvider(provider func(carID int64) interface{}) {
+	h.getCarState = provider
+}
+
 // Run 运行 Hub
 func (h *Hub) Run() {
+	var flushC <-chan time.Time
+	if h.flushInterval > 0 {
+		flushTicker := time.NewTicker(h.flushInterval)
+		defer flushTicker.Stop()
+		flushC = flushTicker.C
+	}
+
 	for {
 		select {
 		case client := <-h.register:
@@ -73,8 +150,13 @@ func (h *Hub) Run() {
 			h.mu.Unlock()
 			h.logger.Info("WebSocket client connected", zap.Int("total_clients", len(h.clients)))
 
-			// 发送初始数据
-			h.sendInitData(client)
+			// 先补发断线期间的事件，再发送当前状态，保证客户端最终显示最新状态
+			h.sendReplay(client)
+			if client.carID != 0 {
+				h.sendCarState(client)
+			} else {
+				h.sendInitData(client)
+			}
 
 		case client := <-h.unregister:
 			h.mu.Lock()
@@ -85,20 +167,122 @@ func (h *Hub) Run() {
 			h.mu.Unlock()
 			h.logger.Info("WebSocket client disconnected", zap.Int("total_clients", len(h.clients)))
 
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					// 慢消费者，关闭连接
-					close(client.send)
-					delete(h.clients, client)
-				}
+		case out := <-h.broadcast:
+			h.publish(out)
+
+		case <-flushC:
+			h.flushPending()
+		}
+	}
+}
+
+// publish 为消息分配序号并发送给所有客户端，事件同时保存到该车辆的事件缓冲区
+func (h *Hub) publish(out outbound) {
+	if out.raw != nil {
+		h.deliver(out.raw, 0)
+		return
+	}
+
+	data, err := json.Marshal(out.data)
+	if err != nil {
+		h.logger.Error("Failed to marshal broadcast message", zap.String("type", out.typ), zap.Error(err))
+		return
+	}
+	h.seq++
+	message, err := json.Marshal(Message{Type: out.typ, Seq: h.seq, Data: json.RawMessage(data)})
+	if err != nil {
+		h.logger.Error("Failed to marshal broadcast message", zap.String("type", out.typ), zap.Error(err))
+		return
+	}
+
+	if out.event {
+		events := append(h.events[out.carID], replayEntry{seq: h.seq, typ: out.typ, data: data})
+		if len(events) > replayBufferSize {
+			events = events[len(events)-replayBufferSize:]
+		}
+		h.events[out.carID] = events
+	}
+
+	h.deliver(message, out.carID)
+}
+
+// sendReplay 补发客户端断线期间 (序号大于 lastSeq) 的事件
+// lastSeq 大于当前序号说明服务已重启，补发缓冲区中的全部事件
+func (h *Hub) sendReplay(client *Client) {
+	if client.lastSeq == 0 {
+		return
+	}
+	after := client.lastSeq
+	if after > h.seq {
+		after = 0
+	}
+
+	var missed []replayEntry
+	for carID, events := range h.events {
+		if client.carID != 0 && carID != client.carID {
+			continue
+		}
+		for _, e := range events {
+			if e.seq > after {
+				missed = append(missed, e)
 			}
-			h.mu.RUnlock()
 		}
 	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].seq < missed[j].seq })
+
+	for _, e := range missed {
+		data, err := json.Marshal(Message{Type: e.typ, Seq: e.seq, Replay: true, Data: e.data})
+		if err != nil {
+			h.logger.Error("Failed to marshal replay message", zap.Error(err))
+			continue
+		}
+		select {
+		case client.send <- data:
+		default:
+			h.logger.Warn("Failed to replay events, client buffer full")
+			return
+		}
+	}
+	if len(missed) > 0 {
+		h.logger.Debug("Replayed missed events to client",
+			zap.Uint64("last_seq", client.lastSeq),
+			zap.Int("count", len(missed)))
+	}
+}
+
+// deliver 将消息发送给所有客户端，carID 不为 0 时跳过订阅其他车辆的客户端
+func (h *Hub) deliver(message []byte, carID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		if carID != 0 && client.carID != 0 && client.carID != carID {
+			continue
+		}
+		select {
+		case client.send <- message:
+		default:
+			// 慢消费者，关闭连接
+			close(client.send)
+			delete(h.clients, client)
+		}
+	}
+}
+
+// flushPending 发送所有合并后的状态更新（每辆车最新一条）
+func (h *Hub) flushPending() {
+	h.pendingMu.Lock()
+	if len(h.pending) == 0 {
+		h.pendingMu.Unlock()
+		return
+	}
+	pending := h.pending
+	h.pending = make(map[int64]interface{})
+	h.pendingMu.Unlock()
+
+	for carID, state := range pending {
+		h.publish(outbound{typ: MsgTypeStateUpdate, data: state, carID: carID})
+	}
 }
 
 // sendInitData 发送初始数据给新连接的客户端
@@ -116,6 +300,7 @@ func (h *Hub) sendInitData(client *Client) {
 
 	msg := Message{
 		Type: MsgTypeInit,
+		Seq:  h.seq,
 		Data: initData,
 	}
 
@@ -133,25 +318,42 @@ func (h *Hub) sendInitData(client *Client) {
 	}
 }
 
-// Broadcast 广播消息给所有客户端
-func (h *Hub) Broadcast(message []byte) {
-	h.broadcast <- message
-}
-
-// BroadcastMessage 广播结构化消息给所有客户端
-func (h *Hub) BroadcastMessage(msgType string, data interface{}) {
-	msg := Message{
-		Type: msgType,
-		Data: data,
+// sendCarState 发送车辆当前状态给订阅单个车辆的新客户端 (state_update 消息，携带当前最新序号)
+func (h *Hub) sendCarState(client *Client) {
+	if h.getCarState == nil {
+		return
+	}
+	state := h.getCarState(client.carID)
+	if state == nil {
+		return
 	}
 
-	jsonData, err := json.Marshal(msg)
+	data, err := json.Marshal(Message{Type: MsgTypeStateUpdate, Seq: h.seq, Data: state})
 	if err != nil {
-		h.logger.Error("Failed to marshal broadcast message", zap.Error(err))
+		h.logger.Error("Failed to marshal car state", zap.Int64("car_id", client.carID), zap.Error(err))
 		return
 	}
 
-	h.Broadcast(jsonData)
+	select {
+	case client.send <- data:
+	default:
+		h.logger.Warn("Failed to send car state, client buffer full")
+	}
+}
+
+// Broadcast 广播原始消息给所有客户端 (不分配序号)
+func (h *Hub) Broadcast(message []byte) {
+	h.broadcast <- outbound{raw: message}
+}
+
+// BroadcastMessage 广播结构化消息给所有客户端
+func (h *Hub) BroadcastMessage(msgType string, data interface{}) {
+	h.broadcast <- outbound{typ: msgType, data: data}
+}
+
+// BroadcastEvent 广播车辆事件 (状态切换、告警等)，并保存到该车辆的事件缓冲区供重连补发
+func (h *Hub) BroadcastEvent(carID int64, msgType string, data interface{}) {
+	h.broadcast <- outbound{typ: msgType, data: data, carID: carID, event: true}
 }
 
 // BroadcastStateUpdate 广播状态更新
@@ -159,6 +361,40 @@ func (h *Hub) BroadcastStateUpdate(state interface{}) {
 	h.BroadcastMessage(MsgTypeStateUpdate, state)
 }
 
+// QueueStateUpdate 排队一条状态更新，下次 flush 时只发送该车辆的最新状态
+// 未设置合并间隔时立即广播
+func (h *Hub) QueueStateUpdate(carID int64, state interface{}) {
+	if h.flushInterval <= 0 {
+		h.broadcast <- outbound{typ: MsgTypeStateUpdate, data: state, carID: carID}
+		return
+	}
+
+	h.pendingMu.Lock()
+	h.pending[carID] = state
+	h.pendingMu.Unlock()
+}
+
+// BroadcastStateUpdateNow 立即广播状态更新（用于状态切换等重要事件）
+// 同时丢弃该车辆已排队的旧状态
+func (h *Hub) BroadcastStateUpdateNow(carID int64, state interface{}) {
+	h.pendingMu.Lock()
+	delete(h.pending, carID)
+	h.pendingMu.Unlock()
+
+	h.BroadcastEvent(carID, MsgTypeStateUpdate, state)
+}
+
+// DisconnectAll 断开所有客户端 (关闭发送队列)，用于服务关闭时结束 WebSocket 和 SSE 长连接
+func (h *Hub) DisconnectAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		close(client.send)
+		delete(h.clients, client)
+	}
+}
+
 // ClientCount 获取客户端数量
 func (h *Hub) ClientCount() int {
 	h.mu.RLock()
@@ -171,10 +407,30 @@ func NewClient(hub *Hub, conn *websocket.Conn) *Client {
 	return &Client{
 		hub:  hub,
 		conn: conn,
-		send: make(chan []byte, 256),
+		send: make(chan []byte, hub.sendBufferSize),
 	}
 }
 
+// NewSubscriber 创建只接收指定车辆消息的订阅者 (没有 WebSocket 连接，从 Messages 读取)
+// 注册后先补发断线期间的事件，再发送该车辆的当前状态
+func NewSubscriber(hub *Hub, carID int64) *Client {
+	return &Client{
+		hub:   hub,
+		send:  make(chan []byte, hub.sendBufferSize),
+		carID: carID,
+	}
+}
+
+// Messages 待发送的消息 (JSON 编码的 Message)，客户端被注销或因消费过慢被断开时关闭
+func (c *Client) Messages() <-chan []byte {
+	return c.send
+}
+
+// ResumeAfter 设置重连前收到的最后序号，注册后补发此后的事件 (需在 Register 之前调用)
+func (c *Client) ResumeAfter(seq uint64) {
+	c.lastSeq = seq
+}
+
 // Register 注册客户端
 func (c *Client) Register() {
 	c.hub.register <- c
@@ -192,6 +448,7 @@ func (c *Client) ReadPump() {
 		c.conn.Close()
 	}()
 
+	c.conn.SetReadLimit(maxReadMessageSize)
 	for {
 		_, _, err := c.conn.ReadMessage()
 		if err != nil {